@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,11 +11,21 @@ import (
 	"time"
 
 	"github.com/BlackSound1/Go-B-and-B/internal/config"
+	"github.com/BlackSound1/Go-B-and-B/internal/digest"
 	"github.com/BlackSound1/Go-B-and-B/internal/driver"
+	"github.com/BlackSound1/Go-B-and-B/internal/emails"
 	"github.com/BlackSound1/Go-B-and-B/internal/handlers"
 	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/icalsync"
 	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/notify"
+	"github.com/BlackSound1/Go-B-and-B/internal/oidc"
+	"github.com/BlackSound1/Go-B-and-B/internal/outbox"
 	"github.com/BlackSound1/Go-B-and-B/internal/render"
+	"github.com/BlackSound1/Go-B-and-B/internal/scheduler"
+	"github.com/BlackSound1/Go-B-and-B/internal/sessionstore"
+	"github.com/BlackSound1/Go-B-and-B/internal/sms"
+	"github.com/BlackSound1/Go-B-and-B/internal/webhooks"
 	"github.com/alexedwards/scs/v2"
 )
 
@@ -36,12 +48,46 @@ func main() {
 	// when main loop is finished, not when run() returns
 	defer db.SQL.Close()
 
-	// Close the mail channel when the main loop is finished
-	defer close(app.MailChan)
+	// Send queued emails in the background, retrying the ones that failed
+	log.Println("Starting email outbox...")
+	go outbox.New(handlers.Repo.DB, app.Mailer, app.ErrorLog).Run(context.Background(), 10*time.Second)
 
-	// Listen for mail
-	log.Println("Starting mail listener...")
-	listenForMail()
+	// Send queued text messages in the background, if they're on
+	if app.SMS != nil {
+		log.Println("Starting text message outbox...")
+		go sms.NewWorker(handlers.Repo.DB, app.SMS, app.ErrorLog).Run(context.Background(), 10*time.Second)
+	}
+
+	// Email guests before and after their stay
+	log.Println("Starting guest email scheduler...")
+	go scheduler.New(handlers.Repo.DB, app.Emails, scheduler.Config{
+		PreArrivalDays: app.EnvVars["PRE_ARRIVAL_EMAIL_DAYS"].(int),
+		PostStayDays:   app.EnvVars["POST_STAY_EMAIL_DAYS"].(int),
+		CheckInTime:    app.EnvVars["CHECK_IN_TIME"].(string),
+		ReviewURL:      app.EnvVars["REVIEW_URL"].(string),
+		SiteURL:        app.EnvVars["BASE_URL"].(string),
+		From:           "me@here.com",
+		SMS:            app.SMSTemplates,
+		SMSCountryCode: app.SMSCountryCode,
+	}, app.ErrorLog).Run(context.Background(), time.Hour)
+
+	// Email staff who opted in an overview of the day's bookings
+	log.Println("Starting daily digest...")
+	go digest.New(handlers.Repo.DB, app.Emails, digest.Config{
+		Time:    app.DigestTime,
+		SiteURL: app.EnvVars["BASE_URL"].(string),
+		From:    "me@here.com",
+	}, app.ErrorLog).Run(context.Background(), 5*time.Minute)
+
+	// Keep the blocks imported from other booking sites up to date
+	if minutes := app.EnvVars["ICAL_SYNC_MINUTES"].(int); minutes > 0 {
+		log.Println("Starting calendar sync...")
+		go icalsync.New(handlers.Repo.DB, app.ErrorLog).Run(context.Background(), time.Duration(minutes)*time.Minute)
+	}
+
+	// Send webhook events in the background, retrying the ones that failed
+	log.Println("Starting webhook dispatcher...")
+	go webhooks.New(handlers.Repo.DB, app.ErrorLog).Run(context.Background(), 10*time.Second)
 
 	fmt.Println("Starting server on port", portNumber)
 
@@ -76,6 +122,38 @@ func run(envFile string) (*driver.DB, error) {
 	// Change to true when in production
 	app.InProduction = app.EnvVars["PROD"].(bool)
 
+	app.BookingHorizon = app.EnvVars["BOOKING_HORIZON_DAYS"].(int)
+	app.ICalSecret = []byte(app.EnvVars["ICAL_SECRET"].(string))
+	app.PropertyAddress = app.EnvVars["PROPERTY_ADDRESS"].(string)
+	app.PropertyName = app.EnvVars["PROPERTY_NAME"].(string)
+	app.PropertyEmail = app.EnvVars["PROPERTY_EMAIL"].(string)
+	app.PropertyPhone = app.EnvVars["PROPERTY_PHONE"].(string)
+	app.PropertyTaxID = app.EnvVars["PROPERTY_TAX_ID"].(string)
+	app.Currency = app.EnvVars["CURRENCY"].(string)
+
+	checkIn, err := helpers.ParseTimeOfDay(app.EnvVars["CHECK_IN_TIME"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("CHECK_IN_TIME: %w", err)
+	}
+
+	checkOut, err := helpers.ParseTimeOfDay(app.EnvVars["CHECK_OUT_TIME"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("CHECK_OUT_TIME: %w", err)
+	}
+
+	app.CheckIn = checkIn
+	app.CheckOut = checkOut
+
+	app.DigestTime, err = helpers.ParseTimeOfDay(app.EnvVars["DIGEST_TIME"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("DIGEST_TIME: %w", err)
+	}
+
+	app.TrustedProxies, err = helpers.ParseTrustedProxies(app.EnvVars["TRUSTED_PROXIES"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+
 	// Define loggers. The | is a bitwise OR, so all flags get set to 1 integer value
 	app.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
@@ -86,10 +164,10 @@ func run(envFile string) (*driver.DB, error) {
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
+	gob.Register(time.Time{})
 
-	// Create channel for email data and add it to App config
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
+	// Live notifications for staff in the admin area
+	app.Notifications = notify.NewBroker()
 
 	// Create session info
 	session = scs.New()
@@ -111,6 +189,10 @@ func run(envFile string) (*driver.DB, error) {
 
 	log.Println("Connected to database")
 
+	// Keep sessions in the database so logins and half-finished bookings
+	// survive restarts and deploys
+	session.Store = sessionstore.New(db.SQL)
+
 	// Create template cache and associate it with app config
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
@@ -118,6 +200,13 @@ func run(envFile string) (*driver.DB, error) {
 		return nil, err
 	}
 
+	// Parse the email templates once, so a broken one stops the app now
+	// rather than when the first guest books
+	app.Emails, err = emails.Load("./email_templates", app.Currency)
+	if err != nil {
+		return nil, err
+	}
+
 	// Set settings for config
 	app.TemplateCache = tc
 	app.UseCache = app.EnvVars["USE_TEMPLATE_CACHE"].(bool)
@@ -134,5 +223,63 @@ func run(envFile string) (*driver.DB, error) {
 	// Create helpers
 	helpers.NewHelpers(&app)
 
+	// Check how to send mail now, rather than when the first email fails
+	err = setupMail()
+	if err != nil {
+		return nil, err
+	}
+
+	err = setupSMS()
+	if err != nil {
+		return nil, err
+	}
+
+	err = setupPayments()
+	if err != nil {
+		return nil, err
+	}
+
+	// Let staff log in with the identity provider, if one is set up
+	if app.EnvVars["OIDC_ISSUER"].(string) != "" {
+		log.Println("Connecting to identity provider...")
+
+		err = setupOIDC()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
+
+// setupOIDC discovers the identity provider staff log in with, and works out
+// which of its groups map to which access level.
+func setupOIDC() error {
+	levels, err := oidc.ParseGroupLevels(app.EnvVars["OIDC_GROUP_LEVELS"].(string))
+	if err != nil {
+		return err
+	}
+
+	// Without a mapping nobody could log in, so it's a mistake
+	if len(levels) == 0 {
+		return errors.New("OIDC_GROUP_LEVELS must map at least one group to an access level")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, err := oidc.New(ctx, oidc.Config{
+		Issuer:       app.EnvVars["OIDC_ISSUER"].(string),
+		ClientID:     app.EnvVars["OIDC_CLIENT_ID"].(string),
+		ClientSecret: app.EnvVars["OIDC_CLIENT_SECRET"].(string),
+		RedirectURL:  helpers.BaseURL() + "/user/login/oidc/callback",
+	})
+	if err != nil {
+		return err
+	}
+
+	app.OIDC = provider
+	app.OIDCGroupLevels = levels
+
+	return nil
+}
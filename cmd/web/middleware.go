@@ -2,7 +2,10 @@ package main
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/BlackSound1/Go-B-and-B/internal/handlers"
 	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
 	"github.com/justinas/nosurf"
 )
@@ -12,6 +15,19 @@ import (
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 
+	// Requests authenticated with an access token don't rely on cookies, so
+	// they can't be forged cross-site and don't need a CSRF token
+	csrfHandler.ExemptFunc(helpers.IsTokenAuthenticated)
+
+	// The JSON API never trusts the session cookie, so the public endpoints
+	// don't need a CSRF token either. The website's own JSON endpoints, like
+	// /search-availability-json, aren't exempt, so their POSTs need the token
+	// whether the body is a form or JSON
+	csrfHandler.ExemptRegexp("^/api/")
+
+	// The payment provider's webhooks are checked by their signature instead
+	csrfHandler.ExemptPath("/payments/webhook")
+
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path:     "/",
@@ -28,6 +44,28 @@ func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+// sessionSeenInterval is how often the "last seen" time of a logged in session
+// is refreshed. Refreshing on every request would write to the store every time
+const sessionSeenInterval = time.Minute
+
+// TrackSession records the IP address, user agent and last seen time of logged in
+// sessions so users can see where they are logged in. Must come after SessionLoad.
+func TrackSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if helpers.IsAuthenticated(r) {
+			lastSeen := session.GetTime(r.Context(), "last_seen")
+
+			if time.Since(lastSeen) > sessionSeenInterval {
+				session.Put(r.Context(), "ip", helpers.ClientIP(r))
+				session.Put(r.Context(), "user_agent", r.UserAgent())
+				session.Put(r.Context(), "last_seen", time.Now())
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Auth checks if the user is authenticated first before allowing the user to
 // access a restricted page.
 func Auth(next http.Handler) http.Handler {
@@ -41,3 +79,65 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// GuestAuth checks that a guest is logged in before allowing access to the
+// guest account pages. Staff logins don't count, as staff aren't guests.
+func GuestAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !helpers.IsGuestAuthenticated(r) {
+			session.Put(r.Context(), "error", "Log in first!")
+			http.Redirect(w, r, "/guest/login", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TokenAuth authenticates requests that carry an "Authorization: Bearer" header
+// against the personal access tokens in the database. A valid token is added to
+// the request context, which is how NoSurf and RequireScope know about it. Requests
+// without a bearer token are passed through untouched.
+func TokenAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t, err := handlers.Repo.DB.GetAccessTokenByHash(helpers.HashToken(strings.TrimSpace(token)))
+		if err != nil || t.Expired() {
+			helpers.JSONError(w, http.StatusUnauthorized, "Invalid or expired access token")
+			return
+		}
+
+		err = handlers.Repo.DB.UpdateAccessTokenLastUsed(t.ID)
+		if err != nil {
+			app.ErrorLog.Println(err)
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithAccessToken(r.Context(), t)))
+	})
+}
+
+// RequireScope only lets through requests authenticated with an access token
+// that grants the given scope.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t, ok := helpers.AccessTokenFromRequest(r)
+			if !ok {
+				helpers.JSONError(w, http.StatusUnauthorized, "An access token is required")
+				return
+			}
+
+			if !t.Allows(scope) {
+				helpers.JSONError(w, http.StatusForbidden, "Access token does not have the "+scope+" scope")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
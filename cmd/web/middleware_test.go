@@ -2,7 +2,11 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
 )
 
 // TestNoSurf tests the NoSurf middleware function to ensure it returns
@@ -42,3 +46,176 @@ func TestSessionLoad(t *testing.T) {
 		t.Errorf("type is not http.Handler, but is %T", v)
 	}
 }
+
+// Create a set of tests to run
+var tokenAuthTests = []struct {
+	name               string
+	authorization      string
+	requiredScope      string
+	expectedStatusCode int
+	expectedScope      string
+}{
+	{"no-token", "", "", http.StatusOK, ""},
+	{"other-scheme", "Basic dXNlcjpwYXNz", "", http.StatusOK, ""},
+	{"valid-read-token", "Bearer gbb_read", "", http.StatusOK, "read"},
+	{"unknown-token", "Bearer gbb_nope", "", http.StatusUnauthorized, ""},
+	{"expired-token", "Bearer gbb_expired", "", http.StatusUnauthorized, ""},
+	{"scope-without-token", "", "read", http.StatusUnauthorized, ""},
+	{"read-token-for-write", "Bearer gbb_read", "write", http.StatusForbidden, ""},
+	{"write-token-for-read", "Bearer gbb_write", "read", http.StatusOK, "write"},
+	{"write-token-for-write", "Bearer gbb_write", "write", http.StatusOK, "write"},
+}
+
+// TestTokenAuth tests the TokenAuth and RequireScope middleware together for
+// various combinations of tokens and required scopes.
+func TestTokenAuth(t *testing.T) {
+	for _, test := range tokenAuthTests {
+		var h scopeHandler
+
+		var handler http.Handler = &h
+		if test.requiredScope != "" {
+			handler = RequireScope(test.requiredScope)(handler)
+		}
+		handler = TokenAuth(handler)
+
+		req := httptest.NewRequest("GET", "/api/v1/reservations", nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatusCode)
+		}
+
+		if h.called != (test.expectedStatusCode == http.StatusOK) {
+			t.Errorf("%s: handler called was %v", test.name, h.called)
+		}
+
+		if h.scope != test.expectedScope {
+			t.Errorf("%s: expected scope %q in context, but got %q", test.name, test.expectedScope, h.scope)
+		}
+	}
+}
+
+// TestNoSurfExemptsTokenRequests tests that POST requests authenticated with an
+// access token don't need a CSRF token, while other POST requests still do.
+func TestNoSurfExemptsTokenRequests(t *testing.T) {
+	var h scopeHandler
+	handler := TokenAuth(NoSurf(&h))
+
+	// Without a token, the POST is rejected by NoSurf
+	req := httptest.NewRequest("POST", "/admin/profile/tokens", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected POST without CSRF token to fail with %d, but got %d", http.StatusBadRequest, recorder.Code)
+	}
+
+	// With a token, the POST gets through
+	req = httptest.NewRequest("POST", "/admin/profile/tokens", nil)
+	req.Header.Set("Authorization", "Bearer gbb_write")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if !h.called {
+		t.Errorf("expected token-authenticated POST to reach the handler, but got %d", recorder.Code)
+	}
+
+	// The JSON API doesn't use CSRF tokens, so public API POSTs get through too
+	h.called = false
+	req = httptest.NewRequest("POST", "/api/v1/reservations", nil)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if !h.called {
+		t.Errorf("expected API POST to reach the handler, but got %d", recorder.Code)
+	}
+}
+
+// TestNoSurfChecksWebsiteJSON tests that the website's JSON endpoints still
+// need a CSRF token, unlike the JSON API
+func TestNoSurfChecksWebsiteJSON(t *testing.T) {
+	var h scopeHandler
+	handler := NoSurf(&h)
+
+	req := httptest.NewRequest("POST", "/search-availability-json", strings.NewReader(`{"start": "2040-01-01", "end": "2040-01-02", "room_id": 1}`))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest || h.called {
+		t.Errorf("expected a JSON POST without a CSRF token to fail with %d, but got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+// TestTrackSession tests that TrackSession records where logged in sessions are
+// used from, and leaves anonymous sessions alone. X-Forwarded-For is only
+// believed when the request comes from a trusted proxy.
+func TestTrackSession(t *testing.T) {
+	defer func() { app.TrustedProxies = nil }()
+
+	tests := []struct {
+		name      string
+		userID    int
+		proxies   string
+		forwarded string
+		expected  string
+	}{
+		{"logged-in", 1, "", "", "192.0.2.1"},
+		{"anonymous", 0, "", "", ""},
+		{"untrusted-peer", 1, "", "203.0.113.7", "192.0.2.1"},
+		{"trusted-proxy", 1, "192.0.2.1", "203.0.113.7", "203.0.113.7"},
+		{"spoofed-start", 1, "192.0.2.1", "198.51.100.9, 203.0.113.7", "203.0.113.7"},
+		{"proxy-chain", 1, "192.0.2.0/24,10.0.0.0/8", "203.0.113.7, 10.0.0.1", "203.0.113.7"},
+		{"garbage", 1, "192.0.2.1", "203.0.113.7, nonsense", "192.0.2.1"},
+		{"all-trusted", 1, "192.0.2.1,10.0.0.0/8", "10.0.0.2, 10.0.0.1", "10.0.0.2"},
+	}
+
+	for _, test := range tests {
+		proxies, err := helpers.ParseTrustedProxies(test.proxies)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		app.TrustedProxies = proxies
+
+		// httptest requests come from 192.0.2.1
+		req := httptest.NewRequest("GET", "/admin/dashboard", nil)
+		if test.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+
+		ctx, _ := session.Load(req.Context(), "")
+		if test.userID > 0 {
+			session.Put(ctx, "user_id", test.userID)
+		}
+		req = req.WithContext(ctx)
+
+		TrackSession(&myHandler{}).ServeHTTP(httptest.NewRecorder(), req)
+
+		if ip := session.GetString(ctx, "ip"); ip != test.expected {
+			t.Errorf("%s: expected ip %q, but got %q", test.name, test.expected, ip)
+		}
+	}
+}
+
+// TestParseTrustedProxies tests that addresses and ranges are accepted, and
+// anything else is an error.
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := helpers.ParseTrustedProxies(" 10.0.0.1, 172.16.0.0/12,,::1 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(proxies) != 3 {
+		t.Errorf("expected 3 proxies, but got %d", len(proxies))
+	}
+
+	_, err = helpers.ParseTrustedProxies("10.0.0.1,proxy.internal")
+	if err == nil {
+		t.Error("expected an error for a host name, but got none")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/payments"
+)
+
+// setupPayments picks how guests pay for reservations from the environment,
+// and sets it on the app config. Guests don't pay when booking unless
+// PAYMENT_PROVIDER is set.
+func setupPayments() error {
+	provider, err := newPaymentProvider(app.EnvVars)
+	if err != nil || provider == nil {
+		return err
+	}
+
+	app.Payments = provider
+	app.DepositPercent = app.EnvVars["PAYMENT_DEPOSIT_PERCENT"].(int)
+
+	return nil
+}
+
+// newPaymentProvider creates the provider PAYMENT_PROVIDER asks for. Nil means
+// guests don't pay when booking.
+func newPaymentProvider(env map[string]any) (payments.Provider, error) {
+	switch provider := env["PAYMENT_PROVIDER"].(string); provider {
+	case "", "none":
+		return nil, nil
+	case "hosted":
+		apiURL := env["PAYMENT_API_URL"].(string)
+		if apiURL == "" {
+			return nil, fmt.Errorf("PAYMENT_API_URL is required when PAYMENT_PROVIDER is hosted")
+		}
+
+		// Without the secret anyone could mark a payment as paid
+		secret := env["PAYMENT_WEBHOOK_SECRET"].(string)
+		if secret == "" {
+			return nil, fmt.Errorf("PAYMENT_WEBHOOK_SECRET is required when PAYMENT_PROVIDER is hosted")
+		}
+
+		return payments.NewHosted(apiURL, env["PAYMENT_API_KEY"].(string), secret), nil
+	case "fake":
+		// The fake provider marks reservations as paid without taking any
+		// money, so it must never take real bookings
+		if env["PROD"].(bool) {
+			return nil, fmt.Errorf("PAYMENT_PROVIDER can't be fake in production")
+		}
+
+		return payments.NewFake(log.New(os.Stdout, "PAYMENT\t", log.Ldate|log.Ltime)), nil
+	default:
+		return nil, fmt.Errorf("PAYMENT_PROVIDER must be none, hosted or fake, not %q", provider)
+	}
+}
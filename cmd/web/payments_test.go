@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/payments"
+)
+
+func TestNewPaymentProvider(t *testing.T) {
+	env := map[string]any{
+		"PAYMENT_API_URL":        "https://pay.example.com/v1",
+		"PAYMENT_API_KEY":        "sk_test",
+		"PAYMENT_WEBHOOK_SECRET": "whsec_test",
+		"PROD":                   false,
+	}
+
+	for _, off := range []string{"", "none"} {
+		env["PAYMENT_PROVIDER"] = off
+		if p, err := newPaymentProvider(env); p != nil || err != nil {
+			t.Errorf("%q: expected payments to be off, got %v, %v", off, p, err)
+		}
+	}
+
+	env["PAYMENT_PROVIDER"] = "hosted"
+	if p, err := newPaymentProvider(env); err != nil {
+		t.Error(err)
+	} else if h, ok := p.(*payments.Hosted); !ok || h.URL != "https://pay.example.com/v1" || h.APIKey != "sk_test" {
+		t.Errorf("expected a hosted provider, got %#v", p)
+	}
+
+	env["PAYMENT_PROVIDER"] = "fake"
+	if p, _ := newPaymentProvider(env); p == nil {
+		t.Error("expected a fake provider")
+	}
+
+	// Taking bookings without taking money is only for development
+	env["PROD"] = true
+	_, err := newPaymentProvider(env)
+	env["PROD"] = false
+	if err == nil {
+		t.Error("expected an error for a fake provider in production")
+	}
+
+	env["PAYMENT_PROVIDER"] = "hosted"
+	env["PAYMENT_WEBHOOK_SECRET"] = ""
+	if _, err := newPaymentProvider(env); err == nil {
+		t.Error("expected an error without a webhook secret")
+	}
+
+	env["PAYMENT_API_URL"] = ""
+	if _, err := newPaymentProvider(env); err == nil {
+		t.Error("expected an error without an API URL")
+	}
+
+	env["PAYMENT_PROVIDER"] = "cheque"
+	if _, err := newPaymentProvider(env); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+}
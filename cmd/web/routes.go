@@ -5,6 +5,7 @@ import (
 
 	"github.com/BlackSound1/Go-B-and-B/internal/config"
 	"github.com/BlackSound1/Go-B-and-B/internal/handlers"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
@@ -15,8 +16,10 @@ func routes(app *config.AppConfig) http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(middleware.Recoverer) // Recoverer middleware to recover from panics more gracefully
+	mux.Use(TokenAuth)            // TokenAuth must come before NoSurf so token requests can be exempted
 	mux.Use(NoSurf)               // NoSurf middleware to prevent CSRF attacks on POST requests
 	mux.Use(SessionLoad)
+	mux.Use(TrackSession)
 
 	// Set up routes
 	mux.Get("/", handlers.Repo.Home)
@@ -26,6 +29,7 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/majors-suite", handlers.Repo.Majors)
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
+	mux.Get("/search-availability-json", handlers.Repo.AvailabilityJSON)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
@@ -34,9 +38,63 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
+	mux.Get("/payments/{id}/return", handlers.Repo.PaymentReturn)
+	mux.Get("/payments/{id}/cancel", handlers.Repo.PaymentCancel)
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
+	mux.Get("/user/login/oidc", handlers.Repo.OIDCLogin)
+	mux.Get("/user/login/oidc/callback", handlers.Repo.OIDCCallback)
+
+	// Calendar feeds are protected by the secret token in their URL
+	mux.Get("/ical/rooms/{id}/{token}.ics", handlers.Repo.RoomICalFeed)
+	mux.Get("/ical/property/{token}.ics", handlers.Repo.PropertyICalFeed)
+
+	mux.Get("/guest/register", handlers.Repo.ShowGuestRegister)
+	mux.Post("/guest/register", handlers.Repo.PostGuestRegister)
+	mux.Get("/guest/login", handlers.Repo.ShowGuestLogin)
+	mux.Post("/guest/login", handlers.Repo.PostGuestLogin)
+	mux.Get("/guest/logout", handlers.Repo.GuestLogout)
+	mux.Get("/guest/invite/{token}", handlers.Repo.ShowGuestInvite)
+	mux.Post("/guest/invite/{token}", handlers.Repo.PostGuestInvite)
+
+	// Guest account pages are for logged in guests only
+	mux.Route("/guest/account", func(r chi.Router) {
+		r.Use(GuestAuth)
+
+		r.Get("/", handlers.Repo.GuestAccount)
+		r.Post("/", handlers.Repo.PostGuestAccount)
+		r.Post("/verify-email", handlers.Repo.PostGuestSendVerification)
+		r.Get("/email/{token}", handlers.Repo.ShowGuestEmailVerification)
+		r.Post("/email/{token}", handlers.Repo.PostGuestEmailVerification)
+		r.Post("/reservations/{id}/invoice", handlers.Repo.GuestIssueInvoice)
+		r.Get("/reservations/{id}/invoice.pdf", handlers.Repo.GuestReservationInvoice)
+	})
+
+	mux.Get("/api/docs", handlers.Repo.APIDocs)
+
+	// JSON API. Rooms, availability and booking are public, while managing
+	// reservations needs a personal access token
+	mux.Route("/api/v1", func(r chi.Router) {
+		r.NotFound(handlers.Repo.APINotFound)
+		r.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+
+		r.Get("/openapi.json", handlers.Repo.OpenAPI)
+		r.Get("/rooms", handlers.Repo.APIRooms)
+		r.Get("/rooms/{id}", handlers.Repo.APIRoom)
+		r.Get("/rooms/{id}/availability", handlers.Repo.APIRoomAvailability)
+		r.Get("/rooms/{id}/calendar", handlers.Repo.APIRoomCalendar)
+		r.Get("/availability", handlers.Repo.APIAvailability)
+		r.Post("/reservations", handlers.Repo.APIPostReservation)
+
+		r.With(RequireScope(models.ScopeRead)).Get("/reservations", handlers.Repo.APIReservations)
+		r.With(RequireScope(models.ScopeRead)).Get("/reservations/{id}", handlers.Repo.APIReservation)
+		r.With(RequireScope(models.ScopeWrite)).Patch("/reservations/{id}", handlers.Repo.APIPatchReservation)
+		r.With(RequireScope(models.ScopeWrite)).Post("/reservations/{id}/cancel", handlers.Repo.APICancelReservation)
+		r.With(RequireScope(models.ScopeWrite)).Post("/blocks", handlers.Repo.APIPostBlock)
+	})
 
 	// All routes starting with /admin will be protected
 	mux.Route("/admin", func(r chi.Router) {
@@ -53,8 +111,40 @@ func routes(app *config.AppConfig) http.Handler {
 		r.Post("/reservations-calendar", handlers.Repo.AdminPostReservationCalendar)
 		r.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
 		r.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
-		r.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		r.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+		r.Get("/reservations/{src}/{id}/folio", handlers.Repo.AdminReservationFolio)
+		r.Post("/reservations/{src}/{id}/invoice", handlers.Repo.AdminIssueInvoice)
+		r.Get("/reservations/{src}/{id}/invoice.pdf", handlers.Repo.AdminReservationInvoice)
+		r.Post("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
+		r.Post("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+
+		r.Get("/profile", handlers.Repo.AdminProfile)
+		r.Post("/profile/tokens", handlers.Repo.AdminPostAccessToken)
+		r.Post("/profile/tokens/{id}/revoke", handlers.Repo.AdminRevokeAccessToken)
+		r.Post("/profile/digest", handlers.Repo.AdminPostDailyDigest)
+		r.Post("/profile/sso", handlers.Repo.AdminLinkSSO)
+
+		r.Get("/calendar-feeds", handlers.Repo.AdminCalendarFeeds)
+		r.Post("/calendar-feeds/import", handlers.Repo.AdminPostICalImport)
+		r.Post("/calendar-feeds/import/{id}/sync", handlers.Repo.AdminSyncICalImport)
+		r.Post("/calendar-feeds/import/{id}/delete", handlers.Repo.AdminDeleteICalImport)
+
+		r.Get("/charges", handlers.Repo.AdminCharges)
+		r.Post("/charges", handlers.Repo.AdminPostCharge)
+		r.Post("/charges/{id}/delete", handlers.Repo.AdminDeleteCharge)
+
+		r.Get("/webhooks", handlers.Repo.AdminWebhooks)
+		r.Post("/webhooks", handlers.Repo.AdminPostWebhook)
+		r.Get("/webhooks/{id}", handlers.Repo.AdminShowWebhook)
+		r.Post("/webhooks/{id}/delete", handlers.Repo.AdminDeleteWebhook)
+		r.Post("/webhooks/{id}/deliveries/{delivery}/redeliver", handlers.Repo.AdminRedeliverWebhook)
+
+		r.Get("/emails", handlers.Repo.AdminEmails)
+		r.Post("/emails/{id}/resend", handlers.Repo.AdminResendEmail)
+
+		r.Get("/events", handlers.Repo.AdminEvents)
+
+		r.Get("/sessions", handlers.Repo.AdminSessions)
+		r.Post("/sessions/{id}/revoke", handlers.Repo.AdminRevokeSession)
 	})
 
 	// Serve static files
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/BlackSound1/Go-B-and-B/internal/config"
@@ -21,3 +25,36 @@ func TestRoutes(t *testing.T) {
 		t.Errorf("type is not *chi.Mux, but is %T", v)
 	}
 }
+
+// TestAPIRoutesDocumented tests that every JSON API route the app serves is in
+// the OpenAPI document, since the handlers' tests only see a copy of the routes.
+func TestAPIRoutesDocumented(t *testing.T) {
+	mux := routes(&app)
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+
+	var doc struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+
+	err := json.Unmarshal(recorder.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatalf("could not read the OpenAPI document: %s", recorder.Body.String())
+	}
+
+	err = chi.Walk(mux.(chi.Router), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, "/api/v1/") && route != "/search-availability-json" {
+			return nil
+		}
+
+		if _, ok := doc.Paths[route][strings.ToLower(method)]; !ok {
+			t.Errorf("%s %s is not documented", method, route)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,72 +2,101 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"os"
-	"strings"
+	"strconv"
 	"time"
 
-	"github.com/BlackSound1/Go-B-and-B/internal/models"
-	mail "github.com/xhit/go-simple-mail"
+	"github.com/BlackSound1/Go-B-and-B/internal/mailer"
 )
 
-// listenForMail starts a goroutine that continuously listens for messages on the mail channel.
-// When a message is received, it calls sendMessage to process and send the email.
-func listenForMail() {
+// setupMail picks how mail is sent from the environment, and sets it on the
+// app config
+func setupMail() error {
+	m, err := newMailer(app.EnvVars)
+	if err != nil {
+		return err
+	}
 
-	// Create function that runs forever in the background.
-	// It's anonymous and an asynchronous goroutine
-	go func() {
-		for {
-			message := <-app.MailChan
-			sendMessage(message)
-		}
-	}()
+	app.Mailer = m
+
+	return nil
 }
 
-// sendMessage sends an email using the provided MailData. It configures the SMTP server,
-// creates the email message, and sends it.
-func sendMessage(m models.MailData) {
-	// Create mail server object
-	server := mail.NewSMTPClient()
+// newMailer creates the mailer MAIL_BACKEND asks for. SMTP is the default, and
+// anything left out of its config defaults to MailHog on localhost:1025.
+func newMailer(env map[string]any) (mailer.Mailer, error) {
+	switch backend := env["MAIL_BACKEND"].(string); backend {
+	case "", "smtp":
+		cfg, err := smtpConfig(env)
+		if err != nil {
+			return nil, err
+		}
+
+		return mailer.NewSMTPMailer(cfg)
+	case "file":
+		dir := env["MAIL_DIR"].(string)
+		if dir == "" {
+			dir = "./mail"
+		}
 
-	server.Host = "localhost"
-	server.Port = 1025
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
+		return mailer.NewFile(dir)
+	case "memory":
+		return mailer.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("MAIL_BACKEND must be smtp, file or memory, not %q", backend)
+	}
+}
 
-	// Try to connect
-	client, err := server.Connect()
-	if err != nil {
-		log.Println(err)
+// smtpConfig builds the SMTP config from the environment variables
+func smtpConfig(env map[string]any) (mailer.SMTPConfig, error) {
+	cfg := mailer.SMTPConfig{
+		Host:     env["SMTP_HOST"].(string),
+		Username: env["SMTP_USERNAME"].(string),
+		Password: env["SMTP_PASSWORD"].(string),
 	}
 
-	// Create email message
-	email := mail.NewMSG()
-	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
-	if m.Template == "" {
-		email.SetBody(mail.TextHTML, m.Content)
-	} else {
-		// Read the HTML template from file
-		data, err := os.ReadFile(fmt.Sprintf("./email_templates/%s", m.Template))
+	if cfg.Host == "" {
+		cfg.Host = "localhost"
+	}
+
+	cfg.Port = 1025
+	if port := env["SMTP_PORT"].(string); port != "" {
+		n, err := strconv.Atoi(port)
 		if err != nil {
-			log.Println(err)
+			return cfg, fmt.Errorf("SMTP_PORT must be a number, not %q", port)
 		}
+		cfg.Port = n
+	}
 
-		// Convert the template to a string
-		mailTemplate := string(data)
+	encryption, err := mailer.ParseEncryption(env["SMTP_ENCRYPTION"].(string))
+	if err != nil {
+		return cfg, fmt.Errorf("SMTP_ENCRYPTION: %w", err)
+	}
+	cfg.Encryption = encryption
 
-		// Replace the template variables and send
-		msgToSend := strings.Replace(mailTemplate, "[%CONTENT%]", m.Content, 1)
-		email.SetBody(mail.TextHTML, msgToSend)
+	durations := []struct {
+		name string
+		dst  *time.Duration
+		def  time.Duration
+	}{
+		{"SMTP_CONNECT_TIMEOUT", &cfg.ConnectTimeout, 10 * time.Second},
+		{"SMTP_SEND_TIMEOUT", &cfg.SendTimeout, 30 * time.Second},
+		{"SMTP_KEEPALIVE", &cfg.KeepAlive, 30 * time.Second},
 	}
 
-	// Send email
-	err = email.Send(client)
-	if err != nil {
-		log.Println(err)
-	} else {
-		log.Println("Email Sent!")
+	for _, d := range durations {
+		*d.dst = d.def
+
+		value := env[d.name].(string)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return cfg, fmt.Errorf("%s must be a duration like 30s, not %q", d.name, value)
+		}
+		*d.dst = parsed
 	}
+
+	return cfg, cfg.Validate()
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/mailer"
+)
+
+// smtpEnv returns the SMTP environment variables, all empty apart from the
+// ones given
+func smtpEnv(set map[string]string) map[string]any {
+	env := map[string]any{}
+	for _, name := range []string{
+		"SMTP_HOST", "SMTP_PORT", "SMTP_USERNAME", "SMTP_PASSWORD", "SMTP_ENCRYPTION",
+		"SMTP_CONNECT_TIMEOUT", "SMTP_SEND_TIMEOUT", "SMTP_KEEPALIVE",
+	} {
+		env[name] = set[name]
+	}
+
+	return env
+}
+
+func TestSMTPConfigDefaults(t *testing.T) {
+	cfg, err := smtpConfig(smtpEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Host != "localhost" || cfg.Port != 1025 || cfg.Encryption != mailer.EncryptionNone {
+		t.Errorf("expected MailHog on localhost:1025, got %s:%d (%s)", cfg.Host, cfg.Port, cfg.Encryption)
+	}
+
+	if cfg.ConnectTimeout != 10*time.Second || cfg.SendTimeout != 30*time.Second || cfg.KeepAlive != 30*time.Second {
+		t.Errorf("unexpected default timeouts: %+v", cfg)
+	}
+}
+
+func TestSMTPConfig(t *testing.T) {
+	cfg, err := smtpConfig(smtpEnv(map[string]string{
+		"SMTP_HOST":       "smtp.example.com",
+		"SMTP_PORT":       "587",
+		"SMTP_USERNAME":   "bnb",
+		"SMTP_PASSWORD":   "secret",
+		"SMTP_ENCRYPTION": "starttls",
+		"SMTP_KEEPALIVE":  "0",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port != 587 || cfg.Encryption != mailer.EncryptionSTARTTLS || cfg.KeepAlive != 0 {
+		t.Errorf("settings weren't read: %+v", cfg)
+	}
+}
+
+func TestSMTPConfigInvalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"port":       {"SMTP_PORT": "smtp"},
+		"encryption": {"SMTP_ENCRYPTION": "ssl"},
+		"timeout":    {"SMTP_SEND_TIMEOUT": "30"},
+		"plain text": {"SMTP_HOST": "smtp.example.com", "SMTP_USERNAME": "bnb", "SMTP_PASSWORD": "secret"},
+	}
+
+	for name, set := range tests {
+		if _, err := smtpConfig(smtpEnv(set)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestNewMailer(t *testing.T) {
+	env := smtpEnv(nil)
+	env["MAIL_DIR"] = t.TempDir()
+
+	tests := map[string]any{
+		"":       &mailer.SMTPMailer{},
+		"smtp":   &mailer.SMTPMailer{},
+		"file":   &mailer.File{},
+		"memory": &mailer.Memory{},
+	}
+
+	for backend, expected := range tests {
+		env["MAIL_BACKEND"] = backend
+
+		m, err := newMailer(env)
+		if err != nil {
+			t.Errorf("%q: %v", backend, err)
+			continue
+		}
+
+		if fmt.Sprintf("%T", m) != fmt.Sprintf("%T", expected) {
+			t.Errorf("%q: expected a %T, got a %T", backend, expected, m)
+		}
+	}
+
+	env["MAIL_BACKEND"] = "carrier-pigeon"
+	if _, err := newMailer(env); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+}
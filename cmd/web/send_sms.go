@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/sms"
+)
+
+// setupSMS picks how text messages are sent from the environment, and sets it
+// on the app config. Text messages are off unless SMS_PROVIDER is set.
+func setupSMS() error {
+	provider, err := newSMSProvider(app.EnvVars)
+	if err != nil || provider == nil {
+		return err
+	}
+
+	templates, err := sms.LoadTemplates("./sms_templates")
+	if err != nil {
+		return err
+	}
+
+	app.SMS = provider
+	app.SMSTemplates = templates
+	app.SMSCountryCode = app.EnvVars["SMS_COUNTRY_CODE"].(string)
+
+	// Check the owner's number now, rather than when the first alert fails
+	if owner := app.EnvVars["OWNER_PHONE"].(string); owner != "" {
+		app.OwnerPhone, err = sms.Normalize(owner, app.SMSCountryCode)
+		if err != nil {
+			return fmt.Errorf("OWNER_PHONE: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// newSMSProvider creates the provider SMS_PROVIDER asks for. Nil means text
+// messages are off.
+func newSMSProvider(env map[string]any) (sms.Provider, error) {
+	switch provider := env["SMS_PROVIDER"].(string); provider {
+	case "", "none":
+		return nil, nil
+	case "http":
+		apiURL := env["SMS_API_URL"].(string)
+		if apiURL == "" {
+			return nil, fmt.Errorf("SMS_API_URL is required when SMS_PROVIDER is http")
+		}
+
+		return sms.NewHTTPProvider(apiURL, env["SMS_API_USERNAME"].(string), env["SMS_API_PASSWORD"].(string), env["SMS_FROM"].(string)), nil
+	case "fake":
+		return sms.NewFake(log.New(os.Stdout, "SMS\t", log.Ldate|log.Ltime)), nil
+	default:
+		return nil, fmt.Errorf("SMS_PROVIDER must be none, http or fake, not %q", provider)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/sms"
+)
+
+func TestNewSMSProvider(t *testing.T) {
+	env := map[string]any{
+		"SMS_API_URL":      "https://sms.example.com/messages",
+		"SMS_API_USERNAME": "bnb",
+		"SMS_API_PASSWORD": "secret",
+		"SMS_FROM":         "+15555550000",
+	}
+
+	for _, off := range []string{"", "none"} {
+		env["SMS_PROVIDER"] = off
+		if p, err := newSMSProvider(env); p != nil || err != nil {
+			t.Errorf("%q: expected text messages to be off, got %v, %v", off, p, err)
+		}
+	}
+
+	env["SMS_PROVIDER"] = "http"
+	if p, err := newSMSProvider(env); err != nil {
+		t.Error(err)
+	} else if h, ok := p.(*sms.HTTPProvider); !ok || h.URL != "https://sms.example.com/messages" || h.From != "+15555550000" {
+		t.Errorf("expected an HTTP provider, got %#v", p)
+	}
+
+	env["SMS_PROVIDER"] = "fake"
+	if p, _ := newSMSProvider(env); p == nil {
+		t.Error("expected a fake provider")
+	}
+
+	env["SMS_PROVIDER"] = "http"
+	env["SMS_API_URL"] = ""
+	if _, err := newSMSProvider(env); err == nil {
+		t.Error("expected an error without an API URL")
+	}
+
+	env["SMS_PROVIDER"] = "pager"
+	if _, err := newSMSProvider(env); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+}
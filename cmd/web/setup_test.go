@@ -1,14 +1,29 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"testing"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/handlers"
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/alexedwards/scs/v2"
 )
 
 // TestMain sets up the testing environment and runs the tests. It is the
 // entrypoint for the testing framework.
 func TestMain(m *testing.M) {
+	app.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	// Middleware that reads the session needs a session manager
+	session = scs.New()
+	app.Session = session
+	helpers.NewHelpers(&app)
+
+	// Middleware that looks things up in the database uses the test repo
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+
 	os.Exit(m.Run())
 }
 
@@ -17,3 +32,19 @@ type myHandler struct{}
 
 // ServeHTTP only exists to satisfy the http.Handler interface
 func (h *myHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+// scopeHandler is a dummy handler that records the access token scope of the
+// request it was called with
+type scopeHandler struct {
+	called bool
+	scope  string
+}
+
+// ServeHTTP records that the handler was reached
+func (h *scopeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.called = true
+
+	if t, ok := helpers.AccessTokenFromRequest(r); ok {
+		h.scope = t.Scope
+	}
+}
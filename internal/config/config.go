@@ -3,8 +3,15 @@ package config
 import (
 	"html/template"
 	"log"
+	"net/netip"
+	"time"
 
-	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/emails"
+	"github.com/BlackSound1/Go-B-and-B/internal/mailer"
+	"github.com/BlackSound1/Go-B-and-B/internal/notify"
+	"github.com/BlackSound1/Go-B-and-B/internal/oidc"
+	"github.com/BlackSound1/Go-B-and-B/internal/payments"
+	"github.com/BlackSound1/Go-B-and-B/internal/sms"
 	"github.com/alexedwards/scs/v2"
 )
 
@@ -16,6 +23,57 @@ type AppConfig struct {
 	ErrorLog      *log.Logger
 	InProduction  bool
 	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	Emails        *emails.Templates
+	Mailer        mailer.Mailer
 	EnvVars       map[string]any
+
+	// The property's address, and when guests can check in and must check
+	// out, as how long after midnight
+	PropertyAddress string
+	CheckIn         time.Duration
+	CheckOut        time.Duration
+
+	// Who invoices are from. Empty details are left off
+	PropertyName  string
+	PropertyEmail string
+	PropertyPhone string
+	PropertyTaxID string // e.g. a VAT or GST number
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header is
+	// believed. Requests from anywhere else are taken at their word
+	TrustedProxies []netip.Prefix
+
+	// When the daily digest is sent, as how long after midnight
+	DigestTime time.Duration
+
+	// BookingHorizon is how many days ahead guests can book. 0 means there
+	// is no limit
+	BookingHorizon int
+
+	// ICalSecret signs the secret URLs of the calendar feeds. Feeds are off
+	// if it's empty
+	ICalSecret []byte
+
+	// Notifications passes live notifications on to staff in the admin area
+	Notifications *notify.Broker
+
+	// OIDC is the identity provider staff can log in with. Nil if single
+	// sign-on isn't set up
+	OIDC            *oidc.Provider
+	OIDCGroupLevels map[string]int // Identity provider groups to AccessLevel
+
+	// SMS sends text messages to guests who opt in, and the owner. Nil if
+	// text messages are off
+	SMS            sms.Provider
+	SMSTemplates   *sms.Templates
+	SMSCountryCode string // For phone numbers given without one
+	OwnerPhone     string // Where new booking alerts go. Empty sends none
+
+	// Currency is what room prices are in, and guests pay in. ISO 4217, e.g. USD
+	Currency string
+
+	// Payments takes payment for reservations when they're made. Nil if
+	// guests don't pay when booking
+	Payments       payments.Provider
+	DepositPercent int // How much of the total a deposit is. 0 only offers paying in full
 }
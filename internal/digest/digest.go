@@ -0,0 +1,121 @@
+// Package digest emails the users who opted in an overview of the day's
+// bookings, once a day at a set time: arrivals, departures, guests staying,
+// new bookings and cancellations, reservations waiting to be processed and
+// blocked days coming up.
+package digest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/emails"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// blockDays is how many days of upcoming blocks the digest includes
+const blockDays = 7
+
+// Store is what the Sender needs from the database
+type Store interface {
+	DigestRecipients(day time.Time) ([]models.User, error)
+	GetDigest(day, since, blocksUntil time.Time) (models.Digest, error)
+	QueueDigest(userID int, day time.Time, msg models.MailData) (bool, error)
+}
+
+// Config is when the digest is sent and what goes in it
+type Config struct {
+	Time    time.Duration // Local time of day, as how long after midnight
+	SiteURL string
+	From    string
+}
+
+// Sender queues the daily digest for each user who opted in
+type Sender struct {
+	Store     Store
+	Templates *emails.Templates
+	Config    Config
+	ErrorLog  *log.Logger
+}
+
+// New creates a Sender
+func New(store Store, templates *emails.Templates, cfg Config, errorLog *log.Logger) *Sender {
+	return &Sender{
+		Store:     store,
+		Templates: templates,
+		Config:    cfg,
+		ErrorLog:  errorLog,
+	}
+}
+
+// Run queues due digests straight away, and then every interval until ctx is
+// done
+func (s *Sender) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.SendDue(time.Now())
+		if err != nil {
+			s.ErrorLog.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue queues the digest for the day of now to every user who opted in and
+// hasn't had it yet, once it's past the time of day it's sent at. A user who
+// opts in later in the day gets it the next time this runs.
+func (s *Sender) SendDue(now time.Time) error {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if now.Before(midnight.Add(s.Config.Time)) {
+		return nil
+	}
+
+	// Dates are stored at midnight UTC
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	users, err := s.Store.DigestRecipients(day)
+	if err != nil || len(users) == 0 {
+		return err
+	}
+
+	digest, err := s.Store.GetDigest(day, now.Add(-24*time.Hour), day.AddDate(0, 0, blockDays))
+	if err != nil {
+		return err
+	}
+
+	html, text, err := s.Templates.Render(emails.OwnerDigest, emails.DigestEmail{
+		Digest:  digest,
+		Date:    day,
+		Days:    blockDays,
+		SiteURL: s.Config.SiteURL,
+	})
+	if err != nil {
+		return fmt.Errorf("rendering %s: %w", emails.OwnerDigest, err)
+	}
+
+	// One that fails is logged, and tried again next time
+	for _, user := range users {
+		msg := models.MailData{
+			To:      user.Email,
+			From:    s.Config.From,
+			Subject: "Go B & B daily digest for " + day.Format("Monday, January 2"),
+			HTML:    html,
+			Text:    text,
+		}
+
+		_, err := s.Store.QueueDigest(user.ID, day, msg)
+		if err != nil {
+			s.ErrorLog.Printf("queueing daily digest for user %d: %v", user.ID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package digest
+
+import (
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/emails"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// memoryStore keeps users, and the days their digest was sent, in memory
+type memoryStore struct {
+	users  []models.User
+	sentOn map[int]time.Time // User ID to day
+	digest models.Digest
+	queued []models.MailData
+}
+
+func (s *memoryStore) DigestRecipients(day time.Time) ([]models.User, error) {
+	var found []models.User
+
+	for _, u := range s.users {
+		if u.DailyDigest && s.sentOn[u.ID].Before(day) {
+			found = append(found, u)
+		}
+	}
+
+	return found, nil
+}
+
+func (s *memoryStore) GetDigest(day, since, blocksUntil time.Time) (models.Digest, error) {
+	return s.digest, nil
+}
+
+func (s *memoryStore) QueueDigest(userID int, day time.Time, msg models.MailData) (bool, error) {
+	if !s.sentOn[userID].Before(day) {
+		return false, nil
+	}
+
+	s.sentOn[userID] = day
+	s.queued = append(s.queued, msg)
+
+	return true, nil
+}
+
+func newSender(t *testing.T, store Store) *Sender {
+	templates, err := emails.Load("./../../email_templates", "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(store, templates, Config{
+		Time:    7 * time.Hour,
+		SiteURL: "https://bnb.example.com",
+		From:    "me@here.com",
+	}, log.New(io.Discard, "", 0))
+}
+
+func TestSendDue(t *testing.T) {
+	store := &memoryStore{
+		users: []models.User{
+			{ID: 1, Email: "owner@example.com", DailyDigest: true},
+			{ID: 2, Email: "staff@example.com", DailyDigest: true},
+			{ID: 3, Email: "nope@example.com"},
+		},
+		sentOn: map[int]time.Time{},
+		digest: models.Digest{
+			Arrivals: []models.Reservation{{ID: 7, FirstName: "John", LastName: "Smith", Room: models.Room{RoomName: "General's Quarters"}}},
+		},
+	}
+	s := newSender(t, store)
+
+	// Too early in the day
+	err := s.SendDue(time.Date(2050, 1, 1, 6, 59, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.queued) != 0 {
+		t.Fatalf("expected nothing before 7 AM, got %d emails", len(store.queued))
+	}
+
+	err = s.SendDue(time.Date(2050, 1, 1, 7, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.queued) != 2 || store.queued[0].To != "owner@example.com" || store.queued[1].To != "staff@example.com" {
+		t.Fatalf("expected a digest for each user who opted in, got %v", store.queued)
+	}
+
+	if !strings.Contains(store.queued[0].Text, "John Smith") || !strings.Contains(store.queued[0].Subject, "Saturday, January 1") {
+		t.Errorf("expected the digest to have the day's arrivals, got %q: %q", store.queued[0].Subject, store.queued[0].Text)
+	}
+
+	// Only once a day
+	err = s.SendDue(time.Date(2050, 1, 1, 18, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.queued) != 2 {
+		t.Errorf("expected the digest to be sent once a day, got %d emails", len(store.queued))
+	}
+
+	err = s.SendDue(time.Date(2050, 1, 2, 7, 30, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.queued) != 4 {
+		t.Errorf("expected the next day's digest, got %d emails", len(store.queued))
+	}
+}
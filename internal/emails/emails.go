@@ -0,0 +1,193 @@
+// Package emails renders the emails the app sends, from the templates in
+// email_templates. Each email has an HTML page (name.page.html) and a plain
+// text page (name.page.txt), which share the layouts and partials.
+package emails
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/pricing"
+)
+
+// The emails there are templates for
+const (
+	GuestConfirmation = "guest_confirmation"
+	OwnerConfirmation = "owner_confirmation"
+	GuestInvitation   = "guest_invitation"
+	GuestVerifyEmail  = "guest_verify_email"
+	PreArrival        = "pre_arrival"
+	PostStay          = "post_stay"
+
+	GuestConfirmedByOwner = "guest_confirmed_by_owner"
+	GuestChanges          = "guest_changes"
+	GuestCancellation     = "guest_cancellation"
+
+	OwnerDigest = "owner_digest"
+)
+
+// ReservationEmail is the data for emails about a reservation
+type ReservationEmail struct {
+	Reservation models.Reservation // Must include the room
+	SiteURL     string
+}
+
+// AdminURL links to the reservation in the admin area
+func (e ReservationEmail) AdminURL() string {
+	return fmt.Sprintf("%s/admin/reservations/all/%d/show", e.SiteURL, e.Reservation.ID)
+}
+
+// Change is one detail of a reservation that was changed
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// ChangesEmail is the data for the email telling a guest what was changed
+// about their reservation
+type ChangesEmail struct {
+	ReservationEmail
+	Changes []Change
+}
+
+// StayEmail is the data for the emails sent to guests before and after their
+// stay
+type StayEmail struct {
+	ReservationEmail
+	CheckInTime string
+	ReviewURL   string
+}
+
+// DigestEmail is the data for the daily digest sent to the owner and staff
+type DigestEmail struct {
+	Digest  models.Digest
+	Date    time.Time
+	Days    int // How many days of blocks are included
+	SiteURL string
+}
+
+// ReservationURL links to a reservation in the admin area
+func (e DigestEmail) ReservationURL(id int) string {
+	return fmt.Sprintf("%s/admin/reservations/all/%d/show", e.SiteURL, id)
+}
+
+// DigestSection is one list of reservations in the daily digest
+type DigestSection struct {
+	Title        string
+	Note         string
+	Reservations []models.Reservation
+}
+
+// Sections are the lists of reservations in the digest, in order
+func (e DigestEmail) Sections() []DigestSection {
+	return []DigestSection{
+		{Title: "Arriving Today", Reservations: e.Digest.Arrivals},
+		{Title: "Leaving Today", Reservations: e.Digest.Departures},
+		{Title: "Staying Tonight", Reservations: e.Digest.InHouse},
+		{Title: "New Bookings", Note: "In the last 24 hours", Reservations: e.Digest.NewBookings},
+		{Title: "Cancellations", Note: "In the last 24 hours", Reservations: e.Digest.Cancellations},
+		{Title: "Waiting to be Processed", Reservations: e.Digest.Unprocessed},
+	}
+}
+
+// InviteEmail is the data for the email inviting a guest to set up an account
+type InviteEmail struct {
+	Link          string
+	ExpiresInDays int
+	SiteURL       string
+}
+
+// Templates are the parsed email templates, ready to render
+type Templates struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// Load parses every email template in dir. Each email must have both an HTML
+// and a plain text page. Amounts of money are shown in currency.
+func Load(dir, currency string) (*Templates, error) {
+	t := &Templates{
+		html: map[string]*htmltemplate.Template{},
+		text: map[string]*texttemplate.Template{},
+	}
+
+	money := func(cents int) string {
+		return pricing.FormatMoney(cents, currency)
+	}
+
+	pages, err := filepath.Glob(filepath.Join(dir, "*.page.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("emails: no templates in %s", dir)
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".page.html")
+
+		// Layouts and partials are parsed first, so the page's blocks replace
+		// the defaults in the layout
+		html, err := htmltemplate.New(filepath.Base(page)).Funcs(htmltemplate.FuncMap{"money": money}).ParseGlob(filepath.Join(dir, "*.layout.html"))
+		if err == nil {
+			html, err = html.ParseGlob(filepath.Join(dir, "*.partial.html"))
+		}
+		if err == nil {
+			html, err = html.ParseFiles(page)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("emails: parsing %s: %w", page, err)
+		}
+
+		textPage := filepath.Join(dir, name+".page.txt")
+
+		text, err := texttemplate.New(filepath.Base(textPage)).Funcs(texttemplate.FuncMap{"money": money}).ParseGlob(filepath.Join(dir, "*.layout.txt"))
+		if err == nil {
+			text, err = text.ParseGlob(filepath.Join(dir, "*.partial.txt"))
+		}
+		if err == nil {
+			text, err = text.ParseFiles(textPage)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("emails: parsing %s: %w", textPage, err)
+		}
+
+		t.html[name] = html
+		t.text[name] = text
+	}
+
+	return t, nil
+}
+
+// Render renders an email's HTML and plain text bodies
+func (t *Templates) Render(name string, data any) (html, text string, err error) {
+	htmlTemplate, ok := t.html[name]
+	if !ok {
+		return "", "", fmt.Errorf("emails: no template called %s", name)
+	}
+
+	var buf bytes.Buffer
+
+	err = htmlTemplate.Execute(&buf, data)
+	if err != nil {
+		return "", "", fmt.Errorf("emails: rendering %s: %w", name, err)
+	}
+
+	html = buf.String()
+	buf.Reset()
+
+	err = t.text[name].Execute(&buf, data)
+	if err != nil {
+		return "", "", fmt.Errorf("emails: rendering %s: %w", name, err)
+	}
+
+	return html, buf.String(), nil
+}
@@ -0,0 +1,126 @@
+package emails
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+var pathToTemplates = "./../../email_templates"
+
+func testReservation() models.Reservation {
+	return models.Reservation{
+		ID:        7,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		Phone:     "555-555-5555",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "General's Quarters"},
+		Total:     27500,
+		Items: []models.LineItem{
+			{Kind: models.LineItemRoom, Description: "General's Quarters", Quantity: 2, UnitPrice: 10000, Amount: 20000},
+			{Kind: models.ChargeFee, Description: "Cleaning", Quantity: 1, UnitPrice: 5000, Amount: 5000},
+			{Kind: models.ChargeTax, Description: "Occupancy tax (10%)", Quantity: 1, UnitPrice: 2500, Amount: 2500},
+		},
+	}
+}
+
+func TestRender(t *testing.T) {
+	templates, err := Load(pathToTemplates, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reservation := ReservationEmail{Reservation: testReservation(), SiteURL: "https://bnb.example.com"}
+	changes := ChangesEmail{ReservationEmail: reservation, Changes: []Change{{Field: "Phone", Old: "555-555-0000", New: "555-555-5555"}}}
+	stay := StayEmail{ReservationEmail: reservation, CheckInTime: "3 PM", ReviewURL: "https://bnb.example.com/review"}
+	digest := DigestEmail{
+		Digest: models.Digest{
+			Arrivals: []models.Reservation{testReservation()},
+			Blocks:   []models.RoomRestriction{{StartDate: time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC), Room: models.Room{RoomName: "Major's Suite"}}},
+		},
+		Date:    time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		Days:    7,
+		SiteURL: "https://bnb.example.com",
+	}
+
+	tests := []struct {
+		name     string
+		data     any
+		expected []string // Must be in both bodies
+	}{
+		{GuestConfirmation, reservation, []string{"2050-01-01 to 2050-01-03", "General", "https://bnb.example.com/", "Occupancy tax (10%)", "$25.00", "$275.00"}},
+		{OwnerConfirmation, reservation, []string{"john@smith.com", "https://bnb.example.com/admin/reservations/all/7/show"}},
+		{GuestInvitation, InviteEmail{Link: "https://bnb.example.com/guest/invite/abc", ExpiresInDays: 7, SiteURL: "https://bnb.example.com"}, []string{"https://bnb.example.com/guest/invite/abc", "expires in 7 days"}},
+		{GuestVerifyEmail, InviteEmail{Link: "https://bnb.example.com/guest/account/email/abc", ExpiresInDays: 1, SiteURL: "https://bnb.example.com"}, []string{"https://bnb.example.com/guest/account/email/abc", "expires in 1 day."}},
+		{PreArrival, stay, []string{"Check-in is from 3 PM on Saturday, January 1", "2050-01-01 to 2050-01-03"}},
+		{GuestConfirmedByOwner, reservation, []string{"confirmed your stay", "2050-01-01 to 2050-01-03"}},
+		{GuestChanges, changes, []string{"Phone", "555-555-0000", "555-555-5555"}},
+		{GuestCancellation, reservation, []string{"has been cancelled", "2050-01-01 to 2050-01-03"}},
+		{PostStay, stay, []string{"General", "https://bnb.example.com/review"}},
+		{OwnerDigest, digest, []string{"Saturday, January 1", "Arriving Today", "https://bnb.example.com/admin/reservations/all/7/show", "Major", "2050-01-05 to 2050-01-06"}},
+		{OwnerDigest, DigestEmail{Date: digest.Date, SiteURL: digest.SiteURL}, []string{"Nothing to report today"}},
+	}
+
+	for _, test := range tests {
+		html, text, err := templates.Render(test.name, test.data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if !strings.Contains(html, "<html") {
+			t.Errorf("%s: expected an HTML body, got %q", test.name, html)
+		}
+
+		if strings.Contains(text, "<") {
+			t.Errorf("%s: expected no HTML in the text body, got %q", test.name, text)
+		}
+
+		for _, expected := range test.expected {
+			if !strings.Contains(html, expected) || !strings.Contains(text, expected) {
+				t.Errorf("%s: expected %q in both bodies, got %q and %q", test.name, expected, html, text)
+			}
+		}
+	}
+}
+
+func TestRenderEscapesGuestDetails(t *testing.T) {
+	templates, err := Load(pathToTemplates, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reservation := testReservation()
+	reservation.FirstName = "<b>John</b>"
+
+	html, _, err := templates.Render(OwnerConfirmation, ReservationEmail{Reservation: reservation})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(html, "<b>John</b>") || !strings.Contains(html, "&lt;b&gt;John&lt;/b&gt;") {
+		t.Errorf("expected the guest's name to be escaped, got %q", html)
+	}
+}
+
+func TestRenderUnknown(t *testing.T) {
+	templates, err := Load(pathToTemplates, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := templates.Render("nope", nil); err == nil {
+		t.Error("expected an error for a template that doesn't exist")
+	}
+}
+
+func TestLoadMissingDir(t *testing.T) {
+	if _, err := Load(t.TempDir(), "USD"); err == nil {
+		t.Error("expected an error when there are no templates")
+	}
+}
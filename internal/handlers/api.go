@@ -0,0 +1,659 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/emails"
+	"github.com/BlackSound1/Go-B-and-B/internal/forms"
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/ical"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/repository"
+	"github.com/BlackSound1/Go-B-and-B/internal/sms"
+	"github.com/BlackSound1/Go-B-and-B/internal/webhooks"
+	"github.com/go-chi/chi"
+)
+
+// apiReservation is the JSON representation of a reservation
+type apiReservation struct {
+	ID          int    `json:"id"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	Email       string `json:"email"`
+	Phone       string `json:"phone"`
+	StartDate   string `json:"start_date"`
+	EndDate     string `json:"end_date"`
+	RoomID      int    `json:"room_id"`
+	RoomName    string `json:"room_name"`
+	Processed   bool   `json:"processed"`
+	CancelledAt string `json:"cancelled_at,omitempty"`
+}
+
+// newAPIReservation converts a reservation to its JSON representation.
+func newAPIReservation(res models.Reservation) apiReservation {
+	out := apiReservation{
+		ID:        res.ID,
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Email:     res.Email,
+		Phone:     res.Phone,
+		StartDate: res.StartDate.Format("2006-01-02"),
+		EndDate:   res.EndDate.Format("2006-01-02"),
+		RoomID:    res.RoomID,
+		RoomName:  res.Room.RoomName,
+		Processed: res.Processed == 1,
+	}
+
+	if res.Cancelled() {
+		out.CancelledAt = res.CancelledAt.Format(time.RFC3339)
+	}
+
+	return out
+}
+
+// apiRoom is the JSON representation of a room
+type apiRoom struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// apiAvailability is the JSON representation of a room's availability for a stay
+type apiAvailability struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Available bool   `json:"available"`
+}
+
+// apiReservationRequest is the JSON request body for creating a reservation
+type apiReservationRequest struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	SMSOptIn  bool   `json:"sms_opt_in"` // Text the guest about their stay, if text messages are on
+	Guests    int    `json:"guests"`     // How many people are staying. Left out, it's 1
+}
+
+// apiReservationUpdate is the JSON request body for updating a reservation.
+// Fields that are left out are not changed
+type apiReservationUpdate struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Email     *string `json:"email"`
+	Phone     *string `json:"phone"`
+	Processed *bool   `json:"processed"`
+}
+
+// apiCancelRequest is the optional JSON body of a request to cancel a reservation
+type apiCancelRequest struct {
+	SuppressEmail bool `json:"suppress_email"` // Don't email the guest
+}
+
+// apiBlock is the JSON representation of an owner block spanning one or more nights
+type apiBlock struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+// APIReservations returns all reservations as JSON.
+func (m *Repository) APIReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// Always return a list, even if there are no reservations
+	out := []apiReservation{}
+	for _, res := range reservations {
+		out = append(out, newAPIReservation(res))
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, out)
+}
+
+// APIPostBlock blocks a room for every night from start_date up to, but not
+// including, end_date. If end_date is left out, only start_date is blocked.
+func (m *Repository) APIPostBlock(w http.ResponseWriter, r *http.Request) {
+	var block apiBlock
+
+	if !readJSON(w, r, &block) {
+		return
+	}
+
+	layout := "2006-01-02"
+
+	startDate, err := time.Parse(layout, block.StartDate)
+	if err != nil {
+		helpers.JSONError(w, http.StatusBadRequest, "start_date must be a date in the format YYYY-MM-DD")
+		return
+	}
+
+	endDate := startDate.AddDate(0, 0, 1)
+	if block.EndDate != "" {
+		endDate, err = time.Parse(layout, block.EndDate)
+		if err != nil || !endDate.After(startDate) {
+			helpers.JSONError(w, http.StatusBadRequest, "end_date must be a date in the format YYYY-MM-DD after start_date")
+			return
+		}
+	}
+
+	_, err = m.DB.GetRoomByID(block.RoomID)
+	if err != nil {
+		helpers.JSONError(w, http.StatusNotFound, "Room not found")
+		return
+	}
+
+	// Blocks are stored one night at a time, the same as on the reservation calendar
+	for d := startDate; d.Before(endDate); d = d.AddDate(0, 0, 1) {
+		err := m.DB.InsertBlockForRoom(block.RoomID, d)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.emitBlock(webhooks.BlockAdded, block.RoomID, d)
+	}
+
+	block.EndDate = endDate.Format(layout)
+
+	helpers.WriteJSON(w, http.StatusCreated, block)
+}
+
+// APIRooms returns all rooms as JSON.
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	out := []apiRoom{}
+	for _, room := range rooms {
+		out = append(out, apiRoom{ID: room.ID, Name: room.RoomName})
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, out)
+}
+
+// APIRoom returns a single room as JSON.
+func (m *Repository) APIRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, apiRoom{ID: room.ID, Name: room.RoomName})
+}
+
+// APIAvailability returns the rooms that are free for a whole stay, given as
+// the start and end query parameters.
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	fields := map[string]string{}
+
+	startDate, endDate := parseAPIStay(r.URL.Query().Get("start"), r.URL.Query().Get("end"), "start", "end", fields)
+	if len(fields) > 0 {
+		badRequestFields(w, "Some query parameters are invalid", fields)
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	out := []apiRoom{}
+	for _, room := range rooms {
+		out = append(out, apiRoom{ID: room.ID, Name: room.RoomName})
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, out)
+}
+
+// APIRoomAvailability returns whether a room is free for a whole stay, given
+// as the start and end query parameters.
+func (m *Repository) APIRoomAvailability(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	fields := map[string]string{}
+
+	startDate, endDate := parseAPIStay(r.URL.Query().Get("start"), r.URL.Query().Get("end"), "start", "end", fields)
+	if len(fields) > 0 {
+		badRequestFields(w, "Some query parameters are invalid", fields)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, apiAvailability{
+		RoomID:    room.ID,
+		StartDate: startDate.Format("2006-01-02"),
+		EndDate:   endDate.Format("2006-01-02"),
+		Available: available,
+	})
+}
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key header accepted
+const maxIdempotencyKeyLength = 255
+
+// APIPostReservation books a room. It is the JSON equivalent of PostReservation,
+// and sends the same emails. If the room is already taken, it responds with 409.
+// Requests with an Idempotency-Key header that already made a reservation get
+// that reservation back, instead of making another.
+func (m *Repository) APIPostReservation(w http.ResponseWriter, r *http.Request) {
+	var body apiReservationRequest
+
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	header := r.Header.Get("Idempotency-Key")
+	if len(header) > maxIdempotencyKeyLength {
+		helpers.JSONError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
+		return
+	}
+
+	// Keys are chosen by the client, so they only replay requests from the
+	// same client: the same access token, or anyone without one
+	scope := "api"
+	if t, ok := helpers.AccessTokenFromRequest(r); ok {
+		scope = fmt.Sprintf("api:%d", t.ID)
+	}
+
+	key := helpers.IdempotencyKey(scope, header)
+
+	if res, ok := m.replayedReservation(key); ok {
+		replayAPIReservation(w, res, body)
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName: body.FirstName,
+		LastName:  body.LastName,
+		Email:     strings.TrimSpace(body.Email),
+		Phone:     body.Phone,
+		RoomID:    body.RoomID,
+		Guests:    max(body.Guests, 1),
+
+		IdempotencyKey: key,
+		SMSOptIn:       m.App.SMS != nil && body.SMSOptIn,
+	}
+
+	fields := validateAPIReservation(reservation)
+
+	if reservation.SMSOptIn {
+		if _, err := sms.Normalize(reservation.Phone, m.App.SMSCountryCode); err != nil {
+			fields["phone"] = "Must be a mobile number to get text messages"
+		}
+	}
+
+	if body.Guests < 0 || body.Guests > maxGuests {
+		fields["guests"] = fmt.Sprintf("Must be from 1 to %d guests", maxGuests)
+	}
+
+	reservation.StartDate, reservation.EndDate = parseAPIStay(body.StartDate, body.EndDate, "start_date", "end_date", fields)
+
+	room, err := m.DB.GetRoomByID(body.RoomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		fields["room_id"] = "Room not found"
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if len(fields) > 0 {
+		helpers.JSONFieldErrors(w, fields)
+		return
+	}
+
+	// Stays can only be booked if an availability search would offer them
+	m.checkBookingHorizon(reservation.StartDate, reservation.EndDate, "start_date", "end_date", fields)
+
+	if _, ok := fields["end_date"]; !ok && reservation.Nights() < room.MinNights() {
+		fields["end_date"] = fmt.Sprintf("Stays in this room must be at least %d nights", room.MinNights())
+	}
+
+	if len(fields) > 0 {
+		helpers.JSONFieldErrors(w, fields)
+		return
+	}
+
+	reservation.Room = room
+
+	err = m.priceStay(&reservation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// Link the reservation to a guest account, the same as PostReservation
+	var invite string
+	reservation.GuestID, invite, err = m.findOrInviteGuest(reservation)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	reservation.ID, err = m.DB.InsertReservation(reservation, m.reservationEmails(reservation))
+	if err != nil {
+		// Another request with the same key may have just made the reservation
+		if res, ok := m.replayedReservation(key); ok {
+			replayAPIReservation(w, res, body)
+			return
+		}
+
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			helpers.JSONError(w, http.StatusConflict, "The room is not available for those dates")
+			return
+		}
+
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.emitReservation(webhooks.ReservationCreated, reservation)
+
+	// Invite new guests to set up an account to see their bookings
+	if invite != "" {
+		m.sendGuestInvite(reservation.Email, invite)
+	}
+
+	m.textReservation(reservation)
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/reservations/%d", reservation.ID))
+	helpers.WriteJSON(w, http.StatusCreated, newAPIReservation(reservation))
+}
+
+// replayAPIReservation responds to a request whose Idempotency-Key already made
+// a reservation the same way the first request was answered. A key can't be
+// reused for a different stay.
+func replayAPIReservation(w http.ResponseWriter, res models.Reservation, body apiReservationRequest) {
+	if body.RoomID != res.RoomID || body.StartDate != res.StartDate.Format("2006-01-02") ||
+		!strings.EqualFold(strings.TrimSpace(body.Email), res.Email) {
+		helpers.JSONError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different reservation")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/reservations/%d", res.ID))
+	w.Header().Set("Idempotent-Replayed", "true")
+	helpers.WriteJSON(w, http.StatusCreated, newAPIReservation(res))
+}
+
+// APIReservation returns a single reservation as JSON.
+func (m *Repository) APIReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, newAPIReservation(res))
+}
+
+// APIPatchReservation updates a reservation's guest details and whether it has
+// been processed. Cancelled reservations can't be changed.
+func (m *Repository) APIPatchReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var body apiReservationUpdate
+
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	if res.Cancelled() {
+		helpers.JSONError(w, http.StatusConflict, "Cancelled reservations can't be changed")
+		return
+	}
+
+	if body.FirstName != nil {
+		res.FirstName = *body.FirstName
+	}
+	if body.LastName != nil {
+		res.LastName = *body.LastName
+	}
+	if body.Email != nil {
+		res.Email = strings.TrimSpace(*body.Email)
+	}
+	if body.Phone != nil {
+		res.Phone = *body.Phone
+	}
+
+	fields := validateAPIReservation(res)
+	if len(fields) > 0 {
+		helpers.JSONFieldErrors(w, fields)
+		return
+	}
+
+	// The details and whether it's processed are saved together, so a failure
+	// can't leave half of the change behind
+	var processedNow bool
+
+	if body.Processed != nil {
+		res.Processed = 0
+		if *body.Processed {
+			res.Processed = 1
+		}
+
+		changed, err := m.DB.UpdateReservationAndProcessed(res)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		processedNow = changed && res.Processed == 1
+	} else {
+		err := m.DB.UpdateReservation(res)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.emitReservation(webhooks.ReservationUpdated, res)
+	if processedNow {
+		m.emitReservation(webhooks.ReservationProcessed, res)
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, newAPIReservation(res))
+}
+
+// APICancelReservation cancels a reservation, which frees up the room again.
+// The guest is emailed unless the body asks not to.
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	// The body is optional
+	var body apiCancelRequest
+
+	if r.ContentLength != 0 && !readJSON(w, r, &body) {
+		return
+	}
+
+	if res.Cancelled() {
+		helpers.JSONError(w, http.StatusConflict, "The reservation is already cancelled")
+		return
+	}
+
+	// Another request may have just cancelled it, and told everyone
+	cancelled, err := m.DB.CancelReservation(res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	} else if !cancelled {
+		helpers.JSONError(w, http.StatusConflict, "The reservation is already cancelled")
+		return
+	}
+
+	res.CancelledAt = time.Now()
+
+	m.emitReservation(webhooks.ReservationCancelled, res)
+
+	// Let the guest know, and take the stay off their calendar
+	if !body.SuppressEmail && res.Email != "" {
+		data := emails.ReservationEmail{Reservation: res, SiteURL: helpers.BaseURL()}
+		m.queueGuestEmail(res, "Your Reservation Was Cancelled", emails.GuestCancellation, data, m.updatedInvite(res, ical.MethodCancel)...)
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, newAPIReservation(res))
+}
+
+// APINotFound responds to unknown API routes with a JSON error
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	helpers.JSONError(w, http.StatusNotFound, "Not found")
+}
+
+// APIMethodNotAllowed responds to API requests with the wrong method with a JSON error
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	helpers.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// apiRoomFromURL looks up the room with the ID in the URL. If there is no such
+// room, it writes a JSON error and ok is false.
+func (m *Repository) apiRoomFromURL(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.JSONError(w, http.StatusNotFound, "Room not found")
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(id)
+	if err != nil {
+		helpers.JSONError(w, http.StatusNotFound, "Room not found")
+		return room, false
+	}
+
+	return room, true
+}
+
+// apiReservationFromURL looks up the reservation with the ID in the URL. If
+// there is no such reservation, it writes a JSON error and ok is false.
+func (m *Repository) apiReservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.JSONError(w, http.StatusNotFound, "Reservation not found")
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.JSONError(w, http.StatusNotFound, "Reservation not found")
+		return res, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+
+	res.ID = id
+
+	return res, true
+}
+
+// readJSON decodes a JSON request body into dst. Unknown fields are rejected
+// so typos don't go unnoticed. If the body can't be decoded, it writes a JSON
+// error and returns false.
+func readJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		helpers.JSONError(w, http.StatusBadRequest, "Request body must be valid JSON: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+// validateAPIReservation checks a reservation's guest details with the same
+// rules as the reservation form, and returns what's wrong with each field.
+func validateAPIReservation(res models.Reservation) map[string]string {
+	form := forms.New(url.Values{
+		"first_name": {res.FirstName},
+		"last_name":  {res.LastName},
+		"email":      {res.Email},
+	})
+
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	fields := map[string]string{}
+	for field := range form.Errors {
+		fields[field] = form.Errors.Get(field)
+	}
+
+	return fields
+}
+
+// parseAPIStay parses the start and end dates of a stay. Anything wrong with
+// them is added to fields, under the given field names.
+func parseAPIStay(start, end, startField, endField string, fields map[string]string) (time.Time, time.Time) {
+	layout := "2006-01-02"
+
+	startDate, err := time.Parse(layout, start)
+	if err != nil {
+		fields[startField] = "Must be a date in the format YYYY-MM-DD"
+	}
+
+	endDate, err := time.Parse(layout, end)
+	if err != nil {
+		fields[endField] = "Must be a date in the format YYYY-MM-DD"
+	} else if _, ok := fields[startField]; !ok && !endDate.After(startDate) {
+		fields[endField] = "Must be after " + startField
+	}
+
+	return startDate, endDate
+}
+
+// checkBookingHorizon checks that a stay doesn't start in the past, and ends
+// within the booking horizon. Anything wrong is added to fields.
+func (m *Repository) checkBookingHorizon(start, end time.Time, startField, endField string, fields map[string]string) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if start.Before(today) {
+		fields[startField] = "Must not be in the past"
+	}
+
+	if m.App.BookingHorizon > 0 && end.After(today.AddDate(0, 0, m.App.BookingHorizon)) {
+		fields[endField] = fmt.Sprintf("Must be within %d days from today", m.App.BookingHorizon)
+	}
+}
+
+// badRequestFields writes a 400 JSON error listing what's wrong with each
+// invalid field or query parameter
+func badRequestFields(w http.ResponseWriter, message string, fields map[string]string) {
+	helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorEnvelope{
+		Error: helpers.APIError{
+			Status:  http.StatusBadRequest,
+			Message: message,
+			Fields:  fields,
+		},
+	})
+}
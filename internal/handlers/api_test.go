@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// TestAPIReservations tests that the APIReservations handler returns a JSON list.
+func TestAPIReservations(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/v1/reservations", nil)
+	recorder := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.APIReservations)
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("APIReservations returned wrong response code: got %d, wanted %d", recorder.Code, http.StatusOK)
+	}
+
+	var out []apiReservation
+
+	err := json.Unmarshal(recorder.Body.Bytes(), &out)
+	if err != nil {
+		t.Errorf("APIReservations did not return a JSON list: %s", recorder.Body.String())
+	}
+}
+
+// Create a set of tests to run
+var apiPostBlockTests = []struct {
+	name               string
+	body               string
+	expectedStatusCode int
+	expectedEndDate    string
+}{
+	{"single-night", `{"room_id": 1, "start_date": "2050-01-01"}`, http.StatusCreated, "2050-01-02"},
+	{"several-nights", `{"room_id": 1, "start_date": "2050-01-01", "end_date": "2050-01-04"}`, http.StatusCreated, "2050-01-04"},
+	{"invalid-json", `{"room_id": `, http.StatusBadRequest, ""},
+	{"invalid-start-date", `{"room_id": 1, "start_date": "invalid"}`, http.StatusBadRequest, ""},
+	{"end-before-start", `{"room_id": 1, "start_date": "2050-01-04", "end_date": "2050-01-01"}`, http.StatusBadRequest, ""},
+	{"non-existent-room", `{"room_id": 100, "start_date": "2050-01-01"}`, http.StatusNotFound, ""},
+}
+
+// TestAPIPostBlock tests the APIPostBlock handler for various scenarios.
+func TestAPIPostBlock(t *testing.T) {
+	for _, test := range apiPostBlockTests {
+		req, _ := http.NewRequest("POST", "/api/v1/blocks", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.APIPostBlock)
+		handler.ServeHTTP(recorder, req)
+
+		// Check status code
+		if recorder.Code != test.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatusCode)
+		}
+
+		// Check the block that was created
+		if test.expectedEndDate != "" {
+			var block apiBlock
+
+			_ = json.Unmarshal(recorder.Body.Bytes(), &block)
+
+			if block.EndDate != test.expectedEndDate {
+				t.Errorf("%s returned wrong end date: got %s, wanted %s", test.name, block.EndDate, test.expectedEndDate)
+			}
+		}
+	}
+}
+
+// TestAPIRooms tests that the APIRooms handler returns a JSON list.
+func TestAPIRooms(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/v1/rooms", nil)
+	recorder := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.APIRooms)
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("APIRooms returned wrong response code: got %d, wanted %d", recorder.Code, http.StatusOK)
+	}
+
+	if strings.TrimSpace(recorder.Body.String()) != "[]" {
+		t.Errorf("APIRooms did not return an empty JSON list: %s", recorder.Body.String())
+	}
+}
+
+// Create a set of tests to run
+var apiRoomAvailabilityTests = []struct {
+	name               string
+	id                 string
+	query              string
+	expectedStatusCode int
+	expectedAvailable  bool
+}{
+	{"not-available", "1", "start=2050-01-01&end=2050-01-02", http.StatusOK, false},
+	{"available", "1", "start=2040-01-01&end=2040-01-02", http.StatusOK, true},
+	{"missing-dates", "1", "", http.StatusBadRequest, false},
+	{"end-before-start", "1", "start=2040-01-04&end=2040-01-02", http.StatusBadRequest, false},
+	{"non-existent-room", "100", "start=2040-01-01&end=2040-01-02", http.StatusNotFound, false},
+	{"invalid-room-id", "abc", "start=2040-01-01&end=2040-01-02", http.StatusNotFound, false},
+	{"database-error", "1", "start=2060-01-01&end=2060-01-02", http.StatusInternalServerError, false},
+}
+
+// TestAPIRoomAvailability tests the APIRoomAvailability handler for various scenarios.
+func TestAPIRoomAvailability(t *testing.T) {
+	for _, test := range apiRoomAvailabilityTests {
+		req, _ := http.NewRequest("GET", "/api/v1/rooms/"+test.id+"/availability?"+test.query, nil)
+		req = req.WithContext(addIdToChiContext(req.Context(), test.id))
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.APIRoomAvailability)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatusCode)
+		}
+
+		if recorder.Code == http.StatusOK {
+			var out apiAvailability
+
+			_ = json.Unmarshal(recorder.Body.Bytes(), &out)
+
+			if out.Available != test.expectedAvailable {
+				t.Errorf("%s returned wrong availability: got %t, wanted %t", test.name, out.Available, test.expectedAvailable)
+			}
+		}
+	}
+}
+
+// Create a set of tests to run
+var apiAvailabilityTests = []struct {
+	name               string
+	query              string
+	expectedStatusCode int
+}{
+	{"valid", "start=2040-01-01&end=2040-01-02", http.StatusOK},
+	{"invalid-date", "start=tomorrow&end=2040-01-02", http.StatusBadRequest},
+	{"database-error", "start=2060-01-01&end=2060-01-02", http.StatusInternalServerError},
+}
+
+// TestAPIAvailability tests the APIAvailability handler for various scenarios.
+func TestAPIAvailability(t *testing.T) {
+	for _, test := range apiAvailabilityTests {
+		req, _ := http.NewRequest("GET", "/api/v1/availability?"+test.query, nil)
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.APIAvailability)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatusCode)
+		}
+	}
+}
+
+// apiReservationBody builds a reservation request body for tests
+func apiReservationBody(roomID int, start, end, firstName string) string {
+	return fmt.Sprintf(`{"room_id": %d, "start_date": %q, "end_date": %q, "first_name": %q, "last_name": "Smith", "email": "john@smith.com", "phone": "555-555-5555"}`,
+		roomID, start, end, firstName)
+}
+
+// Create a set of tests to run
+var apiPostReservationTests = []struct {
+	name               string
+	body               string
+	expectedStatusCode int
+	expectedFields     []string
+}{
+	{"valid", apiReservationBody(1, "2040-01-01", "2040-01-02", "John"), http.StatusCreated, nil},
+	{"not-available", apiReservationBody(1, "2050-01-01", "2050-01-02", "John"), http.StatusConflict, nil},
+	{"invalid-json", `{"room_id": `, http.StatusBadRequest, nil},
+	{"unknown-field", `{"room_id": 1, "nights": 2}`, http.StatusBadRequest, nil},
+	{"invalid-fields", apiReservationBody(100, "2040-01-02", "2040-01-01", "J"), http.StatusUnprocessableEntity, []string{"room_id", "end_date", "first_name"}},
+	{"database-error", apiReservationBody(1, "2060-01-01", "2060-01-02", "John"), http.StatusInternalServerError, nil},
+	{"room-lookup-fails", apiReservationBody(99, "2040-01-01", "2040-01-02", "John"), http.StatusInternalServerError, nil},
+	{"insert-reservation-fails", apiReservationBody(2, "2040-01-01", "2040-01-04", "John"), http.StatusInternalServerError, nil},
+	{"shorter-than-min-stay", apiReservationBody(2, "2040-01-01", "2040-01-03", "John"), http.StatusUnprocessableEntity, []string{"end_date"}},
+	{"starts-in-the-past", apiReservationBody(1, "2020-01-01", "2020-01-02", "John"), http.StatusUnprocessableEntity, []string{"start_date"}},
+}
+
+// TestAPIPostReservation tests the APIPostReservation handler for various scenarios.
+func TestAPIPostReservation(t *testing.T) {
+	for _, test := range apiPostReservationTests {
+		req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.APIPostReservation)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatusCode)
+		}
+
+		if recorder.Code == http.StatusCreated && recorder.Header().Get("Location") != "/api/v1/reservations/1" {
+			t.Errorf("%s returned wrong location: %s", test.name, recorder.Header().Get("Location"))
+		}
+
+		// Check that each invalid field is reported
+		if len(test.expectedFields) > 0 {
+			var out struct {
+				Error struct {
+					Fields map[string]string `json:"fields"`
+				} `json:"error"`
+			}
+
+			_ = json.Unmarshal(recorder.Body.Bytes(), &out)
+
+			for _, field := range test.expectedFields {
+				if out.Error.Fields[field] == "" {
+					t.Errorf("%s did not report an error for %s: %s", test.name, field, recorder.Body.String())
+				}
+			}
+		}
+	}
+}
+
+// TestAPIPostReservationInvitesGuest tests that guests who book through the API
+// are invited to set up an account, the same as on the website
+func TestAPIPostReservationInvitesGuest(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(apiReservationBody(1, "2040-01-01", "2040-01-02", "John")))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+
+	sentMail.Reset()
+
+	http.HandlerFunc(Repo.APIPostReservation).ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, recorder.Code)
+	}
+
+	sent := sentMail.Sent()
+	if len(sent) == 0 || sent[len(sent)-1].To != "john@smith.com" || sent[len(sent)-1].Subject != "Set up your Go B & B account" {
+		t.Errorf("expected the guest to be invited last, but sent %v", sent)
+	}
+}
+
+// TestAPIPostReservationBookingHorizon tests that stays can't be booked past the
+// booking horizon
+func TestAPIPostReservationBookingHorizon(t *testing.T) {
+	app.BookingHorizon = 30
+	defer func() { app.BookingHorizon = 0 }()
+
+	start := time.Now().AddDate(0, 0, 1)
+
+	tests := []struct {
+		end      time.Time
+		expected int
+	}{
+		{start.AddDate(0, 0, 2), http.StatusCreated},
+		{start.AddDate(0, 0, 60), http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		body := apiReservationBody(1, start.Format("2006-01-02"), test.end.Format("2006-01-02"), "John")
+
+		req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.APIPostReservation).ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d", body, test.expected, recorder.Code)
+		}
+	}
+}
+
+// Create a set of tests to run
+var apiPostReservationIdempotencyTests = []struct {
+	name               string
+	key                string
+	body               string
+	expectedStatusCode int
+	expectReplay       bool
+	tokenID            int // Access token the request is made with. 0 uses none
+}{
+	{"new-key", "new-key", apiReservationBody(1, "2040-01-01", "2040-01-02", "John"), http.StatusCreated, false, 0},
+	// Room 1 is taken on 2050-01-01, so a new booking would get 409
+	{"replayed-key", "replayed-key", apiReservationBody(1, "2050-01-01", "2050-01-02", "John"), http.StatusCreated, true, 0},
+	{"replayed-key-different-stay", "replayed-key", apiReservationBody(1, "2040-01-01", "2040-01-02", "John"), http.StatusUnprocessableEntity, false, 0},
+	{"replayed-key-other-client", "replayed-key", apiReservationBody(1, "2050-01-01", "2050-01-02", "John"), http.StatusConflict, false, 7},
+	{"key-too-long", strings.Repeat("k", 256), apiReservationBody(1, "2040-01-01", "2040-01-02", "John"), http.StatusBadRequest, false, 0},
+}
+
+// TestAPIPostReservationIdempotency tests that retrying a request with the same
+// Idempotency-Key returns the original reservation instead of making another.
+func TestAPIPostReservationIdempotency(t *testing.T) {
+	for _, test := range apiPostReservationIdempotencyTests {
+		req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", test.key)
+		if test.tokenID > 0 {
+			req = req.WithContext(helpers.WithAccessToken(req.Context(), models.AccessToken{ID: test.tokenID, Scope: models.ScopeWrite}))
+		}
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.APIPostReservation)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatusCode)
+		}
+
+		replayed := recorder.Header().Get("Idempotent-Replayed") == "true"
+		if replayed != test.expectReplay {
+			t.Errorf("%s: expected replayed to be %t, got %t", test.name, test.expectReplay, replayed)
+		}
+	}
+}
+
+// Create a set of tests to run
+var apiReservationTests = []struct {
+	name               string
+	method             string
+	url                string
+	id                 string
+	body               string
+	expectedStatusCode int
+}{
+	{"get", "GET", "/api/v1/reservations/1", "1", "", http.StatusOK},
+	{"get-non-existent", "GET", "/api/v1/reservations/100", "100", "", http.StatusNotFound},
+	{"get-invalid-id", "GET", "/api/v1/reservations/abc", "abc", "", http.StatusNotFound},
+	{"patch", "PATCH", "/api/v1/reservations/1", "1", `{"phone": "555-123-4567", "processed": true}`, http.StatusOK},
+	{"patch-invalid-email", "PATCH", "/api/v1/reservations/1", "1", `{"email": "nope"}`, http.StatusUnprocessableEntity},
+	{"patch-unknown-field", "PATCH", "/api/v1/reservations/1", "1", `{"room_id": 2}`, http.StatusBadRequest},
+	{"patch-cancelled", "PATCH", "/api/v1/reservations/101", "101", `{"phone": "555-123-4567"}`, http.StatusConflict},
+	{"patch-already-processed", "PATCH", "/api/v1/reservations/104", "104", `{"phone": "555-123-4567", "processed": true}`, http.StatusOK},
+	{"patch-fails", "PATCH", "/api/v1/reservations/102", "102", `{"phone": "555-123-4567", "processed": true}`, http.StatusInternalServerError},
+	{"cancel", "POST", "/api/v1/reservations/1/cancel", "1", "", http.StatusOK},
+	{"cancel-non-existent", "POST", "/api/v1/reservations/100/cancel", "100", "", http.StatusNotFound},
+	{"cancel-already-cancelled", "POST", "/api/v1/reservations/101/cancel", "101", "", http.StatusConflict},
+	{"cancel-fails", "POST", "/api/v1/reservations/102/cancel", "102", "", http.StatusInternalServerError},
+	{"cancel-concurrently", "POST", "/api/v1/reservations/105/cancel", "105", "", http.StatusConflict},
+	{"cancel-suppress-email", "POST", "/api/v1/reservations/1/cancel", "1", `{"suppress_email": true}`, http.StatusOK},
+	{"cancel-invalid-body", "POST", "/api/v1/reservations/1/cancel", "1", `{"notify": false}`, http.StatusBadRequest},
+}
+
+// TestAPIReservation tests the staff reservation endpoints for various scenarios.
+func TestAPIReservation(t *testing.T) {
+	for _, test := range apiReservationTests {
+		req, _ := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		req = req.WithContext(addIdToChiContext(req.Context(), test.id))
+		recorder := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		switch {
+		case test.method == "GET":
+			handler = Repo.APIReservation
+		case test.method == "PATCH":
+			handler = Repo.APIPatchReservation
+		default:
+			handler = Repo.APICancelReservation
+		}
+
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatusCode)
+		}
+	}
+}
+
+// TestAPICancelReservationEmail tests that the guest is emailed when their
+// reservation is cancelled, unless the body asks not to
+func TestAPICancelReservationEmail(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected int
+	}{
+		{"", 1},
+		{`{"suppress_email": false}`, 1},
+		{`{"suppress_email": true}`, 0},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/api/v1/reservations/1/cancel", strings.NewReader(test.body))
+		req = req.WithContext(addIdToChiContext(req.Context(), "1"))
+		recorder := httptest.NewRecorder()
+
+		sentMail.Reset()
+
+		http.HandlerFunc(Repo.APICancelReservation).ServeHTTP(recorder, req)
+
+		if sent := len(sentMail.Sent()); sent != test.expected {
+			t.Errorf("%q: expected %d emails, but sent %d", test.body, test.expected, sent)
+		}
+	}
+}
+
+// TestAPIErrorHandlers tests that unknown API routes and methods get JSON errors
+func TestAPIErrorHandlers(t *testing.T) {
+	routes := getRoutes()
+
+	tests := []struct {
+		method   string
+		url      string
+		expected int
+	}{
+		{"GET", "/api/v1/nothing", http.StatusNotFound},
+		{"DELETE", "/api/v1/rooms", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		recorder := httptest.NewRecorder()
+
+		routes.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expected {
+			t.Errorf("%s %s: expected status %d, got %d", test.method, test.url, test.expected, recorder.Code)
+		}
+
+		if recorder.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: expected a JSON error, got %s", test.method, test.url, recorder.Body.String())
+		}
+	}
+}
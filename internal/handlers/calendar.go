@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// What a night on a room's calendar can be
+const (
+	nightAvailable = "available"
+	nightBooked    = "booked"
+	nightBlocked   = "blocked"
+	nightMinStay   = "min_stay" // Free, but a stay starting that night would be too short
+)
+
+// maxCalendarMonths is the most months a calendar can be fetched for at once
+const maxCalendarMonths = 12
+
+// apiCalendar is the JSON representation of a room's calendar
+type apiCalendar struct {
+	RoomID  int        `json:"room_id"`
+	MinStay int        `json:"min_stay"`
+	Nights  []apiNight `json:"nights"`
+}
+
+// apiNight is the JSON representation of one night on a room's calendar
+type apiNight struct {
+	Date   string `json:"date"`
+	Status string `json:"status"`
+}
+
+// APIRoomCalendar returns the status of each night in a room's calendar, from
+// the first of the start month to the end of the end month. Months are given
+// as YYYY-MM, and both default to the current month.
+func (m *Repository) APIRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	fields := map[string]string{}
+
+	now := time.Now()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	firstMonth := parseCalendarMonth(r.URL.Query().Get("start"), thisMonth, "start", fields)
+	lastMonth := parseCalendarMonth(r.URL.Query().Get("end"), firstMonth, "end", fields)
+
+	if len(fields) == 0 {
+		if lastMonth.Before(firstMonth) {
+			fields["end"] = "Must not be before start"
+		} else if lastMonth.After(firstMonth.AddDate(0, maxCalendarMonths-1, 0)) {
+			fields["end"] = "Can be at most 12 months after start"
+		}
+	}
+
+	if len(fields) > 0 {
+		badRequestFields(w, "Some query parameters are invalid", fields)
+		return
+	}
+
+	start := firstMonth
+	end := lastMonth.AddDate(0, 1, 0)
+
+	// Look past the end of the calendar so the minimum stay can be checked
+	// for the last few nights
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, start, end.AddDate(0, 0, room.MinNights()))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, apiCalendar{
+		RoomID:  room.ID,
+		MinStay: room.MinNights(),
+		Nights:  roomCalendar(restrictions, room.MinNights(), start, end),
+	})
+}
+
+// roomCalendar works out the status of each night from start up to, but not
+// including, end.
+func roomCalendar(restrictions []models.RoomRestriction, minStay int, start, end time.Time) []apiNight {
+	layout := "2006-01-02"
+
+	// Restrictions cover the nights from their start date up to their end date
+	taken := map[string]string{}
+	for _, rr := range restrictions {
+		status := nightBlocked
+		if rr.ReservationID > 0 {
+			status = nightBooked
+		}
+
+		for d := rr.StartDate; d.Before(rr.EndDate); d = d.AddDate(0, 0, 1) {
+			taken[d.Format(layout)] = status
+		}
+	}
+
+	nights := []apiNight{}
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		status, ok := taken[d.Format(layout)]
+
+		if !ok {
+			status = nightAvailable
+
+			for i := 1; i < minStay; i++ {
+				if _, ok := taken[d.AddDate(0, 0, i).Format(layout)]; ok {
+					status = nightMinStay
+					break
+				}
+			}
+		}
+
+		nights = append(nights, apiNight{Date: d.Format(layout), Status: status})
+	}
+
+	return nights
+}
+
+// conflictingNights returns the nights from start up to, but not including,
+// end that a room is booked or blocked.
+func (m *Repository) conflictingNights(roomID int, start, end time.Time) ([]string, error) {
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(roomID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var nights []string
+	for _, night := range roomCalendar(restrictions, 1, start, end) {
+		if night.Status != nightAvailable {
+			nights = append(nights, night.Date)
+		}
+	}
+
+	return nights, nil
+}
+
+// parseCalendarMonth parses a month given as YYYY-MM, or returns def if it's
+// empty. If it's invalid, the problem is added to fields.
+func parseCalendarMonth(value string, def time.Time, field string, fields map[string]string) time.Time {
+	if value == "" {
+		return def
+	}
+
+	month, err := time.Parse("2006-01", value)
+	if err != nil {
+		fields[field] = "Must be a month in the format YYYY-MM"
+	}
+
+	return month
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Create a set of tests to run
+var apiRoomCalendarTests = []struct {
+	name               string
+	id                 string
+	query              string
+	expectedStatusCode int
+	expectedNights     int
+	expectedStatuses   map[string]string
+}{
+	{"one-month", "2", "start=2050-01", http.StatusOK, 31, map[string]string{
+		"2050-01-01": nightAvailable,
+		"2050-01-08": nightMinStay,
+		"2050-01-09": nightMinStay,
+		"2050-01-10": nightBooked,
+		"2050-01-11": nightBooked,
+		"2050-01-12": nightAvailable,
+		"2050-01-18": nightMinStay,
+		"2050-01-20": nightBlocked,
+		"2050-01-21": nightAvailable,
+		"2050-01-23": nightMinStay,
+		"2050-01-25": nightBlocked,
+		"2050-01-26": nightBlocked,
+		"2050-01-27": nightAvailable,
+	}},
+	{"month-range", "2", "start=2049-12&end=2050-02", http.StatusOK, 90, nil},
+	{"no-restrictions", "1", "start=2050-01", http.StatusOK, 31, map[string]string{"2050-01-10": nightAvailable}},
+	{"default-month", "1", "", http.StatusOK, 0, nil},
+	{"invalid-month", "1", "start=2050-13", http.StatusBadRequest, 0, nil},
+	{"end-before-start", "1", "start=2050-02&end=2050-01", http.StatusBadRequest, 0, nil},
+	{"too-many-months", "1", "start=2050-01&end=2051-01", http.StatusBadRequest, 0, nil},
+	{"non-existent-room", "100", "start=2050-01", http.StatusNotFound, 0, nil},
+}
+
+// TestAPIRoomCalendar tests the APIRoomCalendar handler for various scenarios.
+func TestAPIRoomCalendar(t *testing.T) {
+	for _, test := range apiRoomCalendarTests {
+		req, _ := http.NewRequest("GET", "/api/v1/rooms/"+test.id+"/calendar?"+test.query, nil)
+		req = req.WithContext(addIdToChiContext(req.Context(), test.id))
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.APIRoomCalendar)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatusCode)
+			continue
+		}
+
+		if recorder.Code != http.StatusOK {
+			continue
+		}
+
+		var calendar apiCalendar
+
+		_ = json.Unmarshal(recorder.Body.Bytes(), &calendar)
+
+		if test.expectedNights > 0 && len(calendar.Nights) != test.expectedNights {
+			t.Errorf("%s returned %d nights, wanted %d", test.name, len(calendar.Nights), test.expectedNights)
+		}
+
+		statuses := map[string]string{}
+		for _, night := range calendar.Nights {
+			statuses[night.Date] = night.Status
+		}
+
+		for date, expected := range test.expectedStatuses {
+			if statuses[date] != expected {
+				t.Errorf("%s: expected %s to be %s, got %s", test.name, date, expected, statuses[date])
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/forms"
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/pricing"
+	"github.com/BlackSound1/Go-B-and-B/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// maxGuests is the most guests one reservation can be for
+const maxGuests = 8
+
+// priceStay itemizes what a reservation costs with the taxes and fees in
+// effect, and sets its total. The reservation's room must have its price.
+func (m *Repository) priceStay(res *models.Reservation) error {
+	charges, err := m.DB.AllCharges()
+	if err != nil {
+		return err
+	}
+
+	res.Items = pricing.Price(res.Room, res.StartDate, res.EndDate, res.Guests, charges)
+	res.Total = pricing.Total(res.Items)
+
+	return nil
+}
+
+// AdminCharges lists the taxes and fees, with a form to add another
+func (m *Repository) AdminCharges(w http.ResponseWriter, r *http.Request) {
+	charges, err := m.DB.AllCharges()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["charges"] = charges
+
+	render.Template(w, r, "admin-charges.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminPostCharge adds a tax or fee. Percentages are given as a percent, and
+// flat amounts in the property's currency. Either date can be left empty for
+// a charge with no start or end.
+func (m *Repository) AdminPostCharge(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "kind", "basis", "amount")
+
+	charge := models.Charge{
+		Name:  strings.TrimSpace(r.Form.Get("name")),
+		Kind:  r.Form.Get("kind"),
+		Basis: r.Form.Get("basis"),
+	}
+
+	if !slices.Contains(pricing.Bases[charge.Kind], charge.Basis) {
+		form.Errors.Add("basis", "Can't charge this kind that way")
+	}
+
+	if charge.Basis == models.ChargePercent {
+		charge.Amount, err = pricing.ParsePercent(r.Form.Get("amount"))
+	} else {
+		charge.Amount, err = pricing.ParseMoney(r.Form.Get("amount"))
+	}
+	if err != nil {
+		form.Errors.Add("amount", "Must be an amount")
+	}
+
+	for field, day := range map[string]*time.Time{"starts_on": &charge.StartsOn, "ends_on": &charge.EndsOn} {
+		if r.Form.Get(field) == "" {
+			continue
+		}
+
+		*day, err = time.Parse("2006-01-02", r.Form.Get(field))
+		if err != nil {
+			form.Errors.Add(field, "Must be a date")
+		}
+	}
+
+	if !charge.StartsOn.IsZero() && !charge.EndsOn.IsZero() && charge.EndsOn.Before(charge.StartsOn) {
+		form.Errors.Add("ends_on", "Can't end before it starts")
+	}
+
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Invalid tax or fee details")
+		http.Redirect(w, r, "/admin/charges", http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.InsertCharge(charge)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't add tax or fee")
+		http.Redirect(w, r, "/admin/charges", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Added "+charge.Name)
+	http.Redirect(w, r, "/admin/charges", http.StatusSeeOther)
+}
+
+// AdminDeleteCharge deletes a tax or fee. Stays already booked keep the
+// price they were booked at.
+func (m *Repository) AdminDeleteCharge(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteCharge(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Tax or fee deleted")
+	http.Redirect(w, r, "/admin/charges", http.StatusSeeOther)
+}
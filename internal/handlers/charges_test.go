@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+var adminChargeTests = []struct {
+	name             string
+	handler          func(*Repository, http.ResponseWriter, *http.Request)
+	id               string
+	postedData       url.Values
+	expectedStatus   int
+	expectedLocation string
+	expectedSession  string // Session key the outcome is reported in
+	expectedMessage  string
+}{
+	{
+		name:             "add-percent-tax",
+		handler:          (*Repository).AdminPostCharge,
+		postedData:       url.Values{"name": {"VAT"}, "kind": {"tax"}, "basis": {"percent"}, "amount": {"12.5"}, "starts_on": {"2050-01-01"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/charges",
+		expectedSession:  "flash",
+		expectedMessage:  "Added VAT",
+	},
+	{
+		name:             "add-fee",
+		handler:          (*Repository).AdminPostCharge,
+		postedData:       url.Values{"name": {"Cleaning"}, "kind": {"fee"}, "basis": {"per_stay"}, "amount": {"50.00"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/charges",
+		expectedSession:  "flash",
+		expectedMessage:  "Added Cleaning",
+	},
+	{
+		name:             "add-wrong-basis",
+		handler:          (*Repository).AdminPostCharge,
+		postedData:       url.Values{"name": {"Cleaning"}, "kind": {"fee"}, "basis": {"percent"}, "amount": {"10"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/charges",
+		expectedSession:  "error",
+		expectedMessage:  "Invalid tax or fee details",
+	},
+	{
+		name:             "add-invalid-amount",
+		handler:          (*Repository).AdminPostCharge,
+		postedData:       url.Values{"name": {"VAT"}, "kind": {"tax"}, "basis": {"percent"}, "amount": {"150"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/charges",
+		expectedSession:  "error",
+		expectedMessage:  "Invalid tax or fee details",
+	},
+	{
+		name:             "add-ends-before-starts",
+		handler:          (*Repository).AdminPostCharge,
+		postedData:       url.Values{"name": {"VAT"}, "kind": {"tax"}, "basis": {"percent"}, "amount": {"20"}, "starts_on": {"2050-02-01"}, "ends_on": {"2050-01-01"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/charges",
+		expectedSession:  "error",
+		expectedMessage:  "Invalid tax or fee details",
+	},
+	{
+		name:             "DB-insert-fails",
+		handler:          (*Repository).AdminPostCharge,
+		postedData:       url.Values{"name": {"fail"}, "kind": {"fee"}, "basis": {"per_night"}, "amount": {"5"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/charges",
+		expectedSession:  "error",
+		expectedMessage:  "Can't add tax or fee",
+	},
+	{
+		name:             "delete",
+		handler:          (*Repository).AdminDeleteCharge,
+		id:               "1",
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/charges",
+		expectedSession:  "flash",
+		expectedMessage:  "Tax or fee deleted",
+	},
+	{
+		name:           "delete-invalid-id",
+		handler:        (*Repository).AdminDeleteCharge,
+		id:             "bad-id",
+		expectedStatus: http.StatusBadRequest,
+	},
+}
+
+// TestAdminCharges tests adding and deleting taxes and fees
+func TestAdminCharges(t *testing.T) {
+	for _, test := range adminChargeTests {
+		req, _ := http.NewRequest("POST", "/admin/charges", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("id", test.id)
+
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, chiCtx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+
+		test.handler(Repo, recorder, req)
+
+		if recorder.Code != test.expectedStatus {
+			t.Errorf("Test %s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatus)
+			continue
+		}
+
+		if location := recorder.Header().Get("Location"); location != test.expectedLocation {
+			t.Errorf("Test %s redirected to %q, wanted %q", test.name, location, test.expectedLocation)
+		}
+
+		if test.expectedSession == "" {
+			continue
+		}
+
+		if msg := session.PopString(ctx, test.expectedSession); msg != test.expectedMessage {
+			t.Errorf("Test %s expected %s %q, but got %q", test.name, test.expectedSession, test.expectedMessage, msg)
+		}
+	}
+}
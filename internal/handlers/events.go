@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/notify"
+	"github.com/BlackSound1/Go-B-and-B/internal/webhooks"
+)
+
+// eventsKeepAlive is how often an idle event stream sends a comment, so
+// proxies don't close it
+const eventsKeepAlive = 25 * time.Second
+
+// AdminEvents streams live notifications to staff as server-sent events. The
+// first event gives the number of new reservations, so the page can catch up
+// on anything that happened while it loaded.
+func (m *Repository) AdminEvents(w http.ResponseWriter, r *http.Request) {
+	newCount, err := m.newReservationCount()
+	if err != nil {
+		http.Error(w, "Can't count new reservations", http.StatusInternalServerError)
+		return
+	}
+
+	notifications, unsubscribe := m.App.Notifications.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Stop nginx buffering the stream
+
+	// The session middleware wraps the response writer, so flush through it
+	rc := http.NewResponseController(w)
+
+	writeEvent(w, notify.Notification{Event: "hello", NewCount: newCount})
+	if err := rc.Flush(); err != nil {
+		m.App.ErrorLog.Printf("streaming admin events: %v", err)
+		return
+	}
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case n, ok := <-notifications:
+			if !ok {
+				return
+			}
+			writeEvent(w, n)
+		case <-ticker.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes a notification as a server-sent event
+func writeEvent(w io.Writer, n notify.Notification) {
+	data, _ := json.Marshal(n)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// notifyStaff tells staff in the admin area that something happened to a
+// reservation
+func (m *Repository) notifyStaff(event string, res apiReservation) {
+	newCount, err := m.newReservationCount()
+	if err != nil {
+		m.App.ErrorLog.Printf("notifying staff of %s: %v", event, err)
+		return
+	}
+
+	m.App.Notifications.Publish(notify.Notification{
+		Event:         event,
+		Message:       staffMessage(event, res),
+		ReservationID: res.ID,
+		NewCount:      newCount,
+	})
+}
+
+// staffMessage describes what happened to a reservation
+func staffMessage(event string, res apiReservation) string {
+	guest := res.FirstName + " " + res.LastName
+
+	switch event {
+	case webhooks.ReservationCreated:
+		room := res.RoomName
+		if room == "" {
+			room = fmt.Sprintf("room %d", res.RoomID)
+		}
+
+		return fmt.Sprintf("New reservation from %s for %s, %s to %s", guest, room, res.StartDate, res.EndDate)
+	case webhooks.ReservationCancelled:
+		return fmt.Sprintf("%s's reservation was cancelled", guest)
+	case webhooks.ReservationProcessed:
+		return fmt.Sprintf("%s's reservation was processed", guest)
+	case webhooks.ReservationDeleted:
+		return fmt.Sprintf("%s's reservation was deleted", guest)
+	default:
+		return fmt.Sprintf("%s's reservation was changed", guest)
+	}
+}
+
+// newReservationCount returns how many reservations haven't been processed yet
+func (m *Repository) newReservationCount() (int, error) {
+	reservations, err := m.DB.AllNewReservations()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(reservations), nil
+}
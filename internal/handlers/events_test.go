@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/notify"
+	"github.com/BlackSound1/Go-B-and-B/internal/webhooks"
+)
+
+func TestAdminEvents(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(Repo.AdminEvents))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected an event stream, got %q", ct)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+
+	// next reads the next notification from the stream
+	next := func() notify.Notification {
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+
+			var n notify.Notification
+			if err := json.Unmarshal([]byte(data), &n); err != nil {
+				t.Fatalf("can't decode event %q: %v", data, err)
+			}
+
+			return n
+		}
+
+		t.Fatalf("stream ended: %v", scanner.Err())
+		return notify.Notification{}
+	}
+
+	if n := next(); n.Event != "hello" || n.NewCount != 0 {
+		t.Errorf("expected a hello event with no new reservations, got %+v", n)
+	}
+
+	Repo.notifyStaff(webhooks.ReservationCreated, apiReservation{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		RoomName:  "General's Quarters",
+		StartDate: "2050-01-01",
+		EndDate:   "2050-01-02",
+	})
+
+	n := next()
+	if n.Event != webhooks.ReservationCreated || n.ReservationID != 1 {
+		t.Errorf("expected a reservation.created event for reservation 1, got %+v", n)
+	}
+
+	expected := "New reservation from John Smith for General's Quarters, 2050-01-01 to 2050-01-02"
+	if n.Message != expected {
+		t.Errorf("expected message %q, got %q", expected, n.Message)
+	}
+}
+
+func TestStaffMessage(t *testing.T) {
+	res := apiReservation{FirstName: "John", LastName: "Smith", RoomID: 2, StartDate: "2050-01-01", EndDate: "2050-01-02"}
+
+	tests := map[string]string{
+		webhooks.ReservationCreated:   "New reservation from John Smith for room 2, 2050-01-01 to 2050-01-02",
+		webhooks.ReservationUpdated:   "John Smith's reservation was changed",
+		webhooks.ReservationProcessed: "John Smith's reservation was processed",
+		webhooks.ReservationCancelled: "John Smith's reservation was cancelled",
+		webhooks.ReservationDeleted:   "John Smith's reservation was deleted",
+	}
+
+	for event, expected := range tests {
+		if got := staffMessage(event, res); got != expected {
+			t.Errorf("%s: expected %q, got %q", event, expected, got)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/emails"
+	"github.com/BlackSound1/Go-B-and-B/internal/forms"
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// guestInviteLifetime is how long a guest has to accept an invitation
+const guestInviteLifetime = 7 * 24 * time.Hour
+
+// guestEmailTokenLifetime is how long a guest has to verify a new email
+const guestEmailTokenLifetime = 24 * time.Hour
+
+// guestPasswordMinLength is the shortest password a guest can choose
+const guestPasswordMinLength = 8
+
+// ShowGuestRegister displays the guest registration page
+func (m *Repository) ShowGuestRegister(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	data["guest"] = models.Guest{}
+
+	render.Template(w, r, "guest-register.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// PostGuestRegister handles the guest registration form. New guests are
+// invited the same way as guests who book without an account, so they choose
+// their password by following a link emailed to them, and nobody can claim an
+// email they don't own. If the email already belongs to an invited guest, the
+// invitation is sent again.
+func (m *Repository) PostGuestRegister(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	guest := models.Guest{
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     strings.TrimSpace(r.Form.Get("email")),
+		Phone:     r.Form.Get("phone"),
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["guest"] = guest
+
+		render.Template(w, r, "guest-register.page.tmpl", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	existing, err := m.DB.GetGuestByEmail(guest.Email)
+	switch {
+	case err == nil && existing.Registered():
+		m.App.Session.Put(r.Context(), "error", "An account with that email already exists. Log in instead")
+		http.Redirect(w, r, "/guest/login", http.StatusSeeOther)
+		return
+
+	case err == nil:
+		err = m.resendGuestInvite(existing)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.App.Session.Put(r.Context(), "flash", "Check your email for a link to finish setting up your account")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+
+	case !errors.Is(err, sql.ErrNoRows):
+		helpers.ServerError(w, err)
+		return
+	}
+
+	plain, hash, err := helpers.NewInviteToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	guest.InviteTokenHash = hash
+	guest.InviteExpiresAt = time.Now().Add(guestInviteLifetime)
+
+	_, err = m.DB.InsertGuest(guest)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't create account")
+		http.Redirect(w, r, "/guest/register", http.StatusSeeOther)
+		return
+	}
+
+	m.sendGuestInvite(guest.Email, plain)
+
+	m.App.Session.Put(r.Context(), "flash", "Check your email for a link to finish setting up your account")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ShowGuestLogin displays the guest login page
+func (m *Repository) ShowGuestLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "guest-login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostGuestLogin handles the guest login form submission
+func (m *Repository) PostGuestLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Template(w, r, "guest-login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	id, err := m.DB.AuthenticateGuest(r.Form.Get("email"), r.Form.Get("password"))
+	if err != nil {
+		m.App.InfoLog.Println(err)
+
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/guest/login", http.StatusSeeOther)
+		return
+	}
+
+	m.logInGuest(r, id)
+
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/guest/account", http.StatusSeeOther)
+}
+
+// GuestLogout logs the guest out. Only the guest is logged out, so staff
+// testing the site as a guest stay logged in.
+func (m *Repository) GuestLogout(w http.ResponseWriter, r *http.Request) {
+	m.App.Session.Remove(r.Context(), "guest_id")
+
+	_ = m.App.Session.RenewToken(r.Context())
+
+	m.App.Session.Put(r.Context(), "flash", "Logged out")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ShowGuestInvite displays the page where an invited guest sets their password
+func (m *Repository) ShowGuestInvite(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	guest, ok := m.guestForInvite(w, r, token)
+	if !ok {
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["guest"] = guest
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	render.Template(w, r, "guest-invite.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// PostGuestInvite sets an invited guest's password and logs them in
+func (m *Repository) PostGuestInvite(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	guest, ok := m.guestForInvite(w, r, token)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password")
+	form.MinLength("password", guestPasswordMinLength)
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["guest"] = guest
+
+		stringMap := make(map[string]string)
+		stringMap["token"] = token
+
+		render.Template(w, r, "guest-invite.page.tmpl", &models.TemplateData{
+			Form:      form,
+			Data:      data,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	err = m.DB.SetGuestPassword(guest.ID, r.Form.Get("password"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.logInGuest(r, guest.ID)
+
+	m.App.Session.Put(r.Context(), "flash", "Welcome! Your account is ready")
+	http.Redirect(w, r, "/guest/account", http.StatusSeeOther)
+}
+
+// GuestAccount displays the logged in guest's stays and contact details
+func (m *Repository) GuestAccount(w http.ResponseWriter, r *http.Request) {
+	guest, err := m.DB.GetGuestByID(m.App.Session.GetInt(r.Context(), "guest_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderGuestAccount(w, r, guest, forms.New(nil))
+}
+
+// PostGuestAccount updates the logged in guest's contact details. A new email
+// isn't used until the guest follows the link sent to it, so nobody can take
+// over the stays booked under an email they don't own.
+func (m *Repository) PostGuestAccount(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	guest, err := m.DB.GetGuestByID(m.App.Session.GetInt(r.Context(), "guest_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	guest.FirstName = r.Form.Get("first_name")
+	guest.LastName = r.Form.Get("last_name")
+	guest.Phone = r.Form.Get("phone")
+
+	email := strings.TrimSpace(r.Form.Get("email"))
+	changed := !strings.EqualFold(email, guest.Email)
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+
+	// Emails must stay unique, as guests log in with them
+	if form.Valid() && changed {
+		_, err := m.DB.GetGuestByEmail(email)
+		if err == nil {
+			form.Errors.Add("email", "That email is already used by another account")
+		}
+	}
+
+	if !form.Valid() {
+		shown := guest
+		shown.Email = email
+
+		m.renderGuestAccount(w, r, shown, form)
+		return
+	}
+
+	err = m.DB.UpdateGuest(guest)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't update your details")
+		http.Redirect(w, r, "/guest/account", http.StatusSeeOther)
+		return
+	}
+
+	if !changed {
+		m.App.Session.Put(r.Context(), "flash", "Details saved")
+		http.Redirect(w, r, "/guest/account", http.StatusSeeOther)
+		return
+	}
+
+	err = m.sendEmailVerification(guest, email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Details saved. Follow the link we've sent to %s to change your email", email))
+	http.Redirect(w, r, "/guest/account", http.StatusSeeOther)
+}
+
+// PostGuestSendVerification emails the logged in guest a link to verify the
+// email they already have, for accounts made before emails were verified.
+func (m *Repository) PostGuestSendVerification(w http.ResponseWriter, r *http.Request) {
+	guest, err := m.DB.GetGuestByID(m.App.Session.GetInt(r.Context(), "guest_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if guest.EmailVerified() {
+		m.App.Session.Put(r.Context(), "flash", "Your email is already verified")
+		http.Redirect(w, r, "/guest/account", http.StatusSeeOther)
+		return
+	}
+
+	err = m.sendEmailVerification(guest, guest.Email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Follow the link we've sent to "+guest.Email+" to verify it")
+	http.Redirect(w, r, "/guest/account", http.StatusSeeOther)
+}
+
+// ShowGuestEmailVerification displays the page where a guest confirms the
+// email a verification link was sent to. Following the link doesn't change
+// anything by itself, as mail scanners open links too.
+func (m *Repository) ShowGuestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	guest, ok := m.guestForEmailToken(w, r, token)
+	if !ok {
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["guest"] = guest
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = token
+
+	render.Template(w, r, "guest-verify-email.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// PostGuestEmailVerification makes the email a verification link was sent to
+// the guest's email. Stays booked under it are added to their account.
+func (m *Repository) PostGuestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	guest, ok := m.guestForEmailToken(w, r, chi.URLParam(r, "token"))
+	if !ok {
+		return
+	}
+
+	err := m.DB.VerifyGuestEmail(guest.ID, guest.PendingEmail)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+
+		m.App.Session.Put(r.Context(), "error", "Can't change your email to "+guest.PendingEmail)
+		http.Redirect(w, r, "/guest/account", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your email is verified")
+	http.Redirect(w, r, "/guest/account", http.StatusSeeOther)
+}
+
+// renderGuestAccount renders the guest account page, with the guest's stays
+// split into upcoming and past stays.
+func (m *Repository) renderGuestAccount(w http.ResponseWriter, r *http.Request, guest models.Guest, form *forms.Form) {
+	reservations, err := m.DB.ReservationsForGuest(guest.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+
+	var upcoming, past []models.Reservation
+	for _, res := range reservations {
+		if res.EndDate.Before(today) {
+			past = append(past, res)
+		} else {
+			upcoming = append(upcoming, res)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["guest"] = guest
+	data["upcoming"] = upcoming
+	data["past"] = past
+
+	render.Template(w, r, "guest-account.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// logInGuest adds a guest to the session. The session token is renewed first
+// to prevent session fixation attacks.
+func (m *Repository) logInGuest(r *http.Request, id int) {
+	_ = m.App.Session.RenewToken(r.Context())
+
+	m.App.Session.Put(r.Context(), "guest_id", id)
+}
+
+// guestForInvite looks up the guest an invitation token belongs to. If the
+// token is unknown or has expired, the user is redirected and ok is false.
+func (m *Repository) guestForInvite(w http.ResponseWriter, r *http.Request, token string) (models.Guest, bool) {
+	guest, err := m.DB.GetGuestByInviteHash(helpers.HashToken(token))
+	if err != nil || time.Now().After(guest.InviteExpiresAt) {
+		m.App.Session.Put(r.Context(), "error", "That invitation link is invalid or has expired. Register to get a new one")
+		http.Redirect(w, r, "/guest/register", http.StatusSeeOther)
+		return guest, false
+	}
+
+	return guest, true
+}
+
+// guestForEmailToken looks up the logged in guest by the token in an email
+// verification link. The link only works for the account it was sent for, so
+// it can't be used to move someone else's email onto another account. If the
+// token is unknown or has expired, the user is redirected and ok is false.
+func (m *Repository) guestForEmailToken(w http.ResponseWriter, r *http.Request, token string) (models.Guest, bool) {
+	guest, err := m.DB.GetGuestByEmailTokenHash(helpers.HashToken(token))
+	if err != nil || time.Now().After(guest.EmailTokenExpiresAt) || guest.ID != m.App.Session.GetInt(r.Context(), "guest_id") {
+		m.App.Session.Put(r.Context(), "error", "That link is invalid or has expired")
+		http.Redirect(w, r, "/guest/account", http.StatusSeeOther)
+		return guest, false
+	}
+
+	return guest, true
+}
+
+// sendEmailVerification emails a link to email, which verifies it as the
+// guest's email when followed. Any earlier link stops working.
+func (m *Repository) sendEmailVerification(guest models.Guest, email string) error {
+	plain, hash, err := helpers.NewInviteToken()
+	if err != nil {
+		return err
+	}
+
+	err = m.DB.UpdateGuestEmailToken(guest.ID, email, hash, time.Now().Add(guestEmailTokenLifetime))
+	if err != nil {
+		return err
+	}
+
+	m.sendEmail(email, "Verify your email", emails.GuestVerifyEmail, emails.InviteEmail{
+		Link:          fmt.Sprintf("%s/guest/account/email/%s", helpers.BaseURL(), plain),
+		ExpiresInDays: int(guestEmailTokenLifetime.Hours() / 24),
+		SiteURL:       helpers.BaseURL(),
+	})
+
+	return nil
+}
+
+// findOrInviteGuest returns the ID of the guest account a new reservation
+// belongs to, found by email. Accounts are only found once their email has
+// been verified, so nobody can see stays booked under an email they don't
+// own. Until then the ID is 0, and the reservation is linked when the email
+// is verified.
+//
+// If there is no account, an invited one is created, and the plain invitation
+// token is returned so it can be emailed.
+func (m *Repository) findOrInviteGuest(res models.Reservation) (int, string, error) {
+	guest, err := m.DB.GetGuestByEmail(res.Email)
+	if err == nil && guest.EmailVerified() {
+		return guest.ID, "", nil
+	} else if err == nil {
+		return 0, "", nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, "", err
+	}
+
+	plain, hash, err := helpers.NewInviteToken()
+	if err != nil {
+		return 0, "", err
+	}
+
+	_, err = m.DB.InsertGuest(models.Guest{
+		FirstName:       res.FirstName,
+		LastName:        res.LastName,
+		Email:           res.Email,
+		Phone:           res.Phone,
+		InviteTokenHash: hash,
+		InviteExpiresAt: time.Now().Add(guestInviteLifetime),
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	return 0, plain, nil
+}
+
+// resendGuestInvite gives an invited guest a new invitation token and emails it
+// to them. The old token stops working.
+func (m *Repository) resendGuestInvite(guest models.Guest) error {
+	plain, hash, err := helpers.NewInviteToken()
+	if err != nil {
+		return err
+	}
+
+	err = m.DB.UpdateGuestInvite(guest.ID, hash, time.Now().Add(guestInviteLifetime))
+	if err != nil {
+		return err
+	}
+
+	m.sendGuestInvite(guest.Email, plain)
+
+	return nil
+}
+
+// sendGuestInvite emails a guest a link to set a password for their account
+func (m *Repository) sendGuestInvite(email, token string) {
+	m.sendEmail(email, "Set up your Go B & B account", emails.GuestInvitation, emails.InviteEmail{
+		Link:          fmt.Sprintf("%s/guest/invite/%s", helpers.BaseURL(), token),
+		ExpiresInDays: int(guestInviteLifetime.Hours() / 24),
+		SiteURL:       helpers.BaseURL(),
+	})
+}
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/go-chi/chi"
+)
+
+// Create a set of tests to run
+var postGuestRegisterTests = []struct {
+	name             string
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+	expectedHTML     string
+}{
+	{
+		name: "new-guest",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/",
+	},
+	{
+		name: "already-registered",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"registered@guest.test"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/guest/login",
+	},
+	{
+		name: "already-invited",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"invited@guest.test"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/",
+	},
+	{
+		name: "invalid-email",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john"},
+		},
+		expectedCode: http.StatusOK,
+		expectedHTML: `action="/guest/register"`,
+	},
+	{
+		name: "database-error",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"fail@guest.test"},
+		},
+		expectedCode: http.StatusInternalServerError,
+	},
+	{
+		name: "insert-fails",
+		postedData: url.Values{
+			"first_name": {"fail"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedCode:     http.StatusSeeOther,
+		expectedLocation: "/guest/register",
+	},
+}
+
+// TestPostGuestRegister tests the PostGuestRegister handler
+func TestPostGuestRegister(t *testing.T) {
+	for _, test := range postGuestRegisterTests {
+		req, _ := http.NewRequest("POST", "/guest/register", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostGuestRegister)
+		handler.ServeHTTP(recorder, req)
+
+		checkGuestResponse(t, test.name, recorder, test.expectedCode, test.expectedLocation, test.expectedHTML)
+
+		// Guests log in once they've followed the link emailed to them
+		if session.GetInt(ctx, "guest_id") != 0 {
+			t.Errorf("%s: expected the guest not to be logged in", test.name)
+		}
+	}
+}
+
+// TestPostGuestLogin tests the PostGuestLogin handler
+func TestPostGuestLogin(t *testing.T) {
+	tests := []struct {
+		name             string
+		email            string
+		password         string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"valid-credentials", "registered@guest.test", "password", http.StatusSeeOther, "/guest/account"},
+		{"wrong-password", "registered@guest.test", "wrong", http.StatusSeeOther, "/guest/login"},
+		{"invited-guest", "invited@guest.test", "password", http.StatusSeeOther, "/guest/login"},
+		{"invalid-email", "nope", "password", http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{}
+		postedData.Add("email", test.email)
+		postedData.Add("password", test.password)
+
+		req, _ := http.NewRequest("POST", "/guest/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostGuestLogin)
+		handler.ServeHTTP(recorder, req)
+
+		checkGuestResponse(t, test.name, recorder, test.expectedCode, test.expectedLocation, "")
+
+		loggedIn := session.GetInt(ctx, "guest_id") != 0
+		if loggedIn != (test.expectedLocation == "/guest/account") {
+			t.Errorf("%s: expected guest to be logged in to be %t", test.name, !loggedIn)
+		}
+	}
+}
+
+// TestGuestInvite tests showing and accepting a guest invitation
+func TestGuestInvite(t *testing.T) {
+	tests := []struct {
+		name             string
+		method           string
+		token            string
+		password         string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"show-valid", "GET", "valid-invite", "", http.StatusOK, ""},
+		{"show-expired", "GET", "expired-invite", "", http.StatusSeeOther, "/guest/register"},
+		{"show-unknown", "GET", "unknown-invite", "", http.StatusSeeOther, "/guest/register"},
+		{"accept-valid", "POST", "valid-invite", "password", http.StatusSeeOther, "/guest/account"},
+		{"accept-short-password", "POST", "valid-invite", "short", http.StatusOK, ""},
+		{"accept-expired", "POST", "expired-invite", "password", http.StatusSeeOther, "/guest/register"},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{}
+		postedData.Add("password", test.password)
+
+		req, _ := http.NewRequest(test.method, "/guest/invite/"+test.token, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		ctx = addTokenToChiContext(ctx, test.token)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ShowGuestInvite)
+		if test.method == "POST" {
+			handler = Repo.PostGuestInvite
+		}
+		handler.ServeHTTP(recorder, req)
+
+		checkGuestResponse(t, test.name, recorder, test.expectedCode, test.expectedLocation, "")
+	}
+}
+
+// TestGuestAccount tests that the guest account page splits stays into
+// upcoming and past stays
+func TestGuestAccount(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/guest/account", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "guest_id", 1)
+	recorder := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.GuestAccount)
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("GuestAccount returned wrong response code: got %d, wanted %d", recorder.Code, http.StatusOK)
+	}
+
+	html := recorder.Body.String()
+
+	// The test repo returns one upcoming and one past stay
+	if strings.Contains(html, "No upcoming stays") || strings.Contains(html, "No past stays") {
+		t.Error("expected both upcoming and past stays to be listed")
+	}
+
+	if !strings.Contains(html, `value="registered@guest.test"`) {
+		t.Error("expected the guest's details to be filled in")
+	}
+
+	if !strings.Contains(html, `action="/guest/account/reservations/1/invoice"`) {
+		t.Error("expected a button to download the stay's invoice")
+	}
+}
+
+// TestPostGuestAccount tests updating a guest's contact details
+func TestPostGuestAccount(t *testing.T) {
+	tests := []struct {
+		name          string
+		firstName     string
+		email         string
+		expectedCode  int
+		expectedHTML  string
+		expectedFlash string
+	}{
+		{"new-email", "Reg", "new@guest.test", http.StatusSeeOther, "", "Details saved. Follow the link we've sent to new@guest.test to change your email"},
+		{"same-email", "Reg", "registered@guest.test", http.StatusSeeOther, "", "Details saved"},
+		{"same-email-different-case", "Reg", "Registered@Guest.test", http.StatusSeeOther, "", "Details saved"},
+		{"email-in-use", "Reg", "invited@guest.test", http.StatusOK, "already used", ""},
+		{"missing-name", "", "registered@guest.test", http.StatusOK, "is-invalid", ""},
+		{"update-fails", "fail", "registered@guest.test", http.StatusSeeOther, "", ""},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{}
+		postedData.Add("first_name", test.firstName)
+		postedData.Add("last_name", "Istered")
+		postedData.Add("email", test.email)
+
+		req, _ := http.NewRequest("POST", "/guest/account", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "guest_id", 1)
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostGuestAccount)
+		handler.ServeHTTP(recorder, req)
+
+		checkGuestResponse(t, test.name, recorder, test.expectedCode, "", test.expectedHTML)
+
+		if flash := session.PopString(ctx, "flash"); flash != test.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", test.name, test.expectedFlash, flash)
+		}
+	}
+}
+
+// TestGuestEmailVerification tests verifying a guest's new email. Links only
+// work for the logged in guest they were sent to.
+func TestGuestEmailVerification(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		token         string
+		guestID       int
+		expectedCode  int
+		expectedFlash string
+		expectedError string
+	}{
+		{"show-valid", "GET", "valid-email-token", 1, http.StatusOK, "", ""},
+		{"show-expired", "GET", "expired-email-token", 1, http.StatusSeeOther, "", "That link is invalid or has expired"},
+		{"show-other-guest", "GET", "valid-email-token", 2, http.StatusSeeOther, "", "That link is invalid or has expired"},
+		{"verify-valid", "POST", "valid-email-token", 1, http.StatusSeeOther, "Your email is verified", ""},
+		{"verify-unknown", "POST", "unknown-email-token", 1, http.StatusSeeOther, "", "That link is invalid or has expired"},
+		{"verify-other-guest", "POST", "valid-email-token", 2, http.StatusSeeOther, "", "That link is invalid or has expired"},
+		{"verify-taken", "POST", "taken-email-token", 1, http.StatusSeeOther, "", "Can't change your email to taken@guest.test"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, "/guest/account/email/"+test.token, nil)
+		ctx := getCtx(req)
+		ctx = addTokenToChiContext(ctx, test.token)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "guest_id", test.guestID)
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ShowGuestEmailVerification)
+		if test.method == "POST" {
+			handler = Repo.PostGuestEmailVerification
+		}
+		handler.ServeHTTP(recorder, req)
+
+		checkGuestResponse(t, test.name, recorder, test.expectedCode, "", "")
+
+		if flash := session.PopString(ctx, "flash"); flash != test.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", test.name, test.expectedFlash, flash)
+		}
+
+		if msg := session.PopString(ctx, "error"); msg != test.expectedError {
+			t.Errorf("%s: expected error %q, got %q", test.name, test.expectedError, msg)
+		}
+	}
+}
+
+// TestPostGuestSendVerification tests sending a link to verify a guest's
+// current email
+func TestPostGuestSendVerification(t *testing.T) {
+	tests := map[int]string{
+		1: "Your email is already verified",
+		4: "Follow the link we've sent to unverified@guest.test to verify it",
+	}
+
+	for guestID, expectedFlash := range tests {
+		req, _ := http.NewRequest("POST", "/guest/account/verify-email", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "guest_id", guestID)
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostGuestSendVerification)
+		handler.ServeHTTP(recorder, req)
+
+		checkGuestResponse(t, expectedFlash, recorder, http.StatusSeeOther, "/guest/account", "")
+
+		if flash := session.PopString(ctx, "flash"); flash != expectedFlash {
+			t.Errorf("guest %d: expected flash %q, got %q", guestID, expectedFlash, flash)
+		}
+	}
+}
+
+// TestFindOrInviteGuest tests that reservations are only linked to accounts
+// whose email is verified
+func TestFindOrInviteGuest(t *testing.T) {
+	tests := []struct {
+		email          string
+		expectedID     int
+		expectedInvite bool
+	}{
+		{"registered@guest.test", 1, false},
+		{"invited@guest.test", 0, false},
+		{"unverified@guest.test", 0, false},
+		{"new@guest.test", 0, true},
+	}
+
+	for _, test := range tests {
+		id, invite, err := Repo.findOrInviteGuest(models.Reservation{FirstName: "John", Email: test.email})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.email, err)
+			continue
+		}
+
+		if id != test.expectedID || (invite != "") != test.expectedInvite {
+			t.Errorf("%s: expected guest %d and an invite %t, got guest %d and invite %q", test.email, test.expectedID, test.expectedInvite, id, invite)
+		}
+	}
+}
+
+// TestReservationPrefillsGuest tests that a logged in guest's details are
+// filled in on the make reservation page
+func TestReservationPrefillsGuest(t *testing.T) {
+	reservation := models.Reservation{
+		RoomID:    1,
+		StartDate: time.Now(),
+		EndDate:   time.Now().AddDate(0, 0, 1),
+	}
+
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	session.Put(ctx, "guest_id", 1)
+	recorder := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Reservation)
+	handler.ServeHTTP(recorder, req)
+
+	if !strings.Contains(recorder.Body.String(), `value="registered@guest.test"`) {
+		t.Error("expected the logged in guest's email to be filled in")
+	}
+}
+
+// checkGuestResponse checks the status code, redirect location and HTML of a
+// response from one of the guest handlers
+func checkGuestResponse(t *testing.T, name string, recorder *httptest.ResponseRecorder, code int, location, html string) {
+	t.Helper()
+
+	if recorder.Code != code {
+		t.Errorf("%s returned wrong response code: got %d, wanted %d", name, recorder.Code, code)
+	}
+
+	if location != "" {
+		actualLocation, _ := recorder.Result().Location()
+		if actualLocation.String() != location {
+			t.Errorf("%s returned wrong location: got %s, wanted %s", name, actualLocation.String(), location)
+		}
+	}
+
+	if html != "" && !strings.Contains(recorder.Body.String(), html) {
+		t.Errorf("%s returned wrong HTML: wanted it to contain %q", name, html)
+	}
+}
+
+// addTokenToChiContext adds a token to the chi route context within the provided context.
+func addTokenToChiContext(ctx context.Context, token string) context.Context {
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("token", token)
+	return context.WithValue(ctx, chi.RouteCtxKey, chiCtx)
+}
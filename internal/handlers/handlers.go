@@ -1,7 +1,10 @@
 package handlers
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,12 +14,16 @@ import (
 
 	"github.com/BlackSound1/Go-B-and-B/internal/config"
 	"github.com/BlackSound1/Go-B-and-B/internal/driver"
+	"github.com/BlackSound1/Go-B-and-B/internal/emails"
 	"github.com/BlackSound1/Go-B-and-B/internal/forms"
 	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/ical"
 	"github.com/BlackSound1/Go-B-and-B/internal/models"
 	"github.com/BlackSound1/Go-B-and-B/internal/render"
 	"github.com/BlackSound1/Go-B-and-B/internal/repository"
 	"github.com/BlackSound1/Go-B-and-B/internal/repository/dbrepo"
+	"github.com/BlackSound1/Go-B-and-B/internal/sms"
+	"github.com/BlackSound1/Go-B-and-B/internal/webhooks"
 	"github.com/go-chi/chi"
 )
 
@@ -78,8 +85,27 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Add the room name to the reservation
-	res.Room.RoomName = room.RoomName
+	// Add the room and its price to the reservation
+	res.Room = room
+	res.Guests = max(res.Guests, 1)
+
+	err = m.priceStay(&res)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't work out the price")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// Fill in a logged in guest's details, so they don't have to type them again
+	if res.Email == "" && helpers.IsGuestAuthenticated(r) {
+		guest, err := m.DB.GetGuestByID(m.App.Session.GetInt(r.Context(), "guest_id"))
+		if err == nil {
+			res.FirstName = guest.FirstName
+			res.LastName = guest.LastName
+			res.Email = guest.Email
+			res.Phone = guest.Phone
+		}
+	}
 
 	// Add the reservation to the session
 	m.App.Session.Put(r.Context(), "reservation", res)
@@ -93,13 +119,17 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
 
-	// Add the reservation data to the template
-	data := make(map[string]interface{})
-	data["reservation"] = res
+	// Each time the form is shown it gets a new key, so submitting it twice
+	// only makes one reservation
+	stringMap["idempotency_key"], err = helpers.NewIdempotencyKey()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil), // Have access to form first time it's rendered
-		Data:      data,
+		Data:      m.reservationFormData(res),
 		StringMap: stringMap,
 	})
 }
@@ -141,6 +171,23 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	email := strings.TrimSpace(r.Form.Get("email"))
+
+	// A form that was already submitted, e.g. by a double click, shows the
+	// reservation it made rather than making another. A key that made a
+	// different stay is ignored, so it can't show someone else's reservation
+	formKey := r.Form.Get("idempotency_key")
+	key := helpers.IdempotencyKey("web", formKey)
+	if res, ok := m.replayedReservation(key); ok {
+		if sameStay(res, roomID, startDate, endDate, email) {
+			m.App.Session.Put(r.Context(), "reservation", res)
+			http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+			return
+		}
+
+		key = ""
+	}
+
 	// Get the room by ID
 	room, err := m.DB.GetRoomByID(roomID)
 	if err != nil {
@@ -153,11 +200,14 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		FirstName: r.Form.Get("first_name"),
 		LastName:  r.Form.Get("last_name"),
 		Phone:     r.Form.Get("phone"),
-		Email:     r.Form.Get("email"),
+		Email:     email,
 		StartDate: startDate,
 		EndDate:   endDate,
 		RoomID:    roomID,
 		Room:      room,
+
+		IdempotencyKey: key,
+		SMSOptIn:       m.App.SMS != nil && r.Form.Get("sms_opt_in") != "",
 	}
 
 	form := forms.New(r.PostForm)
@@ -166,105 +216,263 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
 
+	reservation.Guests = 1
+	if guests := r.Form.Get("guests"); guests != "" {
+		reservation.Guests, err = strconv.Atoi(guests)
+		if err != nil || reservation.Guests < 1 || reservation.Guests > maxGuests {
+			form.Errors.Add("guests", fmt.Sprintf("Must be from 1 to %d guests", maxGuests))
+			reservation.Guests = 1
+		}
+	}
+
+	// The price is fixed when the stay is booked
+	err = m.priceStay(&reservation)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't work out the price")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// Guests who want text messages need a number they can be sent to
+	if reservation.SMSOptIn {
+		if _, err := sms.Normalize(reservation.Phone, m.App.SMSCountryCode); err != nil {
+			form.Errors.Add("phone", "Enter a mobile number to get text messages, e.g. +1 555 555 5555")
+		}
+	}
+
 	stringMap := make(map[string]string)
 	stringMap["start_date"] = startDate.Format("2006-01-02")
 	stringMap["end_date"] = endDate.Format("2006-01-02")
+	stringMap["idempotency_key"] = formKey
+	stringMap["payment"] = r.Form.Get("payment")
 
 	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
 		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
+			Form:      form,
+			Data:      m.reservationFormData(reservation),
 			StringMap: stringMap,
 		})
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	// Link the reservation to a guest account. Guests who book without logging
+	// in are found by email, or invited to set up an account
+	var invite string
+	reservation.GuestID = m.App.Session.GetInt(r.Context(), "guest_id")
+	if reservation.GuestID == 0 {
+		reservation.GuestID, invite, err = m.findOrInviteGuest(reservation)
+		if err != nil {
+			// Not being able to link the account shouldn't stop the booking
+			m.App.ErrorLog.Println(err)
+		}
+	}
+
+	newReservationID, err := m.DB.InsertReservation(reservation, m.reservationEmails(reservation))
 	if err != nil {
+		// The same form may have been submitted twice at once, and the other
+		// submission made the reservation
+		if res, ok := m.replayedReservation(key); ok && sameStay(res, roomID, startDate, endDate, email) {
+			m.App.Session.Put(r.Context(), "reservation", res)
+			http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+			return
+		}
+
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			m.App.Session.Put(r.Context(), "error", "Sorry, that room has just been booked for those dates")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+
 		m.App.Session.Put(r.Context(), "error", "can't insert reservation into database!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     startDate,
-		EndDate:       endDate,
-		RoomID:        roomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
-	}
+	reservation.ID = newReservationID
+	m.emitReservation(webhooks.ReservationCreated, reservation)
 
-	err = m.DB.InsertRoomRestriction(restriction)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+	// Invite new guests to set up an account to see their bookings
+	if invite != "" {
+		m.sendGuestInvite(reservation.Email, invite)
 	}
 
-	// Send email to guest
-
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "me@here.com",
-		Subject:  "Reservation Confirmation",
-		Template: "guest_email_confirmation.html",
-		Content: fmt.Sprintf(
-			`
-				<tr>
-					<td>%s %s</td>
-					<td>%s</td>
-					<td>%s</td>
-					<td>%s</td>
-					<td>%s to %s</td>
-				</tr>
-			`,
-			reservation.FirstName,
-			reservation.LastName,
-			reservation.Email,
-			reservation.Phone,
-			reservation.Room.RoomName,
-			reservation.StartDate.Format("2006-01-02"),
-			reservation.EndDate.Format("2006-01-02"),
-		),
-	}
-
-	m.App.MailChan <- msg
-
-	// Send email to property owner
-
-	msg = models.MailData{
-		To:       "me@here.com",
-		From:     "me@here.com",
-		Subject:  "Reservation Confirmation (Owner)",
-		Template: "owner_email_confirmation.html",
-		Content: fmt.Sprintf(
-			`
-				<tr>
-					<td>%s %s</td>
-					<td>%s</td>
-					<td>%s</td>
-					<td>%s</td>
-					<td>%s to %s</td>
-				</tr>
-			`,
-			reservation.FirstName,
-			reservation.LastName,
-			reservation.Email,
-			reservation.Phone,
-			reservation.Room.RoomName,
-			reservation.StartDate.Format("2006-01-02"),
-			reservation.EndDate.Format("2006-01-02"),
-		),
-	}
-
-	m.App.MailChan <- msg
+	m.textReservation(reservation)
 
 	m.App.Session.Put(r.Context(), "reservation", reservation)
+
+	// Guests pay on the provider's checkout page, which sends them back to
+	// the summary
+	if m.App.Payments != nil && reservation.Total > 0 {
+		checkoutURL, err := m.startPayment(reservation, r.Form.Get("payment"))
+		if err == nil {
+			http.Redirect(w, r, checkoutURL, http.StatusSeeOther)
+			return
+		}
+
+		m.App.ErrorLog.Printf("starting payment for reservation %d: %v", reservation.ID, err)
+		m.App.Session.Put(r.Context(), "warning", "Your reservation is made, but we couldn't take payment. We'll be in touch about paying")
+	}
+
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
+// reservationFormData is what the make reservation form shows, besides the
+// reservation: whether guests can opt in to text messages, how many guests
+// can stay, and what they can pay
+func (m *Repository) reservationFormData(res models.Reservation) map[string]interface{} {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["sms"] = m.App.SMS != nil
+	data["max_guests"] = maxGuests
+
+	if m.App.Payments != nil && res.Total > 0 {
+		data["payments"] = m.paymentOptions(res)
+		data["deposit_percent"] = m.App.DepositPercent
+	}
+
+	return data
+}
+
+// replayedReservation returns the reservation already made by the submission
+// with the given idempotency key, if there is one.
+func (m *Repository) replayedReservation(key string) (models.Reservation, bool) {
+	if key == "" {
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByIdempotencyKey(key)
+	if err != nil {
+		return res, false
+	}
+
+	return res, true
+}
+
+// sameStay reports whether a reservation is for the given room, dates and
+// guest email
+func sameStay(res models.Reservation, roomID int, start, end time.Time, email string) bool {
+	return res.RoomID == roomID && res.StartDate.Equal(start) && res.EndDate.Equal(end) &&
+		strings.EqualFold(res.Email, email)
+}
+
+// reservationEmails builds the confirmations of a new reservation for the
+// guest and the property owner, once the reservation has an ID. They're queued
+// along with the reservation.
+func (m *Repository) reservationEmails(reservation models.Reservation) func(id int) ([]models.MailData, error) {
+	return func(id int) ([]models.MailData, error) {
+		reservation.ID = id
+		data := emails.ReservationEmail{Reservation: reservation, SiteURL: helpers.BaseURL()}
+
+		guest, err := m.renderEmail(reservation.Email, "Reservation Confirmation", emails.GuestConfirmation, data)
+		if err != nil {
+			return nil, err
+		}
+
+		// So the guest can add their stay to their calendar
+		invite, err := m.stayInvite(reservation, ical.MethodRequest, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		guest.Attachments = []models.Attachment{invite}
+
+		owner, err := m.renderEmail("me@here.com", "Reservation Confirmation (Owner)", emails.OwnerConfirmation, data)
+		if err != nil {
+			return nil, err
+		}
+
+		return []models.MailData{guest, owner}, nil
+	}
+}
+
+// renderEmail renders an email template into an email ready to queue
+func (m *Repository) renderEmail(to, subject, template string, data any) (models.MailData, error) {
+	html, text, err := m.App.Emails.Render(template, data)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		To:      to,
+		From:    "me@here.com",
+		Subject: subject,
+		HTML:    html,
+		Text:    text,
+	}, nil
+}
+
+// sendEmail renders an email template and queues the email to be sent in the
+// background
+func (m *Repository) sendEmail(to, subject, template string, data any) {
+	msg, err := m.renderEmail(to, subject, template, data)
+	if err == nil {
+		err = m.DB.QueueEmail(msg)
+	}
+
+	if err != nil {
+		m.App.ErrorLog.Printf("queueing email to %s: %v", to, err)
+	}
+}
+
+// emailGuest emails the guest of a reservation about something an admin did,
+// unless the admin ticked the box to not email them. Reports whether the email
+// was queued.
+func (m *Repository) emailGuest(r *http.Request, res models.Reservation, subject, template string, data any, attachments ...models.Attachment) bool {
+	if !m.shouldEmailGuest(r, res) {
+		return false
+	}
+
+	return m.queueGuestEmail(res, subject, template, data, attachments...)
+}
+
+// queueGuestEmail emails the guest of a reservation, for callers that already
+// checked shouldEmailGuest. Reports whether the email was queued.
+func (m *Repository) queueGuestEmail(res models.Reservation, subject, template string, data any, attachments ...models.Attachment) bool {
+	msg, err := m.renderEmail(res.Email, subject, template, data)
+	if err == nil {
+		msg.Attachments = attachments
+		err = m.DB.QueueEmail(msg)
+	}
+
+	if err != nil {
+		m.App.ErrorLog.Printf("queueing email to %s: %v", res.Email, err)
+		return false
+	}
+
+	return true
+}
+
+// shouldEmailGuest reports whether the guest of a reservation can be emailed
+// about something an admin did. Admins can only stop the email from the form
+// they did it with, not a link.
+func (m *Repository) shouldEmailGuest(r *http.Request, res models.Reservation) bool {
+	return r.PostFormValue("suppress_email") == "" && res.Email != ""
+}
+
+// reservationChanges lists the guest's details that differ between two
+// versions of a reservation
+func reservationChanges(old, updated models.Reservation) []emails.Change {
+	var changes []emails.Change
+
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"First Name", old.FirstName, updated.FirstName},
+		{"Last Name", old.LastName, updated.LastName},
+		{"Email", old.Email, updated.Email},
+		{"Phone", old.Phone, updated.Phone},
+	}
+
+	for _, f := range fields {
+		if f.old != f.new {
+			changes = append(changes, emails.Change{Field: f.name, Old: f.old, New: f.new})
+		}
+	}
+
+	return changes
+}
+
 // Generals displays the General's Quarters room page
 func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
@@ -343,72 +551,111 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 
 // jsonRespose defines what a JSON response for availability is
 type jsonResponse struct {
-	Ok        bool   `json:"ok"`
-	Message   string `json:"message"`
-	RoomID    string `json:"room_id"`
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
+	Ok                bool     `json:"ok"`
+	Message           string   `json:"message"`
+	RoomID            string   `json:"room_id"`
+	StartDate         string   `json:"start_date"`
+	EndDate           string   `json:"end_date"`
+	ConflictingNights []string `json:"conflicting_nights,omitempty"` // Nights the room is taken, if it's not available
 }
 
-// AvailabilityJSON handles request for availability
+// availabilityJSONBody is the JSON request body AvailabilityJSON accepts
+type availabilityJSONBody struct {
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	RoomID int    `json:"room_id"`
+}
+
+// AvailabilityJSON handles request for availability. The dates and room can be
+// sent as a query string, a form or a JSON body. This isn't part of the JSON
+// API, so a POST needs the page's CSRF token even with a JSON body, sent as
+// the X-CSRF-Token header.
 func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
-	// Parse request body
-	err := r.ParseForm()
-	if err != nil {
-		// Can't parse form. Return appropriate json
-		resp := jsonResponse{
-			Ok:      false,
-			Message: "Internal Server Error",
+	var body availabilityJSONBody
+
+	fields := map[string]string{}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if !readJSON(w, r, &body) {
+			return
+		}
+
+		if body.RoomID < 1 {
+			fields["room_id"] = "Must be a room ID"
+		}
+	} else {
+		// Parse request body, or the query string for GET requests
+		err := r.ParseForm()
+		if err != nil {
+			helpers.JSONError(w, http.StatusBadRequest, "Can't parse form")
+			return
+		}
+
+		body.Start = r.Form.Get("start")
+		body.End = r.Form.Get("end")
+
+		body.RoomID, err = strconv.Atoi(r.Form.Get("room_id"))
+		if err != nil || body.RoomID < 1 {
+			fields["room_id"] = "Must be a room ID"
 		}
+	}
+
+	startDate, endDate := parseAPIStay(body.Start, body.End, "start", "end", fields)
+
+	if len(fields) == 0 {
+		m.checkBookingHorizon(startDate, endDate, "start", "end", fields)
+	}
 
-		out, _ := json.MarshalIndent(resp, "", "\t")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+	if len(fields) > 0 {
+		badRequestFields(w, "Some fields are invalid", fields)
 		return
 	}
 
-	// Get form data
-	sd := r.Form.Get("start")
-	ed := r.Form.Get("end")
-	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+	room, err := m.DB.GetRoomByID(body.RoomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.JSONError(w, http.StatusNotFound, "Room not found")
+		return
+	} else if err != nil {
+		m.App.ErrorLog.Println(err)
+		helpers.JSONError(w, http.StatusInternalServerError, "Error querying database")
+		return
+	}
 
-	// Convert dates to time
-	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
+	if nights := (models.Reservation{StartDate: startDate, EndDate: endDate}).Nights(); nights < room.MinNights() {
+		badRequestFields(w, "Some fields are invalid", map[string]string{
+			"end": fmt.Sprintf("Stays in this room must be at least %d nights", room.MinNights()),
+		})
+		return
+	}
 
 	// Check availability
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, room.ID)
 	if err != nil {
-		// There was a problem querying the database
-		resp := jsonResponse{
-			Ok:      false,
-			Message: "Error querying database",
-		}
-
-		out, _ := json.MarshalIndent(resp, "", "\t")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
+		m.App.ErrorLog.Println(err)
+		helpers.JSONError(w, http.StatusInternalServerError, "Error querying database")
 		return
 	}
 
-	// Create JSON response
 	resp := jsonResponse{
 		Ok:        available,
-		Message:   "",
-		StartDate: sd,
-		EndDate:   ed,
-		RoomID:    strconv.Itoa(roomID),
+		StartDate: startDate.Format("2006-01-02"),
+		EndDate:   endDate.Format("2006-01-02"),
+		RoomID:    strconv.Itoa(room.ID),
 	}
 
-	// Convert response to JSON
-	out, _ := json.MarshalIndent(resp, "", "\t")
+	// Tell the guest which nights get in the way
+	if !available {
+		resp.Message = "The room is not available for those dates"
 
-	// Add json header
-	w.Header().Set("Content-Type", "application/json")
+		resp.ConflictingNights, err = m.conflictingNights(room.ID, startDate, endDate)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			helpers.JSONError(w, http.StatusInternalServerError, "Error querying database")
+			return
+		}
+	}
 
-	// Write json response
-	w.Write(out)
+	helpers.WriteJSON(w, http.StatusOK, resp)
 }
 
 // Contact displays the contact page
@@ -519,10 +766,22 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 // ShowLogin displays the login page
 func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
+		Form:      forms.New(nil),
+		StringMap: m.loginStringMap(),
 	})
 }
 
+// loginStringMap tells the login page whether to offer single sign-on
+func (m *Repository) loginStringMap() map[string]string {
+	stringMap := make(map[string]string)
+
+	if m.App.OIDC != nil {
+		stringMap["sso"] = "enabled"
+	}
+
+	return stringMap
+}
+
 // PostShowLogin handles the login form submission.
 func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 	// Prevents Session Fixation Attack. Best to renew token at each login and logout
@@ -545,7 +804,8 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 	if !form.Valid() {
 		// If the form is not valid, render the login page with the form data
 		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-			Form: form,
+			Form:      form,
+			StringMap: m.loginStringMap(),
 		})
 		return
 	}
@@ -558,14 +818,27 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		// If not authenticated, redirect and add error to session
 		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
 	}
 
 	// Add user to session, flash success message, and redirect
-	m.App.Session.Put(r.Context(), "user_id", id)
+	m.logInUser(r, id)
+
 	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// logInUser adds a staff user to the session, and remembers where the session
+// was started so it can be listed and revoked.
+func (m *Repository) logInUser(r *http.Request, id int) {
+	m.App.Session.Put(r.Context(), "user_id", id)
+
+	m.App.Session.Put(r.Context(), "logged_in_at", time.Now())
+	m.App.Session.Put(r.Context(), "last_seen", time.Now())
+	m.App.Session.Put(r.Context(), "ip", helpers.ClientIP(r))
+	m.App.Session.Put(r.Context(), "user_agent", r.UserAgent())
+}
+
 // Logout logs the user out of the system
 // It destroys the session, renews the CSRF token, and redirects to the login screen
 func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
@@ -651,8 +924,15 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	payments, err := m.DB.PaymentsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["payments"] = payments
 
 	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
@@ -686,6 +966,8 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	old := res
+
 	res.FirstName = r.Form.Get("first_name")
 	res.LastName = r.Form.Get("last_name")
 	res.Email = r.Form.Get("email")
@@ -697,10 +979,32 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	m.emitReservation(webhooks.ReservationUpdated, res)
+
 	month := r.Form.Get("month")
 	year := r.Form.Get("year")
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	flash := "Changes saved"
+
+	// Tell the guest what changed. They don't need to know if nothing did
+	changes := reservationChanges(old, res)
+	if len(changes) > 0 && res.CancelledAt.IsZero() {
+		data := emails.ChangesEmail{
+			ReservationEmail: emails.ReservationEmail{Reservation: res, SiteURL: helpers.BaseURL()},
+			Changes:          changes,
+		}
+
+		var invite []models.Attachment
+		if m.shouldEmailGuest(r, res) {
+			invite = m.updatedInvite(res, ical.MethodRequest)
+		}
+
+		if m.emailGuest(r, res, "Your Reservation Was Updated", emails.GuestChanges, data, invite...) {
+			flash += ", and the guest was emailed"
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", flash)
 
 	// May have to redirect to the calendar
 	if year == "" {
@@ -713,18 +1017,39 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 // AdminProcessReservation marks a reservation as processed
 // and redirects to the page from which this was called.
 func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
 
-	err := m.DB.UpdateProcessedForReservation(id, 1)
+	flash := "Reservation marked as processed"
+
+	// The guest is only told once, however many times it's marked
+	changed, err := m.DB.UpdateProcessedForReservation(id, 1)
 	if err != nil {
 		log.Println(err)
+	} else if !changed {
+		flash = "Reservation was already processed"
+	} else if res, err := m.DB.GetReservationByID(id); err != nil {
+		m.App.ErrorLog.Println(err)
+	} else {
+		m.emitReservation(webhooks.ReservationProcessed, res)
+
+		// Let the guest know their stay is confirmed
+		data := emails.ReservationEmail{Reservation: res, SiteURL: helpers.BaseURL()}
+		if res.CancelledAt.IsZero() && m.emailGuest(r, res, "Your Reservation Is Confirmed", emails.GuestConfirmedByOwner, data) {
+			flash += ", and the guest was emailed"
+		}
 	}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	year := r.Form.Get("y")
+	month := r.Form.Get("m")
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+	m.App.Session.Put(r.Context(), "flash", flash)
 
 	if year == "" {
 		http.Redirect(w, r, "/admin/reservations-"+src, http.StatusSeeOther)
@@ -734,20 +1059,93 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 }
 
 // AdminDeleteReservation deletes a reservation by ID and redirects to the
-// page from which this was called.
+// page from which this was called. Reservations that have been invoiced or
+// paid for are cancelled instead, so the invoice and payments are kept.
 func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
 	src := chi.URLParam(r, "src")
 
-	err := m.DB.DeleteReservation(id)
+	// Look the reservation up first, so the webhook event can say what was deleted
+	res, ok := m.reservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	id := res.ID
+
+	flash := "Reservation deleted"
+	event := webhooks.ReservationDeleted
+
+	// Invoices and payments can't lose their reservation, so reservations
+	// with either are kept. Only delete when sure there are none
+	kept := ""
+	_, err = m.DB.InvoiceForReservation(id)
+	if err == nil {
+		kept = "it has an invoice"
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	} else {
+		payments, err := m.DB.PaymentsForReservation(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if len(payments) > 0 {
+			kept = "it has payments"
+		}
+	}
+
+	switch {
+	case kept == "":
+		err = m.DB.DeleteReservation(id)
+	case res.CancelledAt.IsZero():
+		flash = "Reservation cancelled instead, as " + kept
+		event = webhooks.ReservationCancelled
+
+		// Another request may have just cancelled it, and told everyone
+		var cancelled bool
+		cancelled, err = m.DB.CancelReservation(id)
+		if err == nil && !cancelled {
+			flash = "Reservation is already cancelled, and kept as " + kept
+			event = ""
+		}
+	default:
+		flash = "Reservation is already cancelled, and kept as " + kept
+		event = ""
+	}
+
 	if err != nil {
 		log.Println(err)
+	} else if event != "" {
+		m.emitReservation(event, res)
+
+		// Guests who already cancelled were told then. The invite's sequence
+		// only goes up when the guest is sent it
+		var invite []models.Attachment
+		if res.CancelledAt.IsZero() && m.shouldEmailGuest(r, res) {
+			if event == webhooks.ReservationDeleted {
+				invite = m.deletedInvite(res)
+			} else {
+				invite = m.updatedInvite(res, ical.MethodCancel)
+			}
+		}
+
+		data := emails.ReservationEmail{Reservation: res, SiteURL: helpers.BaseURL()}
+		if res.CancelledAt.IsZero() && m.emailGuest(r, res, "Your Reservation Was Cancelled", emails.GuestCancellation, data, invite...) {
+			flash += ", and the guest was emailed"
+		}
 	}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	year := r.Form.Get("y")
+	month := r.Form.Get("m")
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+	m.App.Session.Put(r.Context(), "flash", flash)
 
 	if year == "" {
 		http.Redirect(w, r, "/admin/reservations-"+src, http.StatusSeeOther)
@@ -813,6 +1211,7 @@ func (m *Repository) AdminReservationCalendar(w http.ResponseWriter, r *http.Req
 		// Create maps
 		reservationMap := make(map[string]int)
 		blockMap := make(map[string]int)
+		externalMap := make(map[string]int)
 
 		// Loop through all dates in month
 		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
@@ -838,6 +1237,13 @@ func (m *Repository) AdminReservationCalendar(w http.ResponseWriter, r *http.Req
 					reservationMap[d.Format("2006-01-2")] = y.ReservationID
 				}
 
+			} else if y.RestrictionID == models.RestrictionExternal {
+				// Blocks imported from other booking sites can only be changed there,
+				// so they're kept out of the block map
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					externalMap[d.Format("2006-01-2")] = y.ID
+				}
+
 			} else {
 				// If it's a block, associate the block ID to the corresponding date
 				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
@@ -847,6 +1253,7 @@ func (m *Repository) AdminReservationCalendar(w http.ResponseWriter, r *http.Req
 		// Add maps to data
 		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
 		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		data[fmt.Sprintf("external_map_%d", x.ID)] = externalMap
 
 		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
 
@@ -894,7 +1301,11 @@ func (m *Repository) AdminPostReservationCalendar(w http.ResponseWriter, r *http
 						err := m.DB.DeleteBlockByID(value)
 						if err != nil {
 							log.Println(err)
+							continue
 						}
+
+						date, _ := time.Parse("2006-01-2", name)
+						m.emitBlock(webhooks.BlockRemoved, room.ID, date)
 					}
 				}
 			}
@@ -911,10 +1322,228 @@ func (m *Repository) AdminPostReservationCalendar(w http.ResponseWriter, r *http
 			err := m.DB.InsertBlockForRoom(roomID, startDate)
 			if err != nil {
 				log.Println(err)
+				continue
 			}
+
+			m.emitBlock(webhooks.BlockAdded, roomID, startDate)
 		}
 	}
 
 	m.App.Session.Put(r.Context(), "flash", "Changes saved")
 	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
 }
+
+// AdminProfile displays the logged in user's profile, along with their personal
+// access tokens.
+func (m *Repository) AdminProfile(w http.ResponseWriter, r *http.Request) {
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	user, err := m.DB.GetUserByID(userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	tokens, err := m.DB.AccessTokensForUser(userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["tokens"] = tokens
+	data["sso"] = m.App.OIDC != nil
+
+	// A freshly created token is only ever shown once
+	stringMap := make(map[string]string)
+	stringMap["new_token"] = m.App.Session.PopString(r.Context(), "new_token")
+
+	render.Template(w, r, "admin-profile.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostAccessToken creates a new personal access token for the logged in user.
+// The plain token is put in the session so the profile page can show it once.
+func (m *Repository) AdminPostAccessToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "scope", "expires_in")
+
+	scope := r.Form.Get("scope")
+	if scope != models.ScopeRead && scope != models.ScopeWrite {
+		form.Errors.Add("scope", "Invalid scope")
+	}
+
+	days, err := strconv.Atoi(r.Form.Get("expires_in"))
+	if err != nil || days < 1 || days > 365 {
+		form.Errors.Add("expires_in", "Must be between 1 and 365 days")
+	}
+
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Invalid token details")
+		http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+		return
+	}
+
+	plain, hash, err := helpers.NewAccessToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	token := models.AccessToken{
+		UserID:    m.App.Session.GetInt(r.Context(), "user_id"),
+		Name:      r.Form.Get("name"),
+		TokenHash: hash,
+		Scope:     scope,
+		ExpiresAt: time.Now().AddDate(0, 0, days),
+	}
+
+	_, err = m.DB.InsertAccessToken(token)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't create token")
+		http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "new_token", plain)
+	m.App.Session.Put(r.Context(), "flash", "Token created")
+	http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+}
+
+// AdminRevokeAccessToken revokes one of the logged in user's personal access tokens.
+func (m *Repository) AdminRevokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteAccessToken(id, m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Token revoked")
+	http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+}
+
+// AdminPostDailyDigest turns the daily digest email on or off for the logged
+// in user
+func (m *Repository) AdminPostDailyDigest(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	on := r.Form.Get("daily_digest") != ""
+
+	err = m.DB.UpdateDailyDigest(m.App.Session.GetInt(r.Context(), "user_id"), on)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	flash := "You'll no longer get the daily digest"
+	if on {
+		flash = "You'll get the daily digest every day at " + formatTimeOfDay(m.App.DigestTime)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+}
+
+// formatTimeOfDay formats how long after midnight something happens as a time
+// of day, like 7:00 AM
+func formatTimeOfDay(d time.Duration) string {
+	return time.Time{}.Add(d).Format("3:04 PM")
+}
+
+// sessionID derives the ID a session is shown with from its token. The token
+// itself is never sent to the browser, as anyone holding it could take over the
+// session.
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// AdminSessions displays all the sessions the logged in user is logged in with,
+// so they can revoke any they don't recognise.
+func (m *Repository) AdminSessions(w http.ResponseWriter, r *http.Request) {
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	current := sessionID(m.App.Session.Token(r.Context()))
+
+	sessions, err := m.DB.SessionsForUser(userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].ID = sessionID(sessions[i].Token)
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	data := make(map[string]interface{})
+	data["sessions"] = sessions
+
+	render.Template(w, r, "admin-sessions.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRevokeSession logs out one of the logged in user's sessions by deleting
+// it from the session store. Revoking the current session logs the user out.
+func (m *Repository) AdminRevokeSession(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	current := sessionID(m.App.Session.Token(r.Context()))
+
+	// Users can only revoke their own sessions
+	sessions, err := m.DB.SessionsForUser(userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	found := false
+
+	for _, s := range sessions {
+		if sessionID(s.Token) != id {
+			continue
+		}
+
+		found = true
+
+		err = m.App.Session.Store.Delete(s.Token)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !found {
+		m.App.Session.Put(r.Context(), "error", "Session not found")
+		http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+		return
+	}
+
+	if id == current {
+		http.Redirect(w, r, "/user/logout", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Session revoked")
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
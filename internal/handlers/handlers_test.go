@@ -9,11 +9,13 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/BlackSound1/Go-B-and-B/internal/driver"
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
 	"github.com/BlackSound1/Go-B-and-B/internal/models"
 	"github.com/go-chi/chi"
 )
@@ -35,12 +37,28 @@ var handlerTests = []struct {
 	{"non-existant-route", "/nothing-here", "GET", http.StatusNotFound},
 	{"login", "/user/login", "GET", http.StatusOK},
 	{"logout", "/user/logout", "GET", http.StatusOK},
+	{"guest-register", "/guest/register", "GET", http.StatusOK},
+	{"guest-login", "/guest/login", "GET", http.StatusOK},
+	{"guest-logout", "/guest/logout", "GET", http.StatusOK},
 	{"dashboard", "/admin/dashboard", "GET", http.StatusOK},
 	{"reservation - new", "/admin/reservations-new", "GET", http.StatusOK},
 	{"reservation - all", "/admin/reservations-all", "GET", http.StatusOK},
 	{"reservation - show", "/admin/reservations/new/1/show", "GET", http.StatusOK},
 	{"reservation-calendar", "/admin/reservations-calendar", "GET", http.StatusOK},
 	{"reservation-calendar-with-params", "/admin/reservations-calendar?y=2020&m=2", "GET", http.StatusOK},
+	{"profile", "/admin/profile", "GET", http.StatusOK},
+	{"sessions", "/admin/sessions", "GET", http.StatusOK},
+	{"calendar-feeds", "/admin/calendar-feeds", "GET", http.StatusOK},
+	{"charges", "/admin/charges", "GET", http.StatusOK},
+	{"webhooks", "/admin/webhooks", "GET", http.StatusOK},
+	{"webhook", "/admin/webhooks/1", "GET", http.StatusOK},
+	{"webhook-non-existent", "/admin/webhooks/2", "GET", http.StatusNotFound},
+	{"emails", "/admin/emails", "GET", http.StatusOK},
+	{"emails-failed", "/admin/emails?status=failed", "GET", http.StatusOK},
+	{"emails-bad-status", "/admin/emails?status=lost", "GET", http.StatusBadRequest},
+	{"api-reservations", "/api/v1/reservations", "GET", http.StatusOK},
+	{"api-docs", "/api/docs", "GET", http.StatusOK},
+	{"openapi", "/api/v1/openapi.json", "GET", http.StatusOK},
 }
 
 // TestHandlers tests all the routes in the application. It sends a GET request to
@@ -86,6 +104,15 @@ var reservationTests = []struct {
 		expectedStatusCode: http.StatusOK,
 		expectedHTML:       `action="/make-reservation"`,
 	},
+	{
+		name: "form-has-idempotency-key",
+		reservation: models.Reservation{
+			RoomID: 1,
+			Room:   models.Room{ID: 1, RoomName: "General's Quarters"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `name="idempotency_key" value="`,
+	},
 	{
 		name:               "reservation-not-in-session",
 		reservation:        models.Reservation{},
@@ -156,12 +183,13 @@ var postReservationTests = []struct {
 	expectedResponseCode int
 	expectedLocation     string
 	expectedHTML         string
+	expectedEmails       []string // Who was emailed. Nil doesn't check
 }{
 	{
 		name: "valid-data",
 		postedData: url.Values{
-			"start_date": {"2050-01-01"},
-			"end_date":   {"2050-01-02"},
+			"start_date": {"2040-01-01"},
+			"end_date":   {"2040-01-02"},
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
@@ -171,6 +199,43 @@ var postReservationTests = []struct {
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
 		expectedLocation:     "/reservation-summary",
+		expectedEmails:       []string{"john@smith.com", "me@here.com", "john@smith.com"}, // Confirmations, then the invite
+	},
+	{
+		// The room is taken in 2050, so this only passes if nothing is inserted
+		name: "replayed-submission",
+		postedData: url.Values{
+			"start_date":      {"2050-01-01"},
+			"end_date":        {"2050-01-02"},
+			"first_name":      {"John"},
+			"last_name":       {"Smith"},
+			"email":           {"John@Smith.com"},
+			"phone":           {"555-555-5555"},
+			"room_id":         {"1"},
+			"idempotency_key": {"replayed-key"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/reservation-summary",
+		expectedEmails:       []string{},
+	},
+	{
+		// A key that made someone else's reservation is ignored
+		name: "replayed-key-different-guest",
+		postedData: url.Values{
+			"start_date":      {"2050-01-01"},
+			"end_date":        {"2050-01-02"},
+			"first_name":      {"Jane"},
+			"last_name":       {"Smith"},
+			"email":           {"jane@smith.com"},
+			"phone":           {"555-555-5555"},
+			"room_id":         {"1"},
+			"idempotency_key": {"replayed-key"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/search-availability",
+		expectedEmails:       []string{},
 	},
 	{
 		name:                 "missing-post-body",
@@ -183,7 +248,7 @@ var postReservationTests = []struct {
 		name: "invalid-start-date",
 		postedData: url.Values{
 			"start_date": {"invalid"},
-			"end_date":   {"2050-01-02"},
+			"end_date":   {"2040-01-02"},
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
@@ -197,7 +262,7 @@ var postReservationTests = []struct {
 	{
 		name: "invalid-end-date",
 		postedData: url.Values{
-			"start_date": {"2050-01-01"},
+			"start_date": {"2040-01-01"},
 			"end_date":   {"invalid"},
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
@@ -212,8 +277,8 @@ var postReservationTests = []struct {
 	{
 		name: "invalid-room-id",
 		postedData: url.Values{
-			"start_date": {"2050-01-01"},
-			"end_date":   {"2050-01-02"},
+			"start_date": {"2040-01-01"},
+			"end_date":   {"2040-01-02"},
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
@@ -227,8 +292,8 @@ var postReservationTests = []struct {
 	{
 		name: "invalid-data",
 		postedData: url.Values{
-			"start_date": {"2050-01-01"},
-			"end_date":   {"2050-01-02"},
+			"start_date": {"2040-01-01"},
+			"end_date":   {"2040-01-02"},
 			"first_name": {"J"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
@@ -239,11 +304,27 @@ var postReservationTests = []struct {
 		expectedHTML:         `action="/make-reservation"`,
 		expectedLocation:     "",
 	},
+	{
+		name: "too-many-guests",
+		postedData: url.Values{
+			"start_date": {"2040-01-01"},
+			"end_date":   {"2040-01-02"},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"1"},
+			"guests":     {"99"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Must be from 1 to 8 guests",
+		expectedLocation:     "",
+	},
 	{
 		name: "DB-insert-fails-reservation",
 		postedData: url.Values{
-			"start_date": {"2050-01-01"},
-			"end_date":   {"2050-01-02"},
+			"start_date": {"2040-01-01"},
+			"end_date":   {"2040-01-02"},
 			"first_name": {"John"},
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
@@ -253,9 +334,10 @@ var postReservationTests = []struct {
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
 		expectedLocation:     "/",
+		expectedEmails:       []string{},
 	},
 	{
-		name: "DB-insert-fails-restriction",
+		name: "room-taken",
 		postedData: url.Values{
 			"start_date": {"2050-01-01"},
 			"end_date":   {"2050-01-02"},
@@ -263,11 +345,12 @@ var postReservationTests = []struct {
 			"last_name":  {"Smith"},
 			"email":      {"john@smith.com"},
 			"phone":      {"555-555-5555"},
-			"room_id":    {"1000"},
+			"room_id":    {"1"},
 		},
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
-		expectedLocation:     "/",
+		expectedLocation:     "/search-availability",
+		expectedEmails:       []string{},
 	},
 }
 
@@ -287,10 +370,24 @@ func TestPostReservation(t *testing.T) {
 		req = req.WithContext(ctx)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+		sentMail.Reset()
+
 		recorder := httptest.NewRecorder()
 		handler := http.HandlerFunc(Repo.PostReservation)
 		handler.ServeHTTP(recorder, req)
 
+		// Check who was emailed
+		if test.expectedEmails != nil {
+			var to []string
+			for _, msg := range sentMail.Sent() {
+				to = append(to, msg.To)
+			}
+
+			if !slices.Equal(to, test.expectedEmails) {
+				t.Errorf("%s expected emails to %v, but sent them to %v", test.name, test.expectedEmails, to)
+			}
+		}
+
 		// Check status code
 		if recorder.Code != test.expectedResponseCode {
 			t.Errorf("%s expected code %d, but got %d", test.name, test.expectedResponseCode, recorder.Code)
@@ -404,45 +501,29 @@ func TestPostAvailability(t *testing.T) {
 
 // Create a set of tests to run
 var availabilityJSONTests = []struct {
-	name            string
-	postedData      url.Values
-	expectedOK      bool
-	expectedMessage string
+	name               string
+	method             string
+	contentType        string
+	body               string
+	expectedStatusCode int
+	expectedOK         bool
+	expectedFields     []string
+	expectedConflicts  []string
 }{
-	{
-		name: "rooms-not-available",
-		postedData: url.Values{
-			"start":   {"2050-01-01"},
-			"end":     {"2050-01-02"},
-			"room_id": {"1"},
-		},
-		expectedOK: false,
-	},
-	{
-		name: "rooms-available",
-		postedData: url.Values{
-			"start":   {"2040-01-01"},
-			"end":     {"2040-01-02"},
-			"room_id": {"1"},
-		},
-		expectedOK: true,
-	},
-	{
-		name:            "empty-post-body",
-		postedData:      nil,
-		expectedOK:      false,
-		expectedMessage: "Internal Server Error",
-	},
-	{
-		name: "DB-query-fails",
-		postedData: url.Values{
-			"start":   {"2060-01-01"},
-			"end":     {"2060-01-02"},
-			"room_id": {"1"},
-		},
-		expectedOK:      false,
-		expectedMessage: "Error querying database",
-	},
+	{"rooms-not-available", "POST", "form", "start=2050-01-01&end=2050-01-02&room_id=1", http.StatusOK, false, nil, nil},
+	{"rooms-available", "POST", "form", "start=2040-01-01&end=2040-01-02&room_id=1", http.StatusOK, true, nil, nil},
+	{"conflicting-nights", "POST", "form", "start=2050-01-09&end=2050-01-12&room_id=2", http.StatusOK, false, nil, []string{"2050-01-10", "2050-01-11"}},
+	{"query-string", "GET", "", "start=2040-01-01&end=2040-01-02&room_id=1", http.StatusOK, true, nil, nil},
+	{"json-body", "POST", "json", `{"start": "2040-01-01", "end": "2040-01-02", "room_id": 1}`, http.StatusOK, true, nil, nil},
+	{"invalid-json", "POST", "json", `{"start": `, http.StatusBadRequest, false, nil, nil},
+	{"json-without-room", "POST", "json", `{"start": "2040-01-01", "end": "2040-01-02"}`, http.StatusBadRequest, false, []string{"room_id"}, nil},
+	{"empty-post-body", "POST", "form", "", http.StatusBadRequest, false, []string{"start", "end", "room_id"}, nil},
+	{"end-before-start", "POST", "form", "start=2040-01-02&end=2040-01-01&room_id=1", http.StatusBadRequest, false, []string{"end"}, nil},
+	{"start-in-past", "POST", "form", "start=2020-01-01&end=2020-01-02&room_id=1", http.StatusBadRequest, false, []string{"start"}, nil},
+	{"shorter-than-min-stay", "POST", "form", "start=2040-01-01&end=2040-01-02&room_id=2", http.StatusBadRequest, false, []string{"end"}, nil},
+	{"non-existent-room", "POST", "form", "start=2040-01-01&end=2040-01-02&room_id=100", http.StatusNotFound, false, nil, nil},
+	{"DB-query-fails", "POST", "form", "start=2060-01-01&end=2060-01-02&room_id=1", http.StatusInternalServerError, false, nil, nil},
+	{"room-lookup-fails", "POST", "form", "start=2040-01-01&end=2040-01-02&room_id=99", http.StatusInternalServerError, false, nil, nil},
 }
 
 // TestAvailabilityJSON tests the AvailabilityJSON handler for various scenarios.
@@ -450,20 +531,34 @@ func TestAvailabilityJSON(t *testing.T) {
 	for _, test := range availabilityJSONTests {
 		var req *http.Request
 
-		if test.postedData != nil {
-			req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(test.postedData.Encode()))
+		if test.method == "GET" {
+			req, _ = http.NewRequest("GET", "/search-availability-json?"+test.body, nil)
 		} else {
-			req, _ = http.NewRequest("POST", "/search-availability-json", nil)
+			req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(test.body))
 		}
 
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		switch test.contentType {
+		case "form":
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		case "json":
+			req.Header.Set("Content-Type", "application/json")
+		}
+
 		recorder := httptest.NewRecorder()
 		handler := http.HandlerFunc(Repo.AvailabilityJSON)
 		handler.ServeHTTP(recorder, req)
 
-		var JSONResponse jsonResponse
+		if recorder.Code != test.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatusCode)
+		}
+
+		var JSONResponse struct {
+			jsonResponse
+			Error helpers.APIError `json:"error"`
+		}
 
 		err := json.Unmarshal(recorder.Body.Bytes(), &JSONResponse)
 
@@ -475,6 +570,46 @@ func TestAvailabilityJSON(t *testing.T) {
 		if JSONResponse.Ok != test.expectedOK {
 			t.Errorf("%s: expected %v but got %v", test.name, test.expectedOK, JSONResponse.Ok)
 		}
+
+		// Check that each invalid field is reported
+		for _, field := range test.expectedFields {
+			if JSONResponse.Error.Fields[field] == "" {
+				t.Errorf("%s did not report an error for %s: %s", test.name, field, recorder.Body.String())
+			}
+		}
+
+		if strings.Join(JSONResponse.ConflictingNights, ",") != strings.Join(test.expectedConflicts, ",") {
+			t.Errorf("%s: expected conflicting nights %v, got %v", test.name, test.expectedConflicts, JSONResponse.ConflictingNights)
+		}
+	}
+}
+
+// TestAvailabilityJSONBookingHorizon tests that stays can't end past the booking horizon
+func TestAvailabilityJSONBookingHorizon(t *testing.T) {
+	app.BookingHorizon = 30
+	defer func() { app.BookingHorizon = 0 }()
+
+	start := time.Now().AddDate(0, 0, 1)
+
+	tests := []struct {
+		end      time.Time
+		expected int
+	}{
+		{start.AddDate(0, 0, 2), http.StatusOK},
+		{start.AddDate(0, 0, 60), http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		query := fmt.Sprintf("start=%s&end=%s&room_id=1", start.Format("2006-01-02"), test.end.Format("2006-01-02"))
+
+		req, _ := http.NewRequest("GET", "/search-availability-json?"+query, nil)
+		recorder := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.AvailabilityJSON).ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d", query, test.expected, recorder.Code)
+		}
 	}
 }
 
@@ -718,6 +853,7 @@ var adminPostShowReservationsTests = []struct {
 	expectedResponseCode int
 	expectedLocation     string
 	expectedHTML         string
+	expectedFlash        string
 }{
 	{
 		name: "valid-data-from-new",
@@ -731,6 +867,7 @@ var adminPostShowReservationsTests = []struct {
 		expectedResponseCode: http.StatusSeeOther,
 		expectedLocation:     "/admin/reservations-new",
 		expectedHTML:         "",
+		expectedFlash:        "Changes saved, and the guest was emailed",
 	},
 	{
 		name: "valid-data-from-all",
@@ -744,6 +881,7 @@ var adminPostShowReservationsTests = []struct {
 		expectedResponseCode: http.StatusSeeOther,
 		expectedLocation:     "/admin/reservations-all",
 		expectedHTML:         "",
+		expectedFlash:        "Changes saved, and the guest was emailed",
 	},
 	{
 		name: "valid-data-from-cal",
@@ -759,6 +897,33 @@ var adminPostShowReservationsTests = []struct {
 		expectedResponseCode: http.StatusSeeOther,
 		expectedLocation:     "/admin/reservations-calendar?y=2022&m=01",
 		expectedHTML:         "",
+		expectedFlash:        "Changes saved, and the guest was emailed",
+	},
+	{
+		name: "suppress-email",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name":     {"John"},
+			"last_name":      {"Doe"},
+			"email":          {"john@doe.com"},
+			"phone":          {"555-555-5555"},
+			"suppress_email": {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-all",
+		expectedFlash:        "Changes saved",
+	},
+	{
+		name: "nothing-changed",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-all",
+		expectedFlash:        "Changes saved",
 	},
 }
 
@@ -803,6 +968,11 @@ func TestAdminPostShowReservations(t *testing.T) {
 				t.Errorf("Test %s expected to find %s, but didn't", test.name, test.expectedHTML)
 			}
 		}
+
+		// Check the guest was emailed, or not
+		if flash := session.PopString(ctx, "flash"); test.expectedFlash != "" && flash != test.expectedFlash {
+			t.Errorf("Test %s expected flash %q, but got %q", test.name, test.expectedFlash, flash)
+		}
 	}
 }
 
@@ -895,31 +1065,55 @@ func TestPostReservationCalendar(t *testing.T) {
 // Create a set of tests to run
 var adminProcessReservationTests = []struct {
 	name                 string
-	queryParams          string
+	id                   string
+	postedData           string
 	expectedResponseCode int
 	expectedLocation     string
+	expectedFlash        string
 }{
 	{
 		name:                 "process-reservation",
-		queryParams:          "",
+		postedData:           "",
 		expectedResponseCode: http.StatusSeeOther,
 		expectedLocation:     "",
+		expectedFlash:        "Reservation marked as processed, and the guest was emailed",
 	},
 	{
 		name:                 "process-reservation-back-to-calendar",
-		queryParams:          "?y=2021&m=12",
+		postedData:           "y=2021&m=12",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-calendar?y=2021&m=12",
+		expectedFlash:        "Reservation marked as processed, and the guest was emailed",
+	},
+	{
+		name:                 "process-reservation-suppress-email",
+		postedData:           "suppress_email=1",
 		expectedResponseCode: http.StatusSeeOther,
 		expectedLocation:     "",
+		expectedFlash:        "Reservation marked as processed",
+	},
+	{
+		name:                 "already-processed",
+		id:                   "104",
+		postedData:           "",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "",
+		expectedFlash:        "Reservation was already processed",
 	},
 }
 
 // TestAdminProcessReservation tests the AdminProcessReservation handler for various scenarios.
 func TestAdminProcessReservation(t *testing.T) {
 	for _, test := range adminProcessReservationTests {
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", test.queryParams), nil)
+		req, _ := http.NewRequest("POST", "/admin/process-reservation/cal/1/do", strings.NewReader(test.postedData))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 		ctx := getCtx(req)
+		if test.id != "" {
+			ctx = addIdToChiContext(ctx, test.id)
+		}
 		req = req.WithContext(ctx)
+		sentMail.Reset()
 		recorder := httptest.NewRecorder()
 
 		handler := http.HandlerFunc(Repo.AdminProcessReservation)
@@ -929,35 +1123,102 @@ func TestAdminProcessReservation(t *testing.T) {
 		if recorder.Code != test.expectedResponseCode {
 			t.Errorf("Test %s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedResponseCode)
 		}
+
+		if location := recorder.Header().Get("Location"); test.expectedLocation != "" && location != test.expectedLocation {
+			t.Errorf("Test %s returned wrong location: got %s, wanted %s", test.name, location, test.expectedLocation)
+		}
+
+		// Check the guest was emailed, or not
+		if flash := session.PopString(ctx, "flash"); flash != test.expectedFlash {
+			t.Errorf("Test %s expected flash %q, but got %q", test.name, test.expectedFlash, flash)
+		}
 	}
 }
 
 // Create a set of tests to run
 var adminDeleteReservationTests = []struct {
 	name                 string
-	queryParams          string
+	id                   string
+	postedData           string
 	expectedResponseCode int
 	expectedLocation     string
+	expectedFlash        string
 }{
 	{
 		name:                 "delete-reservation",
-		queryParams:          "",
+		id:                   "5",
+		postedData:           "",
 		expectedResponseCode: http.StatusSeeOther,
 		expectedLocation:     "",
+		expectedFlash:        "Reservation deleted, and the guest was emailed",
 	},
 	{
 		name:                 "delete-reservation-back-to-calendar",
-		queryParams:          "?y=2021&m=12",
+		id:                   "5",
+		postedData:           "y=2021&m=12",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-calendar?y=2021&m=12",
+		expectedFlash:        "Reservation deleted, and the guest was emailed",
+	},
+	{
+		name:                 "delete-reservation-suppress-email",
+		id:                   "5",
+		postedData:           "suppress_email=1",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "",
+		expectedFlash:        "Reservation deleted",
+	},
+	{
+		name:                 "invoiced-reservation-is-cancelled",
+		id:                   "1",
+		postedData:           "",
 		expectedResponseCode: http.StatusSeeOther,
 		expectedLocation:     "",
+		expectedFlash:        "Reservation cancelled instead, as it has an invoice, and the guest was emailed",
+	},
+	{
+		name:                 "paid-reservation-is-cancelled",
+		id:                   "103",
+		postedData:           "",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "",
+		expectedFlash:        "Reservation cancelled instead, as it has payments, and the guest was emailed",
+	},
+	{
+		name:                 "paid-reservation-cancelled-concurrently",
+		id:                   "105",
+		postedData:           "",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "",
+		expectedFlash:        "Reservation is already cancelled, and kept as it has payments",
+	},
+	{
+		name:                 "non-existent-reservation",
+		id:                   "100",
+		postedData:           "",
+		expectedResponseCode: http.StatusNotFound,
+		expectedLocation:     "",
+		expectedFlash:        "",
+	},
+	{
+		name:                 "cant-look-up-invoice",
+		id:                   "102",
+		postedData:           "",
+		expectedResponseCode: http.StatusInternalServerError,
+		expectedLocation:     "",
+		expectedFlash:        "",
 	},
 }
 
 // TestAdminDeleteReservation tests the AdminDeleteReservation handler for various scenarios.
 func TestAdminDeleteReservation(t *testing.T) {
 	for _, test := range adminDeleteReservationTests {
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", test.queryParams), nil)
+		req, _ := http.NewRequest("POST", "/admin/delete-reservation/cal/1/do", strings.NewReader(test.postedData))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		ctx := getCtx(req)
+		if test.id != "" {
+			ctx = addIdToChiContext(ctx, test.id)
+		}
 		req = req.WithContext(ctx)
 		recorder := httptest.NewRecorder()
 		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
@@ -967,6 +1228,182 @@ func TestAdminDeleteReservation(t *testing.T) {
 		if recorder.Code != test.expectedResponseCode {
 			t.Errorf("Test %s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedResponseCode)
 		}
+
+		if location := recorder.Header().Get("Location"); test.expectedLocation != "" && location != test.expectedLocation {
+			t.Errorf("Test %s returned wrong location: got %s, wanted %s", test.name, location, test.expectedLocation)
+		}
+
+		// Check the guest was emailed, or not
+		if flash := session.PopString(ctx, "flash"); flash != test.expectedFlash {
+			t.Errorf("Test %s expected flash %q, but got %q", test.name, test.expectedFlash, flash)
+		}
+	}
+}
+
+// Create a set of tests to run
+var adminPostAccessTokenTests = []struct {
+	name            string
+	postedData      url.Values
+	expectedFlash   string
+	expectedError   string
+	expectsNewToken bool
+}{
+	{
+		name:            "valid-token",
+		postedData:      url.Values{"name": {"Channel sync"}, "scope": {"write"}, "expires_in": {"90"}},
+		expectedFlash:   "Token created",
+		expectsNewToken: true,
+	},
+	{
+		name:          "invalid-scope",
+		postedData:    url.Values{"name": {"Channel sync"}, "scope": {"admin"}, "expires_in": {"90"}},
+		expectedError: "Invalid token details",
+	},
+	{
+		name:          "invalid-expiry",
+		postedData:    url.Values{"name": {"Channel sync"}, "scope": {"read"}, "expires_in": {"1000"}},
+		expectedError: "Invalid token details",
+	},
+	{
+		name:          "missing-name",
+		postedData:    url.Values{"scope": {"read"}, "expires_in": {"30"}},
+		expectedError: "Invalid token details",
+	},
+	{
+		name:          "DB-insert-fails",
+		postedData:    url.Values{"name": {"fail"}, "scope": {"read"}, "expires_in": {"30"}},
+		expectedError: "Can't create token",
+	},
+}
+
+// TestAdminPostAccessToken tests the AdminPostAccessToken handler for various scenarios.
+func TestAdminPostAccessToken(t *testing.T) {
+	for _, test := range adminPostAccessTokenTests {
+		req, _ := http.NewRequest("POST", "/admin/profile/tokens", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostAccessToken)
+		handler.ServeHTTP(recorder, req)
+
+		// Check status code
+		if recorder.Code != http.StatusSeeOther {
+			t.Errorf("Test %s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, http.StatusSeeOther)
+		}
+
+		// Check the messages put in the session
+		if flash := session.PopString(ctx, "flash"); flash != test.expectedFlash {
+			t.Errorf("Test %s expected flash %q, but got %q", test.name, test.expectedFlash, flash)
+		}
+
+		if e := session.PopString(ctx, "error"); e != test.expectedError {
+			t.Errorf("Test %s expected error %q, but got %q", test.name, test.expectedError, e)
+		}
+
+		// The plain token should only be handed out when one was created
+		newToken := session.PopString(ctx, "new_token")
+		if test.expectsNewToken && !strings.HasPrefix(newToken, "gbb_") {
+			t.Errorf("Test %s expected a new token in the session, but got %q", test.name, newToken)
+		}
+
+		if !test.expectsNewToken && newToken != "" {
+			t.Errorf("Test %s did not expect a new token in the session", test.name)
+		}
+	}
+}
+
+// TestAdminRevokeAccessToken tests the AdminRevokeAccessToken handler.
+func TestAdminRevokeAccessToken(t *testing.T) {
+	for _, id := range []string{"1", "bad-id"} {
+		req, _ := http.NewRequest("POST", "/admin/profile/tokens/"+id+"/revoke", nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, id)
+		req = req.WithContext(ctx)
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminRevokeAccessToken)
+		handler.ServeHTTP(recorder, req)
+
+		expected := http.StatusSeeOther
+		if id == "bad-id" {
+			expected = http.StatusBadRequest
+		}
+
+		if recorder.Code != expected {
+			t.Errorf("revoking token %s returned wrong response code: got %d, wanted %d", id, recorder.Code, expected)
+		}
+	}
+}
+
+// TestAdminPostDailyDigest tests the AdminPostDailyDigest handler.
+func TestAdminPostDailyDigest(t *testing.T) {
+	tests := map[string]string{
+		"daily_digest=1": "You'll get the daily digest every day at 7:00 AM",
+		"":               "You'll no longer get the daily digest",
+	}
+
+	for body, expectedFlash := range tests {
+		req, _ := http.NewRequest("POST", "/admin/profile/digest", strings.NewReader(body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostDailyDigest)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusSeeOther {
+			t.Errorf("posting %q returned wrong response code: got %d, wanted %d", body, recorder.Code, http.StatusSeeOther)
+		}
+
+		if flash := session.PopString(ctx, "flash"); flash != expectedFlash {
+			t.Errorf("posting %q expected flash %q, but got %q", body, expectedFlash, flash)
+		}
+	}
+}
+
+// TestAdminRevokeSession tests that users can only revoke their own sessions
+func TestAdminRevokeSession(t *testing.T) {
+	// The test repo has user 1 logged in on another "device"
+	token := "other-device"
+
+	tests := []struct {
+		name     string
+		id       string
+		userID   int
+		expected bool // Whether the other session should still exist afterwards
+	}{
+		{"unknown-session", "deadbeef", 1, true},
+		{"other-users-session", sessionID(token), 2, true},
+		{"own-session", sessionID(token), 1, false},
+	}
+
+	for _, test := range tests {
+		err := session.Store.Commit(token, []byte("data"), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, _ := http.NewRequest("POST", "/admin/sessions/"+test.id+"/revoke", nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, test.id)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", test.userID)
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminRevokeSession)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, http.StatusSeeOther)
+		}
+
+		_, exists, _ := session.Store.Find(token)
+		if exists != test.expected {
+			t.Errorf("%s: expected other session to exist to be %t, but got %t", test.name, test.expected, exists)
+		}
 	}
 }
 
@@ -990,3 +1427,20 @@ func getCtx(req *http.Request) context.Context {
 
 	return ctx
 }
+
+// TestReservationChanges tests that only the details that changed are listed
+func TestReservationChanges(t *testing.T) {
+	old := models.Reservation{FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "555"}
+
+	updated := old
+	updated.Email = "john@doe.com"
+
+	changes := reservationChanges(old, updated)
+	if len(changes) != 1 || changes[0].Field != "Email" || changes[0].Old != "john@smith.com" || changes[0].New != "john@doe.com" {
+		t.Errorf("expected only the email to have changed, got %+v", changes)
+	}
+
+	if changes := reservationChanges(old, old); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
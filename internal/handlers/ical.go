@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/forms"
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/ical"
+	"github.com/BlackSound1/Go-B-and-B/internal/icalsync"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// How far back and ahead the calendar feeds go
+const (
+	icalPastDays    = 90
+	icalFutureYears = 2
+)
+
+// feedLink is a calendar feed staff can copy into a booking site
+type feedLink struct {
+	Name string
+	URL  string
+}
+
+// RoomICalFeed serves a room's reservations and blocks as an iCalendar feed.
+// Guests' details are left out, since booking sites only need to know the room is busy.
+func (m *Repository) RoomICalFeed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || !m.validFeedToken(roomFeedName(id), chi.URLParam(r, "token")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	events, err := m.roomEvents(room, "")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	writeICal(w, ical.Calendar{Name: "Go B & B - " + room.RoomName, Events: events})
+}
+
+// PropertyICalFeed serves the reservations and blocks of every room as one
+// iCalendar feed
+func (m *Repository) PropertyICalFeed(w http.ResponseWriter, r *http.Request) {
+	if !m.validFeedToken(propertyFeedName, chi.URLParam(r, "token")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var events []ical.Event
+
+	for _, room := range rooms {
+		roomEvents, err := m.roomEvents(room, " - "+room.RoomName)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		events = append(events, roomEvents...)
+	}
+
+	writeICal(w, ical.Calendar{Name: "Go B & B", Events: events})
+}
+
+// AdminCalendarFeeds shows the secret URLs of the calendar feeds, and the
+// calendars imported from other booking sites
+func (m *Repository) AdminCalendarFeeds(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	imports, err := m.DB.AllICalFeeds()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data["rooms"] = rooms
+	data["imports"] = imports
+
+	if len(m.App.ICalSecret) > 0 {
+		feeds := []feedLink{{Name: "All rooms", URL: m.propertyFeedURL()}}
+		for _, room := range rooms {
+			feeds = append(feeds, feedLink{Name: room.RoomName, URL: m.roomFeedURL(room.ID)})
+		}
+
+		data["feeds"] = feeds
+	}
+
+	render.Template(w, r, "admin-calendar-feeds.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminPostICalImport adds another booking site's calendar to import into a
+// room, and syncs it straight away
+func (m *Repository) AdminPostICalImport(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "name", "url")
+
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err != nil {
+		form.Errors.Add("room_id", "Invalid room")
+	} else if _, err := m.DB.GetRoomByID(roomID); err != nil {
+		form.Errors.Add("room_id", "Invalid room")
+	}
+
+	u, err := url.Parse(r.Form.Get("url"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		form.Errors.Add("url", "Must be an http or https URL")
+	}
+
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Invalid calendar details")
+		http.Redirect(w, r, "/admin/calendar-feeds", http.StatusSeeOther)
+		return
+	}
+
+	feed := models.ICalFeed{
+		RoomID: roomID,
+		Name:   r.Form.Get("name"),
+		URL:    u.String(),
+	}
+
+	feed.ID, err = m.DB.InsertICalFeed(feed)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't add calendar")
+		http.Redirect(w, r, "/admin/calendar-feeds", http.StatusSeeOther)
+		return
+	}
+
+	m.syncICalImport(r, feed, "Calendar added")
+	http.Redirect(w, r, "/admin/calendar-feeds", http.StatusSeeOther)
+}
+
+// AdminSyncICalImport syncs an imported calendar now, rather than waiting for
+// the poller
+func (m *Repository) AdminSyncICalImport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	feed, err := m.DB.GetICalFeedByID(id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	m.syncICalImport(r, feed, "Calendar synced")
+	http.Redirect(w, r, "/admin/calendar-feeds", http.StatusSeeOther)
+}
+
+// AdminDeleteICalImport stops importing a calendar, and removes its blocks
+func (m *Repository) AdminDeleteICalImport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteICalFeed(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar removed")
+	http.Redirect(w, r, "/admin/calendar-feeds", http.StatusSeeOther)
+}
+
+// syncICalImport syncs an imported calendar, and tells staff how it went
+func (m *Repository) syncICalImport(r *http.Request, feed models.ICalFeed, done string) {
+	result, err := icalsync.New(m.DB, m.App.ErrorLog).SyncFeed(r.Context(), feed)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", done+", but syncing it failed: "+err.Error())
+		return
+	}
+
+	msg := fmt.Sprintf("%s: %d new, %d changed and %d removed blocks", done, result.Created, result.Updated, result.Removed)
+
+	if len(result.Conflicts) > 0 {
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("%s. %d overlap reservations", msg, len(result.Conflicts)))
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", msg)
+}
+
+// roomEvents turns a room's reservations and blocks into calendar events. The
+// restriction's ID makes a stable UID, so changes replace the old event and
+// cancellations remove it.
+func (m *Repository) roomEvents(room models.Room, suffix string) ([]ical.Event, error) {
+	now := time.Now()
+	start := now.AddDate(0, 0, -icalPastDays)
+	end := now.AddDate(icalFutureYears, 0, 0)
+
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	host := "localhost"
+	if u, err := url.Parse(helpers.BaseURL()); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+
+	var events []ical.Event
+
+	for _, rr := range restrictions {
+		// Blocks imported from a booking site are left out, or they would
+		// echo back to it
+		if rr.RestrictionID == models.RestrictionExternal {
+			continue
+		}
+
+		summary := "Blocked"
+		if rr.ReservationID > 0 {
+			summary = "Reserved"
+		}
+
+		events = append(events, ical.Event{
+			UID:      fmt.Sprintf("room-restriction-%d@%s", rr.ID, host),
+			Summary:  summary + suffix,
+			Start:    rr.StartDate,
+			End:      rr.EndDate,
+			Modified: rr.UpdatedAt,
+		})
+	}
+
+	return events, nil
+}
+
+// writeICal writes a calendar feed response
+func writeICal(w http.ResponseWriter, cal ical.Calendar) {
+	var buf bytes.Buffer
+
+	err := ical.Write(&buf, cal)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
+
+// propertyFeedName names the feed of every room
+const propertyFeedName = "property"
+
+// roomFeedName names a room's feed
+func roomFeedName(roomID int) string {
+	return fmt.Sprintf("room-%d", roomID)
+}
+
+// feedToken signs a feed's name, so only people given the URL can read it
+func (m *Repository) feedToken(name string) string {
+	mac := hmac.New(sha256.New, m.App.ICalSecret)
+	mac.Write([]byte("ical:" + name))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validFeedToken reports whether token is the right one for a feed. Feeds are
+// off if there is no secret to sign them with.
+func (m *Repository) validFeedToken(name, token string) bool {
+	if len(m.App.ICalSecret) == 0 {
+		return false
+	}
+
+	return hmac.Equal([]byte(token), []byte(m.feedToken(name)))
+}
+
+// roomFeedURL returns the full secret URL of a room's feed
+func (m *Repository) roomFeedURL(roomID int) string {
+	return fmt.Sprintf("%s/ical/rooms/%d/%s.ics", helpers.BaseURL(), roomID, m.feedToken(roomFeedName(roomID)))
+}
+
+// propertyFeedURL returns the full secret URL of the feed of every room
+func (m *Repository) propertyFeedURL() string {
+	return fmt.Sprintf("%s/ical/property/%s.ics", helpers.BaseURL(), m.feedToken(propertyFeedName))
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestICalFeeds tests that calendar feeds can only be read with the right
+// token, and list reservations and blocks without guest details or imported
+// blocks.
+func TestICalFeeds(t *testing.T) {
+	app.ICalSecret = []byte("secret")
+	defer func() { app.ICalSecret = nil }()
+
+	routes := getRoutes()
+
+	roomFeed := strings.TrimPrefix(Repo.roomFeedURL(2), "http://localhost:8080")
+	propertyFeed := strings.TrimPrefix(Repo.propertyFeedURL(), "http://localhost:8080")
+
+	tests := []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expected           []string
+	}{
+		{"room", roomFeed, http.StatusOK, []string{
+			"X-WR-CALNAME:Go B & B - \r\n",
+			"UID:room-restriction-1@localhost\r\n",
+			"SUMMARY:Reserved\r\n",
+			"DTSTART;VALUE=DATE:20500110\r\n",
+			"DTEND;VALUE=DATE:20500112\r\n",
+			"UID:room-restriction-2@localhost\r\n",
+			"SUMMARY:Blocked\r\n",
+		}},
+		{"property", propertyFeed, http.StatusOK, []string{"X-WR-CALNAME:Go B & B\r\n"}},
+		{"other-rooms-token", strings.Replace(roomFeed, "/rooms/2/", "/rooms/1/", 1), http.StatusNotFound, nil},
+		{"wrong-token", "/ical/rooms/2/forged.ics", http.StatusNotFound, nil},
+		{"non-existent-room", strings.TrimPrefix(Repo.roomFeedURL(100), "http://localhost:8080"), http.StatusNotFound, nil},
+		{"wrong-property-token", "/ical/property/forged.ics", http.StatusNotFound, nil},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.url, nil)
+		recorder := httptest.NewRecorder()
+
+		routes.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expectedStatusCode, recorder.Code)
+			continue
+		}
+
+		body := recorder.Body.String()
+
+		for _, expected := range test.expected {
+			if !strings.Contains(body, expected) {
+				t.Errorf("%s: expected %q in %q", test.name, expected, body)
+			}
+		}
+
+		// Blocks imported from booking sites mustn't echo back to them
+		if strings.Contains(body, "room-restriction-3@") {
+			t.Errorf("%s: feed contains an imported block", test.name)
+		}
+
+		// Guest details must never be shared with booking sites
+		if strings.Contains(body, "John") || strings.Contains(body, "@smith.com") {
+			t.Errorf("%s: feed contains guest details", test.name)
+		}
+	}
+}
+
+// TestICalFeedsOff tests that feeds can't be read when there's no secret
+func TestICalFeedsOff(t *testing.T) {
+	req := httptest.NewRequest("GET", "/ical/property/"+Repo.feedToken(propertyFeedName)+".ics", nil)
+	recorder := httptest.NewRecorder()
+
+	getRoutes().ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+var adminICalImportTests = []struct {
+	name            string
+	handler         func(*Repository, http.ResponseWriter, *http.Request)
+	id              string
+	postedData      url.Values
+	expectedStatus  int
+	expectedSession string // Session key the outcome is reported in
+	expectedMessage string // Start of the message
+}{
+	{
+		name:            "add",
+		handler:         (*Repository).AdminPostICalImport,
+		postedData:      url.Values{"room_id": {"1"}, "name": {"Channel"}, "url": {"http://127.0.0.1:1/feed.ics"}},
+		expectedStatus:  http.StatusSeeOther,
+		expectedSession: "error", // Nothing is listening, so the first sync fails
+		expectedMessage: "Calendar added, but syncing it failed",
+	},
+	{
+		name:            "add-missing-name",
+		handler:         (*Repository).AdminPostICalImport,
+		postedData:      url.Values{"room_id": {"1"}, "url": {"https://example.com/feed.ics"}},
+		expectedStatus:  http.StatusSeeOther,
+		expectedSession: "error",
+		expectedMessage: "Invalid calendar details",
+	},
+	{
+		name:            "add-invalid-url",
+		handler:         (*Repository).AdminPostICalImport,
+		postedData:      url.Values{"room_id": {"1"}, "name": {"Channel"}, "url": {"ftp://example.com/feed.ics"}},
+		expectedStatus:  http.StatusSeeOther,
+		expectedSession: "error",
+		expectedMessage: "Invalid calendar details",
+	},
+	{
+		name:            "add-non-existent-room",
+		handler:         (*Repository).AdminPostICalImport,
+		postedData:      url.Values{"room_id": {"100"}, "name": {"Channel"}, "url": {"https://example.com/feed.ics"}},
+		expectedStatus:  http.StatusSeeOther,
+		expectedSession: "error",
+		expectedMessage: "Invalid calendar details",
+	},
+	{
+		name:            "DB-insert-fails",
+		handler:         (*Repository).AdminPostICalImport,
+		postedData:      url.Values{"room_id": {"1"}, "name": {"fail"}, "url": {"https://example.com/feed.ics"}},
+		expectedStatus:  http.StatusSeeOther,
+		expectedSession: "error",
+		expectedMessage: "Can't add calendar",
+	},
+	{
+		name:            "sync",
+		handler:         (*Repository).AdminSyncICalImport,
+		id:              "1",
+		expectedStatus:  http.StatusSeeOther,
+		expectedSession: "error",
+		expectedMessage: "Calendar synced, but syncing it failed",
+	},
+	{
+		name:           "sync-non-existent",
+		handler:        (*Repository).AdminSyncICalImport,
+		id:             "2",
+		expectedStatus: http.StatusNotFound,
+	},
+	{
+		name:           "sync-invalid-id",
+		handler:        (*Repository).AdminSyncICalImport,
+		id:             "bad-id",
+		expectedStatus: http.StatusBadRequest,
+	},
+	{
+		name:            "delete",
+		handler:         (*Repository).AdminDeleteICalImport,
+		id:              "1",
+		expectedStatus:  http.StatusSeeOther,
+		expectedSession: "flash",
+		expectedMessage: "Calendar removed",
+	},
+	{
+		name:           "delete-invalid-id",
+		handler:        (*Repository).AdminDeleteICalImport,
+		id:             "bad-id",
+		expectedStatus: http.StatusBadRequest,
+	},
+}
+
+// TestAdminICalImports tests adding, syncing and removing imported calendars
+func TestAdminICalImports(t *testing.T) {
+	for _, test := range adminICalImportTests {
+		req, _ := http.NewRequest("POST", "/admin/calendar-feeds/import", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(addIdToChiContext(ctx, test.id))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+
+		test.handler(Repo, recorder, req)
+
+		if recorder.Code != test.expectedStatus {
+			t.Errorf("Test %s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatus)
+			continue
+		}
+
+		if test.expectedSession == "" {
+			continue
+		}
+
+		if msg := session.PopString(ctx, test.expectedSession); !strings.HasPrefix(msg, test.expectedMessage) {
+			t.Errorf("Test %s expected %s %q, but got %q", test.name, test.expectedSession, test.expectedMessage, msg)
+		}
+	}
+}
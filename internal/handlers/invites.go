@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/ical"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// inviteName is what calendar invites are called when attached to emails
+const inviteName = "stay.ics"
+
+// stayInvite builds a calendar invite for a guest's stay, from check-in to
+// check-out. The reservation's ID makes a stable UID, so an update with a
+// higher sequence replaces the invite on the guest's calendar, and a
+// cancellation takes it off.
+func (m *Repository) stayInvite(res models.Reservation, method string, sequence int) (models.Attachment, error) {
+	host := "localhost"
+	if u, err := url.Parse(helpers.BaseURL()); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+
+	start := atTimeOfDay(res.StartDate, m.App.CheckIn)
+	end := atTimeOfDay(res.EndDate, m.App.CheckOut)
+
+	var description strings.Builder
+	fmt.Fprintf(&description, "Reservation #%d\n", res.ID)
+	fmt.Fprintf(&description, "Room: %s\n", res.Room.RoomName)
+	fmt.Fprintf(&description, "Guest: %s %s\n", res.FirstName, res.LastName)
+	fmt.Fprintf(&description, "Check-in: %s\n", start.Format("Monday, January 2 at 3:04 PM"))
+	fmt.Fprintf(&description, "Check-out: %s\n", end.Format("Monday, January 2 at 3:04 PM"))
+	fmt.Fprintf(&description, "%s/", helpers.BaseURL())
+
+	event := ical.Event{
+		UID:         fmt.Sprintf("reservation-%d@%s", res.ID, host),
+		Summary:     "Stay at Go B & B - " + res.Room.RoomName,
+		Start:       start,
+		End:         end,
+		Timed:       true,
+		Modified:    time.Now(),
+		Description: description.String(),
+		Location:    m.App.PropertyAddress,
+		Sequence:    sequence,
+		Organizer:   "me@here.com",
+		Attendee:    res.Email,
+	}
+
+	if method == ical.MethodCancel {
+		event.Status = "CANCELLED"
+	}
+
+	var buf bytes.Buffer
+
+	err := ical.Write(&buf, ical.Calendar{Method: method, Events: []ical.Event{event}})
+	if err != nil {
+		return models.Attachment{}, err
+	}
+
+	return models.Attachment{Name: inviteName, Data: buf.Bytes()}, nil
+}
+
+// updatedInvite builds a new version of a guest's invite, for when their stay
+// changes or is cancelled. Any problem is logged and no invite is attached, so
+// the email still goes out.
+func (m *Repository) updatedInvite(res models.Reservation, method string) []models.Attachment {
+	sequence, err := m.DB.NextInviteSequence(res.ID)
+	if err != nil {
+		m.App.ErrorLog.Printf("updating invite for reservation %d: %v", res.ID, err)
+		return nil
+	}
+
+	invite, err := m.stayInvite(res, method, sequence)
+	if err != nil {
+		m.App.ErrorLog.Printf("updating invite for reservation %d: %v", res.ID, err)
+		return nil
+	}
+
+	return []models.Attachment{invite}
+}
+
+// deletedInvite builds the cancellation of the invite for a stay that has been
+// deleted. Its sequence number went with the reservation, so the next one is
+// used without being saved. Any problem is logged and no invite is attached.
+func (m *Repository) deletedInvite(res models.Reservation) []models.Attachment {
+	invite, err := m.stayInvite(res, ical.MethodCancel, res.InviteSequence+1)
+	if err != nil {
+		m.App.ErrorLog.Printf("cancelling invite for reservation %d: %v", res.ID, err)
+		return nil
+	}
+
+	return []models.Attachment{invite}
+}
+
+// atTimeOfDay returns the local time on a date, an offset after midnight
+func atTimeOfDay(date time.Time, offset time.Duration) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local).Add(offset)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// inviteFor returns the calendar invite attached to an email, if there is one
+func inviteFor(msg models.MailData) (string, bool) {
+	for _, a := range msg.Attachments {
+		if a.Name == inviteName {
+			return string(a.Data), true
+		}
+	}
+
+	return "", false
+}
+
+func TestConfirmationInvite(t *testing.T) {
+	res := models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+
+	msgs, err := Repo.reservationEmails(res)(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invite, ok := inviteFor(msgs[0])
+	if !ok {
+		t.Fatal("expected the guest's confirmation to have an invite")
+	}
+
+	start := time.Date(2050, 1, 1, 15, 0, 0, 0, time.Local).UTC().Format("20060102T150405Z")
+	end := time.Date(2050, 1, 3, 11, 0, 0, 0, time.Local).UTC().Format("20060102T150405Z")
+
+	for _, expected := range []string{
+		"METHOD:REQUEST",
+		"UID:reservation-7@",
+		"DTSTART:" + start,
+		"DTEND:" + end,
+		`LOCATION:1 Fort Road\, Smythe`,
+		"Reservation #7",
+		"ATTENDEE;RSVP=FALSE:mailto:john@smith.com",
+	} {
+		if !strings.Contains(invite, expected) {
+			t.Errorf("expected %q in the invite, got %q", expected, invite)
+		}
+	}
+
+	if _, ok := inviteFor(msgs[1]); ok {
+		t.Error("expected no invite for the owner")
+	}
+}
+
+// Create a set of tests to run
+var cancelledInviteTests = []struct {
+	name             string
+	id               int
+	postedData       string
+	expectedInvite   bool
+	expectedSequence int
+}{
+	// Reservation 1 has an invoice, so it's cancelled instead and the next
+	// sequence is saved. Others are deleted, and the invite's sequence with them
+	{"cancel-invoiced", 1, "", true, 1},
+	{"delete", 5, "", true, 3},
+	{"delete-suppress-email", 5, "suppress_email=1", false, 0},
+	{"delete-cancelled", 101, "", false, 0},
+}
+
+func TestCancelledInvite(t *testing.T) {
+	for _, test := range cancelledInviteTests {
+		id := test.id
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/delete-reservation/all/%d/do", id), strings.NewReader(test.postedData))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(addIdToChiContext(getCtx(req), fmt.Sprint(id)))
+
+		sentMail.Reset()
+
+		http.HandlerFunc(Repo.AdminDeleteReservation).ServeHTTP(httptest.NewRecorder(), req)
+
+		var invite string
+		var ok bool
+		for _, msg := range sentMail.Sent() {
+			if invite, ok = inviteFor(msg); ok {
+				break
+			}
+		}
+
+		if ok != test.expectedInvite {
+			t.Errorf("%s: expected an invite %t, got %t", test.name, test.expectedInvite, ok)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		for _, expected := range []string{"METHOD:CANCEL", "STATUS:CANCELLED", fmt.Sprintf("SEQUENCE:%d", test.expectedSequence), fmt.Sprintf("UID:reservation-%d@", id)} {
+			if !strings.Contains(invite, expected) {
+				t.Errorf("%s: expected %q in the invite, got %q", test.name, expected, invite)
+			}
+		}
+	}
+}
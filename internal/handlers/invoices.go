@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/invoices"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// folio builds a reservation's folio. Its invoice is left zero until one is
+// issued.
+func (m *Repository) folio(res models.Reservation) (invoices.Folio, error) {
+	payments, err := m.DB.PaymentsForReservation(res.ID)
+	if err != nil {
+		return invoices.Folio{}, err
+	}
+
+	inv, err := m.DB.InvoiceForReservation(res.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return invoices.Folio{}, err
+	}
+
+	property := invoices.Property{
+		Name:    m.App.PropertyName,
+		Address: m.App.PropertyAddress,
+		Email:   m.App.PropertyEmail,
+		Phone:   m.App.PropertyPhone,
+		TaxID:   m.App.PropertyTaxID,
+	}
+
+	return invoices.New(inv, res, payments, property, m.App.Currency), nil
+}
+
+// reservationFromURL looks up the reservation with the ID in the URL. If
+// there is no such reservation, it writes an error and ok is false.
+func (m *Repository) reservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+
+	return res, true
+}
+
+// writeInvoice sends a folio's invoice as a PDF to download. Folios that
+// haven't been invoiced are not found.
+func writeInvoice(w http.ResponseWriter, folio invoices.Folio) {
+	if !folio.Invoice.Issued() {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, folio.Filename()))
+
+	_, _ = w.Write(folio.PDF())
+}
+
+// AdminReservationFolio shows a reservation's charges and payments
+func (m *Repository) AdminReservationFolio(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	folio, err := m.folio(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = chi.URLParam(r, "src")
+
+	data := make(map[string]interface{})
+	data["folio"] = folio
+
+	render.Template(w, r, "admin-reservation-folio.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminIssueInvoice gives a reservation's folio the next invoice number, if it
+// doesn't have one yet. Numbers are only ever given this way, so looking at a
+// folio doesn't use one up.
+func (m *Repository) AdminIssueInvoice(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	inv, err := m.DB.IssueInvoice(res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Invoice "+inv.Code()+" issued")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/folio", chi.URLParam(r, "src"), res.ID), http.StatusSeeOther)
+}
+
+// AdminReservationInvoice downloads a reservation's invoice
+func (m *Repository) AdminReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	folio, err := m.folio(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	writeInvoice(w, folio)
+}
+
+// guestReservationFromURL looks up one of the logged in guest's stays by the
+// ID in the URL. Other guests' stays are not found, so their IDs can't be
+// guessed.
+func (m *Repository) guestReservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	res, ok := m.reservationFromURL(w, r)
+	if !ok {
+		return res, false
+	}
+
+	if res.GuestID != m.App.Session.GetInt(r.Context(), "guest_id") {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	}
+
+	return res, true
+}
+
+// GuestIssueInvoice issues the invoice for one of the logged in guest's stays,
+// if it hasn't been already, and sends them to download it
+func (m *Repository) GuestIssueInvoice(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := m.DB.IssueInvoice(res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/guest/account/reservations/%d/invoice.pdf", res.ID), http.StatusSeeOther)
+}
+
+// GuestReservationInvoice downloads the invoice for one of the logged in
+// guest's stays
+func (m *Repository) GuestReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	folio, err := m.folio(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	writeInvoice(w, folio)
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+// Create a set of tests to run
+var adminReservationFolioTests = []struct {
+	name                 string
+	id                   string
+	expectedResponseCode int
+	expectedHTML         string
+}{
+	{"valid", "1", http.StatusOK, "Invoice INV-000042"},
+	{"not-invoiced", "103", http.StatusOK, `value="Issue Invoice"`},
+	{"cancelled", "101", http.StatusOK, "Cancellation, "},
+	{"not-found", "100", http.StatusNotFound, ""},
+	{"bad-id", "x", http.StatusNotFound, ""},
+	{"cant-look-up-invoice", "102", http.StatusInternalServerError, ""},
+}
+
+// TestAdminReservationFolio tests that admins can see a reservation's charges
+// and payments
+func TestAdminReservationFolio(t *testing.T) {
+	for _, test := range adminReservationFolioTests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/all/"+test.id+"/folio", nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, test.id)
+		req = req.WithContext(ctx)
+
+		recorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminReservationFolio)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedResponseCode {
+			t.Errorf("%s expected code %d, but got %d", test.name, test.expectedResponseCode, recorder.Code)
+		}
+
+		if test.expectedHTML != "" && !strings.Contains(recorder.Body.String(), test.expectedHTML) {
+			t.Errorf("%s expected to find HTML %s but didn't", test.name, test.expectedHTML)
+		}
+	}
+}
+
+// Create a set of tests to run
+var adminIssueInvoiceTests = []struct {
+	name                 string
+	id                   string
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"valid", "103", http.StatusSeeOther, "/admin/reservations/all/103/folio"},
+	{"not-found", "100", http.StatusNotFound, ""},
+	{"cant-issue-invoice", "102", http.StatusInternalServerError, ""},
+}
+
+// TestAdminIssueInvoice tests that admins can issue a reservation's invoice
+func TestAdminIssueInvoice(t *testing.T) {
+	for _, test := range adminIssueInvoiceTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+test.id+"/invoice", nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", test.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		recorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminIssueInvoice)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedResponseCode {
+			t.Errorf("%s expected code %d, but got %d", test.name, test.expectedResponseCode, recorder.Code)
+			continue
+		}
+
+		if location := recorder.Header().Get("Location"); location != test.expectedLocation {
+			t.Errorf("%s expected redirect to %s, got %s", test.name, test.expectedLocation, location)
+		}
+
+		if test.expectedResponseCode == http.StatusSeeOther {
+			if flash := session.PopString(ctx, "flash"); flash != "Invoice INV-000042 issued" {
+				t.Errorf("%s got unexpected flash %q", test.name, flash)
+			}
+		}
+	}
+}
+
+// Create a set of tests to run
+var adminReservationInvoiceTests = []struct {
+	name                 string
+	id                   string
+	expectedResponseCode int
+}{
+	{"invoiced", "1", http.StatusOK},
+	{"not-invoiced", "103", http.StatusNotFound},
+}
+
+// TestAdminReservationInvoice tests that admins can download a reservation's
+// invoice once it's issued
+func TestAdminReservationInvoice(t *testing.T) {
+	for _, test := range adminReservationInvoiceTests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/all/"+test.id+"/invoice.pdf", nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, test.id)
+		req = req.WithContext(ctx)
+
+		recorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminReservationInvoice)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedResponseCode {
+			t.Errorf("%s expected code %d, but got %d", test.name, test.expectedResponseCode, recorder.Code)
+			continue
+		}
+
+		if test.expectedResponseCode == http.StatusOK {
+			checkInvoice(t, recorder)
+		}
+	}
+}
+
+// Create a set of tests to run
+var guestReservationInvoiceTests = []struct {
+	name                 string
+	id                   string
+	guestID              int
+	expectedResponseCode int
+}{
+	{"own-stay", "1", 1, http.StatusOK},
+	{"someone-elses-stay", "1", 2, http.StatusNotFound},
+	{"not-found", "100", 1, http.StatusNotFound},
+	{"not-invoiced", "103", 1, http.StatusNotFound},
+}
+
+// TestGuestReservationInvoice tests that guests can download invoices for
+// their own stays, and only their own
+func TestGuestReservationInvoice(t *testing.T) {
+	for _, test := range guestReservationInvoiceTests {
+		req, _ := http.NewRequest("GET", "/guest/account/reservations/"+test.id+"/invoice.pdf", nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, test.id)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "guest_id", test.guestID)
+
+		recorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.GuestReservationInvoice)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedResponseCode {
+			t.Errorf("%s expected code %d, but got %d", test.name, test.expectedResponseCode, recorder.Code)
+			continue
+		}
+
+		if test.expectedResponseCode == http.StatusOK {
+			checkInvoice(t, recorder)
+		}
+	}
+}
+
+// Create a set of tests to run
+var guestIssueInvoiceTests = []struct {
+	name                 string
+	id                   string
+	guestID              int
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{"own-stay", "103", 1, http.StatusSeeOther, "/guest/account/reservations/103/invoice.pdf"},
+	{"someone-elses-stay", "103", 2, http.StatusNotFound, ""},
+	{"cant-issue-invoice", "102", 1, http.StatusInternalServerError, ""},
+}
+
+// TestGuestIssueInvoice tests that guests can issue invoices for their own
+// stays, and only their own
+func TestGuestIssueInvoice(t *testing.T) {
+	for _, test := range guestIssueInvoiceTests {
+		req, _ := http.NewRequest("POST", "/guest/account/reservations/"+test.id+"/invoice", nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, test.id)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "guest_id", test.guestID)
+
+		recorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.GuestIssueInvoice)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedResponseCode {
+			t.Errorf("%s expected code %d, but got %d", test.name, test.expectedResponseCode, recorder.Code)
+			continue
+		}
+
+		if location := recorder.Header().Get("Location"); location != test.expectedLocation {
+			t.Errorf("%s expected redirect to %s, got %s", test.name, test.expectedLocation, location)
+		}
+	}
+}
+
+// checkInvoice checks a response is the test repo's invoice, as a PDF to
+// download
+func checkInvoice(t *testing.T, recorder *httptest.ResponseRecorder) {
+	t.Helper()
+
+	if ct := recorder.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Errorf("expected a PDF, got %s", ct)
+	}
+
+	if cd := recorder.Header().Get("Content-Disposition"); cd != `attachment; filename="INV-000042.pdf"` {
+		t.Errorf("expected the invoice to be downloaded, got %s", cd)
+	}
+
+	body := recorder.Body.Bytes()
+	if !bytes.HasPrefix(body, []byte("%PDF-")) || !bytes.Contains(body, []byte("(Invoice INV-000042)")) {
+		t.Error("expected the invoice as a PDF")
+	}
+}
@@ -0,0 +1,434 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/render"
+)
+
+// apiOperation describes one JSON endpoint for the OpenAPI document. Request
+// and response bodies are given as values of the Go types the handlers
+// actually use, so the schemas can't drift from the code.
+type apiOperation struct {
+	Method       string
+	Path         string
+	Summary      string
+	Description  string
+	Tag          string
+	Scope        string // Access token scope needed, if any
+	Params       []apiParam
+	Body         any  // JSON request body, if any
+	OptionalBody bool // Whether the request body can be left out
+	Form         any  // Form encoded request body, if any
+	Responses    map[int]any
+}
+
+// apiParam describes a path, query or header parameter
+type apiParam struct {
+	Name        string
+	In          string
+	Description string
+	Format      string
+	Optional    bool
+}
+
+// availabilityJSONForm documents the form fields AvailabilityJSON reads
+type availabilityJSONForm struct {
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	RoomID    string `json:"room_id"`
+	CSRFToken string `json:"csrf_token"`
+}
+
+// availabilityJSONResponses are the responses AvailabilityJSON can send
+var availabilityJSONResponses = map[int]any{
+	http.StatusOK:                  jsonResponse{},
+	http.StatusBadRequest:          helpers.ErrorEnvelope{},
+	http.StatusNotFound:            helpers.ErrorEnvelope{},
+	http.StatusInternalServerError: helpers.ErrorEnvelope{},
+}
+
+// plainTextError stands for the plain text body helpers.ServerError writes
+type plainTextError string
+
+var (
+	roomIDParam      = apiParam{Name: "id", In: "path", Description: "The room's ID"}
+	reservationParam = apiParam{Name: "id", In: "path", Description: "The reservation's ID"}
+	startParam       = apiParam{Name: "start", In: "query", Description: "The first night of the stay", Format: "date"}
+	endParam         = apiParam{Name: "end", In: "query", Description: "The day the guest leaves", Format: "date"}
+	apiErr           = helpers.ErrorEnvelope{}
+	serverErr        = plainTextError("")
+
+	idempotencyKeyParam = apiParam{
+		Name:        "Idempotency-Key",
+		In:          "header",
+		Description: "A unique value, e.g. a UUID, that identifies the booking. At most 255 characters. Keys only replay requests made with the same access token, or without one",
+		Optional:    true,
+	}
+)
+
+// apiOperations lists every JSON endpoint the app serves. Tests check it
+// against the router, so a new endpoint must be added here too.
+var apiOperations = []apiOperation{
+	{
+		Method:      "GET",
+		Path:        "/search-availability-json",
+		Summary:     "Check if a room is free",
+		Description: "Used by the room pages. If the room is taken, ok is false and the nights that get in the way are listed.",
+		Tag:         "Website",
+		Params: []apiParam{
+			startParam,
+			endParam,
+			{Name: "room_id", In: "query", Description: "The room's ID"},
+		},
+		Responses: availabilityJSONResponses,
+	},
+	{
+		Method:      "POST",
+		Path:        "/search-availability-json",
+		Summary:     "Check if a room is free",
+		Description: "The same as the GET request, but with the dates and room in a form or JSON body. Needs the CSRF token from the page, as a csrf_token form field or, with a JSON body, an X-CSRF-Token header.",
+		Tag:         "Website",
+		Body:        availabilityJSONBody{},
+		Form:        availabilityJSONForm{},
+		Responses:   availabilityJSONResponses,
+	},
+	{
+		Method:    "GET",
+		Path:      "/api/v1/openapi.json",
+		Summary:   "This document",
+		Tag:       "Meta",
+		Responses: map[int]any{http.StatusOK: map[string]any{}},
+	},
+	{
+		Method:    "GET",
+		Path:      "/api/v1/rooms",
+		Summary:   "List rooms",
+		Tag:       "Rooms",
+		Responses: map[int]any{http.StatusOK: []apiRoom{}, http.StatusInternalServerError: serverErr},
+	},
+	{
+		Method:    "GET",
+		Path:      "/api/v1/rooms/{id}",
+		Summary:   "Get a room",
+		Tag:       "Rooms",
+		Params:    []apiParam{roomIDParam},
+		Responses: map[int]any{http.StatusOK: apiRoom{}, http.StatusNotFound: apiErr},
+	},
+	{
+		Method:    "GET",
+		Path:      "/api/v1/rooms/{id}/availability",
+		Summary:   "Check if a room is free for a stay",
+		Tag:       "Availability",
+		Params:    []apiParam{roomIDParam, startParam, endParam},
+		Responses: map[int]any{http.StatusOK: apiAvailability{}, http.StatusBadRequest: apiErr, http.StatusNotFound: apiErr, http.StatusInternalServerError: serverErr},
+	},
+	{
+		Method:      "GET",
+		Path:        "/api/v1/rooms/{id}/calendar",
+		Summary:     "Get a room's calendar",
+		Description: "Returns the status of each night from the first of the start month to the end of the end month, for up to 12 months. A min_stay night is free, but a stay starting then would be shorter than the room's minimum stay.",
+		Tag:         "Availability",
+		Params: []apiParam{
+			roomIDParam,
+			{Name: "start", In: "query", Description: "The first month, as YYYY-MM. Defaults to this month", Optional: true},
+			{Name: "end", In: "query", Description: "The last month, as YYYY-MM. Defaults to the start month", Optional: true},
+		},
+		Responses: map[int]any{http.StatusOK: apiCalendar{}, http.StatusBadRequest: apiErr, http.StatusNotFound: apiErr, http.StatusInternalServerError: serverErr},
+	},
+	{
+		Method:    "GET",
+		Path:      "/api/v1/availability",
+		Summary:   "List the rooms that are free for a stay",
+		Tag:       "Availability",
+		Params:    []apiParam{startParam, endParam},
+		Responses: map[int]any{http.StatusOK: []apiRoom{}, http.StatusBadRequest: apiErr, http.StatusInternalServerError: serverErr},
+	},
+	{
+		Method:      "POST",
+		Path:        "/api/v1/reservations",
+		Summary:     "Book a room",
+		Description: "Sends the same confirmation emails as booking on the website. Responds with 422 if any field is invalid, including stays past the booking horizon or shorter than the room's minimum stay, and 409 if the room is taken. Retrying with the same Idempotency-Key returns the reservation the first request made, instead of making another.",
+		Tag:         "Reservations",
+		Params:      []apiParam{idempotencyKeyParam},
+		Body:        apiReservationRequest{},
+		Responses: map[int]any{
+			http.StatusCreated:             apiReservation{},
+			http.StatusBadRequest:          apiErr,
+			http.StatusConflict:            apiErr,
+			http.StatusUnprocessableEntity: apiErr,
+			http.StatusInternalServerError: serverErr,
+		},
+	},
+	{
+		Method:    "GET",
+		Path:      "/api/v1/reservations",
+		Summary:   "List reservations",
+		Tag:       "Reservations",
+		Scope:     models.ScopeRead,
+		Responses: map[int]any{http.StatusOK: []apiReservation{}, http.StatusInternalServerError: serverErr},
+	},
+	{
+		Method:    "GET",
+		Path:      "/api/v1/reservations/{id}",
+		Summary:   "Get a reservation",
+		Tag:       "Reservations",
+		Scope:     models.ScopeRead,
+		Params:    []apiParam{reservationParam},
+		Responses: map[int]any{http.StatusOK: apiReservation{}, http.StatusNotFound: apiErr, http.StatusInternalServerError: serverErr},
+	},
+	{
+		Method:      "PATCH",
+		Path:        "/api/v1/reservations/{id}",
+		Summary:     "Update a reservation",
+		Description: "Fields that are left out are not changed. Cancelled reservations can't be changed.",
+		Tag:         "Reservations",
+		Scope:       models.ScopeWrite,
+		Params:      []apiParam{reservationParam},
+		Body:        apiReservationUpdate{},
+		Responses: map[int]any{
+			http.StatusOK:                  apiReservation{},
+			http.StatusBadRequest:          apiErr,
+			http.StatusNotFound:            apiErr,
+			http.StatusConflict:            apiErr,
+			http.StatusUnprocessableEntity: apiErr,
+			http.StatusInternalServerError: serverErr,
+		},
+	},
+	{
+		Method:       "POST",
+		Path:         "/api/v1/reservations/{id}/cancel",
+		Summary:      "Cancel a reservation",
+		Description:  "Emails the guest, unless suppress_email is true. Responds with 409 if the reservation is already cancelled.",
+		Tag:          "Reservations",
+		Scope:        models.ScopeWrite,
+		Params:       []apiParam{reservationParam},
+		Body:         apiCancelRequest{},
+		OptionalBody: true,
+		Responses:    map[int]any{http.StatusOK: apiReservation{}, http.StatusBadRequest: apiErr, http.StatusNotFound: apiErr, http.StatusConflict: apiErr, http.StatusInternalServerError: serverErr},
+	},
+	{
+		Method:      "POST",
+		Path:        "/api/v1/blocks",
+		Summary:     "Block a room",
+		Description: "Blocks every night from start_date up to, but not including, end_date. If end_date is left out, only start_date is blocked.",
+		Tag:         "Blocks",
+		Scope:       models.ScopeWrite,
+		Body:        apiBlock{},
+		Responses:   map[int]any{http.StatusCreated: apiBlock{}, http.StatusBadRequest: apiErr, http.StatusNotFound: apiErr, http.StatusInternalServerError: serverErr},
+	},
+}
+
+// OpenAPI serves the OpenAPI document describing the JSON API
+func (m *Repository) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	helpers.WriteJSON(w, http.StatusOK, openAPIDocument())
+}
+
+// APIDocs renders the interactive API docs page
+func (m *Repository) APIDocs(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "api-docs.page.tmpl", &models.TemplateData{})
+}
+
+// openAPIDocument builds the OpenAPI 3 document from apiOperations
+func openAPIDocument() map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]any{}
+
+	for _, op := range apiOperations {
+		operation := map[string]any{
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"operationId": operationID(op),
+		}
+
+		description := op.Description
+
+		statuses := map[int]any{}
+		for status, body := range op.Responses {
+			statuses[status] = body
+		}
+
+		if op.Scope != "" {
+			operation["security"] = []map[string][]string{{"accessToken": {}}}
+			description = strings.TrimSpace(description + " Needs an access token with the " + op.Scope + " scope.")
+
+			// The scope check happens before the handler runs
+			statuses[http.StatusUnauthorized] = apiErr
+			statuses[http.StatusForbidden] = apiErr
+		}
+
+		if description != "" {
+			operation["description"] = description
+		}
+
+		var params []map[string]any
+		for _, p := range op.Params {
+			schema := map[string]any{"type": "string"}
+			if p.In == "path" {
+				schema = map[string]any{"type": "integer"}
+			}
+			if p.Format != "" {
+				schema["format"] = p.Format
+			}
+
+			params = append(params, map[string]any{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    !p.Optional,
+				"description": p.Description,
+				"schema":      schema,
+			})
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		content := map[string]any{}
+		if op.Body != nil {
+			content["application/json"] = map[string]any{"schema": schemaFor(reflect.TypeOf(op.Body), schemas)}
+		}
+		if op.Form != nil {
+			content["application/x-www-form-urlencoded"] = map[string]any{"schema": schemaFor(reflect.TypeOf(op.Form), schemas)}
+		}
+		if len(content) > 0 {
+			operation["requestBody"] = map[string]any{"required": !op.OptionalBody, "content": content}
+		}
+
+		responses := map[string]any{}
+		for status, body := range statuses {
+			response := map[string]any{"description": http.StatusText(status)}
+
+			if _, ok := body.(plainTextError); ok {
+				response["content"] = map[string]any{
+					"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+				}
+			} else if body != nil {
+				response["content"] = map[string]any{
+					"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(body), schemas)},
+				}
+			}
+
+			responses[strconv.Itoa(status)] = response
+		}
+		operation["responses"] = responses
+
+		path, ok := paths[op.Path].(map[string]any)
+		if !ok {
+			path = map[string]any{}
+			paths[op.Path] = path
+		}
+		path[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Go B & B API",
+			"version":     "1",
+			"description": "Rooms, availability and reservations. Errors have the shape of the ErrorEnvelope schema, except 500 errors, which are plain text.",
+		},
+		"servers": []map[string]string{{"url": helpers.BaseURL()}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"accessToken": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A personal access token created on the admin profile page",
+				},
+			},
+		},
+	}
+}
+
+// operationID names an operation after the handler's route, e.g. post_api_v1_reservations_id_cancel
+func operationID(op apiOperation) string {
+	id := strings.ToLower(op.Method) + op.Path
+	id = strings.NewReplacer("/", "_", "-", "_", ".", "_", "{", "", "}", "").Replace(id)
+
+	return id
+}
+
+// schemaFor returns the JSON schema for a Go type. Named structs are added to
+// schemas and referenced, so each one is only described once.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]any{"type": "object"}
+		}
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := schemaName(t)
+
+		if _, ok := schemas[name]; !ok {
+			// Reserve the name first in case the struct refers to itself
+			schemas[name] = nil
+
+			properties := map[string]any{}
+			var required []string
+
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+
+				tag := f.Tag.Get("json")
+				if tag == "-" || !f.IsExported() {
+					continue
+				}
+
+				fieldName, opts, _ := strings.Cut(tag, ",")
+				if fieldName == "" {
+					fieldName = f.Name
+				}
+
+				properties[fieldName] = schemaFor(f.Type, schemas)
+
+				// Optional fields are either left out when empty or pointers
+				if opts != "omitempty" && f.Type.Kind() != reflect.Pointer {
+					required = append(required, fieldName)
+				}
+			}
+
+			sort.Strings(required)
+
+			schema := map[string]any{"type": "object", "properties": properties}
+			if len(required) > 0 {
+				schema["required"] = required
+			}
+
+			schemas[name] = schema
+		}
+
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+
+	return map[string]any{}
+}
+
+// schemaName names a schema after its Go type, e.g. apiReservation becomes Reservation
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "api")
+
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+
+	return string(r)
+}
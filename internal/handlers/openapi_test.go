@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+// getOpenAPIDocument fetches the OpenAPI document the way a client would
+func getOpenAPIDocument(t *testing.T) map[string]any {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	getRoutes().ServeHTTP(recorder, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+
+	var doc map[string]any
+
+	err := json.Unmarshal(recorder.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return doc
+}
+
+// TestOpenAPIDocumentsEveryRoute tests that every JSON route is documented, and
+// every documented operation has a route.
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	paths := getOpenAPIDocument(t)["paths"].(map[string]any)
+
+	routed := map[string]bool{}
+
+	err := chi.Walk(getRoutes().(chi.Router), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, "/api/v1/") && route != "/search-availability-json" {
+			return nil
+		}
+
+		routed[method+" "+route] = true
+
+		if _, ok := paths[route].(map[string]any)[strings.ToLower(method)]; !ok {
+			t.Errorf("%s %s is not documented", method, route)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, item := range paths {
+		for method := range item.(map[string]any) {
+			if !routed[strings.ToUpper(method)+" "+path] {
+				t.Errorf("%s %s is documented, but has no route", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+// Create a set of tests to run
+var openAPIResponseTests = []struct {
+	method string
+	url    string
+	path   string
+	body   string
+}{
+	{"POST", "/search-availability-json", "/search-availability-json", "start=2050-01-01&end=2050-01-02&room_id=1"},
+	{"POST", "/search-availability-json", "/search-availability-json", "start=2050-01-09&end=2050-01-12&room_id=2"},
+	{"POST", "/search-availability-json", "/search-availability-json", "start=2050-01-09&end=2050-01-08&room_id=1"},
+	{"GET", "/search-availability-json?start=2040-01-01&end=2040-01-02&room_id=100", "/search-availability-json", ""},
+	{"GET", "/api/v1/rooms", "/api/v1/rooms", ""},
+	{"GET", "/api/v1/rooms/1", "/api/v1/rooms/{id}", ""},
+	{"GET", "/api/v1/rooms/100", "/api/v1/rooms/{id}", ""},
+	{"GET", "/api/v1/rooms/1/availability?start=2040-01-01&end=2040-01-02", "/api/v1/rooms/{id}/availability", ""},
+	{"GET", "/api/v1/rooms/1/availability", "/api/v1/rooms/{id}/availability", ""},
+	{"GET", "/api/v1/rooms/2/calendar?start=2050-01", "/api/v1/rooms/{id}/calendar", ""},
+	{"GET", "/api/v1/rooms/2/calendar?start=2050-01&end=2049-12", "/api/v1/rooms/{id}/calendar", ""},
+	{"GET", "/api/v1/availability?start=2040-01-01&end=2040-01-02", "/api/v1/availability", ""},
+	{"POST", "/api/v1/reservations", "/api/v1/reservations", apiReservationBody(1, "2040-01-01", "2040-01-02", "John")},
+	{"POST", "/api/v1/reservations", "/api/v1/reservations", apiReservationBody(1, "2050-01-01", "2050-01-02", "John")},
+	{"POST", "/api/v1/reservations", "/api/v1/reservations", apiReservationBody(100, "2040-01-01", "2040-01-02", "J")},
+	{"GET", "/api/v1/reservations", "/api/v1/reservations", ""},
+	{"GET", "/api/v1/reservations/1", "/api/v1/reservations/{id}", ""},
+	{"GET", "/api/v1/reservations/100", "/api/v1/reservations/{id}", ""},
+	{"PATCH", "/api/v1/reservations/1", "/api/v1/reservations/{id}", `{"processed": true}`},
+	{"PATCH", "/api/v1/reservations/101", "/api/v1/reservations/{id}", `{"phone": "555"}`},
+	{"POST", "/api/v1/reservations/101/cancel", "/api/v1/reservations/{id}/cancel", ""},
+	{"POST", "/api/v1/reservations/1/cancel", "/api/v1/reservations/{id}/cancel", ""},
+	{"POST", "/api/v1/blocks", "/api/v1/blocks", `{"room_id": 1, "start_date": "2050-01-01"}`},
+	{"POST", "/api/v1/blocks", "/api/v1/blocks", `{"room_id": 1}`},
+}
+
+// TestOpenAPIResponsesMatchHandlers sends requests to the real handlers and
+// checks that each response is documented and matches its schema.
+func TestOpenAPIResponsesMatchHandlers(t *testing.T) {
+	doc := getOpenAPIDocument(t)
+	paths := doc["paths"].(map[string]any)
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+
+	routes := getRoutes()
+
+	for _, test := range openAPIResponseTests {
+		name := test.method + " " + test.url
+
+		req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		if test.path == "/search-availability-json" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		recorder := httptest.NewRecorder()
+
+		routes.ServeHTTP(recorder, req)
+
+		operation := paths[test.path].(map[string]any)[strings.ToLower(test.method)].(map[string]any)
+
+		response, ok := operation["responses"].(map[string]any)[strconv.Itoa(recorder.Code)].(map[string]any)
+		if !ok {
+			t.Errorf("%s: status %d is not documented", name, recorder.Code)
+			continue
+		}
+
+		content, _ := response["content"].(map[string]any)
+
+		media, ok := content[recorder.Header().Get("Content-Type")].(map[string]any)
+		if !ok {
+			t.Errorf("%s: content type %q is not documented for status %d", name, recorder.Header().Get("Content-Type"), recorder.Code)
+			continue
+		}
+
+		var body any
+
+		err := json.Unmarshal(recorder.Body.Bytes(), &body)
+		if err != nil {
+			t.Errorf("%s: response is not JSON: %s", name, recorder.Body.String())
+			continue
+		}
+
+		for _, problem := range matchSchema(body, media["schema"].(map[string]any), schemas, "") {
+			t.Errorf("%s: %s", name, problem)
+		}
+	}
+}
+
+// matchSchema returns how a decoded JSON value differs from a schema
+func matchSchema(value any, schema map[string]any, schemas map[string]any, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		schema = schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]any)
+	}
+
+	var problems []string
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an object, got %v", at, value)}
+		}
+
+		required, _ := schema["required"].([]any)
+		for _, field := range required {
+			if _, ok := obj[field.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing %s", at, field))
+			}
+		}
+
+		properties, hasProperties := schema["properties"].(map[string]any)
+		additional, hasAdditional := schema["additionalProperties"].(map[string]any)
+
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			switch {
+			case hasProperties && properties[key] != nil:
+				problems = append(problems, matchSchema(obj[key], properties[key].(map[string]any), schemas, at+"."+key)...)
+			case hasAdditional:
+				problems = append(problems, matchSchema(obj[key], additional, schemas, at+"."+key)...)
+			case hasProperties:
+				problems = append(problems, fmt.Sprintf("%s: %s is not documented", at, key))
+			}
+		}
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an array, got %v", at, value)}
+		}
+
+		for i, item := range arr {
+			problems = append(problems, matchSchema(item, schema["items"].(map[string]any), schemas, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected a string, got %v", at, value))
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int(n)) {
+			problems = append(problems, fmt.Sprintf("%s: expected an integer, got %v", at, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected a boolean, got %v", at, value))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// emailLogSize is how many emails the outbox page shows
+const emailLogSize = 100
+
+// AdminEmails shows the most recent emails in the outbox, optionally only the
+// ones with a given status
+func (m *Repository) AdminEmails(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	switch status {
+	case "", models.EmailPending, models.EmailSent, models.EmailFailed:
+	default:
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	emails, err := m.DB.RecentEmails(status, emailLogSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["emails"] = emails
+
+	render.Template(w, r, "admin-emails.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{"status": status},
+		Data:      data,
+	})
+}
+
+// AdminResendEmail queues an email to be sent again, e.g. one that failed or
+// a guest says never arrived
+func (m *Repository) AdminResendEmail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.ResendEmail(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Email queued to be sent again")
+	http.Redirect(w, r, "/admin/emails", http.StatusSeeOther)
+}
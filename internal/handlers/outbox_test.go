@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var adminResendEmailTests = []struct {
+	name             string
+	id               string
+	expectedStatus   int
+	expectedLocation string
+}{
+	{"resend", "1", http.StatusSeeOther, "/admin/emails"},
+	{"resend-non-existent", "100", http.StatusNotFound, ""},
+	{"resend-invalid-id", "bad-id", http.StatusBadRequest, ""},
+}
+
+// TestAdminResendEmail tests queueing emails to be sent again
+func TestAdminResendEmail(t *testing.T) {
+	for _, test := range adminResendEmailTests {
+		req, _ := http.NewRequest("POST", "/admin/emails/"+test.id+"/resend", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(addIdToChiContext(ctx, test.id))
+		recorder := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminResendEmail)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedStatus {
+			t.Errorf("Test %s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatus)
+			continue
+		}
+
+		if location := recorder.Header().Get("Location"); location != test.expectedLocation {
+			t.Errorf("Test %s redirected to %q, wanted %q", test.name, location, test.expectedLocation)
+		}
+
+		if test.expectedStatus == http.StatusSeeOther {
+			if msg := session.PopString(ctx, "flash"); msg != "Email queued to be sent again" {
+				t.Errorf("Test %s expected a flash, got %q", test.name, msg)
+			}
+		}
+	}
+}
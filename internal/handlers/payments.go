@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/payments"
+	"github.com/go-chi/chi"
+)
+
+// paymentOptions are what the guest can choose to pay when booking: the whole
+// stay, or a deposit if there is one. Keys are the kinds of payment, and
+// values are the amounts.
+func (m *Repository) paymentOptions(res models.Reservation) map[string]int {
+	options := map[string]int{models.PaymentFull: res.Total}
+
+	if m.App.DepositPercent > 0 && m.App.DepositPercent < 100 {
+		options[models.PaymentDeposit] = payments.Deposit(res.Total, m.App.DepositPercent)
+	}
+
+	return options
+}
+
+// startPayment starts a payment of the given kind for a reservation that was
+// just made, and returns the provider's checkout page to send the guest to
+func (m *Repository) startPayment(res models.Reservation, kind string) (string, error) {
+	amount, ok := m.paymentOptions(res)[kind]
+	if !ok {
+		kind = models.PaymentFull
+		amount = res.Total
+	}
+
+	payment := models.Payment{
+		ReservationID: res.ID,
+		Provider:      m.App.Payments.Name(),
+		Kind:          kind,
+		Amount:        amount,
+		Currency:      m.App.Currency,
+		Status:        models.PaymentPending,
+	}
+
+	id, err := m.DB.InsertPayment(payment)
+	if err != nil {
+		return "", err
+	}
+
+	payment.ID = id
+
+	description := fmt.Sprintf("Reservation #%d: %s, %s to %s", res.ID, res.Room.RoomName,
+		res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"))
+	if kind == models.PaymentDeposit {
+		description = "Deposit for " + description
+	}
+
+	session, err := m.App.Payments.CreateCheckout(payments.Checkout{
+		Reference:   strconv.Itoa(id),
+		Amount:      amount,
+		Currency:    m.App.Currency,
+		Description: description,
+		Email:       res.Email,
+		SuccessURL:  fmt.Sprintf("%s/payments/%d/return", helpers.BaseURL(), id),
+		CancelURL:   fmt.Sprintf("%s/payments/%d/cancel", helpers.BaseURL(), id),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payment.ProviderRef = session.ID
+	payment.CheckoutURL = session.URL
+
+	err = m.DB.UpdatePayment(payment)
+	if err != nil {
+		return "", err
+	}
+
+	return session.URL, nil
+}
+
+// updatePaymentStatus saves a payment's new status. A payment that's been
+// paid stays paid, whatever arrives after.
+func (m *Repository) updatePaymentStatus(p models.Payment, status string) (models.Payment, error) {
+	if status == "" || status == p.Status || p.Status == models.PaymentPaid {
+		return p, nil
+	}
+
+	p.Status = status
+	if status == models.PaymentPaid {
+		p.PaidAt = time.Now()
+	}
+
+	return p, m.DB.UpdatePayment(p)
+}
+
+// paymentFromURL looks up the payment with the ID in the URL. If there is no
+// such payment, it writes an error and ok is false.
+func (m *Repository) paymentFromURL(w http.ResponseWriter, r *http.Request) (models.Payment, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Payment{}, false
+	}
+
+	payment, err := m.DB.GetPaymentByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return payment, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return payment, false
+	}
+
+	return payment, true
+}
+
+// PaymentReturn is where the provider sends guests back to once they've paid.
+// The webhook saying so may not have arrived yet, so the provider is asked.
+func (m *Repository) PaymentReturn(w http.ResponseWriter, r *http.Request) {
+	payment, ok := m.paymentFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if payment.Status == models.PaymentPending && m.App.Payments != nil {
+		status, err := m.App.Payments.Status(payment.ProviderRef)
+		if err == nil {
+			payment, err = m.updatePaymentStatus(payment, status)
+		}
+
+		if err != nil {
+			m.App.ErrorLog.Printf("checking payment %d: %v", payment.ID, err)
+		}
+	}
+
+	switch payment.Status {
+	case models.PaymentPaid:
+		m.App.Session.Put(r.Context(), "flash", "Thank you, your payment was received")
+	case models.PaymentFailed:
+		m.App.Session.Put(r.Context(), "error", "Your payment didn't go through. Your reservation is held, and we'll be in touch about paying")
+	default:
+		m.App.Session.Put(r.Context(), "warning", "Your payment is still going through. We'll email you if there's a problem")
+	}
+
+	m.paymentSummary(w, r, payment)
+}
+
+// PaymentCancel is where the provider sends guests back to if they leave the
+// checkout without paying
+func (m *Repository) PaymentCancel(w http.ResponseWriter, r *http.Request) {
+	payment, ok := m.paymentFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if payment.Status != models.PaymentPaid {
+		m.App.Session.Put(r.Context(), "warning", "You didn't finish paying. Your reservation is held, and we'll be in touch about paying")
+	}
+
+	m.paymentSummary(w, r, payment)
+}
+
+// paymentSummary shows the guest the summary of the reservation they're
+// paying for. It's only shown to the guest who just made it, whose session
+// has it.
+func (m *Repository) paymentSummary(w http.ResponseWriter, r *http.Request, payment models.Payment) {
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok || res.ID != payment.ReservationID {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if updated, err := m.DB.GetReservationByID(res.ID); err == nil {
+		res.AmountPaid = updated.AmountPaid
+		m.App.Session.Put(r.Context(), "reservation", res)
+	}
+
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// PaymentWebhook is where the provider tells us a checkout was paid, or
+// expired. Requests that weren't signed by the provider are rejected.
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if m.App.Payments == nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	event, err := m.App.Payments.ParseWebhook(r)
+	if err != nil {
+		m.App.ErrorLog.Printf("payment webhook: %v", err)
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Events that don't change a payment are acknowledged, so the provider
+	// doesn't keep sending them
+	if event.Status == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payment, err := m.DB.GetPaymentByProviderRef(m.App.Payments.Name(), event.SessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.ErrorLog.Printf("payment webhook: no payment for checkout %s", event.SessionID)
+		w.WriteHeader(http.StatusOK)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	_, err = m.updatePaymentStatus(payment, event.Status)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/payments"
+)
+
+// withPayments turns payments on for one test, taking them with a fake
+func withPayments(t *testing.T, depositPercent int) *payments.Fake {
+	t.Helper()
+
+	fake := payments.NewFake(nil)
+
+	app.Payments = fake
+	app.DepositPercent = depositPercent
+
+	t.Cleanup(func() {
+		app.Payments = nil
+		app.DepositPercent = 0
+	})
+
+	return fake
+}
+
+// Create a set of tests to run
+var postReservationPaymentTests = []struct {
+	name             string
+	payment          string
+	fail             error
+	expectedLocation string
+	expectedAmount   int // 0 expects no checkout
+	expectedFlash    string
+}{
+	// A night at 12000, with the test cleaning fee of 5000 and 10% tax on both
+	{"full", models.PaymentFull, nil, "http://localhost:8080/payments/1/return", 18700, ""},
+	{"deposit", models.PaymentDeposit, nil, "http://localhost:8080/payments/1/return", 4675, ""},
+	{"unknown-kind", "half", nil, "http://localhost:8080/payments/1/return", 18700, ""},
+	{"provider-down", models.PaymentFull, errors.New("provider down"), "/reservation-summary", 0, "couldn't take payment"},
+}
+
+// TestPostReservationPayment tests that guests are sent to the checkout for
+// what they chose to pay, and that the reservation stands if it fails
+func TestPostReservationPayment(t *testing.T) {
+	fake := withPayments(t, 25)
+
+	for _, test := range postReservationPaymentTests {
+		fake.Reset()
+		fake.Fail(test.fail)
+
+		postedData := url.Values{
+			"start_date": {"2040-01-01"},
+			"end_date":   {"2040-01-02"},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"1"},
+			"payment":    {test.payment},
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		recorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusSeeOther {
+			t.Errorf("%s expected code %d, but got %d", test.name, http.StatusSeeOther, recorder.Code)
+		}
+
+		actualLocation, _ := recorder.Result().Location()
+		if actualLocation.String() != test.expectedLocation {
+			t.Errorf("%s returned wrong location: got %s, wanted %s", test.name, actualLocation.String(), test.expectedLocation)
+		}
+
+		checkouts := fake.Checkouts()
+		if test.expectedAmount == 0 {
+			if len(checkouts) != 0 {
+				t.Errorf("%s expected no checkout, got %v", test.name, checkouts)
+			}
+		} else if len(checkouts) != 1 || checkouts[0].Amount != test.expectedAmount || checkouts[0].Currency != "USD" || checkouts[0].Email != "john@smith.com" {
+			t.Errorf("%s expected a checkout for %d, got %v", test.name, test.expectedAmount, checkouts)
+		}
+
+		if flash := session.PopString(ctx, "warning"); !strings.Contains(flash, test.expectedFlash) {
+			t.Errorf("%s expected warning %q, got %q", test.name, test.expectedFlash, flash)
+		}
+	}
+}
+
+// Create a set of tests to run
+var paymentReturnTests = []struct {
+	name                 string
+	url                  string
+	id                   string
+	status               string // The fake's status for the checkout. Empty leaves it unknown
+	inSession            bool
+	expectedResponseCode int
+	expectedLocation     string
+	expectedFlashKey     string
+}{
+	{"paid", "/payments/1/return", "1", models.PaymentPaid, true, http.StatusSeeOther, "/reservation-summary", "flash"},
+	{"failed", "/payments/1/return", "1", models.PaymentFailed, true, http.StatusSeeOther, "/reservation-summary", "error"},
+	{"still-pending", "/payments/1/return", "1", models.PaymentPending, true, http.StatusSeeOther, "/reservation-summary", "warning"},
+	{"provider-doesnt-know", "/payments/1/return", "1", "", true, http.StatusSeeOther, "/reservation-summary", "warning"},
+	{"someone-elses", "/payments/1/return", "1", models.PaymentPaid, false, http.StatusSeeOther, "/", ""},
+	{"cancelled", "/payments/1/cancel", "1", "", true, http.StatusSeeOther, "/reservation-summary", "warning"},
+	{"not-found", "/payments/100/return", "100", "", true, http.StatusNotFound, "", ""},
+	{"bad-id", "/payments/x/return", "x", "", true, http.StatusNotFound, "", ""},
+}
+
+// TestPaymentReturn tests that guests coming back from the checkout are told
+// whether they paid, and shown their reservation
+func TestPaymentReturn(t *testing.T) {
+	fake := withPayments(t, 0)
+
+	for _, test := range paymentReturnTests {
+		fake.Reset()
+		if test.status != "" {
+			fake.SetStatus("fake_1", test.status)
+		}
+
+		req, _ := http.NewRequest("GET", test.url, nil)
+		ctx := getCtx(req)
+		ctx = addIdToChiContext(ctx, test.id)
+		req = req.WithContext(ctx)
+
+		if test.inSession {
+			session.Put(ctx, "reservation", models.Reservation{ID: 1, Total: 12000})
+		}
+
+		handler := http.HandlerFunc(Repo.PaymentReturn)
+		if strings.HasSuffix(test.url, "/cancel") {
+			handler = Repo.PaymentCancel
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedResponseCode {
+			t.Errorf("%s expected code %d, but got %d", test.name, test.expectedResponseCode, recorder.Code)
+			continue
+		}
+
+		if test.expectedLocation != "" {
+			actualLocation, _ := recorder.Result().Location()
+			if actualLocation.String() != test.expectedLocation {
+				t.Errorf("%s returned wrong location: got %s, wanted %s", test.name, actualLocation.String(), test.expectedLocation)
+			}
+		}
+
+		if test.expectedFlashKey != "" && session.PopString(ctx, test.expectedFlashKey) == "" {
+			t.Errorf("%s expected a %s message", test.name, test.expectedFlashKey)
+		}
+	}
+}
+
+// Create a set of tests to run
+var paymentWebhookTests = []struct {
+	name                 string
+	body                 string
+	expectedResponseCode int
+}{
+	{"paid", `{"session_id": "fake_1", "status": "paid"}`, http.StatusOK},
+	{"not-a-change", `{"session_id": "fake_1"}`, http.StatusOK},
+	{"unknown-checkout", `{"session_id": "other_1", "status": "paid"}`, http.StatusOK},
+	{"not-json", `paid`, http.StatusBadRequest},
+}
+
+// TestPaymentWebhook tests that the provider's webhooks are acknowledged, and
+// rejected if they can't be read
+func TestPaymentWebhook(t *testing.T) {
+	for _, test := range paymentWebhookTests {
+		withPayments(t, 0)
+
+		req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(test.body))
+
+		recorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PaymentWebhook)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedResponseCode {
+			t.Errorf("%s expected code %d, but got %d", test.name, test.expectedResponseCode, recorder.Code)
+		}
+	}
+
+	// Without payments, there's nothing to tell us about
+	app.Payments = nil
+
+	req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(paymentWebhookTests[0].body))
+
+	recorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PaymentWebhook)
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected code %d without payments, but got %d", http.StatusNotFound, recorder.Code)
+	}
+}
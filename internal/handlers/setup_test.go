@@ -12,7 +12,11 @@ import (
 	"time"
 
 	"github.com/BlackSound1/Go-B-and-B/internal/config"
+	"github.com/BlackSound1/Go-B-and-B/internal/emails"
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/mailer"
 	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/notify"
 	"github.com/BlackSound1/Go-B-and-B/internal/render"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
@@ -23,12 +27,15 @@ import (
 
 var app config.AppConfig
 var session *scs.SessionManager
+var sentMail = mailer.NewMemory() // What the handlers emailed
 var pathToTemplates = "./../../templates"
 var functions = template.FuncMap{
 	"humanDate":  render.HumanDate,
 	"formatDate": render.FormatDate,
 	"iterate":    render.Iterate,
 	"add":        render.Add,
+	"money":      render.Money,
+	"percent":    render.Percent,
 }
 
 // TestMain sets up the testing environment and runs the tests. It is the
@@ -51,6 +58,7 @@ func TestMain(m *testing.M) {
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
+	gob.Register(time.Time{})
 
 	// Define loggers. The | is a bitwise OR, so all flags get set to 1 integer value
 	app.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -66,11 +74,19 @@ func TestMain(m *testing.M) {
 	// Associate session with app config
 	app.Session = session
 
-	// Set up dummy mail channel
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(app.MailChan)
-	listenForMail()
+	app.Notifications = notify.NewBroker()
+
+	app.Mailer = sentMail
+	app.PropertyAddress = "1 Fort Road, Smythe"
+	app.PropertyName = "Go B & B"
+	app.Currency = "USD"
+	app.CheckIn = 15 * time.Hour
+	app.CheckOut = 11 * time.Hour
+	app.DigestTime = 7 * time.Hour
+	app.Emails, err = emails.Load("./../../email_templates", app.Currency)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Create template cache and associate it with app config
 	tc, err := CreateTestTemplateCache()
@@ -91,20 +107,13 @@ func TestMain(m *testing.M) {
 	// Gives render package access to app config
 	render.NewRenderer(&app)
 
+	// Gives helpers package access to app config
+	helpers.NewHelpers(&app)
+
 	// Run the tests
 	os.Exit(m.Run())
 }
 
-// listenForMail starts a goroutine to listen for messages on the app's mail channel.
-// This is just a dummy implementation for testing
-func listenForMail() {
-	go func() {
-		for {
-			<-app.MailChan
-		}
-	}()
-}
-
 // getRoutes returns the chi multiplexer with routes set up for application testing
 func getRoutes() http.Handler {
 
@@ -123,15 +132,41 @@ func getRoutes() http.Handler {
 	mux.Get("/majors-suite", Repo.Majors)
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
+	mux.Get("/search-availability-json", Repo.AvailabilityJSON)
 	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
 
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Get("/payments/{id}/return", Repo.PaymentReturn)
+	mux.Get("/payments/{id}/cancel", Repo.PaymentCancel)
+	mux.Post("/payments/webhook", Repo.PaymentWebhook)
+
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
 	mux.Get("/user/logout", Repo.Logout)
+	mux.Get("/user/login/oidc", Repo.OIDCLogin)
+	mux.Get("/user/login/oidc/callback", Repo.OIDCCallback)
+
+	// Calendar feeds are protected by the secret token in their URL
+	mux.Get("/ical/rooms/{id}/{token}.ics", Repo.RoomICalFeed)
+	mux.Get("/ical/property/{token}.ics", Repo.PropertyICalFeed)
+
+	mux.Get("/guest/register", Repo.ShowGuestRegister)
+	mux.Post("/guest/register", Repo.PostGuestRegister)
+	mux.Get("/guest/login", Repo.ShowGuestLogin)
+	mux.Post("/guest/login", Repo.PostGuestLogin)
+	mux.Get("/guest/logout", Repo.GuestLogout)
+	mux.Get("/guest/invite/{token}", Repo.ShowGuestInvite)
+	mux.Post("/guest/invite/{token}", Repo.PostGuestInvite)
+	mux.Get("/guest/account", Repo.GuestAccount)
+	mux.Post("/guest/account", Repo.PostGuestAccount)
+	mux.Post("/guest/account/verify-email", Repo.PostGuestSendVerification)
+	mux.Get("/guest/account/email/{token}", Repo.ShowGuestEmailVerification)
+	mux.Post("/guest/account/email/{token}", Repo.PostGuestEmailVerification)
+	mux.Post("/guest/account/reservations/{id}/invoice", Repo.GuestIssueInvoice)
+	mux.Get("/guest/account/reservations/{id}/invoice.pdf", Repo.GuestReservationInvoice)
 
 	mux.Get("/admin/dashboard", Repo.AdminDashboard)
 
@@ -141,8 +176,53 @@ func getRoutes() http.Handler {
 	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationCalendar)
 	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
-	mux.Get("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
-	mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
+	mux.Get("/admin/reservations/{src}/{id}/folio", Repo.AdminReservationFolio)
+	mux.Post("/admin/reservations/{src}/{id}/invoice", Repo.AdminIssueInvoice)
+	mux.Get("/admin/reservations/{src}/{id}/invoice.pdf", Repo.AdminReservationInvoice)
+	mux.Post("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
+	mux.Post("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
+
+	mux.Get("/admin/profile", Repo.AdminProfile)
+	mux.Post("/admin/profile/tokens", Repo.AdminPostAccessToken)
+	mux.Post("/admin/profile/tokens/{id}/revoke", Repo.AdminRevokeAccessToken)
+	mux.Post("/admin/profile/digest", Repo.AdminPostDailyDigest)
+	mux.Post("/admin/profile/sso", Repo.AdminLinkSSO)
+	mux.Get("/admin/calendar-feeds", Repo.AdminCalendarFeeds)
+	mux.Post("/admin/calendar-feeds/import", Repo.AdminPostICalImport)
+	mux.Post("/admin/calendar-feeds/import/{id}/sync", Repo.AdminSyncICalImport)
+	mux.Post("/admin/calendar-feeds/import/{id}/delete", Repo.AdminDeleteICalImport)
+	mux.Get("/admin/charges", Repo.AdminCharges)
+	mux.Post("/admin/charges", Repo.AdminPostCharge)
+	mux.Post("/admin/charges/{id}/delete", Repo.AdminDeleteCharge)
+	mux.Get("/admin/webhooks", Repo.AdminWebhooks)
+	mux.Post("/admin/webhooks", Repo.AdminPostWebhook)
+	mux.Get("/admin/webhooks/{id}", Repo.AdminShowWebhook)
+	mux.Post("/admin/webhooks/{id}/delete", Repo.AdminDeleteWebhook)
+	mux.Post("/admin/webhooks/{id}/deliveries/{delivery}/redeliver", Repo.AdminRedeliverWebhook)
+	mux.Get("/admin/emails", Repo.AdminEmails)
+	mux.Post("/admin/emails/{id}/resend", Repo.AdminResendEmail)
+	mux.Get("/admin/events", Repo.AdminEvents)
+	mux.Get("/admin/sessions", Repo.AdminSessions)
+	mux.Post("/admin/sessions/{id}/revoke", Repo.AdminRevokeSession)
+
+	mux.Get("/api/docs", Repo.APIDocs)
+	mux.Route("/api/v1", func(r chi.Router) {
+		r.NotFound(Repo.APINotFound)
+		r.MethodNotAllowed(Repo.APIMethodNotAllowed)
+
+		r.Get("/openapi.json", Repo.OpenAPI)
+		r.Get("/rooms", Repo.APIRooms)
+		r.Get("/rooms/{id}", Repo.APIRoom)
+		r.Get("/rooms/{id}/availability", Repo.APIRoomAvailability)
+		r.Get("/rooms/{id}/calendar", Repo.APIRoomCalendar)
+		r.Get("/availability", Repo.APIAvailability)
+		r.Post("/reservations", Repo.APIPostReservation)
+		r.Get("/reservations", Repo.APIReservations)
+		r.Get("/reservations/{id}", Repo.APIReservation)
+		r.Patch("/reservations/{id}", Repo.APIPatchReservation)
+		r.Post("/reservations/{id}/cancel", Repo.APICancelReservation)
+		r.Post("/blocks", Repo.APIPostBlock)
+	})
 
 	// Serve static files
 	fileServer := http.FileServer(http.Dir("./static/"))
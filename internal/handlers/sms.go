@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"github.com/BlackSound1/Go-B-and-B/internal/emails"
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/sms"
+)
+
+// textReservation texts the guest a confirmation of a new reservation, if
+// they opted in, and alerts the owner
+func (m *Repository) textReservation(res models.Reservation) {
+	if m.App.SMS == nil {
+		return
+	}
+
+	data := emails.ReservationEmail{Reservation: res, SiteURL: helpers.BaseURL()}
+
+	if res.SMSOptIn {
+		m.sendSMS(res.Phone, sms.GuestConfirmation, data)
+	}
+
+	if m.App.OwnerPhone != "" {
+		m.sendSMS(m.App.OwnerPhone, sms.OwnerAlert, data)
+	}
+}
+
+// sendSMS renders a text message template and queues the message to be sent
+// in the background
+func (m *Repository) sendSMS(phone, template string, data any) {
+	to, err := sms.Normalize(phone, m.App.SMSCountryCode)
+	if err != nil {
+		m.App.ErrorLog.Printf("texting %q: %v", phone, err)
+		return
+	}
+
+	body, err := m.App.SMSTemplates.Render(template, data)
+	if err == nil {
+		err = m.DB.QueueSMS(to, body)
+	}
+
+	if err != nil {
+		m.App.ErrorLog.Printf("queueing text message to %s: %v", to, err)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/sms"
+)
+
+// withSMS turns text messages on for one test, sending them to a fake
+func withSMS(t *testing.T) *sms.Fake {
+	t.Helper()
+
+	templates, err := sms.LoadTemplates("./../../sms_templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := sms.NewFake(nil)
+
+	app.SMS = fake
+	app.SMSTemplates = templates
+	app.SMSCountryCode = "1"
+	app.OwnerPhone = "+15555550000"
+
+	t.Cleanup(func() {
+		app.SMS = nil
+		app.SMSTemplates = nil
+		app.OwnerPhone = ""
+	})
+
+	return fake
+}
+
+// Create a set of tests to run
+var postReservationSMSTests = []struct {
+	name                 string
+	optIn                bool
+	phone                string
+	expectedResponseCode int
+	expectedTexts        []string
+}{
+	{"opted-in", true, "(555) 555-5555", http.StatusSeeOther, []string{"+15555555555", "+15555550000"}},
+	{"not-opted-in", false, "(555) 555-5555", http.StatusSeeOther, []string{"+15555550000"}},
+	{"opted-in-without-a-number", true, "", http.StatusOK, nil},
+}
+
+// TestPostReservationSMS tests that guests who opt in are texted, and the owner
+// is alerted
+func TestPostReservationSMS(t *testing.T) {
+	fake := withSMS(t)
+
+	for _, test := range postReservationSMSTests {
+		fake.Reset()
+
+		postedData := url.Values{
+			"start_date": {"2040-01-01"},
+			"end_date":   {"2040-01-02"},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {test.phone},
+			"room_id":    {"1"},
+		}
+		if test.optIn {
+			postedData.Set("sms_opt_in", "1")
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		recorder := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(recorder, req)
+
+		if recorder.Code != test.expectedResponseCode {
+			t.Errorf("%s expected code %d, but got %d", test.name, test.expectedResponseCode, recorder.Code)
+		}
+
+		var to []string
+		for _, msg := range fake.Sent() {
+			to = append(to, msg.To)
+		}
+
+		if !slices.Equal(to, test.expectedTexts) {
+			t.Errorf("%s expected texts to %v, but sent them to %v", test.name, test.expectedTexts, to)
+		}
+	}
+}
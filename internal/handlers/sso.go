@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/oidc"
+)
+
+// OIDCLogin starts a single sign-on login by sending the user to the identity
+// provider. The state, nonce and PKCE verifier are kept in the session so the
+// callback can check them.
+func (m *Repository) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if m.App.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		values[i] = v
+	}
+
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	m.App.Session.Put(r.Context(), "oidc_state", state)
+	m.App.Session.Put(r.Context(), "oidc_nonce", nonce)
+	m.App.Session.Put(r.Context(), "oidc_verifier", verifier)
+
+	http.Redirect(w, r, m.App.OIDC.AuthCodeURL(state, nonce, verifier), http.StatusSeeOther)
+}
+
+// OIDCCallback finishes a single sign-on login. The ID token's groups decide
+// the user's access level, and users are created the first time they log in.
+// Users who started linking single sign-on from their profile have the
+// identity provider account linked to them instead.
+func (m *Repository) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if m.App.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Each login attempt can only be finished once
+	state := m.App.Session.PopString(r.Context(), "oidc_state")
+	nonce := m.App.Session.PopString(r.Context(), "oidc_nonce")
+	verifier := m.App.Session.PopString(r.Context(), "oidc_verifier")
+
+	// Only the user who asked for it can have an account linked to them
+	linkID := m.App.Session.PopInt(r.Context(), "oidc_link_user")
+	if linkID != m.App.Session.GetInt(r.Context(), "user_id") {
+		linkID = 0
+	}
+
+	failTo := "/user/login"
+	if linkID != 0 {
+		failTo = "/admin/profile"
+	}
+
+	q := r.URL.Query()
+
+	if errCode := q.Get("error"); errCode != "" {
+		m.App.InfoLog.Println("identity provider returned an error:", errCode, q.Get("error_description"))
+		m.ssoFailed(w, r, failTo, "Single sign-on failed")
+		return
+	}
+
+	if state == "" || q.Get("state") != state {
+		m.ssoFailed(w, r, failTo, "Single sign-on failed. Please try again")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	claims, err := m.App.OIDC.Exchange(ctx, q.Get("code"), verifier, nonce)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.ssoFailed(w, r, failTo, "Single sign-on failed")
+		return
+	}
+
+	level := oidc.AccessLevel(claims.Groups, m.App.OIDCGroupLevels)
+	if level == 0 {
+		m.ssoFailed(w, r, failTo, "Your account doesn't have access to the admin area")
+		return
+	}
+
+	id, err := m.provisionUser(claims, level, linkID)
+	switch {
+	case errors.Is(err, errSSONoEmail):
+		m.ssoFailed(w, r, failTo, "Your identity provider didn't share a verified email address")
+		return
+	case errors.Is(err, errSSOUnlinkedAccount):
+		m.ssoFailed(w, r, failTo, "An account with your email already exists. Log in with your password and link single sign-on from your profile")
+		return
+	case errors.Is(err, errSSOAlreadyLinked):
+		m.ssoFailed(w, r, failTo, "That account is already linked to someone else's single sign-on")
+		return
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if linkID != 0 {
+		m.App.Session.Put(r.Context(), "flash", "Single sign-on linked")
+		http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+		return
+	}
+
+	// Prevents Session Fixation Attack
+	_ = m.App.Session.RenewToken(r.Context())
+
+	m.logInUser(r, id)
+
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// AdminLinkSSO starts linking the logged in user to an identity provider
+// account, so they can log in with single sign-on from then on
+func (m *Repository) AdminLinkSSO(w http.ResponseWriter, r *http.Request) {
+	if m.App.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "oidc_link_user", m.App.Session.GetInt(r.Context(), "user_id"))
+	http.Redirect(w, r, "/user/login/oidc", http.StatusSeeOther)
+}
+
+// ssoFailed sends the user back to where they started single sign-on from,
+// with an error
+func (m *Repository) ssoFailed(w http.ResponseWriter, r *http.Request, url, message string) {
+	m.App.Session.Put(r.Context(), "error", message)
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+var (
+	// errSSONoEmail is returned when a new user's ID token has no verified email
+	errSSONoEmail = errors.New("identity provider didn't share a verified email")
+
+	// errSSOUnlinkedAccount is returned when an ID token's email belongs to a
+	// user who has to link single sign-on themselves
+	errSSOUnlinkedAccount = errors.New("user with that email isn't linked to the identity provider")
+
+	// errSSOAlreadyLinked is returned when the user or the identity provider
+	// account is already linked to another one
+	errSSOAlreadyLinked = errors.New("already linked to another identity provider account")
+)
+
+// provisionUser returns the ID of the user an ID token belongs to, creating the
+// user if this is their first login. Users are matched by the token's issuer
+// and subject, which never change, unlike emails. The access level always
+// follows the identity provider, so removing someone from a group takes effect
+// at their next login. linkID is the user who asked to link their account, if
+// any.
+func (m *Repository) provisionUser(claims *oidc.Claims, level, linkID int) (int, error) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(claims.Name, " ")
+	}
+
+	u, err := m.DB.GetUserByOIDC(claims.Issuer, claims.Subject)
+	if err == nil && linkID != 0 && u.ID != linkID {
+		return 0, errSSOAlreadyLinked
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		u, err = m.linkSSOUser(claims, linkID)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		if claims.Email == "" || (claims.EmailVerified != nil && !*claims.EmailVerified) {
+			return 0, errSSONoEmail
+		}
+
+		// Single sign-on users have no password, so they can't use the login form
+		return m.DB.InsertUser(models.User{
+			FirstName:   firstName,
+			LastName:    lastName,
+			Email:       claims.Email,
+			AccessLevel: level,
+			OIDCIssuer:  claims.Issuer,
+			OIDCSubject: claims.Subject,
+		})
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if u.AccessLevel != level || (firstName != "" && (u.FirstName != firstName || u.LastName != lastName)) {
+		u.AccessLevel = level
+
+		if firstName != "" {
+			u.FirstName = firstName
+			u.LastName = lastName
+		}
+
+		err = m.DB.UpdateUser(u)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return u.ID, nil
+}
+
+// linkSSOUser links an existing user to the identity provider account of an
+// ID token, and returns them. That's the user who asked for it, if anyone did.
+// Otherwise it's the user with the token's email, but only if the provider
+// vouches for the email and the user has no password, so an account can't be
+// taken over by whoever controls an identity provider account with its email.
+// It returns sql.ErrNoRows if there's no one to link.
+func (m *Repository) linkSSOUser(claims *oidc.Claims, linkID int) (models.User, error) {
+	var u models.User
+	var err error
+
+	if linkID != 0 {
+		u, err = m.DB.GetUserByID(linkID)
+		if err != nil {
+			return u, fmt.Errorf("getting user %d to link: %v", linkID, err)
+		}
+	} else {
+		if claims.Email == "" {
+			return u, sql.ErrNoRows
+		}
+
+		u, err = m.DB.GetUserByEmail(claims.Email)
+		if err != nil {
+			return u, err
+		}
+
+		if claims.EmailVerified == nil || !*claims.EmailVerified || u.Password != "" {
+			return u, errSSOUnlinkedAccount
+		}
+	}
+
+	if u.OIDCSubject != "" {
+		return u, errSSOAlreadyLinked
+	}
+
+	err = m.DB.LinkUserOIDC(u.ID, claims.Issuer, claims.Subject)
+	if err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
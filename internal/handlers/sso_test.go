@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/oidc"
+	"github.com/BlackSound1/Go-B-and-B/internal/oidc/oidctest"
+)
+
+// Create a set of tests to run
+var oidcCallbackTests = []struct {
+	name             string
+	claims           map[string]any
+	badState         bool
+	linkUser         int
+	expectedLocation string
+	expectedUserID   int
+}{
+	{
+		name:             "new-admin",
+		claims:           map[string]any{"email": "new@example.com", "name": "New Person", "groups": []string{"bnb-admins"}},
+		expectedLocation: "/admin/dashboard",
+		expectedUserID:   2,
+	},
+	{
+		name:             "linked-staff",
+		claims:           map[string]any{"sub": "sso-subject", "email": "changed@example.com", "groups": []string{"bnb-staff"}},
+		expectedLocation: "/admin/dashboard",
+		expectedUserID:   3,
+	},
+	{
+		name:             "verified-email-links-account-without-password",
+		claims:           map[string]any{"email": "unlinked@example.com", "email_verified": true, "groups": []string{"bnb-staff"}},
+		expectedLocation: "/admin/dashboard",
+		expectedUserID:   4,
+	},
+	{
+		name:             "unconfirmed-email-does-not-link-account",
+		claims:           map[string]any{"email": "unlinked@example.com", "groups": []string{"bnb-staff"}},
+		expectedLocation: "/user/login",
+	},
+	{
+		name:             "account-with-password-is-not-taken-over",
+		claims:           map[string]any{"email": "staff@example.com", "email_verified": true, "groups": []string{"bnb-staff"}},
+		expectedLocation: "/user/login",
+	},
+	{
+		name:             "user-links-own-account",
+		claims:           map[string]any{"email": "someone@example.com", "groups": []string{"bnb-staff"}},
+		linkUser:         1,
+		expectedLocation: "/admin/profile",
+		expectedUserID:   1,
+	},
+	{
+		name:             "provider-account-linked-to-someone-else",
+		claims:           map[string]any{"sub": "sso-subject", "groups": []string{"bnb-staff"}},
+		linkUser:         1,
+		expectedLocation: "/admin/profile",
+		expectedUserID:   1,
+	},
+	{
+		name:             "not-in-a-mapped-group",
+		claims:           map[string]any{"sub": "sso-subject", "groups": []string{"marketing"}},
+		expectedLocation: "/user/login",
+	},
+	{
+		name:             "unverified-email",
+		claims:           map[string]any{"email": "new@example.com", "email_verified": false, "groups": []string{"bnb-staff"}},
+		expectedLocation: "/user/login",
+	},
+	{
+		name:             "state-mismatch",
+		claims:           map[string]any{"sub": "sso-subject", "groups": []string{"bnb-staff"}},
+		badState:         true,
+		expectedLocation: "/user/login",
+	},
+	{
+		name:             "provisioning-fails",
+		claims:           map[string]any{"email": "new@example.com", "given_name": "fail", "groups": []string{"bnb-staff"}},
+		expectedLocation: "",
+	},
+}
+
+// TestOIDCLogin runs the single sign-on flow end to end against a stub identity provider
+func TestOIDCLogin(t *testing.T) {
+	srv := oidctest.NewServer("go-b-and-b", "secret")
+	defer srv.Close()
+
+	provider, err := oidc.New(context.Background(), oidc.Config{
+		Issuer:       srv.Issuer(),
+		ClientID:     "go-b-and-b",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/user/login/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.OIDC = provider
+	app.OIDCGroupLevels = map[string]int{"bnb-admins": 3, "bnb-staff": 1}
+	defer func() {
+		app.OIDC = nil
+		app.OIDCGroupLevels = nil
+	}()
+
+	// Don't follow the provider's redirect back to the app
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for _, test := range oidcCallbackTests {
+		srv.Claims = test.claims
+
+		// Start the login
+		req, _ := http.NewRequest("GET", "/user/login/oidc", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		recorder := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.OIDCLogin).ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusSeeOther {
+			t.Fatalf("%s: OIDCLogin returned wrong response code: got %d, wanted %d", test.name, recorder.Code, http.StatusSeeOther)
+		}
+
+		// Log in at the provider, which redirects back with a code
+		resp, err := client.Get(recorder.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		callback, _ := resp.Location()
+		if test.badState {
+			q := callback.Query()
+			q.Set("state", "forged")
+			callback.RawQuery = q.Encode()
+		}
+
+		// Users linking their account are already logged in
+		if test.linkUser != 0 {
+			session.Put(ctx, "user_id", test.linkUser)
+			session.Put(ctx, "oidc_link_user", test.linkUser)
+		}
+
+		// Finish the login in the same session
+		req, _ = http.NewRequest("GET", "/user/login/oidc/callback?"+callback.RawQuery, nil)
+		req = req.WithContext(ctx)
+		recorder = httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.OIDCCallback).ServeHTTP(recorder, req)
+
+		if test.expectedLocation == "" {
+			if recorder.Code != http.StatusInternalServerError {
+				t.Errorf("%s: expected status %d, got %d", test.name, http.StatusInternalServerError, recorder.Code)
+			}
+			continue
+		}
+
+		if location := recorder.Header().Get("Location"); location != test.expectedLocation {
+			t.Errorf("%s: expected redirect to %s, got %s", test.name, test.expectedLocation, location)
+		}
+
+		if userID := session.GetInt(ctx, "user_id"); userID != test.expectedUserID {
+			t.Errorf("%s: expected user %d to be logged in, got %d", test.name, test.expectedUserID, userID)
+		}
+	}
+}
+
+// TestAdminLinkSSO tests that linking single sign-on sends the logged in user
+// to the identity provider, remembering who asked
+func TestAdminLinkSSO(t *testing.T) {
+	app.OIDC = &oidc.Provider{}
+	defer func() { app.OIDC = nil }()
+
+	req, _ := http.NewRequest("POST", "/admin/profile/sso", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+	recorder := httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.AdminLinkSSO).ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusSeeOther {
+		t.Errorf("AdminLinkSSO returned wrong response code: got %d, wanted %d", recorder.Code, http.StatusSeeOther)
+	}
+
+	if location := recorder.Header().Get("Location"); location != "/user/login/oidc" {
+		t.Errorf("expected redirect to /user/login/oidc, got %s", location)
+	}
+
+	if linkUser := session.GetInt(ctx, "oidc_link_user"); linkUser != 1 {
+		t.Errorf("expected user 1 to be linked, got %d", linkUser)
+	}
+}
+
+// TestOIDCNotConfigured tests that single sign-on routes don't exist unless an
+// identity provider is set up
+func TestOIDCNotConfigured(t *testing.T) {
+	for _, handler := range []http.HandlerFunc{Repo.OIDCLogin, Repo.OIDCCallback, Repo.AdminLinkSSO} {
+		req, _ := http.NewRequest("GET", "/user/login/oidc", nil)
+		req = req.WithContext(getCtx(req))
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("expected status %d, got %d", http.StatusNotFound, recorder.Code)
+		}
+	}
+}
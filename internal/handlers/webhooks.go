@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/forms"
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/render"
+	"github.com/BlackSound1/Go-B-and-B/internal/webhooks"
+	"github.com/go-chi/chi"
+)
+
+// webhookLogSize is how many of a webhook's deliveries are shown
+const webhookLogSize = 50
+
+// AdminWebhooks lists the webhooks, with a form to add another
+func (m *Repository) AdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := m.DB.AllWebhooks()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["webhooks"] = hooks
+	data["events"] = webhooks.Events
+
+	render.Template(w, r, "admin-webhooks.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminPostWebhook adds a webhook. If no events are picked, it gets every event.
+func (m *Repository) AdminPostWebhook(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("url")
+
+	u, err := url.Parse(r.Form.Get("url"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		form.Errors.Add("url", "Must be an http or https URL")
+	}
+
+	events := r.Form["events"]
+	for _, event := range events {
+		if !slices.Contains(webhooks.Events, event) {
+			form.Errors.Add("events", "Unknown event")
+		}
+	}
+
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Invalid webhook details")
+		http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := m.DB.InsertWebhook(models.Webhook{
+		URL:    u.String(),
+		Secret: secret,
+		Events: strings.Join(events, ","),
+	})
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't add webhook")
+		http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Webhook added")
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", id), http.StatusSeeOther)
+}
+
+// AdminShowWebhook shows a webhook's secret and its recent deliveries
+func (m *Repository) AdminShowWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := m.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := m.DB.WebhookDeliveriesForWebhook(hook.ID, webhookLogSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["webhook"] = hook
+	data["deliveries"] = deliveries
+
+	render.Template(w, r, "admin-webhook.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminDeleteWebhook deletes a webhook, along with its delivery log
+func (m *Repository) AdminDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteWebhook(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Webhook deleted")
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// AdminRedeliverWebhook queues one of a webhook's deliveries to be sent again
+func (m *Repository) AdminRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	deliveryID, err := strconv.Atoi(chi.URLParam(r, "delivery"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.RedeliverWebhookDelivery(id, deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Delivery queued to be sent again")
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", id), http.StatusSeeOther)
+}
+
+// webhookFromURL looks up the webhook whose ID is in the URL. If there isn't
+// one, it responds with an error and returns false.
+func (m *Repository) webhookFromURL(w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.Webhook{}, false
+	}
+
+	hook, err := m.DB.GetWebhookByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return hook, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return hook, false
+	}
+
+	return hook, true
+}
+
+// emit queues an event for the webhooks subscribed to it. The change it's
+// about has already been made, so failing to queue it is only logged.
+func (m *Repository) emit(event string, data any) {
+	payload, err := webhooks.Payload(event, data)
+	if err == nil {
+		err = m.DB.InsertWebhookDeliveries(event, payload)
+	}
+
+	if err != nil {
+		m.App.ErrorLog.Printf("queueing webhook event %s: %v", event, err)
+	}
+}
+
+// emitReservation emits an event about a reservation, and tells staff in the
+// admin area about it. Each is done whether or not the other worked.
+func (m *Repository) emitReservation(event string, res models.Reservation) {
+	data := newAPIReservation(res)
+
+	m.notifyStaff(event, data)
+	m.emit(event, data)
+}
+
+// emitBlock emits an event about a block on the night of date
+func (m *Repository) emitBlock(event string, roomID int, date time.Time) {
+	m.emit(event, apiBlock{
+		RoomID:    roomID,
+		StartDate: date.Format("2006-01-02"),
+		EndDate:   date.AddDate(0, 0, 1).Format("2006-01-02"),
+	})
+}
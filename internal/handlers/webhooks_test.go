@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+var adminWebhookTests = []struct {
+	name             string
+	handler          func(*Repository, http.ResponseWriter, *http.Request)
+	id               string
+	delivery         string
+	postedData       url.Values
+	expectedStatus   int
+	expectedLocation string
+	expectedSession  string // Session key the outcome is reported in
+	expectedMessage  string
+}{
+	{
+		name:             "add",
+		handler:          (*Repository).AdminPostWebhook,
+		postedData:       url.Values{"url": {"https://hooks.example.com/bnb"}, "events": {"reservation.created", "block.added"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/webhooks/2",
+		expectedSession:  "flash",
+		expectedMessage:  "Webhook added",
+	},
+	{
+		name:             "add-every-event",
+		handler:          (*Repository).AdminPostWebhook,
+		postedData:       url.Values{"url": {"http://localhost:9000/hook"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/webhooks/2",
+		expectedSession:  "flash",
+		expectedMessage:  "Webhook added",
+	},
+	{
+		name:             "add-invalid-url",
+		handler:          (*Repository).AdminPostWebhook,
+		postedData:       url.Values{"url": {"ftp://hooks.example.com"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/webhooks",
+		expectedSession:  "error",
+		expectedMessage:  "Invalid webhook details",
+	},
+	{
+		name:             "add-unknown-event",
+		handler:          (*Repository).AdminPostWebhook,
+		postedData:       url.Values{"url": {"https://hooks.example.com/bnb"}, "events": {"room.painted"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/webhooks",
+		expectedSession:  "error",
+		expectedMessage:  "Invalid webhook details",
+	},
+	{
+		name:             "DB-insert-fails",
+		handler:          (*Repository).AdminPostWebhook,
+		postedData:       url.Values{"url": {"https://fail.example.com"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/webhooks",
+		expectedSession:  "error",
+		expectedMessage:  "Can't add webhook",
+	},
+	{
+		name:             "delete",
+		handler:          (*Repository).AdminDeleteWebhook,
+		id:               "1",
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/webhooks",
+		expectedSession:  "flash",
+		expectedMessage:  "Webhook deleted",
+	},
+	{
+		name:           "delete-invalid-id",
+		handler:        (*Repository).AdminDeleteWebhook,
+		id:             "bad-id",
+		expectedStatus: http.StatusBadRequest,
+	},
+	{
+		name:             "redeliver",
+		handler:          (*Repository).AdminRedeliverWebhook,
+		id:               "1",
+		delivery:         "2",
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/webhooks/1",
+		expectedSession:  "flash",
+		expectedMessage:  "Delivery queued to be sent again",
+	},
+	{
+		name:           "redeliver-non-existent",
+		handler:        (*Repository).AdminRedeliverWebhook,
+		id:             "1",
+		delivery:       "100",
+		expectedStatus: http.StatusNotFound,
+	},
+	{
+		name:           "redeliver-invalid-id",
+		handler:        (*Repository).AdminRedeliverWebhook,
+		id:             "1",
+		delivery:       "bad-id",
+		expectedStatus: http.StatusBadRequest,
+	},
+}
+
+// TestAdminWebhooks tests adding and deleting webhooks, and redelivering events
+func TestAdminWebhooks(t *testing.T) {
+	for _, test := range adminWebhookTests {
+		req, _ := http.NewRequest("POST", "/admin/webhooks", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("id", test.id)
+		chiCtx.URLParams.Add("delivery", test.delivery)
+
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, chiCtx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+
+		test.handler(Repo, recorder, req)
+
+		if recorder.Code != test.expectedStatus {
+			t.Errorf("Test %s returned wrong response code: got %d, wanted %d", test.name, recorder.Code, test.expectedStatus)
+			continue
+		}
+
+		if location := recorder.Header().Get("Location"); location != test.expectedLocation {
+			t.Errorf("Test %s redirected to %q, wanted %q", test.name, location, test.expectedLocation)
+		}
+
+		if test.expectedSession == "" {
+			continue
+		}
+
+		if msg := session.PopString(ctx, test.expectedSession); msg != test.expectedMessage {
+			t.Errorf("Test %s expected %s %q, but got %q", test.name, test.expectedSession, test.expectedMessage, msg)
+		}
+	}
+}
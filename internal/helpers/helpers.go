@@ -1,16 +1,35 @@
 package helpers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"runtime/debug"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/BlackSound1/Go-B-and-B/internal/config"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
 	"github.com/joho/godotenv"
 )
 
+// accessTokenPrefix makes personal access tokens recognisable, e.g. in logs
+// or secret scanners
+const accessTokenPrefix = "gbb_"
+
+// contextKey is used for values this package stores in a request context
+type contextKey string
+
+const accessTokenKey contextKey = "access_token"
+
 var app *config.AppConfig
 
 // NewHelpers sets the config for the helpers package
@@ -48,18 +67,397 @@ func IsAuthenticated(r *http.Request) bool {
 	return app.Session.Exists(r.Context(), "user_id")
 }
 
+// IsGuestAuthenticated checks if a guest is logged in. Guests are kept in the
+// session under "guest_id", so a guest is never treated as staff.
+func IsGuestAuthenticated(r *http.Request) bool {
+	return app.Session.Exists(r.Context(), "guest_id")
+}
+
+// ClientIP returns the IP address of the client that made the request. That is
+// the address the request came from, unless it came from one of the trusted
+// proxies. Then X-Forwarded-For is read from right to left, as anyone can put
+// anything at its start, and the first address that isn't a trusted proxy is
+// the client.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+
+		// Garbage can only have come from the client, so stop at it
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+
+		if !isTrustedProxy(hop) {
+			return hop
+		}
+
+		host = hop
+	}
+
+	// Every hop was a trusted proxy, so the furthest one is the client
+	return host
+}
+
+// isTrustedProxy reports whether ip is one of the proxies in TRUSTED_PROXIES
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+	for _, prefix := range app.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR
+// ranges, like 10.0.0.1,172.16.0.0/12, into the ranges they cover.
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+			}
+
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+		}
+
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return proxies, nil
+}
+
+// NewAccessToken generates a new random personal access token. It returns
+// the plain token, which is only ever shown to the user once, and its hash,
+// which is what gets stored in the database.
+func NewAccessToken() (string, string, error) {
+	return newToken(accessTokenPrefix)
+}
+
+// NewInviteToken generates a new random token for a guest account invitation.
+// Like NewAccessToken, it returns the plain token and the hash to store.
+func NewInviteToken() (string, string, error) {
+	return newToken("")
+}
+
+// NewIdempotencyKey generates a random key that identifies one submission of
+// a form, so submitting it again can be recognised.
+func NewIdempotencyKey() (string, error) {
+	key, _, err := newToken("")
+	return key, err
+}
+
+// IdempotencyKey scopes a key a client sent to where it came from, like the
+// reservation form or an API client, so one can't replay the submissions of
+// another. It returns the hash of the scoped key, which is what gets stored,
+// or "" if there is no key.
+func IdempotencyKey(scope, key string) string {
+	if key == "" {
+		return ""
+	}
+
+	return HashToken(scope + ":" + key)
+}
+
+// newToken generates a random token with the given prefix, and its hash.
+func newToken(prefix string) (string, string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	token := prefix + hex.EncodeToString(b)
+
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex-encoded SHA-256 hash of a plain token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// WithAccessToken returns a copy of ctx carrying the access token that
+// authenticated the request.
+func WithAccessToken(ctx context.Context, t models.AccessToken) context.Context {
+	return context.WithValue(ctx, accessTokenKey, t)
+}
+
+// AccessTokenFromRequest returns the access token that authenticated the
+// request, if any.
+func AccessTokenFromRequest(r *http.Request) (models.AccessToken, bool) {
+	t, ok := r.Context().Value(accessTokenKey).(models.AccessToken)
+	return t, ok
+}
+
+// IsTokenAuthenticated reports whether the request was authenticated with
+// a bearer token rather than a session cookie.
+func IsTokenAuthenticated(r *http.Request) bool {
+	_, ok := AccessTokenFromRequest(r)
+	return ok
+}
+
+// WriteJSON writes data as an indented JSON response with the given status code.
+func WriteJSON(w http.ResponseWriter, status int, data any) {
+	out, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// APIError describes what went wrong with a JSON API request
+type APIError struct {
+	Status  int               `json:"status"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"` // What's wrong with each invalid field
+}
+
+// ErrorEnvelope wraps an APIError so every JSON error response has the same shape
+type ErrorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+// JSONError writes a JSON error response with the given status code and message.
+func JSONError(w http.ResponseWriter, status int, message string) {
+	WriteJSON(w, status, ErrorEnvelope{
+		Error: APIError{Status: status, Message: message},
+	})
+}
+
+// JSONFieldErrors writes a 422 JSON error response listing what's wrong with
+// each invalid field of the request body.
+func JSONFieldErrors(w http.ResponseWriter, fields map[string]string) {
+	WriteJSON(w, http.StatusUnprocessableEntity, ErrorEnvelope{
+		Error: APIError{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "Some fields are invalid",
+			Fields:  fields,
+		},
+	})
+}
+
+// BaseURL returns the public URL of the site, without a trailing slash. Use it
+// for links that are followed from outside the site, such as in emails.
+func BaseURL() string {
+	if u, ok := app.EnvVars["BASE_URL"].(string); ok && u != "" {
+		return strings.TrimRight(u, "/")
+	}
+
+	return "http://localhost:8080"
+}
+
+// ParseTimeOfDay parses a time of day, like 3 PM, 3:30 PM or 15:30, into how
+// long it is after midnight
+func ParseTimeOfDay(s string) (time.Duration, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+
+	for _, layout := range []string{"3 PM", "3PM", "3:04 PM", "3:04PM", "15:04"} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%q is not a time of day like 3 PM or 15:00", s)
+}
+
 // getAllDotEnv reads all the environment variables from the given
 // .env file and puts them into a map.
 func GetAllDotEnv(envfile string) map[string]any {
 	godotenv.Load(envfile)
 
 	connStr := os.Getenv("DB_STRING")
+	baseURL := os.Getenv("BASE_URL")
+	icalSecret := os.Getenv("ICAL_SECRET")
+	oidcIssuer := os.Getenv("OIDC_ISSUER")
+	oidcClientID := os.Getenv("OIDC_CLIENT_ID")
+	oidcClientSecret := os.Getenv("OIDC_CLIENT_SECRET")
+	oidcGroupLevels := os.Getenv("OIDC_GROUP_LEVELS")
+	smsProvider := os.Getenv("SMS_PROVIDER")
+	smsAPIURL := os.Getenv("SMS_API_URL")
+	smsAPIUsername := os.Getenv("SMS_API_USERNAME")
+	smsAPIPassword := os.Getenv("SMS_API_PASSWORD")
+	smsFrom := os.Getenv("SMS_FROM")
+	ownerPhone := os.Getenv("OWNER_PHONE")
+	paymentProvider := os.Getenv("PAYMENT_PROVIDER")
+	paymentAPIURL := os.Getenv("PAYMENT_API_URL")
+	paymentAPIKey := os.Getenv("PAYMENT_API_KEY")
+	paymentWebhookSecret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	mailBackend := os.Getenv("MAIL_BACKEND")
+	mailDir := os.Getenv("MAIL_DIR")
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := os.Getenv("SMTP_PORT")
+	smtpUsername := os.Getenv("SMTP_USERNAME")
+	smtpPassword := os.Getenv("SMTP_PASSWORD")
+	smtpEncryption := os.Getenv("SMTP_ENCRYPTION")
+	smtpConnectTimeout := os.Getenv("SMTP_CONNECT_TIMEOUT")
+	smtpSendTimeout := os.Getenv("SMTP_SEND_TIMEOUT")
+	smtpKeepAlive := os.Getenv("SMTP_KEEPALIVE")
 	prod, _ := strconv.ParseBool(os.Getenv("PROD"))
 	useCache, _ := strconv.ParseBool(os.Getenv("USE_TEMPLATE_CACHE"))
 
+	// Guests can book up to a year ahead unless told otherwise
+	bookingHorizon, err := strconv.Atoi(os.Getenv("BOOKING_HORIZON_DAYS"))
+	if err != nil || bookingHorizon < 0 {
+		bookingHorizon = 365
+	}
+
+	// Imported calendars are synced every 15 minutes unless told otherwise
+	icalSyncMinutes, err := strconv.Atoi(os.Getenv("ICAL_SYNC_MINUTES"))
+	if err != nil || icalSyncMinutes < 0 {
+		icalSyncMinutes = 15
+	}
+
+	// Guests are emailed 3 days before they arrive, and the day after they
+	// leave, unless told otherwise. 0 turns the email off.
+	preArrivalDays, err := strconv.Atoi(os.Getenv("PRE_ARRIVAL_EMAIL_DAYS"))
+	if err != nil || preArrivalDays < 0 {
+		preArrivalDays = 3
+	}
+
+	postStayDays, err := strconv.Atoi(os.Getenv("POST_STAY_EMAIL_DAYS"))
+	if err != nil || postStayDays < 0 {
+		postStayDays = 1
+	}
+
+	checkInTime := os.Getenv("CHECK_IN_TIME")
+	if checkInTime == "" {
+		checkInTime = "3 PM"
+	}
+
+	checkOutTime := os.Getenv("CHECK_OUT_TIME")
+	if checkOutTime == "" {
+		checkOutTime = "11 AM"
+	}
+
+	propertyAddress := os.Getenv("PROPERTY_ADDRESS")
+	propertyEmail := os.Getenv("PROPERTY_EMAIL")
+	propertyPhone := os.Getenv("PROPERTY_PHONE")
+	propertyTaxID := os.Getenv("PROPERTY_TAX_ID")
+	trustedProxies := os.Getenv("TRUSTED_PROXIES")
+
+	propertyName := os.Getenv("PROPERTY_NAME")
+	if propertyName == "" {
+		propertyName = "Go B & B"
+	}
+
+	digestTime := os.Getenv("DIGEST_TIME")
+	if digestTime == "" {
+		digestTime = "7 AM"
+	}
+
+	// Prices are in US dollars unless told otherwise
+	currency := strings.ToUpper(os.Getenv("CURRENCY"))
+	if currency == "" {
+		currency = "USD"
+	}
+
+	// Guests pay in full when booking unless a deposit is set. 0 turns
+	// deposits off.
+	depositPercent, err := strconv.Atoi(os.Getenv("PAYMENT_DEPOSIT_PERCENT"))
+	if err != nil || depositPercent < 0 || depositPercent > 100 {
+		depositPercent = 0
+	}
+
+	reviewURL := os.Getenv("REVIEW_URL")
+	if reviewURL == "" {
+		reviewURL = baseURL + "/contact"
+	}
+
+	// Phone numbers without a country code are taken to be North American
+	// unless told otherwise
+	smsCountryCode := strings.TrimPrefix(os.Getenv("SMS_COUNTRY_CODE"), "+")
+	if smsCountryCode == "" {
+		smsCountryCode = "1"
+	}
+
 	return map[string]any{
-		"DATABASE_URL":       connStr,
-		"PROD":               prod,
-		"USE_TEMPLATE_CACHE": useCache,
+		"DATABASE_URL":            connStr,
+		"PROD":                    prod,
+		"USE_TEMPLATE_CACHE":      useCache,
+		"BASE_URL":                baseURL,
+		"OIDC_ISSUER":             oidcIssuer,
+		"OIDC_CLIENT_ID":          oidcClientID,
+		"OIDC_CLIENT_SECRET":      oidcClientSecret,
+		"OIDC_GROUP_LEVELS":       oidcGroupLevels,
+		"BOOKING_HORIZON_DAYS":    bookingHorizon,
+		"ICAL_SECRET":             icalSecret,
+		"ICAL_SYNC_MINUTES":       icalSyncMinutes,
+		"SMS_PROVIDER":            smsProvider,
+		"SMS_API_URL":             smsAPIURL,
+		"SMS_API_USERNAME":        smsAPIUsername,
+		"SMS_API_PASSWORD":        smsAPIPassword,
+		"SMS_FROM":                smsFrom,
+		"SMS_COUNTRY_CODE":        smsCountryCode,
+		"OWNER_PHONE":             ownerPhone,
+		"PAYMENT_PROVIDER":        paymentProvider,
+		"PAYMENT_API_URL":         paymentAPIURL,
+		"PAYMENT_API_KEY":         paymentAPIKey,
+		"PAYMENT_WEBHOOK_SECRET":  paymentWebhookSecret,
+		"PAYMENT_DEPOSIT_PERCENT": depositPercent,
+		"CURRENCY":                currency,
+		"MAIL_BACKEND":            mailBackend,
+		"MAIL_DIR":                mailDir,
+		"SMTP_HOST":               smtpHost,
+		"SMTP_PORT":               smtpPort,
+		"SMTP_USERNAME":           smtpUsername,
+		"SMTP_PASSWORD":           smtpPassword,
+		"SMTP_ENCRYPTION":         smtpEncryption,
+		"SMTP_CONNECT_TIMEOUT":    smtpConnectTimeout,
+		"SMTP_SEND_TIMEOUT":       smtpSendTimeout,
+		"SMTP_KEEPALIVE":          smtpKeepAlive,
+		"PRE_ARRIVAL_EMAIL_DAYS":  preArrivalDays,
+		"POST_STAY_EMAIL_DAYS":    postStayDays,
+		"CHECK_IN_TIME":           checkInTime,
+		"CHECK_OUT_TIME":          checkOutTime,
+		"PROPERTY_ADDRESS":        propertyAddress,
+		"PROPERTY_NAME":           propertyName,
+		"PROPERTY_EMAIL":          propertyEmail,
+		"PROPERTY_PHONE":          propertyPhone,
+		"PROPERTY_TAX_ID":         propertyTaxID,
+		"DIGEST_TIME":             digestTime,
+		"REVIEW_URL":              reviewURL,
+		"TRUSTED_PROXIES":         trustedProxies,
 	}
 }
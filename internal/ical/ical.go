@@ -0,0 +1,164 @@
+// Package ical reads and writes iCalendar (RFC 5545) feeds, which booking
+// sites use to share availability with each other. It also writes the invites
+// (RFC 5546) guests get to add their stay to their calendar.
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProdID identifies the app as the maker of the feeds
+const ProdID = "-//Go B & B//Room Calendar//EN"
+
+// How a calendar is meant to be used
+const (
+	MethodPublish = "PUBLISH" // A feed to subscribe to
+	MethodRequest = "REQUEST" // An invite, or an update to one
+	MethodCancel  = "CANCEL"  // Takes an invite off the guest's calendar
+)
+
+// Calendar is a feed of events
+type Calendar struct {
+	Name   string
+	Method string // MethodPublish if empty
+	Events []Event
+}
+
+// Event is an all day event covering the nights from Start up to, but not
+// including, End. Timed events run from Start to End exactly.
+type Event struct {
+	UID      string // Stays the same for as long as the event exists, so updates replace it
+	Summary  string
+	Start    time.Time
+	End      time.Time
+	Timed    bool
+	Modified time.Time
+	Status   string // CONFIRMED if empty. CANCELLED events should be ignored
+
+	// Only written if set
+	Description string
+	Location    string
+	Sequence    int    // Goes up every time an invite is updated or cancelled
+	Organizer   string // Email addresses
+	Attendee    string
+
+	// RecurrenceID is set when an event changes one occurrence of a
+	// recurring event, which has the same UID
+	RecurrenceID string
+}
+
+// Write writes the calendar in iCalendar format
+func Write(w io.Writer, cal Calendar) error {
+	bw := bufio.NewWriter(w)
+
+	line := func(name, value string) {
+		writeLine(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", ProdID)
+	line("CALSCALE", "GREGORIAN")
+	method := cal.Method
+	if method == "" {
+		method = MethodPublish
+	}
+	line("METHOD", method)
+	if cal.Name != "" {
+		line("X-WR-CALNAME", escape(cal.Name))
+	}
+
+	for _, e := range cal.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", escape(e.UID))
+		line("DTSTAMP", formatTime(e.Modified))
+		line("LAST-MODIFIED", formatTime(e.Modified))
+		if e.Timed {
+			line("DTSTART", formatTime(e.Start))
+			line("DTEND", formatTime(e.End))
+		} else {
+			line("DTSTART;VALUE=DATE", formatDate(e.Start))
+			line("DTEND;VALUE=DATE", formatDate(e.End))
+		}
+		line("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", escape(e.Description))
+		}
+		if e.Location != "" {
+			line("LOCATION", escape(e.Location))
+		}
+		if e.Sequence > 0 {
+			line("SEQUENCE", strconv.Itoa(e.Sequence))
+		}
+		if e.Organizer != "" {
+			line("ORGANIZER", "mailto:"+e.Organizer)
+		}
+		if e.Attendee != "" {
+			line("ATTENDEE;RSVP=FALSE", "mailto:"+e.Attendee)
+		}
+		line("TRANSP", "OPAQUE")
+		status := e.Status
+		if status == "" {
+			status = "CONFIRMED"
+		}
+		line("STATUS", status)
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+
+	return bw.Flush()
+}
+
+// writeLine writes a content line, folding it so no line is longer than 75
+// octets. Lines are never split in the middle of a UTF-8 character.
+func writeLine(w *bufio.Writer, s string) {
+	limit := 75
+
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(s[cut]) {
+			cut--
+		}
+
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+
+		// Continuation lines start with a space, which counts towards the limit
+		limit = 74
+	}
+
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+// isRuneStart reports whether b is the first byte of a UTF-8 character
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// escape escapes a TEXT value
+func escape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// formatDate formats a DATE value
+func formatDate(t time.Time) string {
+	return t.Format("20060102")
+}
+
+// formatTime formats a DATE-TIME value in UTC
+func formatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
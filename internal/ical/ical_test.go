@@ -0,0 +1,141 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	modified := time.Date(2050, 1, 2, 15, 4, 5, 0, time.FixedZone("EST", -5*60*60))
+
+	cal := Calendar{
+		Name: "General's Quarters",
+		Events: []Event{
+			{
+				UID:      "room-restriction-1@example.com",
+				Summary:  "Reserved",
+				Start:    time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+				End:      time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC),
+				Modified: modified,
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+
+	err := Write(&buf, cal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+
+	if !strings.HasPrefix(out, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n") || !strings.HasSuffix(out, "END:VCALENDAR\r\n") {
+		t.Errorf("expected a calendar with CRLF line endings, got %q", out)
+	}
+
+	for _, expected := range []string{
+		"X-WR-CALNAME:General's Quarters\r\n",
+		"UID:room-restriction-1@example.com\r\n",
+		"DTSTAMP:20500102T200405Z\r\n",
+		"DTSTART;VALUE=DATE:20500110\r\n",
+		"DTEND;VALUE=DATE:20500112\r\n",
+		"SUMMARY:Reserved\r\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in %q", expected, out)
+		}
+	}
+}
+
+func TestWriteInvite(t *testing.T) {
+	cal := Calendar{
+		Method: MethodCancel,
+		Events: []Event{
+			{
+				UID:         "reservation-7@example.com",
+				Summary:     "Stay at the Go B & B",
+				Start:       time.Date(2050, 1, 10, 15, 0, 0, 0, time.UTC),
+				End:         time.Date(2050, 1, 12, 11, 0, 0, 0, time.UTC),
+				Timed:       true,
+				Status:      "CANCELLED",
+				Description: "Reservation 7",
+				Location:    "1 Main Street, Springfield",
+				Sequence:    2,
+				Organizer:   "me@here.com",
+				Attendee:    "john@smith.com",
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+
+	err := Write(&buf, cal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+
+	for _, expected := range []string{
+		"METHOD:CANCEL\r\n",
+		"DTSTART:20500110T150000Z\r\n",
+		"DTEND:20500112T110000Z\r\n",
+		"DESCRIPTION:Reservation 7\r\n",
+		"LOCATION:1 Main Street\\, Springfield\r\n",
+		"SEQUENCE:2\r\n",
+		"ORGANIZER:mailto:me@here.com\r\n",
+		"ATTENDEE;RSVP=FALSE:mailto:john@smith.com\r\n",
+		"STATUS:CANCELLED\r\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in %q", expected, out)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := map[string]string{
+		"Reserved":           "Reserved",
+		"Blocked; no, sorry": `Blocked\; no\, sorry`,
+		"a\\b":               `a\\b`,
+		"two\nlines":         `two\nlines`,
+	}
+
+	for in, expected := range tests {
+		if got := escape(in); got != expected {
+			t.Errorf("escape(%q): expected %q, got %q", in, expected, got)
+		}
+	}
+}
+
+func TestWriteLineFolding(t *testing.T) {
+	long := "SUMMARY:" + strings.Repeat("é", 100)
+
+	var buf bytes.Buffer
+
+	err := Write(&buf, Calendar{Name: long})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var unfolded strings.Builder
+
+	for i, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line %d is %d octets long", i, len(line))
+		}
+
+		if strings.HasPrefix(line, " ") {
+			unfolded.WriteString(line[1:])
+		} else {
+			unfolded.WriteString("\n" + line)
+		}
+	}
+
+	if !strings.Contains(unfolded.String(), "X-WR-CALNAME:"+long) {
+		t.Errorf("folded lines didn't unfold to the original: %q", unfolded.String())
+	}
+}
@@ -0,0 +1,173 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotICalendar is returned when a feed isn't in iCalendar format
+var ErrNotICalendar = errors.New("ical: not an iCalendar feed")
+
+// durationRE matches the day and week durations booking sites use
+var durationRE = regexp.MustCompile(`^P(?:(\d+)W)?(?:(\d+)D)?`)
+
+// Parse reads the events of an iCalendar feed. Only dates matter to a B & B,
+// so times are dropped and every event is treated as covering whole nights.
+// Recurring events are not expanded.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, ErrNotICalendar
+	}
+
+	var events []Event
+	var e *Event
+	var duration string
+
+	for n, l := range lines {
+		name, value := splitLine(l)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			e = &Event{}
+			duration = ""
+		case name == "END" && strings.EqualFold(value, "VEVENT") && e != nil:
+			if e.Start.IsZero() {
+				return nil, fmt.Errorf("ical: event ending on line %d has no DTSTART", n+1)
+			}
+
+			if e.End.IsZero() {
+				e.End = e.Start.Add(parseDuration(duration))
+			}
+
+			// Every event blocks at least one night
+			if !e.End.After(e.Start) {
+				e.End = e.Start.AddDate(0, 0, 1)
+			}
+
+			// RFC 5545 requires a UID, but not every feed has one
+			if e.UID == "" {
+				e.UID = formatDate(e.Start) + "-" + formatDate(e.End)
+			}
+
+			events = append(events, *e)
+			e = nil
+		case e == nil:
+			// Outside of an event
+		case name == "UID":
+			e.UID = value
+		case name == "SUMMARY":
+			e.Summary = unescape(value)
+		case name == "STATUS":
+			e.Status = strings.ToUpper(value)
+		case name == "RECURRENCE-ID":
+			e.RecurrenceID = value
+		case name == "DURATION":
+			duration = value
+		case name == "DTSTART", name == "DTEND":
+			d, err := parseDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("ical: line %d: %w", n+1, err)
+			}
+
+			if name == "DTSTART" {
+				e.Start = d
+			} else {
+				e.End = d
+			}
+		case name == "LAST-MODIFIED", name == "DTSTAMP" && e.Modified.IsZero():
+			if t, err := time.Parse("20060102T150405Z", value); err == nil {
+				e.Modified = t
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// unfold reads content lines, joining folded lines back together
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+
+	for scanner.Scan() {
+		l := strings.TrimRight(scanner.Text(), "\r")
+
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// splitLine splits a content line into its upper case name and its value.
+// Parameters are dropped. Their values can be quoted, and may contain colons.
+func splitLine(l string) (string, string) {
+	quoted := false
+
+	for i, c := range l {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted {
+				name, _, _ := strings.Cut(l[:i], ";")
+				return strings.ToUpper(name), l[i+1:]
+			}
+		}
+	}
+
+	return strings.ToUpper(l), ""
+}
+
+// parseDate reads the date of a DATE or DATE-TIME value. The time and time
+// zone are ignored.
+func parseDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+
+	d, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+
+	return d, nil
+}
+
+// parseDuration reads the days and weeks of a DURATION value, defaulting to
+// one day
+func parseDuration(value string) time.Duration {
+	m := durationRE.FindStringSubmatch(value)
+	if m == nil {
+		return 24 * time.Hour
+	}
+
+	weeks, _ := strconv.Atoi(m[1])
+	days, _ := strconv.Atoi(m[2])
+
+	return time.Duration(weeks*7+days) * 24 * time.Hour
+}
+
+// unescape reverses escape
+func unescape(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(s)
+}
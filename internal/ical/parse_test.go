@@ -0,0 +1,124 @@
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	feed := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Example//Channel//EN",
+		"BEGIN:VEVENT",
+		"UID:abc@example.com",
+		"DTSTART;VALUE=DATE:20500110",
+		"DTEND;VALUE=DATE:20500113",
+		"SUMMARY:Not available\\, sorry",
+		"LAST-MODIFIED:20500102T200405Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:long-uid-that-was-",
+		" folded@example.com",
+		"DTSTART;TZID=\"America/New_York:x\":20500201T150000",
+		"DURATION:P1W",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART:20500301",
+		"STATUS:cancelled",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:same-day@example.com",
+		"DTSTART:20500401",
+		"DTEND:20500401",
+		"RECURRENCE-ID:20500401",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	events, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := func(month, day int) time.Time {
+		return time.Date(2050, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	}
+
+	expected := []Event{
+		{
+			UID:      "abc@example.com",
+			Summary:  "Not available, sorry",
+			Start:    date(1, 10),
+			End:      date(1, 13),
+			Modified: time.Date(2050, 1, 2, 20, 4, 5, 0, time.UTC),
+		},
+		{UID: "long-uid-that-was-folded@example.com", Start: date(2, 1), End: date(2, 8)},
+		{UID: "20500301-20500302", Start: date(3, 1), End: date(3, 2), Status: "CANCELLED"},
+		{UID: "same-day@example.com", Start: date(4, 1), End: date(4, 2), RecurrenceID: "20500401"},
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+
+	for i, e := range expected {
+		if events[i] != e {
+			t.Errorf("event %d: expected %+v, got %+v", i, e, events[i])
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		feed string
+	}{
+		{"not a calendar", "<html></html>"},
+		{"empty", ""},
+		{"no start", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:x\nEND:VEVENT\nEND:VCALENDAR"},
+		{"bad date", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:2050-01-10\nEND:VEVENT\nEND:VCALENDAR"},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(strings.NewReader(test.feed))
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	_, err := Parse(strings.NewReader("<html></html>"))
+	if !errors.Is(err, ErrNotICalendar) {
+		t.Errorf("expected ErrNotICalendar, got %v", err)
+	}
+}
+
+func TestParseWrittenFeed(t *testing.T) {
+	cal := Calendar{Events: []Event{{
+		UID:      "room-restriction-1@example.com",
+		Summary:  "Blocked; no, sorry",
+		Start:    time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+		End:      time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC),
+		Modified: time.Date(2050, 1, 2, 20, 4, 5, 0, time.UTC),
+		Status:   "CONFIRMED",
+	}}}
+
+	var buf bytes.Buffer
+
+	err := Write(&buf, cal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || events[0] != cal.Events[0] {
+		t.Errorf("expected %+v, got %+v", cal.Events, events)
+	}
+}
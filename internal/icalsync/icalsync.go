@@ -0,0 +1,232 @@
+// Package icalsync imports other booking sites' iCalendar feeds as external
+// blocks, so rooms booked elsewhere can't be booked here too.
+package icalsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/ical"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// maxFeedSize is the most of a feed that is read. Feeds are small, so anything
+// bigger is a mistake.
+const maxFeedSize = 5 << 20
+
+// Store is what the Syncer needs from the database
+type Store interface {
+	AllICalFeeds() ([]models.ICalFeed, error)
+	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	SyncExternalBlocks(feed models.ICalFeed, blocks []models.RoomRestriction) (created, updated, removed int, err error)
+	UpdateICalFeedStatus(f models.ICalFeed) error
+}
+
+// Result is what a sync of one feed changed
+type Result struct {
+	Created   int
+	Updated   int
+	Removed   int
+	Conflicts []string // Imported events that overlap reservations
+}
+
+// Syncer fetches calendar feeds and keeps their rooms' external blocks up to date
+type Syncer struct {
+	Store    Store
+	Client   *http.Client
+	ErrorLog *log.Logger
+}
+
+// New creates a Syncer with a client that gives up on slow feeds
+func New(store Store, errorLog *log.Logger) *Syncer {
+	return &Syncer{
+		Store:    store,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		ErrorLog: errorLog,
+	}
+}
+
+// Run syncs every feed straight away, and then every interval until ctx is done
+func (s *Syncer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.SyncAll(ctx)
+		if err != nil {
+			s.ErrorLog.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll syncs every feed. A feed that fails doesn't stop the others from
+// syncing. Its error is logged and recorded against the feed.
+func (s *Syncer) SyncAll(ctx context.Context) error {
+	feeds, err := s.Store.AllICalFeeds()
+	if err != nil {
+		return err
+	}
+
+	for _, feed := range feeds {
+		_, err := s.SyncFeed(ctx, feed)
+		if err != nil {
+			s.ErrorLog.Printf("syncing calendar feed %d (%s): %v", feed.ID, feed.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// SyncFeed fetches a feed and makes its room's external blocks match the
+// feed's upcoming events. If the feed can't be fetched or read, the blocks
+// from the last sync are kept, since the room is more likely still booked
+// than not.
+func (s *Syncer) SyncFeed(ctx context.Context, feed models.ICalFeed) (Result, error) {
+	var result Result
+
+	events, err := s.fetch(ctx, feed.URL)
+	if err == nil {
+		result, err = s.sync(feed, events)
+	}
+
+	if err != nil {
+		feed.LastError = err.Error()
+	} else {
+		feed.LastSyncedAt = time.Now()
+		feed.LastError = ""
+		feed.Conflicts = strings.Join(result.Conflicts, "\n")
+	}
+
+	statusErr := s.Store.UpdateICalFeedStatus(feed)
+
+	return result, errors.Join(err, statusErr)
+}
+
+// fetch downloads and parses a feed
+func (s *Syncer) fetch(ctx context.Context, url string) ([]ical.Event, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "text/calendar")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned %s", resp.Status)
+	}
+
+	return ical.Parse(io.LimitReader(resp.Body, maxFeedSize))
+}
+
+// sync turns a feed's events into blocks, saves them, and finds the ones that
+// overlap reservations
+func (s *Syncer) sync(feed models.ICalFeed, events []ical.Event) (Result, error) {
+	var result Result
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	// Events that changed one occurrence of a recurring event share its
+	// UID, so the recurrence ID is needed to tell them apart
+	byUID := map[string]models.RoomRestriction{}
+	var uids []string
+
+	for _, e := range events {
+		if e.Status == "CANCELLED" || !e.End.After(today) {
+			continue
+		}
+
+		uid := e.UID
+		if e.RecurrenceID != "" {
+			uid += "/" + e.RecurrenceID
+		}
+
+		if _, ok := byUID[uid]; !ok {
+			uids = append(uids, uid)
+		}
+
+		byUID[uid] = models.RoomRestriction{
+			StartDate:      e.Start,
+			EndDate:        e.End,
+			RoomID:         feed.RoomID,
+			RestrictionID:  models.RestrictionExternal,
+			ExternalFeedID: feed.ID,
+			ExternalUID:    uid,
+		}
+	}
+
+	blocks := make([]models.RoomRestriction, 0, len(uids))
+	for _, uid := range uids {
+		blocks = append(blocks, byUID[uid])
+	}
+
+	var err error
+
+	result.Created, result.Updated, result.Removed, err = s.Store.SyncExternalBlocks(feed, blocks)
+	if err != nil {
+		return result, err
+	}
+
+	result.Conflicts, err = s.conflicts(feed.RoomID, blocks)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// conflicts describes the blocks that overlap the room's reservations. They
+// were probably double booked, which staff need to sort out by hand.
+func (s *Syncer) conflicts(roomID int, blocks []models.RoomRestriction) ([]string, error) {
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+
+	start, end := blocks[0].StartDate, blocks[0].EndDate
+	for _, b := range blocks {
+		if b.StartDate.Before(start) {
+			start = b.StartDate
+		}
+		if b.EndDate.After(end) {
+			end = b.EndDate
+		}
+	}
+
+	restrictions, err := s.Store.GetRestrictionsForRoomByDate(roomID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := "2006-01-02"
+
+	var conflicts []string
+
+	for _, b := range blocks {
+		for _, rr := range restrictions {
+			if rr.ReservationID > 0 && b.StartDate.Before(rr.EndDate) && rr.StartDate.Before(b.EndDate) {
+				conflicts = append(conflicts, fmt.Sprintf("%s to %s overlaps reservation %d",
+					b.StartDate.Format(layout), b.EndDate.Format(layout), rr.ReservationID))
+			}
+		}
+	}
+
+	return conflicts, nil
+}
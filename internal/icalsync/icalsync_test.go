@@ -0,0 +1,220 @@
+package icalsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// memoryStore keeps one feed's blocks in memory, alongside a reservation
+type memoryStore struct {
+	feed        models.ICalFeed
+	blocks      map[string]models.RoomRestriction
+	reservation models.RoomRestriction
+}
+
+func (s *memoryStore) AllICalFeeds() ([]models.ICalFeed, error) {
+	return []models.ICalFeed{s.feed}, nil
+}
+
+func (s *memoryStore) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+
+	for _, rr := range append([]models.RoomRestriction{s.reservation}, s.allBlocks()...) {
+		if rr.RoomID == roomID && start.Before(rr.EndDate) && !end.Before(rr.StartDate) {
+			restrictions = append(restrictions, rr)
+		}
+	}
+
+	return restrictions, nil
+}
+
+func (s *memoryStore) SyncExternalBlocks(feed models.ICalFeed, blocks []models.RoomRestriction) (created, updated, removed int, err error) {
+	seen := map[string]bool{}
+
+	for _, b := range blocks {
+		seen[b.ExternalUID] = true
+
+		old, ok := s.blocks[b.ExternalUID]
+		switch {
+		case !ok:
+			created++
+		case !old.StartDate.Equal(b.StartDate) || !old.EndDate.Equal(b.EndDate):
+			updated++
+		}
+
+		s.blocks[b.ExternalUID] = b
+	}
+
+	for uid := range s.blocks {
+		if !seen[uid] {
+			delete(s.blocks, uid)
+			removed++
+		}
+	}
+
+	return created, updated, removed, nil
+}
+
+func (s *memoryStore) UpdateICalFeedStatus(f models.ICalFeed) error {
+	s.feed = f
+	return nil
+}
+
+func (s *memoryStore) allBlocks() []models.RoomRestriction {
+	var blocks []models.RoomRestriction
+	for _, b := range s.blocks {
+		blocks = append(blocks, b)
+	}
+
+	return blocks
+}
+
+// feed builds an iCalendar feed from VEVENT bodies
+func feed(events ...string) string {
+	var b strings.Builder
+
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n")
+	for _, e := range events {
+		b.WriteString("BEGIN:VEVENT\r\n" + e + "END:VEVENT\r\n")
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+
+	return b.String()
+}
+
+// event builds a VEVENT body
+func event(uid string, start, end time.Time, extra ...string) string {
+	return fmt.Sprintf("UID:%s\r\nDTSTART;VALUE=DATE:%s\r\nDTEND;VALUE=DATE:%s\r\n%s",
+		uid, start.Format("20060102"), end.Format("20060102"), strings.Join(extra, ""))
+}
+
+func TestSyncFeed(t *testing.T) {
+	now := time.Now()
+	day := func(n int) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day()+n, 0, 0, 0, 0, time.UTC)
+	}
+
+	// The stub serves whatever the test sets body and status to
+	body := ""
+	status := http.StatusOK
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	store := &memoryStore{
+		feed:        models.ICalFeed{ID: 1, RoomID: 1, URL: srv.URL},
+		blocks:      map[string]models.RoomRestriction{},
+		reservation: models.RoomRestriction{ID: 9, RoomID: 1, ReservationID: 7, StartDate: day(20), EndDate: day(23)},
+	}
+
+	syncer := New(store, log.New(io.Discard, "", 0))
+
+	// First sync creates a block for each upcoming, confirmed event
+	body = feed(
+		event("a", day(5), day(8)),
+		event("b", day(10), day(11)),
+		event("past", day(-10), day(-8)),
+		event("cancelled", day(12), day(14), "STATUS:CANCELLED\r\n"),
+		event("clash", day(22), day(25)),
+	)
+
+	result, err := syncer.SyncFeed(context.Background(), store.feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Created != 3 || result.Updated != 0 || result.Removed != 0 {
+		t.Errorf("first sync: expected 3 created, got %+v", result)
+	}
+
+	if b := store.blocks["a"]; !b.StartDate.Equal(day(5)) || !b.EndDate.Equal(day(8)) || b.RestrictionID != models.RestrictionExternal || b.ExternalFeedID != 1 {
+		t.Errorf("unexpected block for event a: %+v", b)
+	}
+
+	expectedConflict := fmt.Sprintf("%s to %s overlaps reservation 7", day(22).Format("2006-01-02"), day(25).Format("2006-01-02"))
+	if len(result.Conflicts) != 1 || result.Conflicts[0] != expectedConflict || store.feed.Conflicts != expectedConflict {
+		t.Errorf("expected conflict %q, got %v and %q", expectedConflict, result.Conflicts, store.feed.Conflicts)
+	}
+
+	if store.feed.LastSyncedAt.IsZero() || store.feed.LastError != "" {
+		t.Errorf("expected a successful sync to be recorded, got %+v", store.feed)
+	}
+
+	// Second sync moves one event, drops one, and changes one occurrence of
+	// a recurring event
+	body = feed(
+		event("a", day(6), day(8)),
+		event("clash", day(22), day(25)),
+		event("clash", day(30), day(31), "RECURRENCE-ID;VALUE=DATE:"+day(30).Format("20060102")+"\r\n"),
+	)
+
+	result, err = syncer.SyncFeed(context.Background(), store.feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Created != 1 || result.Updated != 1 || result.Removed != 1 {
+		t.Errorf("second sync: expected 1 created, 1 updated and 1 removed, got %+v", result)
+	}
+
+	if _, ok := store.blocks["b"]; ok {
+		t.Error("expected the block for event b to be removed")
+	}
+
+	// A failed fetch keeps the blocks and records the error
+	lastSynced := store.feed.LastSyncedAt
+	status = http.StatusInternalServerError
+
+	_, err = syncer.SyncFeed(context.Background(), store.feed)
+	if err == nil {
+		t.Fatal("expected an error when the feed can't be fetched")
+	}
+
+	if len(store.blocks) != 3 {
+		t.Errorf("expected the 3 blocks to be kept, got %d", len(store.blocks))
+	}
+
+	if !strings.Contains(store.feed.LastError, "500") || !store.feed.LastSyncedAt.Equal(lastSynced) {
+		t.Errorf("expected the failure to be recorded, got %+v", store.feed)
+	}
+
+	// So does a feed that isn't a calendar
+	status = http.StatusOK
+	body = "<html></html>"
+
+	_, err = syncer.SyncFeed(context.Background(), store.feed)
+	if err == nil || len(store.blocks) != 3 {
+		t.Errorf("expected an error and the blocks to be kept, got %v and %d blocks", err, len(store.blocks))
+	}
+}
+
+func TestSyncAll(t *testing.T) {
+	store := &memoryStore{
+		feed:   models.ICalFeed{ID: 1, RoomID: 1, URL: "http://127.0.0.1:1/feed.ics"},
+		blocks: map[string]models.RoomRestriction{},
+	}
+
+	syncer := New(store, log.New(io.Discard, "", 0))
+
+	// A failing feed is recorded, not returned
+	err := syncer.SyncAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store.feed.LastError == "" {
+		t.Error("expected the feed's error to be recorded")
+	}
+}
@@ -0,0 +1,132 @@
+// Package invoices builds a reservation's folio, the account of what the stay
+// costs and what's been paid towards it, and prints it as an invoice guests
+// can claim as a business expense.
+package invoices
+
+import (
+	"fmt"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// Property is who invoices are from. Empty details are left off.
+type Property struct {
+	Name    string
+	Address string
+	Email   string
+	Phone   string
+	TaxID   string
+}
+
+// Line is a charge on the folio
+type Line struct {
+	Description string
+	Quantity    int
+	UnitPrice   int // In cents
+	Amount      int // In cents
+}
+
+// Folio is a reservation's charges and the payments made towards them
+type Folio struct {
+	Invoice     models.Invoice
+	Property    Property
+	Reservation models.Reservation
+	Currency    string
+	Lines       []Line
+	Payments    []models.Payment // Only ones that were paid, oldest first
+}
+
+// New builds the folio for a reservation from what it cost when it was
+// booked, item by item, and the payments recorded against it. Nothing more is
+// owed for a cancelled stay, so what hasn't been paid is credited back. What
+// has been paid stays on the folio, as refunds are made with the payment
+// provider.
+func New(inv models.Invoice, res models.Reservation, payments []models.Payment, property Property, currency string) Folio {
+	f := Folio{
+		Invoice:     inv,
+		Property:    property,
+		Reservation: res,
+		Currency:    currency,
+	}
+
+	stay := fmt.Sprintf("%s, %s to %s", res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"))
+
+	for _, item := range res.Items {
+		description := item.Description
+		if item.Kind == models.LineItemRoom {
+			description = stay
+		}
+
+		f.Lines = append(f.Lines, Line{
+			Description: description,
+			Quantity:    item.Quantity,
+			UnitPrice:   item.UnitPrice,
+			Amount:      item.Amount,
+		})
+	}
+
+	// Stays booked before they were itemized are charged by the night, at
+	// the price when they were booked
+	if len(res.Items) == 0 {
+		nights := res.Nights()
+		unitPrice := res.Total
+		if nights > 0 {
+			unitPrice = res.Total / nights
+		}
+
+		f.Lines = append(f.Lines, Line{
+			Description: stay,
+			Quantity:    nights,
+			UnitPrice:   unitPrice,
+			Amount:      res.Total,
+		})
+	}
+
+	for _, p := range payments {
+		if p.Status == models.PaymentPaid {
+			f.Payments = append(f.Payments, p)
+		}
+	}
+
+	if owed := f.Balance(); res.Cancelled() && owed > 0 {
+		f.Lines = append(f.Lines, Line{
+			Description: "Cancellation, " + res.CancelledAt.Format("2006-01-02"),
+			Quantity:    1,
+			UnitPrice:   -owed,
+			Amount:      -owed,
+		})
+	}
+
+	return f
+}
+
+// Total is what the stay costs, in cents
+func (f Folio) Total() int {
+	var total int
+	for _, l := range f.Lines {
+		total += l.Amount
+	}
+
+	return total
+}
+
+// Paid is how much has been paid, in cents
+func (f Folio) Paid() int {
+	var paid int
+	for _, p := range f.Payments {
+		paid += p.Amount
+	}
+
+	return paid
+}
+
+// Balance is how much is left to pay, in cents. It's negative if the guest
+// paid too much.
+func (f Folio) Balance() int {
+	return f.Total() - f.Paid()
+}
+
+// Filename is what the invoice is saved as when it's downloaded
+func (f Folio) Filename() string {
+	return f.Invoice.Code() + ".pdf"
+}
@@ -0,0 +1,143 @@
+package invoices
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// testFolio is a 3 night stay with a deposit paid, and a payment that failed
+func testFolio() Folio {
+	res := models.Reservation{
+		ID:        7,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		Total:     36000,
+	}
+
+	payments := []models.Payment{
+		{ID: 1, Kind: models.PaymentDeposit, Amount: 9000, Status: models.PaymentPaid, ProviderRef: "cs_1", PaidAt: time.Date(2049, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 2, Kind: models.PaymentFull, Amount: 27000, Status: models.PaymentFailed, ProviderRef: "cs_2"},
+	}
+
+	inv := models.Invoice{ID: 1, ReservationID: 7, Number: 42, IssuedAt: time.Date(2049, 12, 2, 0, 0, 0, 0, time.UTC)}
+	property := Property{Name: "Go B & B", Address: "1 Fort Road, Smythe", TaxID: "GB123456789"}
+
+	return New(inv, res, payments, property, "USD")
+}
+
+func TestNew(t *testing.T) {
+	f := testFolio()
+
+	expected := []Line{{Description: "General's Quarters, 2050-01-01 to 2050-01-04", Quantity: 3, UnitPrice: 12000, Amount: 36000}}
+	if len(f.Lines) != 1 || f.Lines[0] != expected[0] {
+		t.Errorf("expected lines %+v, got %+v", expected, f.Lines)
+	}
+
+	if len(f.Payments) != 1 || f.Payments[0].ID != 1 {
+		t.Errorf("expected only the paid payment, got %+v", f.Payments)
+	}
+
+	if f.Total() != 36000 || f.Paid() != 9000 || f.Balance() != 27000 {
+		t.Errorf("expected 36000 less 9000 paid, got %d less %d paid, leaving %d", f.Total(), f.Paid(), f.Balance())
+	}
+
+	if f.Filename() != "INV-000042.pdf" {
+		t.Errorf("unexpected filename %s", f.Filename())
+	}
+}
+
+func TestNewItemized(t *testing.T) {
+	f := testFolio()
+	f.Reservation.Items = []models.LineItem{
+		{Kind: models.LineItemRoom, Description: "General's Quarters", Quantity: 3, UnitPrice: 10000, Amount: 30000},
+		{Kind: models.ChargeFee, Description: "Cleaning", Quantity: 1, UnitPrice: 3000, Amount: 3000},
+		{Kind: models.ChargeTax, Description: "City tax (per guest per night)", Quantity: 6, UnitPrice: 500, Amount: 3000},
+	}
+
+	f = New(f.Invoice, f.Reservation, f.Payments, f.Property, f.Currency)
+
+	expected := []Line{
+		{Description: "General's Quarters, 2050-01-01 to 2050-01-04", Quantity: 3, UnitPrice: 10000, Amount: 30000},
+		{Description: "Cleaning", Quantity: 1, UnitPrice: 3000, Amount: 3000},
+		{Description: "City tax (per guest per night)", Quantity: 6, UnitPrice: 500, Amount: 3000},
+	}
+	if !slices.Equal(f.Lines, expected) {
+		t.Errorf("expected lines %+v, got %+v", expected, f.Lines)
+	}
+
+	if f.Total() != 36000 {
+		t.Errorf("expected the items to add up to 36000, got %d", f.Total())
+	}
+}
+
+func TestNewCancelled(t *testing.T) {
+	f := testFolio()
+	f.Reservation.CancelledAt = time.Date(2049, 12, 20, 0, 0, 0, 0, time.UTC)
+
+	f = New(f.Invoice, f.Reservation, []models.Payment{f.Payments[0]}, f.Property, f.Currency)
+
+	expected := Line{Description: "Cancellation, 2049-12-20", Quantity: 1, UnitPrice: -27000, Amount: -27000}
+	if len(f.Lines) != 2 || f.Lines[1] != expected {
+		t.Errorf("expected the unpaid charges to be credited with %+v, got %+v", expected, f.Lines)
+	}
+
+	if f.Total() != 9000 || f.Paid() != 9000 || f.Balance() != 0 {
+		t.Errorf("expected nothing left to pay, got %d less %d paid, leaving %d", f.Total(), f.Paid(), f.Balance())
+	}
+
+	// Nothing is credited if it was all paid
+	f = New(f.Invoice, f.Reservation, []models.Payment{{Amount: 36000, Status: models.PaymentPaid}}, f.Property, f.Currency)
+
+	if len(f.Lines) != 1 || f.Balance() != 0 {
+		t.Errorf("expected only the stay with nothing left to pay, got %+v leaving %d", f.Lines, f.Balance())
+	}
+}
+
+func TestPDF(t *testing.T) {
+	out := testFolio().PDF()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-")) {
+		t.Fatal("expected a PDF")
+	}
+
+	for _, expected := range []string{
+		"(INVOICE)",
+		"(Invoice INV-000042)",
+		"(Issued December 2, 2049)",
+		"(Tax ID GB123456789)",
+		"(John Smith)",
+		"(General's Quarters, 3 nights)",
+		"($120.00)",
+		"(Deposit received 2049-12-01, ref. cs_1)",
+		"(Balance due)",
+		"($270.00)",
+	} {
+		if !bytes.Contains(out, []byte(expected)) {
+			t.Errorf("expected the invoice to contain %s", expected)
+		}
+	}
+
+	if bytes.Contains(out, []byte("cs_2")) {
+		t.Error("expected the failed payment to be left off")
+	}
+}
+
+func TestFit(t *testing.T) {
+	if got := fit(0, "Short", 100); got != "Short" {
+		t.Errorf("expected short text to be left alone, got %q", got)
+	}
+
+	got := fit(0, strings.Repeat("Long ", 40), 100)
+	if !strings.HasSuffix(got, "...") || len(got) >= 200 {
+		t.Errorf("expected long text to be cut short, got %q", got)
+	}
+}
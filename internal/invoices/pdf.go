@@ -0,0 +1,187 @@
+package invoices
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/pdf"
+	"github.com/BlackSound1/Go-B-and-B/internal/pricing"
+)
+
+// The invoice's layout, in points from the top left of a letter page
+const (
+	margin     = 54
+	right      = pdf.LetterWidth - margin
+	lineHeight = 14
+	textSize   = 10
+
+	// Where the table columns end, as numbers are right aligned
+	quantityColumn  = 380
+	unitPriceColumn = 470
+)
+
+// invoice lays out a folio on the pages of a PDF, starting a new page when
+// one fills up
+type invoice struct {
+	doc *pdf.Document
+	y   float64
+}
+
+// PDF prints the folio as an invoice
+func (f Folio) PDF() []byte {
+	doc := pdf.New(pdf.LetterWidth, pdf.LetterHeight)
+	doc.Title = fmt.Sprintf("%s invoice %s", f.Property.Name, f.Invoice.Code())
+
+	inv := &invoice{doc: doc, y: margin + 18}
+	res := f.Reservation
+
+	// Who it's from on the left, and which invoice it is on the right
+	doc.Text(margin, inv.y, pdf.HelveticaBold, 18, f.Property.Name)
+	doc.TextRight(right, inv.y, pdf.HelveticaBold, 18, "INVOICE")
+
+	from := inv.y
+	for _, detail := range []string{f.Property.Address, f.Property.Email, f.Property.Phone, taxID(f.Property.TaxID)} {
+		if detail != "" {
+			from += lineHeight
+			doc.Text(margin, from, pdf.Helvetica, textSize, detail)
+		}
+	}
+
+	for _, detail := range []string{
+		"Invoice " + f.Invoice.Code(),
+		"Issued " + f.Invoice.IssuedAt.Format("January 2, 2006"),
+		fmt.Sprintf("Reservation #%d", res.ID),
+	} {
+		inv.y += lineHeight
+		doc.TextRight(right, inv.y, pdf.Helvetica, textSize, detail)
+	}
+
+	inv.y = max(inv.y, from) + 2*lineHeight
+
+	// Who it's for, and the stay
+	inv.heading("Bill to")
+	for _, detail := range []string{res.FirstName + " " + res.LastName, res.Email, res.Phone} {
+		if detail != "" {
+			inv.text(detail)
+		}
+	}
+
+	inv.y += lineHeight
+	inv.heading("Stay")
+	inv.text(fmt.Sprintf("%s, %d nights", res.Room.RoomName, res.Nights()))
+	inv.text(fmt.Sprintf("Arrival %s, departure %s", res.StartDate.Format("January 2, 2006"), res.EndDate.Format("January 2, 2006")))
+	if res.Cancelled() {
+		inv.text("Cancelled " + res.CancelledAt.Format("January 2, 2006"))
+	}
+
+	// The charges
+	inv.y += lineHeight
+	inv.row(pdf.HelveticaBold, "Description", "Qty", "Unit price", "Amount")
+	inv.rule()
+
+	for _, l := range f.Lines {
+		inv.row(pdf.Helvetica, l.Description, strconv.Itoa(l.Quantity), f.money(l.UnitPrice), f.money(l.Amount))
+	}
+
+	inv.rule()
+	inv.row(pdf.HelveticaBold, "Total", "", "", f.money(f.Total()))
+
+	// The payments
+	inv.y += lineHeight
+	inv.row(pdf.HelveticaBold, "Payments", "", "", "")
+	inv.rule()
+
+	for _, p := range f.Payments {
+		inv.row(pdf.Helvetica, paymentDescription(p), "", "", f.money(p.Amount))
+	}
+
+	if len(f.Payments) == 0 {
+		inv.row(pdf.Helvetica, "No payments received", "", "", "")
+	}
+
+	inv.rule()
+	inv.row(pdf.Helvetica, "Paid", "", "", f.money(f.Paid()))
+	inv.row(pdf.HelveticaBold, "Balance due", "", "", f.money(f.Balance()))
+
+	inv.y += 2 * lineHeight
+	inv.text("Thank you for staying with us.")
+
+	return doc.Bytes()
+}
+
+// money formats an amount in the folio's currency
+func (f Folio) money(cents int) string {
+	return pricing.FormatMoney(cents, f.Currency)
+}
+
+// next moves down a line, onto a new page if this one is full
+func (inv *invoice) next() {
+	inv.y += lineHeight
+
+	if inv.y > inv.doc.Height()-margin {
+		inv.doc.AddPage()
+		inv.y = margin + lineHeight
+	}
+}
+
+// text writes a line of text
+func (inv *invoice) text(s string) {
+	inv.next()
+	inv.doc.Text(margin, inv.y, pdf.Helvetica, textSize, s)
+}
+
+// heading writes a line of bold text
+func (inv *invoice) heading(s string) {
+	inv.next()
+	inv.doc.Text(margin, inv.y, pdf.HelveticaBold, textSize, s)
+}
+
+// row writes a row of the table, cutting the description short if it would
+// run into the numbers
+func (inv *invoice) row(font pdf.Font, description, quantity, unitPrice, amount string) {
+	inv.next()
+
+	inv.doc.Text(margin, inv.y, font, textSize, fit(font, description, quantityColumn-60-margin))
+	inv.doc.TextRight(quantityColumn, inv.y, font, textSize, quantity)
+	inv.doc.TextRight(unitPriceColumn, inv.y, font, textSize, unitPrice)
+	inv.doc.TextRight(right, inv.y, font, textSize, amount)
+}
+
+// rule draws a line under the row above
+func (inv *invoice) rule() {
+	inv.doc.Line(margin, inv.y+4, right, inv.y+4, 0.5)
+}
+
+// fit cuts s short, with an ellipsis, so it's no wider than width
+func fit(font pdf.Font, s string, width float64) string {
+	if pdf.TextWidth(font, textSize, s) <= width {
+		return s
+	}
+
+	runes := []rune(s)
+	for len(runes) > 0 && pdf.TextWidth(font, textSize, string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+
+	return string(runes) + "..."
+}
+
+// taxID labels the property's tax number
+func taxID(id string) string {
+	if id == "" {
+		return ""
+	}
+
+	return "Tax ID " + id
+}
+
+// paymentDescription describes a payment on the invoice
+func paymentDescription(p models.Payment) string {
+	kind := "Payment"
+	if p.Kind == models.PaymentDeposit {
+		kind = "Deposit"
+	}
+
+	return fmt.Sprintf("%s received %s, ref. %s", kind, p.PaidAt.Format("2006-01-02"), p.ProviderRef)
+}
@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// File drops emails into a maildir instead of sending them, so they can be
+// read with a mail client during local development. Each email is written to
+// tmp and then moved to new, so readers never see half an email.
+type File struct {
+	Dir string
+}
+
+// count keeps the names of emails written in the same nanosecond apart
+var count atomic.Int64
+
+// NewFile creates a File mailer, and the maildir if it doesn't exist
+func NewFile(dir string) (*File, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0o755)
+		if err != nil {
+			return nil, fmt.Errorf("mailer: creating maildir: %w", err)
+		}
+	}
+
+	return &File{Dir: dir}, nil
+}
+
+// Send writes an email into the maildir
+func (f *File) Send(m models.MailData) error {
+	msg, err := Message(m)
+	if err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s.eml", time.Now().UnixNano(), os.Getpid(), count.Add(1), host)
+
+	tmp := filepath.Join(f.Dir, "tmp", name)
+
+	err = os.WriteFile(tmp, msg, 0o644)
+	if err != nil {
+		return fmt.Errorf("mailer: writing email: %w", err)
+	}
+
+	err = os.Rename(tmp, filepath.Join(f.Dir, "new", name))
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("mailer: writing email: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Package mailer sends email. The Mailer the app uses is picked in the config:
+// SMTP in production, a maildir on disk for local development, or memory for
+// tests.
+package mailer
+
+import (
+	"encoding/base64"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	mail "github.com/xhit/go-simple-mail"
+)
+
+// Mailer sends one email
+type Mailer interface {
+	Send(msg models.MailData) error
+}
+
+// Message formats an email (RFC 5322), with the plain text body and the HTML
+// one as an alternative
+func Message(m models.MailData) ([]byte, error) {
+	email := newEmail(m)
+	if err := email.GetError(); err != nil {
+		return nil, err
+	}
+
+	return []byte(email.GetMessage()), nil
+}
+
+// newEmail builds an email, which holds the first error found building it
+func newEmail(m models.MailData) *mail.Email {
+	email := mail.NewMSG()
+	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
+	email.SetBody(mail.TextPlain, m.Text)
+	email.AddAlternative(mail.TextHTML, m.HTML)
+
+	for _, a := range m.Attachments {
+		email.AddAttachmentBase64(base64.StdEncoding.EncodeToString(a.Data), a.Name)
+	}
+
+	return email
+}
+
+// SMTPMailer sends email through an SMTP server
+type SMTPMailer struct {
+	Server *SMTP
+}
+
+// NewSMTPMailer creates a Mailer that sends through the SMTP server in cfg
+func NewSMTPMailer(cfg SMTPConfig) (*SMTPMailer, error) {
+	server, err := NewSMTP(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMTPMailer{Server: server}, nil
+}
+
+// Send sends an email through the SMTP server
+func (s *SMTPMailer) Send(m models.MailData) error {
+	email := newEmail(m)
+	if err := email.GetError(); err != nil {
+		return err
+	}
+
+	return s.Server.Send(email)
+}
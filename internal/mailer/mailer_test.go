@@ -0,0 +1,93 @@
+package mailer
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+var testMail = models.MailData{
+	To:      "john@smith.com",
+	From:    "me@here.com",
+	Subject: "Reservation Confirmation",
+	HTML:    "<p>Booked</p>",
+	Text:    "Booked",
+}
+
+func TestMessage(t *testing.T) {
+	msg, err := Message(testMail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"To: <john@smith.com>", "Subject: Reservation Confirmation", "text/plain", "text/html", "<p>Booked</p>"} {
+		if !strings.Contains(string(msg), expected) {
+			t.Errorf("expected %q in the message, got %q", expected, msg)
+		}
+	}
+}
+
+func TestMessageAttachments(t *testing.T) {
+	m := testMail
+	m.Attachments = []models.Attachment{{Name: "invite.ics", Data: []byte("BEGIN:VCALENDAR")}}
+
+	msg, err := Message(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{`filename="invite.ics"`, base64.StdEncoding.EncodeToString([]byte("BEGIN:VCALENDAR"))} {
+		if !strings.Contains(string(msg), expected) {
+			t.Errorf("expected %q in the message, got %q", expected, msg)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+
+	f, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if err := f.Send(testMail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	emails, _ := os.ReadDir(filepath.Join(dir, "new"))
+	if len(emails) != 2 {
+		t.Fatalf("expected 2 emails in new, got %d", len(emails))
+	}
+
+	if left, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(left) != 0 {
+		t.Errorf("expected tmp to be empty, got %d files", len(left))
+	}
+
+	msg, _ := os.ReadFile(filepath.Join(dir, "new", emails[0].Name()))
+	if !strings.Contains(string(msg), "Subject: Reservation Confirmation") {
+		t.Errorf("expected the whole message in the file, got %q", msg)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+
+	m.Send(testMail)
+
+	if sent := m.Sent(); len(sent) != 1 || sent[0].Subject != testMail.Subject {
+		t.Errorf("expected the email to be recorded, got %v", sent)
+	}
+
+	m.Reset()
+
+	if sent := m.Sent(); len(sent) != 0 {
+		t.Errorf("expected nothing after a reset, got %v", sent)
+	}
+}
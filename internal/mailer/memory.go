@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"sync"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// Memory keeps the emails it's given instead of sending them, so tests can
+// check what would have been sent
+type Memory struct {
+	mu   sync.Mutex
+	sent []models.MailData
+}
+
+// NewMemory creates a Memory mailer
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Send records an email
+func (m *Memory) Send(msg models.MailData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, msg)
+
+	return nil
+}
+
+// Sent returns the emails sent so far, oldest first
+func (m *Memory) Sent() []models.MailData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]models.MailData(nil), m.sent...)
+}
+
+// Reset forgets the emails sent so far
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = nil
+}
@@ -0,0 +1,220 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail"
+)
+
+// Encryption is how the connection to the SMTP server is secured. With
+// STARTTLS, the connection is only upgraded if the server offers it, though
+// credentials are never sent to a remote server in the clear.
+type Encryption string
+
+const (
+	EncryptionNone     Encryption = "none"
+	EncryptionSTARTTLS Encryption = "starttls" // Upgrade a plain connection, usually on port 587
+	EncryptionTLS      Encryption = "tls"      // Implicit TLS from the start, usually on port 465
+)
+
+// ParseEncryption parses the name of an encryption mode. Empty means none.
+func ParseEncryption(s string) (Encryption, error) {
+	switch e := Encryption(strings.ToLower(strings.TrimSpace(s))); e {
+	case "":
+		return EncryptionNone, nil
+	case EncryptionNone, EncryptionSTARTTLS, EncryptionTLS:
+		return e, nil
+	default:
+		return "", fmt.Errorf("mailer: unknown encryption %q, must be none, starttls or tls", s)
+	}
+}
+
+// SMTPConfig is how to reach the SMTP server mail is sent through
+type SMTPConfig struct {
+	Host       string
+	Port       int
+	Username   string // Leave empty if the server doesn't need authentication
+	Password   string
+	Encryption Encryption
+
+	ConnectTimeout time.Duration // Covers dialing, the greeting, TLS and authentication
+	SendTimeout    time.Duration // Covers sending one message
+
+	// KeepAlive is how long a connection is left open for the next message.
+	// 0 closes it after every message
+	KeepAlive time.Duration
+}
+
+// Validate reports what's wrong with the config, if anything
+func (c SMTPConfig) Validate() error {
+	switch {
+	case c.Host == "":
+		return errors.New("mailer: SMTP host is required")
+	case c.Port < 1 || c.Port > 65535:
+		return fmt.Errorf("mailer: SMTP port %d must be from 1 to 65535", c.Port)
+	case c.Encryption != EncryptionNone && c.Encryption != EncryptionSTARTTLS && c.Encryption != EncryptionTLS:
+		return fmt.Errorf("mailer: unknown encryption %q, must be none, starttls or tls", c.Encryption)
+	case c.Username == "" && c.Password != "":
+		return errors.New("mailer: SMTP password is set without a username")
+	case c.Username != "" && c.Encryption == EncryptionNone && !isLocalhost(c.Host):
+		return errors.New("mailer: SMTP credentials can't be sent to a remote server without encryption")
+	case c.ConnectTimeout <= 0 || c.SendTimeout <= 0:
+		return errors.New("mailer: SMTP timeouts must be more than 0")
+	case c.KeepAlive < 0:
+		return errors.New("mailer: SMTP keep-alive can't be negative")
+	}
+
+	return nil
+}
+
+// server returns the go-simple-mail server the config describes
+func (c SMTPConfig) server() *mail.SMTPServer {
+	server := mail.NewSMTPClient()
+	server.Host = c.Host
+	server.Port = c.Port
+	server.Username = c.Username
+	server.Password = c.Password
+	server.ConnectTimeout = c.ConnectTimeout
+	server.SendTimeout = c.SendTimeout
+	server.KeepAlive = c.KeepAlive > 0
+
+	switch c.Encryption {
+	case EncryptionSTARTTLS:
+		server.Encryption = mail.EncryptionTLS
+	case EncryptionTLS:
+		server.Encryption = mail.EncryptionSSL
+	default:
+		server.Encryption = mail.EncryptionNone
+	}
+
+	return server
+}
+
+// SMTP sends mail through an SMTP server. The connection is kept open between
+// messages, and closed once it has been unused for a while. It's safe to use
+// from more than one goroutine, though messages are sent one at a time.
+type SMTP struct {
+	server    *mail.SMTPServer
+	keepAlive time.Duration
+
+	mu     sync.Mutex
+	client *mail.SMTPClient
+	idle   *time.Timer // Closes the connection once it's unused for keepAlive
+}
+
+// NewSMTP creates an SMTP mailer, checking the config first. It doesn't connect
+// until the first message is sent.
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &SMTP{server: cfg.server(), keepAlive: cfg.KeepAlive}, nil
+}
+
+// Send sends an email
+func (s *SMTP) Send(email *mail.Email) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+
+	err := s.ensureConnected()
+	if err != nil {
+		return err
+	}
+
+	err = email.Send(s.client)
+	if err != nil {
+		// Whatever went wrong, the connection is in an unknown state
+		s.closeConnection()
+		return fmt.Errorf("mailer: sending message: %w", err)
+	}
+
+	if s.keepAlive == 0 {
+		// go-simple-mail has already said goodbye
+		s.client = nil
+		return nil
+	}
+
+	client := s.client
+	s.idle = time.AfterFunc(s.keepAlive, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// Only close it if it's still the connection that went idle
+		if s.client == client {
+			s.closeConnection()
+		}
+	})
+
+	return nil
+}
+
+// Close closes the connection, if there is one
+func (s *SMTP) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+
+	s.closeConnection()
+
+	return nil
+}
+
+// ensureConnected makes sure there is a working connection, opening a new one
+// if the server has dropped the old one
+func (s *SMTP) ensureConnected() error {
+	if s.client != nil {
+		if s.client.Noop() == nil {
+			return nil
+		}
+
+		s.closeConnection()
+	}
+
+	client, err := s.server.Connect()
+	if err != nil {
+		return fmt.Errorf("mailer: connecting to %s: %w", net.JoinHostPort(s.server.Host, strconv.Itoa(s.server.Port)), err)
+	}
+
+	s.client = client
+
+	return nil
+}
+
+// closeConnection says goodbye to the server, if it's still listening, and
+// closes the connection
+func (s *SMTP) closeConnection() {
+	if s.client == nil {
+		return
+	}
+
+	s.client.Quit()
+	s.client.Close()
+
+	s.client = nil
+}
+
+// isLocalhost reports whether host is this machine, where credentials can
+// safely be sent without encryption
+func isLocalhost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
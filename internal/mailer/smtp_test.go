@@ -0,0 +1,284 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	mail "github.com/xhit/go-simple-mail"
+)
+
+// fakeServer is just enough of an SMTP server to test against
+type fakeServer struct {
+	listener   net.Listener
+	extensions []string
+
+	// dropAfter closes each connection after that many messages, if set
+	dropAfter int
+
+	mu          sync.Mutex
+	connections int
+	helos       []string
+	messages    []string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeServer{listener: l}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.connections++
+			s.mu.Unlock()
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake ESMTP")
+
+	sent := 0
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			s.mu.Lock()
+			s.helos = append(s.helos, arg)
+			s.mu.Unlock()
+
+			if len(s.extensions) == 0 {
+				reply("250 fake")
+				continue
+			}
+
+			reply("250-fake")
+			for i, ext := range s.extensions {
+				if i == len(s.extensions)-1 {
+					reply("250 " + ext)
+				} else {
+					reply("250-" + ext)
+				}
+			}
+		case "DATA":
+			reply("354 go ahead")
+
+			var msg strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(line)
+			}
+
+			s.mu.Lock()
+			s.messages = append(s.messages, msg.String())
+			s.mu.Unlock()
+
+			reply("250 queued")
+
+			sent++
+			if s.dropAfter > 0 && sent >= s.dropAfter {
+				return
+			}
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *fakeServer) config() SMTPConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	return SMTPConfig{
+		Host:           host,
+		Port:           p,
+		Encryption:     EncryptionNone,
+		ConnectTimeout: time.Second,
+		SendTimeout:    time.Second,
+		KeepAlive:      time.Minute,
+	}
+}
+
+// hello returns an email to send to the fake server
+func hello() *mail.Email {
+	return newEmail(models.MailData{From: "me@here.ca", To: "john@smith.com", Subject: "Hi", Text: "Hello"})
+}
+
+func (s *fakeServer) stats() (int, []string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.connections, s.helos, s.messages
+}
+
+func TestSMTPKeepsConnectionOpen(t *testing.T) {
+	server := newFakeServer(t)
+
+	m, err := NewSMTP(server.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	for i := 0; i < 3; i++ {
+		err = m.Send(hello())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	connections, helos, messages := server.stats()
+
+	if connections != 1 {
+		t.Errorf("expected 1 connection, got %d", connections)
+	}
+
+	if len(helos) != 1 {
+		t.Errorf("expected to say hello once, got %v", helos)
+	}
+
+	if len(messages) != 3 || !strings.Contains(messages[0], "Hello") {
+		t.Errorf("expected 3 messages, got %q", messages)
+	}
+}
+
+func TestSMTPReconnects(t *testing.T) {
+	server := newFakeServer(t)
+	server.dropAfter = 1
+
+	m, err := NewSMTP(server.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	for i := 0; i < 2; i++ {
+		err = m.Send(hello())
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+
+	connections, _, messages := server.stats()
+
+	if connections != 2 || len(messages) != 2 {
+		t.Errorf("expected 2 messages over 2 connections, got %d over %d", len(messages), connections)
+	}
+}
+
+func TestSMTPNoKeepAlive(t *testing.T) {
+	server := newFakeServer(t)
+
+	cfg := server.config()
+	cfg.KeepAlive = 0
+
+	m, err := NewSMTP(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = m.Send(hello())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if connections, _, _ := server.stats(); connections != 2 {
+		t.Errorf("expected a connection per message, got %d", connections)
+	}
+}
+
+func TestSMTPConfigValidate(t *testing.T) {
+	valid := SMTPConfig{
+		Host:           "smtp.example.com",
+		Port:           587,
+		Username:       "bnb",
+		Password:       "secret",
+		Encryption:     EncryptionSTARTTLS,
+		ConnectTimeout: time.Second,
+		SendTimeout:    time.Second,
+	}
+
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+
+	tests := map[string]func(c *SMTPConfig){
+		"no host":          func(c *SMTPConfig) { c.Host = "" },
+		"bad port":         func(c *SMTPConfig) { c.Port = 70000 },
+		"bad encryption":   func(c *SMTPConfig) { c.Encryption = "ssl" },
+		"password only":    func(c *SMTPConfig) { c.Username = "" },
+		"plain text login": func(c *SMTPConfig) { c.Encryption = EncryptionNone },
+		"no timeout":       func(c *SMTPConfig) { c.SendTimeout = 0 },
+	}
+
+	for name, change := range tests {
+		cfg := valid
+		change(&cfg)
+
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseEncryption(t *testing.T) {
+	tests := map[string]Encryption{
+		"":         EncryptionNone,
+		"none":     EncryptionNone,
+		"STARTTLS": EncryptionSTARTTLS,
+		" tls ":    EncryptionTLS,
+	}
+
+	for in, expected := range tests {
+		got, err := ParseEncryption(in)
+		if err != nil || got != expected {
+			t.Errorf("ParseEncryption(%q): expected %q, got %q (%v)", in, expected, got, err)
+		}
+	}
+
+	if _, err := ParseEncryption("ssl"); err == nil {
+		t.Error("expected an error for an unknown encryption")
+	}
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -12,18 +14,35 @@ type User struct {
 	Email       string
 	Password    string
 	AccessLevel int
+	DailyDigest bool // Whether they get the daily digest email
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+
+	// The identity provider account the user logs in with. Empty unless
+	// they use single sign-on.
+	OIDCIssuer  string
+	OIDCSubject string
 }
 
 // Room describes a Room as per the database schema
 type Room struct {
 	ID        int
 	RoomName  string
+	MinStay   int // The fewest nights a stay in the room can last
+	Price     int // Per night, in cents
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// MinNights returns the fewest nights a stay in the room can last
+func (r Room) MinNights() int {
+	if r.MinStay < 1 {
+		return 1
+	}
+
+	return r.MinStay
+}
+
 // Restriction describes a Restriction as per the database schema
 type Restriction struct {
 	ID              int
@@ -32,20 +51,100 @@ type Restriction struct {
 	UpdatedAt       time.Time
 }
 
+// Kinds of Restriction, by ID
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionExternal    = 3 // Imported from another booking site's calendar
+)
+
 // Reservation describes a Reservation as per the database schema
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Processed int
-	Room      Room // Acts like a Foreign Key
+	ID          int
+	FirstName   string
+	LastName    string
+	Email       string
+	Phone       string
+	StartDate   time.Time
+	EndDate     time.Time
+	RoomID      int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Processed   int
+	GuestID     int       // Zero if the reservation isn't linked to a guest account
+	CancelledAt time.Time // Zero unless the reservation was cancelled
+	Room        Room      // Acts like a Foreign Key
+
+	// IdempotencyKey identifies the submission that made the reservation, so
+	// submitting it again doesn't make another one. It's scoped to where the
+	// submission came from, and hashed
+	IdempotencyKey string
+
+	SMSOptIn bool // The guest wants text messages about their stay
+
+	// Total is the price of the stay when it was booked, in cents.
+	// AmountPaid is how much of it has been paid, and is only filled in when
+	// the reservation is looked up
+	Total      int
+	AmountPaid int
+
+	// Guests is how many people are staying. Items is the price itemized
+	// when it was booked, and is empty for stays booked before prices were
+	Guests int
+	Items  []LineItem
+
+	// InviteSequence is the sequence number the guest's calendar invite was
+	// last sent with. It's only filled in when the reservation is looked up
+	// by ID.
+	InviteSequence int
+}
+
+// Cancelled reports whether the reservation has been cancelled.
+func (r Reservation) Cancelled() bool {
+	return !r.CancelledAt.IsZero()
+}
+
+// Nights returns how many nights the stay lasts
+func (r Reservation) Nights() int {
+	return int(r.EndDate.Sub(r.StartDate).Hours() / 24)
+}
+
+// Balance returns how much of the stay is left to pay, in cents
+func (r Reservation) Balance() int {
+	return max(r.Total-r.AmountPaid, 0)
+}
+
+// Guest describes a guest account as per the database schema. Guests are kept
+// apart from staff Users, so a guest can never reach the admin pages
+type Guest struct {
+	ID              int
+	FirstName       string
+	LastName        string
+	Email           string
+	Phone           string
+	Password        string // Empty until an invited guest sets one
+	InviteTokenHash string
+	InviteExpiresAt time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+
+	// Emails are verified by following a link sent to them. A new email
+	// waits in PendingEmail until it's verified.
+	EmailVerifiedAt     time.Time
+	PendingEmail        string
+	EmailTokenHash      string
+	EmailTokenExpiresAt time.Time
+}
+
+// Registered reports whether the guest has set a password and can log in.
+func (g Guest) Registered() bool {
+	return g.Password != ""
+}
+
+// EmailVerified reports whether the guest has shown they own their email.
+// Reservations are only linked to accounts with a verified email.
+func (g Guest) EmailVerified() bool {
+	return !g.EmailVerifiedAt.IsZero()
 }
 
 // RoomRestriction describes a Room Restriction as per the database schema
@@ -61,13 +160,297 @@ type RoomRestriction struct {
 	Room          Room
 	Reservation   Reservation
 	Restriction   Restriction
+
+	// For external blocks, the calendar feed they were imported from and
+	// the event's UID in that feed
+	ExternalFeedID int
+	ExternalUID    string
+}
+
+// ICalFeed is another booking site's calendar that is imported as external
+// blocks for a room, as per the database schema
+type ICalFeed struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string
+	LastSyncedAt time.Time
+	LastError    string // Why the last sync failed, if it did
+	Conflicts    string // Imported events that overlap reservations, one per line
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+}
+
+// Scopes an AccessToken can be granted. A write token can also read
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// AccessToken describes a personal access token as per the database schema.
+// Only the SHA-256 hash of the token is stored, never the token itself
+type AccessToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	TokenHash  string
+	Scope      string
+	ExpiresAt  time.Time
+	LastUsedAt time.Time // Zero if the token has never been used
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Allows reports whether the token grants the given scope.
+func (t AccessToken) Allows(scope string) bool {
+	if t.Scope == ScopeWrite {
+		return true
+	}
+
+	return t.Scope == scope
+}
+
+// Expired reports whether the token is past its expiry time.
+func (t AccessToken) Expired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
+
+// UserSession describes a logged in session, as shown to the user so they can
+// revoke it. The ID is derived from the session token, never the token itself
+type UserSession struct {
+	ID         string
+	Token      string // Never sent to the browser
+	UserID     int
+	IP         string
+	UserAgent  string
+	LoggedInAt time.Time
+	LastSeen   time.Time
+	Current    bool
+}
+
+// Webhook is a URL that gets sent events as they happen, as per the database
+// schema. Payloads are signed with the secret so the receiver can check they
+// came from us
+type Webhook struct {
+	ID        int
+	URL       string
+	Secret    string
+	Events    string // Comma separated. Empty means every event
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// EventList returns the events the webhook is subscribed to, or nil if it's
+// subscribed to every event.
+func (w Webhook) EventList() []string {
+	if w.Events == "" {
+		return nil
+	}
+
+	return strings.Split(w.Events, ",")
 }
 
-// MailData holds an email message
+// Statuses of a WebhookDelivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed" // Gave up retrying
+)
+
+// WebhookDelivery is one event queued to be sent to a webhook, as per the
+// database schema. It doubles as the delivery log
+type WebhookDelivery struct {
+	ID             int
+	WebhookID      int
+	Event          string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	ResponseStatus int    // Status code of the last attempt, 0 if there was no response
+	LastError      string // Why the last attempt failed, if it did
+	DeliveredAt    time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Webhook        Webhook
+}
+
+// MailData holds an email message. It's sent with both bodies, so mail
+// clients can show whichever they prefer
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To      string
+	From    string
+	Subject string
+	HTML    string
+	Text    string
+
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Name string
+	Data []byte
+}
+
+// Emails sent to guests on a schedule. Each is only sent once per reservation
+const (
+	ScheduledPreArrival = "pre_arrival"
+	ScheduledPostStay   = "post_stay"
+)
+
+// What can happen to a queued email or text message
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed" // Gave up retrying
+)
+
+// Email is an email in the outbox, as per the database schema. Emails are
+// queued rather than sent straight away, so they survive the mail server being
+// down and the app restarting. It doubles as the log of sent email
+type Email struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string // Why the last attempt failed, if it did
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// SMS is a text message in the outbox, as per the database schema. Like emails,
+// they're queued and sent in the background, and use the same statuses
+type SMS struct {
+	ID            int
+	To            string // E.164, e.g. +15555555555
+	Body          string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string // Why the last attempt failed, if it did
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Digest is the day's overview of bookings, emailed to the users who opted in
+type Digest struct {
+	Arrivals      []Reservation     // Arriving on the day
+	Departures    []Reservation     // Leaving on the day
+	InHouse       []Reservation     // Arrived before the day, and leaving after it
+	NewBookings   []Reservation     // Made in the last 24 hours
+	Cancellations []Reservation     // Cancelled in the last 24 hours
+	Unprocessed   []Reservation     // Not cancelled
+	Blocks        []RoomRestriction // Blocked days coming up, including the room
+}
+
+// Empty reports whether there is nothing in the digest
+func (d Digest) Empty() bool {
+	return len(d.Arrivals)+len(d.Departures)+len(d.InHouse)+len(d.NewBookings)+
+		len(d.Cancellations)+len(d.Unprocessed)+len(d.Blocks) == 0
+}
+
+// Kinds of payment
+const (
+	PaymentFull    = "full"
+	PaymentDeposit = "deposit" // Part up front, and the rest on arrival
+)
+
+// What can happen to a payment
+const (
+	PaymentPending = "pending" // The guest was sent to pay, and hasn't yet
+	PaymentPaid    = "paid"
+	PaymentFailed  = "failed" // The checkout expired, or the payment was declined
+)
+
+// Payment is a payment towards a reservation, as per the database schema. The
+// guest pays on the provider's hosted checkout page, which ProviderRef
+// identifies, and the provider tells us when it's paid
+type Payment struct {
+	ID            int
+	ReservationID int
+	Provider      string
+	ProviderRef   string
+	CheckoutURL   string
+	Kind          string
+	Amount        int // In cents
+	Currency      string
+	Status        string
+	PaidAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Invoice is the invoice number given to a reservation's folio when it's
+// first issued, as per the database schema. Numbers run in sequence, and
+// never change once given
+type Invoice struct {
+	ID            int
+	ReservationID int
+	Number        int
+	IssuedAt      time.Time
+}
+
+// Issued is whether the folio has been given an invoice number yet
+func (i Invoice) Issued() bool {
+	return i.Number != 0
+}
+
+// Code is the invoice number as it's printed, e.g. INV-000042
+func (i Invoice) Code() string {
+	return fmt.Sprintf("INV-%06d", i.Number)
+}
+
+// Kinds of charge added to the price of a stay
+const (
+	ChargeTax = "tax"
+	ChargeFee = "fee"
+)
+
+// How a charge is worked out
+const (
+	ChargePercent        = "percent"          // A percentage of the room and fees. Taxes only
+	ChargePerNight       = "per_night"        // A flat amount each night
+	ChargePerPersonNight = "per_person_night" // A flat amount for each guest each night. Taxes only
+	ChargePerStay        = "per_stay"         // A flat amount once. Fees only
+)
+
+// Charge is a tax or fee added to the price of stays, as per the database
+// schema. It applies to the nights from StartsOn to EndsOn, inclusive
+type Charge struct {
+	ID        int
+	Name      string
+	Kind      string
+	Basis     string
+	Amount    int       // In cents, or hundredths of a percent for ChargePercent
+	StartsOn  time.Time // Zero if it has always applied
+	EndsOn    time.Time // Zero if it applies until further notice
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// AppliesOn reports whether the charge applies to the night of day
+func (c Charge) AppliesOn(day time.Time) bool {
+	if !c.StartsOn.IsZero() && day.Before(c.StartsOn) {
+		return false
+	}
+
+	return c.EndsOn.IsZero() || !day.After(c.EndsOn)
+}
+
+// LineItemRoom is the kind of line item for the room itself, alongside
+// ChargeTax and ChargeFee
+const LineItemRoom = "room"
+
+// LineItem is a line of a stay's itemized price, as per the database schema
+type LineItem struct {
+	Kind        string
+	Description string
+	Quantity    int
+	UnitPrice   int // In cents
+	Amount      int // In cents
 }
@@ -0,0 +1,73 @@
+// Package notify passes live notifications on to the staff who have the admin
+// area open.
+package notify
+
+import "sync"
+
+// bufferSize is how many notifications a subscriber can fall behind by before
+// it starts missing them
+const bufferSize = 16
+
+// Notification tells staff something happened to a reservation
+type Notification struct {
+	Event         string `json:"event"`
+	Message       string `json:"message"`
+	ReservationID int    `json:"reservation_id,omitempty"`
+	NewCount      int    `json:"new_count"` // How many reservations haven't been processed yet
+}
+
+// Broker passes each published notification on to every subscriber
+type Broker struct {
+	mu   sync.Mutex
+	subs map[chan Notification]struct{}
+}
+
+// NewBroker creates a broker with no subscribers
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[chan Notification]struct{})}
+}
+
+// Subscribe returns a channel of the notifications published from now on, and
+// a function to call when they're no longer wanted
+func (b *Broker) Subscribe() (<-chan Notification, func()) {
+	ch := make(chan Notification, bufferSize)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// Publish passes n on to every subscriber. It never blocks, so subscribers
+// that have fallen too far behind miss it.
+func (b *Broker) Publish(n Notification) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- n:
+		default:
+		}
+	}
+}
+
+// Subscribers returns how many subscribers there are
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subs)
+}
@@ -0,0 +1,55 @@
+package notify
+
+import "testing"
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+
+	first, unsubscribeFirst := b.Subscribe()
+	second, unsubscribeSecond := b.Subscribe()
+	defer unsubscribeSecond()
+
+	if b.Subscribers() != 2 {
+		t.Fatalf("expected 2 subscribers, got %d", b.Subscribers())
+	}
+
+	b.Publish(Notification{Event: "reservation.created", ReservationID: 1})
+
+	for i, ch := range []<-chan Notification{first, second} {
+		n := <-ch
+		if n.ReservationID != 1 {
+			t.Errorf("subscriber %d: expected reservation 1, got %d", i, n.ReservationID)
+		}
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst() // Calling it again does nothing
+
+	if _, ok := <-first; ok {
+		t.Error("expected the channel to be closed after unsubscribing")
+	}
+
+	if b.Subscribers() != 1 {
+		t.Errorf("expected 1 subscriber, got %d", b.Subscribers())
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+
+	ch, unsubscribe := b.Subscribe()
+	defer unsubscribe()
+
+	// Publishing to a subscriber that isn't reading must not block
+	for i := 0; i < bufferSize*2; i++ {
+		b.Publish(Notification{ReservationID: i})
+	}
+
+	if len(ch) != bufferSize {
+		t.Errorf("expected %d buffered notifications, got %d", bufferSize, len(ch))
+	}
+
+	if n := <-ch; n.ReservationID != 0 {
+		t.Errorf("expected the oldest notification first, got %d", n.ReservationID)
+	}
+}
@@ -0,0 +1,496 @@
+// Package oidc implements the parts of OpenID Connect needed to log staff in
+// with an external identity provider: discovery, the authorization code flow
+// with PKCE, and ID token validation.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is how far the identity provider's clock may be off from ours
+const clockSkew = time.Minute
+
+// keyRefreshInterval limits how often signing keys are fetched again, so
+// tokens with made up key IDs can't make us hammer the provider
+const keyRefreshInterval = time.Minute
+
+// Config holds what the app needs to know to talk to an identity provider
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string     // Defaults to openid, email and profile
+	HTTPClient   *http.Client // Defaults to a client with a 10 second timeout
+}
+
+// discovery is the part of the provider's discovery document that we use
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect identity provider
+type Provider struct {
+	config    Config
+	client    *http.Client
+	endpoints discovery
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey // Signing keys by key ID
+	fetchedAt time.Time
+}
+
+// Claims are the claims we use from a validated ID token
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expiry        unixTime `json:"exp"`
+	IssuedAt      unixTime `json:"iat"`
+	NotBefore     unixTime `json:"nbf"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified *bool    `json:"email_verified"`
+	Name          string   `json:"name"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+	Groups        []string `json:"groups"`
+}
+
+// New fetches the provider's discovery document and returns a Provider. The
+// issuer in the document must match the configured issuer exactly.
+func New(ctx context.Context, config Config) (*Provider, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client ID and redirect URL are required")
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	p := &Provider{
+		config: config,
+		client: client,
+		keys:   map[string]*rsa.PublicKey{},
+	}
+
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+
+	err := p.getJSON(ctx, wellKnown, &p.endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovery failed: %w", err)
+	}
+
+	if p.endpoints.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", p.endpoints.Issuer, config.Issuer)
+	}
+
+	if p.endpoints.AuthorizationEndpoint == "" || p.endpoints.TokenEndpoint == "" || p.endpoints.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+
+	return p, nil
+}
+
+// RandomString returns a random URL-safe string, for use as a state, nonce or
+// PKCE code verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge for a code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL to send the user to to log in with the provider.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.config.ClientID)
+	v.Set("redirect_uri", p.config.RedirectURL)
+	v.Set("scope", strings.Join(p.config.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.endpoints.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return p.endpoints.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange swaps an authorization code for tokens, and returns the claims of
+// the validated ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.config.RedirectURL)
+	v.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.endpoints.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: can't decode token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("oidc: token request failed with status %d: %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
+
+// Verify checks the signature and claims of a raw ID token, and returns its claims.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed id token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: malformed id token header: %w", err)
+	}
+
+	// Only accept the algorithm every provider must support. Never accept "none"
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("oidc: unsupported signing algorithm %q", header.Alg)
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("oidc: malformed id token signature: %w", err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, errors.New("oidc: invalid id token signature")
+	}
+
+	var claims Claims
+
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: malformed id token claims: %w", err)
+	}
+
+	err = p.checkClaims(&claims, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+// checkClaims validates the claims of an ID token whose signature has already
+// been checked.
+func (p *Provider) checkClaims(c *Claims, nonce string) error {
+	now := time.Now()
+
+	if c.Issuer != p.endpoints.Issuer {
+		return fmt.Errorf("oidc: id token issued by %q, not %q", c.Issuer, p.endpoints.Issuer)
+	}
+
+	if !c.Audience.contains(p.config.ClientID) {
+		return errors.New("oidc: id token was not issued for this client")
+	}
+
+	// If there are several audiences, the token must have been issued to us
+	if len(c.Audience) > 1 && c.AuthorizedBy != p.config.ClientID {
+		return errors.New("oidc: id token was not authorized for this client")
+	}
+
+	if c.Subject == "" {
+		return errors.New("oidc: id token has no subject")
+	}
+
+	if c.Expiry == 0 || now.After(c.Expiry.Time().Add(clockSkew)) {
+		return errors.New("oidc: id token has expired")
+	}
+
+	if c.IssuedAt != 0 && c.IssuedAt.Time().After(now.Add(clockSkew)) {
+		return errors.New("oidc: id token was issued in the future")
+	}
+
+	if c.NotBefore != 0 && c.NotBefore.Time().After(now.Add(clockSkew)) {
+		return errors.New("oidc: id token is not valid yet")
+	}
+
+	if c.Nonce != nonce {
+		return errors.New("oidc: id token nonce does not match")
+	}
+
+	return nil
+}
+
+// key returns the provider's signing key with the given ID. Keys are cached,
+// and fetched again when an unknown key ID turns up, so key rotation works.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(p.fetchedAt) < keyRefreshInterval {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys = keys
+	p.fetchedAt = time.Now()
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+// fetchKeys downloads the provider's RSA signing keys.
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err := p.getJSON(ctx, p.endpoints.JWKSURI, &set)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: can't fetch signing keys: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+// getJSON fetches a URL and decodes the JSON response into v.
+func (p *Provider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", u, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// decodeSegment decodes a base64url encoded JWT segment into v.
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// audience is the "aud" claim, which can be a single string or a list
+type audience []string
+
+// UnmarshalJSON accepts both forms of the "aud" claim
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(b, &list)
+	if err != nil {
+		return err
+	}
+
+	*a = list
+
+	return nil
+}
+
+// contains reports whether the audience includes the given client ID
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unixTime is a JWT NumericDate, the number of seconds since the Unix epoch
+type unixTime int64
+
+// UnmarshalJSON accepts both whole and fractional seconds
+func (t *unixTime) UnmarshalJSON(b []byte) error {
+	var f float64
+
+	err := json.Unmarshal(b, &f)
+	if err != nil {
+		return err
+	}
+
+	*t = unixTime(f)
+
+	return nil
+}
+
+// Time converts the NumericDate to a time.Time
+func (t unixTime) Time() time.Time {
+	return time.Unix(int64(t), 0)
+}
+
+// ParseGroupLevels parses a mapping of identity provider groups to access
+// levels, written as "group=level" pairs separated by commas, e.g.
+// "bnb-admins=3,bnb-staff=1".
+func ParseGroupLevels(s string) (map[string]int, error) {
+	levels := map[string]int{}
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		group, level, ok := strings.Cut(pair, "=")
+
+		n, err := strconv.Atoi(strings.TrimSpace(level))
+		if !ok || err != nil || n < 1 {
+			return nil, fmt.Errorf("oidc: invalid group mapping %q", pair)
+		}
+
+		levels[strings.TrimSpace(group)] = n
+	}
+
+	return levels, nil
+}
+
+// AccessLevel returns the highest access level any of the groups map to, or 0
+// if none of them do, in which case the user should not be let in.
+func AccessLevel(groups []string, levels map[string]int) int {
+	level := 0
+
+	for _, g := range groups {
+		if levels[g] > level {
+			level = levels[g]
+		}
+	}
+
+	return level
+}
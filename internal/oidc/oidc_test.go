@@ -0,0 +1,226 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/oidc"
+	"github.com/BlackSound1/Go-B-and-B/internal/oidc/oidctest"
+)
+
+const redirectURL = "http://localhost:8080/user/login/oidc/callback"
+
+// newProvider starts a stub identity provider and returns a Provider for it
+func newProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+
+	srv := oidctest.NewServer("go-b-and-b", "secret")
+	t.Cleanup(srv.Close)
+
+	p, err := oidc.New(context.Background(), oidc.Config{
+		Issuer:       srv.Issuer(),
+		ClientID:     "go-b-and-b",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return srv, p
+}
+
+// authorize follows the authorization URL without following the redirect back
+// to the app, and returns the code and state the provider redirected with
+func authorize(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatalf("expected a redirect from the provider, got status %d", resp.StatusCode)
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestNew(t *testing.T) {
+	srv := oidctest.NewServer("go-b-and-b", "secret")
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		config  oidc.Config
+		wantErr bool
+	}{
+		{"valid", oidc.Config{Issuer: srv.Issuer(), ClientID: "go-b-and-b", RedirectURL: redirectURL}, false},
+		{"issuer-mismatch", oidc.Config{Issuer: srv.Issuer() + "/", ClientID: "go-b-and-b", RedirectURL: redirectURL}, true},
+		{"missing-client-id", oidc.Config{Issuer: srv.Issuer(), RedirectURL: redirectURL}, true},
+		{"no-discovery", oidc.Config{Issuer: srv.Issuer() + "/nothing", ClientID: "go-b-and-b", RedirectURL: redirectURL}, true},
+	}
+
+	for _, test := range tests {
+		_, err := oidc.New(context.Background(), test.config)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error to be %t, but got %v", test.name, test.wantErr, err)
+		}
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	srv, p := newProvider(t)
+	srv.Claims["email"] = "staff@example.com"
+	srv.Claims["groups"] = []string{"bnb-admins"}
+
+	authURL := p.AuthCodeURL("the-state", "the-nonce", "the-verifier")
+
+	code, state := authorize(t, authURL)
+	if state != "the-state" {
+		t.Errorf("expected state to be passed back, got %q", state)
+	}
+
+	// The code can only be swapped with the right PKCE verifier
+	_, err := p.Exchange(context.Background(), code, "wrong-verifier", "the-nonce")
+	if err == nil {
+		t.Error("expected exchange with the wrong code verifier to fail")
+	}
+
+	code, _ = authorize(t, authURL)
+
+	claims, err := p.Exchange(context.Background(), code, "the-verifier", "the-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Email != "staff@example.com" || !reflect.DeepEqual(claims.Groups, []string{"bnb-admins"}) {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	// Codes can only be used once
+	_, err = p.Exchange(context.Background(), code, "the-verifier", "the-nonce")
+	if err == nil {
+		t.Error("expected reusing a code to fail")
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	_, p := newProvider(t)
+
+	u, err := url.Parse(p.AuthCodeURL("s", "n", "v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+
+	if q.Get("code_challenge") != oidc.CodeChallenge("v") || q.Get("code_challenge_method") != "S256" {
+		t.Error("expected a S256 PKCE challenge")
+	}
+
+	if q.Get("redirect_uri") != redirectURL || q.Get("scope") != "openid email profile" {
+		t.Errorf("unexpected query: %v", q)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	srv, p := newProvider(t)
+
+	// Sign a token with a key the provider doesn't publish
+	other := oidctest.NewServer("go-b-and-b", "secret")
+	defer other.Close()
+
+	tests := []struct {
+		name    string
+		modify  func(claims map[string]any)
+		token   func(claims map[string]any) string
+		wantErr bool
+	}{
+		{"valid", nil, nil, false},
+		{"wrong-nonce", func(c map[string]any) { c["nonce"] = "other" }, nil, true},
+		{"wrong-issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }, nil, true},
+		{"wrong-audience", func(c map[string]any) { c["aud"] = "someone-else" }, nil, true},
+		{"audience-list", func(c map[string]any) { c["aud"] = []string{"go-b-and-b"} }, nil, false},
+		{"audience-list-without-azp", func(c map[string]any) { c["aud"] = []string{"go-b-and-b", "other"} }, nil, true},
+		{"audience-list-with-azp", func(c map[string]any) {
+			c["aud"] = []string{"go-b-and-b", "other"}
+			c["azp"] = "go-b-and-b"
+		}, nil, false},
+		{"expired", func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, nil, true},
+		{"not-yet-valid", func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() }, nil, true},
+		{"no-subject", func(c map[string]any) { delete(c, "sub") }, nil, true},
+		{"wrong-key", nil, other.Sign, true},
+		{"alg-none", nil, func(c map[string]any) string { return "eyJhbGciOiJub25lIn0.e30." }, true},
+		{"malformed", nil, func(c map[string]any) string { return "not-a-token" }, true},
+	}
+
+	for _, test := range tests {
+		claims := srv.IDTokenClaims("the-nonce")
+		if test.modify != nil {
+			test.modify(claims)
+		}
+
+		sign := srv.Sign
+		if test.token != nil {
+			sign = test.token
+		}
+
+		_, err := p.Verify(context.Background(), sign(claims), "the-nonce")
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error to be %t, but got %v", test.name, test.wantErr, err)
+		}
+	}
+}
+
+func TestParseGroupLevels(t *testing.T) {
+	levels, err := oidc.ParseGroupLevels("bnb-admins=3, bnb-staff=1,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int{"bnb-admins": 3, "bnb-staff": 1}
+	if !reflect.DeepEqual(levels, expected) {
+		t.Errorf("expected %v, got %v", expected, levels)
+	}
+
+	for _, bad := range []string{"bnb-admins", "bnb-admins=admin", "bnb-admins=0"} {
+		_, err := oidc.ParseGroupLevels(bad)
+		if err == nil {
+			t.Errorf("expected %q to be invalid", bad)
+		}
+	}
+}
+
+func TestAccessLevel(t *testing.T) {
+	levels := map[string]int{"bnb-admins": 3, "bnb-staff": 1}
+
+	tests := []struct {
+		groups   []string
+		expected int
+	}{
+		{[]string{"bnb-staff"}, 1},
+		{[]string{"bnb-staff", "bnb-admins"}, 3},
+		{[]string{"marketing"}, 0},
+		{nil, 0},
+	}
+
+	for _, test := range tests {
+		if level := oidc.AccessLevel(test.groups, levels); level != test.expected {
+			t.Errorf("for %v expected %d, got %d", test.groups, test.expected, level)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Package outbox sends the emails queued in the database, retrying the ones
+// that fail until they go through or it's time to give up.
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// MaxAttempts is how many times an email is tried before giving up
+const MaxAttempts = 8
+
+// batchSize is how many emails are claimed at once
+const batchSize = 50
+
+// claimLease is how long a claimed batch is left to this worker. It's long
+// enough for every email in it to time out, after which another worker can
+// try the ones this one didn't get to record.
+const claimLease = time.Hour
+
+// Backoff is how long to wait before the next attempt, after attempts have
+// failed. It doubles every time, from a minute up to 6 hours.
+func Backoff(attempts int) time.Duration {
+	wait := time.Minute
+
+	for i := 1; i < attempts && wait < 6*time.Hour; i++ {
+		wait *= 2
+	}
+
+	return min(wait, 6*time.Hour)
+}
+
+// Store is what the Worker needs from the database. ClaimDueEmails must hide
+// the emails it returns from other callers until lease is up, so two workers
+// never send the same email.
+type Store interface {
+	ClaimDueEmails(limit int, lease time.Duration) ([]models.Email, error)
+	UpdateEmail(e models.Email) error
+}
+
+// Sender sends one email
+type Sender interface {
+	Send(msg models.MailData) error
+}
+
+// Worker sends queued emails as they come due
+type Worker struct {
+	Store    Store
+	Sender   Sender
+	ErrorLog *log.Logger
+}
+
+// New creates a Worker
+func New(store Store, sender Sender, errorLog *log.Logger) *Worker {
+	return &Worker{
+		Store:    store,
+		Sender:   sender,
+		ErrorLog: errorLog,
+	}
+}
+
+// Run sends due emails every interval until ctx is done
+func (w *Worker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := w.SendDue(ctx)
+		if err != nil {
+			w.ErrorLog.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue sends every email that is due, in batches
+func (w *Worker) SendDue(ctx context.Context) error {
+	for {
+		due, err := w.Store.ClaimDueEmails(batchSize, claimLease)
+		if err != nil {
+			return err
+		}
+
+		for _, email := range due {
+			err := w.Store.UpdateEmail(w.Send(email))
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(due) < batchSize || ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// Send makes one attempt at sending an email, and returns it updated with the
+// outcome
+func (w *Worker) Send(email models.Email) models.Email {
+	now := time.Now()
+
+	email.Attempts++
+	email.LastError = ""
+
+	err := w.Sender.Send(email.Mail)
+	if err == nil {
+		email.Status = models.EmailSent
+		email.SentAt = now
+		return email
+	}
+
+	email.LastError = err.Error()
+
+	if email.Attempts >= MaxAttempts {
+		email.Status = models.EmailFailed
+		w.ErrorLog.Printf("giving up on email %d to %s: %v", email.ID, email.Mail.To, err)
+	} else {
+		email.Status = models.EmailPending
+		email.NextAttemptAt = now.Add(Backoff(email.Attempts))
+	}
+
+	return email
+}
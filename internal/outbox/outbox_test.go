@@ -0,0 +1,129 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		4:  8 * time.Minute,
+		20: 6 * time.Hour,
+	}
+
+	for attempts, expected := range tests {
+		if got := Backoff(attempts); got != expected {
+			t.Errorf("Backoff(%d): expected %s, got %s", attempts, expected, got)
+		}
+	}
+}
+
+// memoryStore keeps emails in memory
+type memoryStore struct {
+	emails map[int]models.Email
+}
+
+func (s *memoryStore) ClaimDueEmails(limit int, lease time.Duration) ([]models.Email, error) {
+	var due []models.Email
+
+	for id, e := range s.emails {
+		if e.Status == models.EmailPending && !e.NextAttemptAt.After(time.Now()) && len(due) < limit {
+			due = append(due, e)
+
+			e.NextAttemptAt = time.Now().Add(lease)
+			s.emails[id] = e
+		}
+	}
+
+	return due, nil
+}
+
+func (s *memoryStore) UpdateEmail(e models.Email) error {
+	s.emails[e.ID] = e
+	return nil
+}
+
+// fakeSender records what it sends, and fails for one address
+type fakeSender struct {
+	sent []models.MailData
+}
+
+func (s *fakeSender) Send(msg models.MailData) error {
+	if msg.To == "down@example.com" {
+		return errors.New("connection refused")
+	}
+
+	s.sent = append(s.sent, msg)
+
+	return nil
+}
+
+func TestSendDue(t *testing.T) {
+	store := &memoryStore{emails: map[int]models.Email{
+		1: {ID: 1, Mail: models.MailData{To: "john@smith.com"}, Status: models.EmailPending},
+		2: {ID: 2, Mail: models.MailData{To: "down@example.com"}, Status: models.EmailPending},
+		3: {ID: 3, Mail: models.MailData{To: "later@example.com"}, Status: models.EmailPending, NextAttemptAt: time.Now().Add(time.Hour)},
+	}}
+	sender := &fakeSender{}
+
+	w := New(store, sender, log.New(io.Discard, "", 0))
+
+	err := w.SendDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.sent) != 1 || sender.sent[0].To != "john@smith.com" {
+		t.Errorf("expected only the due email to be sent, got %v", sender.sent)
+	}
+
+	if e := store.emails[1]; e.Status != models.EmailSent || e.SentAt.IsZero() || e.Attempts != 1 {
+		t.Errorf("expected email 1 to be sent, got %+v", e)
+	}
+
+	failed := store.emails[2]
+	if failed.Status != models.EmailPending || failed.Attempts != 1 || failed.LastError != "connection refused" {
+		t.Errorf("expected email 2 to be retried, got %+v", failed)
+	}
+
+	if !failed.NextAttemptAt.After(time.Now()) {
+		t.Errorf("expected the retry to wait, got %s", failed.NextAttemptAt)
+	}
+
+	if store.emails[3].Attempts != 0 {
+		t.Error("expected the email that isn't due to be left alone")
+	}
+
+	// A claimed email whose worker died before recording the outcome is tried
+	// again once the lease is up
+	store.emails[4] = models.Email{ID: 4, Mail: models.MailData{To: "crashed@example.com"}, Status: models.EmailPending}
+	claimed, _ := store.ClaimDueEmails(batchSize, -time.Second)
+
+	err = w.SendDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(claimed) != 1 || len(sender.sent) != 2 || sender.sent[1].To != "crashed@example.com" {
+		t.Errorf("expected the abandoned email to be sent once, got %v", sender.sent)
+	}
+}
+
+func TestSendGivesUp(t *testing.T) {
+	w := New(&memoryStore{}, &fakeSender{}, log.New(io.Discard, "", 0))
+
+	email := models.Email{ID: 1, Mail: models.MailData{To: "down@example.com"}, Attempts: MaxAttempts - 1}
+
+	email = w.Send(email)
+	if email.Status != models.EmailFailed || email.Attempts != MaxAttempts {
+		t.Errorf("expected the email to fail for good, got %+v", email)
+	}
+}
@@ -0,0 +1,216 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/signature"
+)
+
+// HeaderSignature is the header the Hosted provider signs its webhooks in, as
+// t=<unix time>,v1=<hex HMAC-SHA256 of the time, a dot and the body>
+const HeaderSignature = "Payment-Signature"
+
+// signatureTolerance is how old a signed webhook can be, so old ones can't be
+// replayed
+const signatureTolerance = 5 * time.Minute
+
+// Hosted takes payments through a provider with a Stripe-style checkout API.
+// Checkouts are created by posting a form to /checkout/sessions, and looked
+// up at /checkout/sessions/{id}, authenticated with a bearer API key.
+type Hosted struct {
+	URL           string // The API's base URL
+	APIKey        string
+	WebhookSecret string
+	Client        *http.Client
+}
+
+// NewHosted creates a Hosted provider with a client that gives up on a slow API
+func NewHosted(apiURL, apiKey, webhookSecret string) *Hosted {
+	return &Hosted{
+		URL:           strings.TrimSuffix(apiURL, "/"),
+		APIKey:        apiKey,
+		WebhookSecret: webhookSecret,
+		Client:        &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// checkoutSession is a checkout as the API returns it
+type checkoutSession struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Status        string `json:"status"`         // open, complete or expired
+	PaymentStatus string `json:"payment_status"` // paid or unpaid
+}
+
+// status is what a checkout means for the payment
+func (s checkoutSession) status() string {
+	switch {
+	case s.PaymentStatus == "paid":
+		return models.PaymentPaid
+	case s.Status == "expired":
+		return models.PaymentFailed
+	default:
+		return models.PaymentPending
+	}
+}
+
+// Name is the provider's name
+func (p *Hosted) Name() string {
+	return "hosted"
+}
+
+// CreateCheckout starts a checkout for the guest to be sent to
+func (p *Hosted) CreateCheckout(c Checkout) (Session, error) {
+	form := url.Values{
+		"amount":              {strconv.Itoa(c.Amount)},
+		"currency":            {strings.ToLower(c.Currency)},
+		"description":         {c.Description},
+		"customer_email":      {c.Email},
+		"client_reference_id": {c.Reference},
+		"success_url":         {c.SuccessURL},
+		"cancel_url":          {c.CancelURL},
+	}
+
+	var session checkoutSession
+
+	err := p.do(http.MethodPost, "/checkout/sessions", strings.NewReader(form.Encode()), &session)
+	if err != nil {
+		return Session{}, err
+	}
+
+	if session.ID == "" || session.URL == "" {
+		return Session{}, fmt.Errorf("payments: creating checkout: no ID or URL in the response")
+	}
+
+	return Session{ID: session.ID, URL: session.URL}, nil
+}
+
+// Status looks up whether a checkout has been paid
+func (p *Hosted) Status(sessionID string) (string, error) {
+	var session checkoutSession
+
+	err := p.do(http.MethodGet, "/checkout/sessions/"+url.PathEscape(sessionID), nil, &session)
+	if err != nil {
+		return "", err
+	}
+
+	return session.status(), nil
+}
+
+// do makes an API request and decodes the JSON response into dst. Any
+// response but a 2xx is an error.
+func (p *Hosted) do(method, path string, body io.Reader, dst any) error {
+	req, err := http.NewRequest(method, p.URL+path, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("payments: %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// The body usually says why, and is worth keeping for the log
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("payments: %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(detail)))
+	}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("payments: %s %s: %w", method, path, err)
+	}
+
+	return nil
+}
+
+// webhookEvent is the JSON body of a webhook
+type webhookEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		Object checkoutSession `json:"object"`
+	} `json:"data"`
+}
+
+// ParseWebhook checks a webhook was signed with the webhook secret recently,
+// and returns what happened to the checkout. Events about anything but
+// checkouts being paid or expiring have no status.
+func (p *Hosted) ParseWebhook(r *http.Request) (Event, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return Event{}, err
+	}
+
+	err = p.verify(r.Header.Get(HeaderSignature), body, time.Now())
+	if err != nil {
+		return Event{}, err
+	}
+
+	var event webhookEvent
+
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		return Event{}, fmt.Errorf("payments: decoding webhook: %w", err)
+	}
+
+	session := event.Data.Object
+
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		// Some payment methods complete the checkout before the money arrives
+		if session.PaymentStatus != "paid" {
+			return Event{SessionID: session.ID}, nil
+		}
+
+		return Event{SessionID: session.ID, Status: models.PaymentPaid}, nil
+	case "checkout.session.expired", "checkout.session.async_payment_failed":
+		return Event{SessionID: session.ID, Status: models.PaymentFailed}, nil
+	default:
+		return Event{SessionID: session.ID}, nil
+	}
+}
+
+// verify checks a signature header is for body, and was made in the last few
+// minutes before now
+func (p *Hosted) verify(header string, body []byte, now time.Time) error {
+	var ts string
+
+	for _, part := range strings.Split(header, ",") {
+		if v, ok := strings.CutPrefix(part, "t="); ok {
+			ts = v
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	signedAt := time.Unix(unix, 0)
+	if now.Sub(signedAt).Abs() > signatureTolerance {
+		return ErrInvalidSignature
+	}
+
+	// The header is signed the same way as the webhooks we send
+	expected := signature.Sign(p.WebhookSecret, signedAt, body)
+	if !hmac.Equal([]byte(header), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
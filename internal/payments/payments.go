@@ -0,0 +1,60 @@
+// Package payments takes payment for reservations through a provider's hosted
+// checkout page: the guest is sent there to pay, and the provider tells us
+// with a webhook once they have. There is a provider with a Stripe-style HTTP
+// API, and a fake one for local development and tests.
+package payments
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Checkout is a payment for a guest to make on the provider's checkout page
+type Checkout struct {
+	Reference   string // Our ID for the payment
+	Amount      int    // In cents
+	Currency    string // ISO 4217, e.g. USD
+	Description string
+	Email       string // The guest's, so they don't have to type it again
+	SuccessURL  string // Where the guest is sent back to once they've paid
+	CancelURL   string // Where the guest is sent back to if they give up
+}
+
+// Session is a checkout started with the provider
+type Session struct {
+	ID  string // The provider's ID for it
+	URL string // Where to send the guest to pay
+}
+
+// Event is what a provider's webhook says happened to a checkout
+type Event struct {
+	SessionID string
+	Status    string // models.PaymentPaid or models.PaymentFailed. Empty for events that don't matter
+}
+
+// Provider takes payments on a hosted checkout page
+type Provider interface {
+	// Name is stored with each payment, so it's known where to look it up
+	Name() string
+
+	// CreateCheckout starts a checkout for the guest to be sent to
+	CreateCheckout(c Checkout) (Session, error)
+
+	// Status looks up whether a checkout has been paid, as one of the
+	// models.Payment* statuses, for when the guest is back before the webhook
+	Status(sessionID string) (string, error)
+
+	// ParseWebhook checks a webhook request really came from the provider,
+	// and returns what it says happened
+	ParseWebhook(r *http.Request) (Event, error)
+}
+
+// ErrInvalidSignature is returned for webhook requests that weren't signed by
+// the provider, or were signed too long ago
+var ErrInvalidSignature = errors.New("payments: invalid webhook signature")
+
+// Deposit returns how much of total is paid up front, when the deposit is
+// percent of it. It's rounded up to the cent.
+func Deposit(total, percent int) int {
+	return (total*percent + 99) / 100
+}
@@ -0,0 +1,149 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/signature"
+)
+
+func TestDeposit(t *testing.T) {
+	tests := []struct {
+		total, percent, expected int
+	}{
+		{36000, 25, 9000},
+		{36000, 100, 36000},
+		{10001, 50, 5001}, // Rounded up
+		{36000, 0, 0},
+	}
+
+	for _, test := range tests {
+		if got := Deposit(test.total, test.percent); got != test.expected {
+			t.Errorf("Deposit(%d, %d): expected %d, got %d", test.total, test.percent, test.expected, got)
+		}
+	}
+}
+
+func TestHostedCheckout(t *testing.T) {
+	var form map[string]string
+	var auth string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/checkout/sessions":
+			r.ParseForm()
+			form = map[string]string{}
+			for k := range r.Form {
+				form[k] = r.Form.Get(k)
+			}
+
+			if r.Form.Get("amount") == "0" {
+				http.Error(w, "amount must be positive", http.StatusBadRequest)
+				return
+			}
+
+			fmt.Fprint(w, `{"id": "cs_1", "url": "https://pay.example.com/cs_1", "status": "open", "payment_status": "unpaid"}`)
+		case r.URL.Path == "/v1/checkout/sessions/cs_1":
+			fmt.Fprint(w, `{"id": "cs_1", "status": "complete", "payment_status": "paid"}`)
+		case r.URL.Path == "/v1/checkout/sessions/cs_2":
+			fmt.Fprint(w, `{"id": "cs_2", "status": "expired", "payment_status": "unpaid"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	p := NewHosted(server.URL+"/v1/", "sk_test", "whsec_test")
+
+	session, err := p.CreateCheckout(Checkout{
+		Reference:   "7",
+		Amount:      9000,
+		Currency:    "USD",
+		Description: "Deposit for reservation #3",
+		Email:       "john@smith.com",
+		SuccessURL:  "https://bnb.example.com/payments/7/return",
+		CancelURL:   "https://bnb.example.com/payments/7/cancel",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.ID != "cs_1" || session.URL != "https://pay.example.com/cs_1" {
+		t.Errorf("unexpected session: %+v", session)
+	}
+
+	if auth != "Bearer sk_test" {
+		t.Errorf("expected the API key, got %q", auth)
+	}
+
+	if form["amount"] != "9000" || form["currency"] != "usd" || form["client_reference_id"] != "7" || form["customer_email"] != "john@smith.com" {
+		t.Errorf("unexpected form: %v", form)
+	}
+
+	_, err = p.CreateCheckout(Checkout{Amount: 0})
+	if err == nil || !strings.Contains(err.Error(), "amount must be positive") {
+		t.Errorf("expected the API's error, got %v", err)
+	}
+
+	for id, expected := range map[string]string{"cs_1": models.PaymentPaid, "cs_2": models.PaymentFailed} {
+		status, err := p.Status(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status != expected {
+			t.Errorf("%s: expected %s, got %s", id, expected, status)
+		}
+	}
+}
+
+func TestHostedWebhook(t *testing.T) {
+	p := NewHosted("https://api.example.com", "sk_test", "whsec_test")
+
+	paid := `{"type": "checkout.session.completed", "data": {"object": {"id": "cs_1", "payment_status": "paid"}}}`
+	expired := `{"type": "checkout.session.expired", "data": {"object": {"id": "cs_1", "payment_status": "unpaid"}}}`
+	other := `{"type": "charge.refunded", "data": {"object": {"id": "ch_1"}}}`
+
+	tests := []struct {
+		name     string
+		body     string
+		secret   string
+		signedAt time.Time
+		expected Event
+		err      error
+	}{
+		{"paid", paid, "whsec_test", time.Now(), Event{SessionID: "cs_1", Status: models.PaymentPaid}, nil},
+		{"expired", expired, "whsec_test", time.Now(), Event{SessionID: "cs_1", Status: models.PaymentFailed}, nil},
+		{"other", other, "whsec_test", time.Now(), Event{SessionID: "ch_1"}, nil},
+		{"wrong-secret", paid, "whsec_other", time.Now(), Event{}, ErrInvalidSignature},
+		{"replayed", paid, "whsec_test", time.Now().Add(-time.Hour), Event{}, ErrInvalidSignature},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/payments/webhook", strings.NewReader(test.body))
+		req.Header.Set(HeaderSignature, signature.Sign(test.secret, test.signedAt, []byte(test.body)))
+
+		event, err := p.ParseWebhook(req)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+			continue
+		}
+
+		if event != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, event)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/webhook", strings.NewReader(paid))
+	if _, err := p.ParseWebhook(req); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected an unsigned webhook to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDocument(t *testing.T) {
+	doc := New(LetterWidth, LetterHeight)
+	doc.Title = "Invoice (1)"
+
+	doc.Text(72, 72, HelveticaBold, 18, "Go B & B")
+	doc.TextRight(540, 72, Helvetica, 10, `Total: €120.00 (paid) \ thanks`)
+	doc.Line(72, 80, 540, 80, 0.5)
+	doc.AddPage()
+	doc.Text(72, 72, Helvetica, 10, "Page two, with ✓ in it")
+
+	out := doc.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("expected a PDF header and trailer, got %q", out)
+	}
+
+	// Text is escaped and encoded as WinAnsi, with y from the bottom
+	for _, expected := range []string{
+		"/Title (Invoice \\(1\\))",
+		"/F2 18 Tf 72 720 Td (Go B & B) Tj",
+		"(Total: \\200120.00 \\(paid\\) \\\\ thanks) Tj",
+		"0.5 w 72 712 m 540 712 l S",
+		"(Page two, with ? in it) Tj",
+		"/Count 2",
+		"/BaseFont /Helvetica-Bold",
+	} {
+		if !bytes.Contains(out, []byte(expected)) {
+			t.Errorf("expected the PDF to contain %q", expected)
+		}
+	}
+
+	// startxref points at the cross-reference table, and each entry in it
+	// at its object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d doesn't point at the xref table", xref)
+	}
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != 9 {
+		t.Errorf("expected 9 objects, got %d", len(entries))
+	}
+
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if !bytes.HasPrefix(out[offset:], []byte(fmt.Sprintf("%d 0 obj\n", i+1))) {
+			t.Errorf("object %d isn't at offset %d", i+1, offset)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	tests := []struct {
+		font     Font
+		size     float64
+		s        string
+		expected float64
+	}{
+		{Helvetica, 10, "", 0},
+		{Helvetica, 10, "100", 16.68},
+		{Helvetica, 1000, "Wi", 944 + 222},
+		{HelveticaBold, 1000, "Wi", 944 + 278},
+	}
+
+	for _, test := range tests {
+		got := TextWidth(test.font, test.size, test.s)
+		if got < test.expected-0.001 || got > test.expected+0.001 {
+			t.Errorf("TextWidth(%q): expected %v, got %v", test.s, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package pricing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// currencySymbols are the symbols of the currencies that have one. Others are
+// shown with their code
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"CAD": "$",
+	"AUD": "$",
+	"EUR": "€",
+	"GBP": "£",
+}
+
+// FormatMoney formats an amount in cents, like $1,234.50
+func FormatMoney(cents int, currency string) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	// Group the whole units in threes
+	whole := strconv.Itoa(cents / 100)
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+
+	amount := fmt.Sprintf("%s.%02d", whole, cents%100)
+
+	if symbol, ok := currencySymbols[strings.ToUpper(currency)]; ok {
+		return sign + symbol + amount
+	}
+
+	return strings.TrimSpace(sign + amount + " " + strings.ToUpper(currency))
+}
+
+// FormatPercent formats a rate in hundredths of a percent, like 12.5%
+func FormatPercent(hundredths int) string {
+	s := fmt.Sprintf("%d.%02d", hundredths/100, hundredths%100)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+
+	return s + "%"
+}
+
+// ParsePercent parses a rate like 12.5 or 12.5% into hundredths of a percent
+func ParsePercent(s string) (int, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%"))
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || f > 100 {
+		return 0, fmt.Errorf("%q is not a percentage between 0 and 100", s)
+	}
+
+	return int(f*100 + 0.5), nil
+}
+
+// ParseMoney parses an amount like 25, 25.5 or 1,025.50 into cents
+func ParseMoney(s string) (int, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("%q is not an amount of money", s)
+	}
+
+	return int(f*100 + 0.5), nil
+}
@@ -0,0 +1,123 @@
+// Package pricing works out what stays cost: the room's price for each night,
+// and the fees and taxes on top of it, itemized so guests can see each one.
+package pricing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// Bases are the ways each kind of charge can be worked out
+var Bases = map[string][]string{
+	models.ChargeTax: {models.ChargePercent, models.ChargePerNight, models.ChargePerPersonNight},
+	models.ChargeFee: {models.ChargePerStay, models.ChargePerNight},
+}
+
+// Price itemizes the price of a stay in room from start to end, for guests
+// people. The room comes first, then the fees, then the taxes.
+//
+// Charges only count the nights they're in effect for, and fees charged per
+// stay are charged if they're in effect on the night of arrival. Percentage
+// taxes are charged on the room and the fees for the nights they're in effect.
+func Price(room models.Room, start, end time.Time, guests int, charges []models.Charge) []models.LineItem {
+	guests = max(guests, 1)
+
+	var nights []time.Time
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		nights = append(nights, day)
+	}
+
+	items := []models.LineItem{{
+		Kind:        models.LineItemRoom,
+		Description: room.RoomName,
+		Quantity:    len(nights),
+		UnitPrice:   room.Price,
+		Amount:      room.Price * len(nights),
+	}}
+
+	// What each night costs before tax, for percentage taxes
+	beforeTax := make([]int, len(nights))
+	for i := range beforeTax {
+		beforeTax[i] = room.Price
+	}
+
+	for _, c := range charges {
+		if c.Kind != models.ChargeFee {
+			continue
+		}
+
+		switch c.Basis {
+		case models.ChargePerStay:
+			if len(nights) > 0 && c.AppliesOn(nights[0]) {
+				beforeTax[0] += c.Amount
+				items = append(items, line(c, c.Name, 1, c.Amount))
+			}
+		case models.ChargePerNight:
+			var n int
+			for i, night := range nights {
+				if c.AppliesOn(night) {
+					beforeTax[i] += c.Amount
+					n++
+				}
+			}
+
+			if n > 0 {
+				items = append(items, line(c, c.Name+" (per night)", n, c.Amount))
+			}
+		}
+	}
+
+	for _, c := range charges {
+		if c.Kind != models.ChargeTax {
+			continue
+		}
+
+		var n, base int
+		for i, night := range nights {
+			if c.AppliesOn(night) {
+				base += beforeTax[i]
+				n++
+			}
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		switch c.Basis {
+		case models.ChargePercent:
+			// Rounded to the nearest cent
+			amount := (base*c.Amount + 5000) / 10000
+			items = append(items, line(c, fmt.Sprintf("%s (%s)", c.Name, FormatPercent(c.Amount)), 1, amount))
+		case models.ChargePerNight:
+			items = append(items, line(c, c.Name+" (per night)", n, c.Amount))
+		case models.ChargePerPersonNight:
+			items = append(items, line(c, c.Name+" (per guest per night)", n*guests, c.Amount))
+		}
+	}
+
+	return items
+}
+
+// line is a line item for a charge
+func line(c models.Charge, description string, quantity, unitPrice int) models.LineItem {
+	return models.LineItem{
+		Kind:        c.Kind,
+		Description: description,
+		Quantity:    quantity,
+		UnitPrice:   unitPrice,
+		Amount:      quantity * unitPrice,
+	}
+}
+
+// Total adds up a stay's line items
+func Total(items []models.LineItem) int {
+	var total int
+	for _, item := range items {
+		total += item.Amount
+	}
+
+	return total
+}
@@ -0,0 +1,119 @@
+package pricing
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+)
+
+// day is a date in 2050, for short test cases
+func day(month time.Month, d int) time.Time {
+	return time.Date(2050, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestPrice(t *testing.T) {
+	room := models.Room{RoomName: "General's Quarters", Price: 10000}
+
+	cleaning := models.Charge{Name: "Cleaning", Kind: models.ChargeFee, Basis: models.ChargePerStay, Amount: 5000}
+	towels := models.Charge{Name: "Towels", Kind: models.ChargeFee, Basis: models.ChargePerNight, Amount: 500}
+	occupancy := models.Charge{Name: "Occupancy tax", Kind: models.ChargeTax, Basis: models.ChargePercent, Amount: 1250}
+	city := models.Charge{Name: "City tax", Kind: models.ChargeTax, Basis: models.ChargePerPersonNight, Amount: 200}
+	levy := models.Charge{Name: "Tourism levy", Kind: models.ChargeTax, Basis: models.ChargePerNight, Amount: 100}
+
+	// A tax that goes up part way through the stay, and a fee that's over
+	oldTax := models.Charge{Name: "VAT", Kind: models.ChargeTax, Basis: models.ChargePercent, Amount: 1000, EndsOn: day(1, 1)}
+	newTax := models.Charge{Name: "VAT", Kind: models.ChargeTax, Basis: models.ChargePercent, Amount: 2000, StartsOn: day(1, 2)}
+	expired := models.Charge{Name: "Old fee", Kind: models.ChargeFee, Basis: models.ChargePerStay, Amount: 999, EndsOn: day(1, 1).AddDate(-1, 0, 0)}
+
+	roomLine := models.LineItem{Kind: models.LineItemRoom, Description: "General's Quarters", Quantity: 3, UnitPrice: 10000, Amount: 30000}
+
+	tests := []struct {
+		name     string
+		guests   int
+		charges  []models.Charge
+		expected []models.LineItem
+	}{
+		{"no-charges", 2, nil, []models.LineItem{roomLine}},
+		{
+			"fees-and-taxes", 2,
+			// Taxes listed first still come after the fees they're charged on
+			[]models.Charge{occupancy, city, levy, cleaning, towels},
+			[]models.LineItem{
+				roomLine,
+				{Kind: models.ChargeFee, Description: "Cleaning", Quantity: 1, UnitPrice: 5000, Amount: 5000},
+				{Kind: models.ChargeFee, Description: "Towels (per night)", Quantity: 3, UnitPrice: 500, Amount: 1500},
+				// 12.5% of 300 + 50 + 15
+				{Kind: models.ChargeTax, Description: "Occupancy tax (12.5%)", Quantity: 1, UnitPrice: 4563, Amount: 4563},
+				{Kind: models.ChargeTax, Description: "City tax (per guest per night)", Quantity: 6, UnitPrice: 200, Amount: 1200},
+				{Kind: models.ChargeTax, Description: "Tourism levy (per night)", Quantity: 3, UnitPrice: 100, Amount: 300},
+			},
+		},
+		{
+			"rate-change", 1,
+			[]models.Charge{oldTax, newTax, expired},
+			[]models.LineItem{
+				roomLine,
+				{Kind: models.ChargeTax, Description: "VAT (10%)", Quantity: 1, UnitPrice: 1000, Amount: 1000},
+				{Kind: models.ChargeTax, Description: "VAT (20%)", Quantity: 1, UnitPrice: 4000, Amount: 4000},
+			},
+		},
+		{
+			"no-guests-counts-as-one", 0,
+			[]models.Charge{city},
+			[]models.LineItem{
+				roomLine,
+				{Kind: models.ChargeTax, Description: "City tax (per guest per night)", Quantity: 3, UnitPrice: 200, Amount: 600},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := Price(room, day(1, 1), day(1, 4), test.guests, test.charges)
+
+		if !slices.Equal(got, test.expected) {
+			t.Errorf("%s: expected\n%+v\ngot\n%+v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestTotal(t *testing.T) {
+	items := []models.LineItem{{Amount: 30000}, {Amount: 5000}, {Amount: 4563}}
+
+	if got := Total(items); got != 39563 {
+		t.Errorf("expected 39563, got %d", got)
+	}
+}
+
+func TestFormatPercent(t *testing.T) {
+	tests := map[int]string{1250: "12.5%", 1000: "10%", 5: "0.05%", 0: "0%"}
+
+	for hundredths, expected := range tests {
+		if got := FormatPercent(hundredths); got != expected {
+			t.Errorf("FormatPercent(%d): expected %s, got %s", hundredths, expected, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	if got, err := ParsePercent(" 12.5% "); err != nil || got != 1250 {
+		t.Errorf("ParsePercent: expected 1250, got %d, %v", got, err)
+	}
+
+	if got, err := ParseMoney("1,025.5"); err != nil || got != 102550 {
+		t.Errorf("ParseMoney: expected 102550, got %d, %v", got, err)
+	}
+
+	for _, bad := range []string{"", "lots", "-1", "101"} {
+		if _, err := ParsePercent(bad); err == nil {
+			t.Errorf("ParsePercent(%q): expected an error", bad)
+		}
+	}
+
+	for _, bad := range []string{"", "lots", "-1"} {
+		if _, err := ParseMoney(bad); err == nil {
+			t.Errorf("ParseMoney(%q): expected an error", bad)
+		}
+	}
+}
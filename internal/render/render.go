@@ -12,6 +12,7 @@ import (
 
 	"github.com/BlackSound1/Go-B-and-B/internal/config"
 	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/pricing"
 	"github.com/justinas/nosurf"
 )
 
@@ -21,6 +22,8 @@ var functions = template.FuncMap{
 	"formatDate": FormatDate,
 	"iterate":    Iterate,
 	"add":        Add,
+	"money":      Money,
+	"percent":    Percent,
 }
 var pathToTemplates = "./templates"
 
@@ -29,6 +32,16 @@ func NewRenderer(a *config.AppConfig) {
 	app = a
 }
 
+// Money formats an amount in cents in the site's currency, like $1,234.50
+func Money(cents int) string {
+	return pricing.FormatMoney(cents, app.Currency)
+}
+
+// Percent formats an amount in hundredths of a percent, like 12.5%
+func Percent(hundredths int) string {
+	return pricing.FormatPercent(hundredths)
+}
+
 // Add returns the sum of a and b.
 func Add(a, b int) int {
 	return a + b
@@ -76,6 +89,11 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 		td.IsAuthenticated = 1
 	}
 
+	// Check if a guest is logged in
+	if app.Session.Exists(r.Context(), "guest_id") {
+		td.IsGuest = 1
+	}
+
 	td.CSRFToken = nosurf.Token(r)
 
 	return td
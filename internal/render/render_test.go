@@ -114,3 +114,29 @@ func getSession() (*http.Request, error) {
 
 	return r, nil
 }
+
+// TestMoney tests that amounts are formatted in the site's currency.
+func TestMoney(t *testing.T) {
+	defer func() { app.Currency = "" }()
+
+	tests := []struct {
+		currency string
+		cents    int
+		expected string
+	}{
+		{"USD", 12000, "$120.00"},
+		{"USD", 123456789, "$1,234,567.89"},
+		{"USD", 5, "$0.05"},
+		{"USD", -250, "-$2.50"},
+		{"gbp", 99900, "£999.00"},
+		{"CHF", 100050, "1,000.50 CHF"},
+	}
+
+	for _, test := range tests {
+		app.Currency = test.currency
+
+		if got := Money(test.cents); got != test.expected {
+			t.Errorf("Money(%d) in %s: expected %q, got %q", test.cents, test.currency, test.expected, got)
+		}
+	}
+}
@@ -2,11 +2,15 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -14,8 +18,14 @@ func (m *postgresDBRepo) AllUsers() bool {
 	return true
 }
 
-// InsertReservation inserts a new reservation record into the database.
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+// InsertReservation inserts a new reservation record into the database, along
+// with the room restriction that takes the room for the stay. The room is
+// locked while it's checked for availability, so two reservations can't take
+// it at once; if it's taken, repository.ErrRoomUnavailable is returned. If
+// emails is given, it's called with the new reservation's ID, and the emails it
+// returns are queued in the same transaction, so they're sent if and only if
+// the reservation is saved.
+func (m *postgresDBRepo) InsertReservation(res models.Reservation, emails func(id int) ([]models.MailData, error)) (int, error) {
 	// Allows for a 3 second timeout of the query. Needs to be able to cancel
 	// the query if it takes too long or else the connection might have been lost
 	// and data can be corrupted
@@ -23,17 +33,49 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Reservations for the room wait here until this one is committed or
+	// rolled back, then see its room restriction
+	_, err = tx.ExecContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, res.RoomID)
+	if err != nil {
+		return 0, err
+	}
+
+	var taken int
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT
+			COUNT(id)
+		FROM
+			room_restrictions
+		WHERE
+			room_id = $1 AND
+			$2 < end_date AND $3 > start_date
+	`, res.RoomID, res.StartDate, res.EndDate).Scan(&taken)
+	if err != nil {
+		return 0, err
+	}
+
+	if taken > 0 {
+		return 0, repository.ErrRoomUnavailable
+	}
+
 	var newID int
 
 	stmt := `
 		INSERT INTO 
-			reservations (first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id
+			reservations (first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at, guest_id, idempotency_key, sms_opt_in, total, guests) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, 0), NULLIF($11, ''), $12, $13, $14) returning id
 	`
 
 	// Instead of Exec(), use QueryRowContext() to allow for the 3 second timeout.
 	// Also, it allows us to return the id of the reservation
-	err := m.DB.QueryRowContext(
+	err = tx.QueryRowContext(
 		ctx,
 		stmt,
 		res.FirstName,
@@ -45,42 +87,58 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.RoomID,
 		time.Now(),
 		time.Now(),
+		res.GuestID,
+		res.IdempotencyKey,
+		res.SMSOptIn,
+		res.Total,
+		max(res.Guests, 1),
 	).Scan(&newID)
 
 	if err != nil {
 		return 0, err
 	}
 
-	return newID, nil
-}
-
-// InsertRoomRestriction inserts a new room restriction record into the database.
-func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	// The itemized price is kept as it was when booked, like the total
+	for _, item := range res.Items {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO
+				reservation_items (reservation_id, kind, description, quantity, unit_price, amount, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, newID, item.Kind, item.Description, item.Quantity, item.UnitPrice, item.Amount, time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
 
-	stmt := `
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO 
 			room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`
+	`, res.StartDate, res.EndDate, res.RoomID, newID, 1, time.Now(), time.Now())
+	if err != nil {
+		return 0, err
+	}
 
-	_, err := m.DB.ExecContext(
-		ctx,
-		stmt,
-		r.StartDate,
-		r.EndDate,
-		r.RoomID,
-		r.ReservationID,
-		r.RestrictionID,
-		time.Now(),
-		time.Now(),
-	)
+	if emails != nil {
+		mail, err := emails(newID)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, msg := range mail {
+			err = queueEmail(ctx, tx, msg)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return newID, nil
 }
 
 // SearchAvailabilityByDates takes in a start and end date and checks to see if there
@@ -188,7 +246,7 @@ func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 
 	stmt := `
 		SELECT
-			id, room_name, created_at, updated_at
+			id, room_name, min_stay, price, created_at, updated_at
 		FROM
 			rooms
 		WHERE
@@ -201,6 +259,8 @@ func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.MinStay,
+		&room.Price,
 		&room.CreatedAt,
 		&room.UpdatedAt,
 	)
@@ -218,7 +278,8 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 
 	query := `
 		SELECT 
-			id, first_name, last_name, email, password, access_level, created_at, updated_at
+			id, first_name, last_name, email, password, access_level, daily_digest, created_at, updated_at,
+			oidc_issuer, oidc_subject
 		FROM
 			users
 		WHERE
@@ -230,6 +291,85 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 	var u models.User
 
 	// Try to scan the row into the user
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.DailyDigest,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+		&u.OIDCIssuer,
+		&u.OIDCSubject,
+	)
+	if err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+// GetUserByEmail retrieves a user record from the database by their email.
+// Emails are compared case-insensitively.
+func (m *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT 
+			id, first_name, last_name, email, password, access_level, created_at, updated_at,
+			oidc_issuer, oidc_subject
+		FROM
+			users
+		WHERE
+			lower(email) = lower($1)
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, email)
+
+	var u models.User
+
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+		&u.OIDCIssuer,
+		&u.OIDCSubject,
+	)
+	if err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+// GetUserByOIDC retrieves the user who logs in with an identity provider
+// account, by the provider's issuer and the account's subject.
+func (m *postgresDBRepo) GetUserByOIDC(issuer, subject string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT 
+			id, first_name, last_name, email, password, access_level, created_at, updated_at,
+			oidc_issuer, oidc_subject
+		FROM
+			users
+		WHERE
+			oidc_issuer = $1 AND oidc_subject = $2 AND oidc_subject <> ''
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, issuer, subject)
+
+	var u models.User
+
 	err := row.Scan(
 		&u.ID,
 		&u.FirstName,
@@ -239,6 +379,8 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 		&u.AccessLevel,
 		&u.CreatedAt,
 		&u.UpdatedAt,
+		&u.OIDCIssuer,
+		&u.OIDCSubject,
 	)
 	if err != nil {
 		return u, err
@@ -247,6 +389,65 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 	return u, nil
 }
 
+// LinkUserOIDC links a user to an identity provider account, so they can log
+// in with single sign-on.
+func (m *postgresDBRepo) LinkUserOIDC(id int, issuer, subject string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE
+			users
+		SET
+			oidc_issuer = $1,
+			oidc_subject = $2,
+			updated_at = $3
+		WHERE
+			id = $4
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, issuer, subject, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertUser inserts a new user record into the database and returns its ID.
+// The password is stored as given, so it must already be hashed. Users who
+// log in with single sign-on have no password.
+func (m *postgresDBRepo) InsertUser(u models.User) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	query := `
+		INSERT INTO
+			users (first_name, last_name, email, password, access_level, created_at, updated_at,
+				oidc_issuer, oidc_subject)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id
+	`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.Password,
+		u.AccessLevel,
+		time.Now(),
+		time.Now(),
+		u.OIDCIssuer,
+		u.OIDCSubject,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
 // UpdateUser updates a user record in the database.
 func (m *postgresDBRepo) UpdateUser(u models.User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -314,6 +515,9 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	return id, hashedPassword, nil
 }
 
+// amountPaid selects how much has been paid towards the reservation r
+const amountPaid = `COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.reservation_id = r.id AND p.status = 'paid'), 0)`
+
 // AllReservations retrieves all reservations from the database.
 func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -324,7 +528,8 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 	query := `
 		SELECT
 			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
-		 	r.room_id, r.created_at, r.updated_at, r.processed, rm.id, rm.room_name
+		 	r.room_id, r.created_at, r.updated_at, r.processed,
+			COALESCE(r.cancelled_at, '0001-01-01'), r.total, ` + amountPaid + `, rm.id, rm.room_name
 		FROM 
 			reservations r
 		JOIN 
@@ -356,6 +561,9 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 			&item.CreatedAt,
 			&item.UpdatedAt,
 			&item.Processed,
+			&item.CancelledAt,
+			&item.Total,
+			&item.AmountPaid,
 			&item.Room.ID,
 			&item.Room.RoomName,
 		)
@@ -383,7 +591,7 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 	query := `
 		SELECT
 			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
-		 	r.room_id, r.created_at, r.updated_at, r.processed, rm.id, rm.room_name
+		 	r.room_id, r.created_at, r.updated_at, r.processed, r.total, ` + amountPaid + `, rm.id, rm.room_name
 		FROM 
 			reservations r
 		LEFT JOIN 
@@ -391,6 +599,7 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 				ON (r.room_id = rm.id)
 		WHERE
 			r.processed = 0
+			AND r.cancelled_at IS NULL
 		ORDER BY 
 			r.start_date ASC
 	`
@@ -417,6 +626,8 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 			&item.CreatedAt,
 			&item.UpdatedAt,
 			&item.Processed,
+			&item.Total,
+			&item.AmountPaid,
 			&item.Room.ID,
 			&item.Room.RoomName,
 		)
@@ -444,7 +655,9 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 	query := `
 		SELECT 
 			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
-			r.room_id, r.created_at, r.updated_at, r.processed, rm.id, rm.room_name
+			r.room_id, r.created_at, r.updated_at, r.processed, COALESCE(r.guest_id, 0),
+			COALESCE(r.cancelled_at, '0001-01-01'), r.sms_opt_in, r.total, ` + amountPaid + `,
+			r.guests, r.invite_sequence, rm.id, rm.room_name
 		FROM 
 			reservations r
 		LEFT JOIN 
@@ -468,6 +681,13 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		&res.CreatedAt,
 		&res.UpdatedAt,
 		&res.Processed,
+		&res.GuestID,
+		&res.CancelledAt,
+		&res.SMSOptIn,
+		&res.Total,
+		&res.AmountPaid,
+		&res.Guests,
+		&res.InviteSequence,
 		&res.Room.ID,
 		&res.Room.RoomName,
 	)
@@ -475,6 +695,70 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		return res, err
 	}
 
+	res.Items, err = m.reservationItems(ctx, res.ID)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// reservationItems retrieves a reservation's itemized price, in the order it
+// was itemized
+func (m *postgresDBRepo) reservationItems(ctx context.Context, reservationID int) ([]models.LineItem, error) {
+	var items []models.LineItem
+
+	query := `
+		SELECT kind, description, quantity, unit_price, amount
+		FROM reservation_items
+		WHERE reservation_id = $1
+		ORDER BY id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.LineItem
+
+		err := rows.Scan(&item.Kind, &item.Description, &item.Quantity, &item.UnitPrice, &item.Amount)
+		if err != nil {
+			return items, err
+		}
+
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return items, err
+	}
+
+	return items, nil
+}
+
+// GetReservationByIdempotencyKey retrieves the reservation made by the
+// submission with the given idempotency key.
+func (m *postgresDBRepo) GetReservationByIdempotencyKey(key string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+
+	err := m.DB.QueryRowContext(ctx, `SELECT id FROM reservations WHERE idempotency_key = $1`, key).Scan(&id)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	res, err := m.GetReservationByID(id)
+	if err != nil {
+		return res, err
+	}
+
+	res.IdempotencyKey = key
+
 	return res, nil
 }
 
@@ -512,6 +796,8 @@ func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
 }
 
 // DeleteReservation deletes a reservation record from the database by ID.
+// Reservations that have been invoiced or paid for can't be deleted, as their
+// invoice and payments refer to them.
 func (m *postgresDBRepo) DeleteReservation(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -526,70 +812,178 @@ func (m *postgresDBRepo) DeleteReservation(id int) error {
 	return nil
 }
 
-// UpdateProcessedForReservation updates the processed status of a reservation
-// record in the database by ID.
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
+// CancelReservation marks a reservation as cancelled and frees up its room by
+// deleting the reservation's room restrictions. The reservation itself is kept
+// for the record. It reports whether the reservation was cancelled, so only one
+// of several requests to cancel it at once sees it happen.
+func (m *postgresDBRepo) CancelReservation(id int) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
 	query := `
-		UPDATE 
+		UPDATE
 			reservations
-		SET 
-			processed = $1
-		WHERE 
+		SET
+			cancelled_at = $1,
+			updated_at = $1
+		WHERE
 			id = $2
+			AND cancelled_at IS NULL
 	`
 
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	result, err := tx.ExecContext(ctx, query, time.Now(), id)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE reservation_id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
 }
 
-// AllRooms retrieves all rooms from the database, ordered by room name.
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
+// UpdateReservationAndProcessed updates the guest's details and the processed
+// status of a reservation in one transaction, and reports whether the processed
+// status changed. Only one of several requests to change it at once sees it
+// change.
+func (m *postgresDBRepo) UpdateReservationAndProcessed(r models.Reservation) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var rooms []models.Room
-
-	query := `
-		SELECT
-			id, room_name, created_at, updated_at
-		FROM
-			rooms
-		ORDER BY
-			room_name
-	`
-
-	rows, err := m.DB.QueryContext(ctx, query)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return rooms, err
+		return false, err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var rm models.Room
-		err := rows.Scan(
-			&rm.ID,
-			&rm.RoomName,
-			&rm.CreatedAt,
-			&rm.UpdatedAt,
-		)
+	defer tx.Rollback()
 
-		if err != nil {
-			return rooms, err
-		}
+	// Other changes to the reservation wait here until this one is committed
+	var processed int
 
-		rooms = append(rooms, rm)
+	err = tx.QueryRowContext(ctx, `SELECT processed FROM reservations WHERE id = $1 FOR UPDATE`, r.ID).Scan(&processed)
+	if err != nil {
+		return false, err
 	}
 
-	if err = rows.Err(); err != nil {
-		return rooms, err
-	}
+	query := `
+		UPDATE
+			reservations
+		SET
+			first_name = $1,
+			last_name = $2,
+			email = $3,
+			phone = $4,
+			processed = $5,
+			updated_at = $6
+		WHERE
+			id = $7
+	`
+
+	_, err = tx.ExecContext(ctx, query,
+		r.FirstName,
+		r.LastName,
+		r.Email,
+		r.Phone,
+		r.Processed,
+		time.Now(),
+		r.ID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return processed != r.Processed, nil
+}
+
+// UpdateProcessedForReservation updates the processed status of a reservation,
+// and reports whether it changed. Only one of several requests to change it at
+// once sees it change.
+func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE 
+			reservations
+		SET 
+			processed = $1
+		WHERE 
+			id = $2 AND processed <> $1
+	`
+
+	result, err := m.DB.ExecContext(ctx, query, processed, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// AllRooms retrieves all rooms from the database, ordered by room name.
+func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := `
+		SELECT
+			id, room_name, min_stay, price, created_at, updated_at
+		FROM
+			rooms
+		ORDER BY
+			room_name
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rm models.Room
+		err := rows.Scan(
+			&rm.ID,
+			&rm.RoomName,
+			&rm.MinStay,
+			&rm.Price,
+			&rm.CreatedAt,
+			&rm.UpdatedAt,
+		)
+
+		if err != nil {
+			return rooms, err
+		}
+
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
 
 	return rooms, nil
 }
@@ -603,7 +997,7 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end tim
 	// coalesce lets us return 0 if there is no reservation_id
 	query := `
 		SELECT
-			id, COALESCE(reservation_id, 0), restriction_id, room_id, start_date, end_date
+			id, COALESCE(reservation_id, 0), restriction_id, room_id, start_date, end_date, updated_at
 		FROM
 			room_restrictions
 		WHERE
@@ -629,6 +1023,7 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end tim
 			&r.RoomID,
 			&r.StartDate,
 			&r.EndDate,
+			&r.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -686,3 +1081,2215 @@ func (m *postgresDBRepo) DeleteBlockByID(id int) error {
 
 	return nil
 }
+
+// InsertAccessToken inserts a new personal access token into the database and
+// returns its ID. Only the hash of the token is stored.
+func (m *postgresDBRepo) InsertAccessToken(t models.AccessToken) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	query := `
+		INSERT INTO
+			personal_access_tokens (user_id, name, token_hash, scope, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) returning id
+	`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		t.UserID,
+		t.Name,
+		t.TokenHash,
+		t.Scope,
+		t.ExpiresAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// AccessTokensForUser retrieves all personal access tokens belonging to a user,
+// newest first.
+func (m *postgresDBRepo) AccessTokensForUser(userID int) ([]models.AccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var tokens []models.AccessToken
+
+	// coalesce lets us return the zero time if the token was never used
+	query := `
+		SELECT
+			id, user_id, name, token_hash, scope, expires_at,
+			COALESCE(last_used_at, '0001-01-01'), created_at, updated_at
+		FROM
+			personal_access_tokens
+		WHERE
+			user_id = $1
+		ORDER BY
+			created_at DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return tokens, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.AccessToken
+
+		err := rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.Name,
+			&t.TokenHash,
+			&t.Scope,
+			&t.ExpiresAt,
+			&t.LastUsedAt,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+		)
+		if err != nil {
+			return tokens, err
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return tokens, err
+	}
+
+	return tokens, nil
+}
+
+// SessionsForUser retrieves the unexpired sessions a user is logged in with,
+// most recently used first.
+func (m *postgresDBRepo) SessionsForUser(userID int) ([]models.UserSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sessions []models.UserSession
+
+	// coalesce lets us return the zero time if it was never recorded
+	query := `
+		SELECT
+			token, user_id, ip, user_agent,
+			COALESCE(logged_in_at, '0001-01-01'), COALESCE(last_seen, '0001-01-01')
+		FROM
+			sessions
+		WHERE
+			user_id = $1 AND current_timestamp < expiry
+		ORDER BY
+			last_seen DESC NULLS LAST
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return sessions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.UserSession
+
+		err := rows.Scan(
+			&s.Token,
+			&s.UserID,
+			&s.IP,
+			&s.UserAgent,
+			&s.LoggedInAt,
+			&s.LastSeen,
+		)
+		if err != nil {
+			return sessions, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return sessions, err
+	}
+
+	return sessions, nil
+}
+
+// GetAccessTokenByHash retrieves a personal access token by the hash of the
+// plain token. It is up to the caller to check whether the token has expired.
+func (m *postgresDBRepo) GetAccessTokenByHash(hash string) (models.AccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t models.AccessToken
+
+	query := `
+		SELECT
+			id, user_id, name, token_hash, scope, expires_at,
+			COALESCE(last_used_at, '0001-01-01'), created_at, updated_at
+		FROM
+			personal_access_tokens
+		WHERE
+			token_hash = $1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, hash)
+
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.TokenHash,
+		&t.Scope,
+		&t.ExpiresAt,
+		&t.LastUsedAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	if err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+// UpdateAccessTokenLastUsed records that a personal access token was just used.
+func (m *postgresDBRepo) UpdateAccessTokenLastUsed(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE
+			personal_access_tokens
+		SET
+			last_used_at = $1
+		WHERE
+			id = $2
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteAccessToken revokes a personal access token. The user ID is part of
+// the query so users can only revoke their own tokens.
+func (m *postgresDBRepo) DeleteAccessToken(id, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertGuest inserts a new guest account into the database and returns its ID.
+// The password is hashed before it is stored. Invited guests have no password
+// until they accept their invitation. Emails are stored in lower case, as
+// they're unique whatever their case.
+func (m *postgresDBRepo) InsertGuest(g models.Guest) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	password := ""
+	if g.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(g.Password), 12)
+		if err != nil {
+			return 0, err
+		}
+
+		password = string(hashedPassword)
+	}
+
+	// Only invited guests have an invitation expiry
+	expiresAt := sql.NullTime{Time: g.InviteExpiresAt, Valid: !g.InviteExpiresAt.IsZero()}
+
+	var newID int
+
+	query := `
+		INSERT INTO
+			guests (first_name, last_name, email, phone, password, invite_token_hash,
+				invite_expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id
+	`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		g.FirstName,
+		g.LastName,
+		strings.ToLower(g.Email),
+		g.Phone,
+		password,
+		g.InviteTokenHash,
+		expiresAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// guestColumns are the columns scanned by scanGuest, in order
+const guestColumns = `
+	id, first_name, last_name, email, phone, password, invite_token_hash,
+	COALESCE(invite_expires_at, '0001-01-01'), created_at, updated_at,
+	COALESCE(email_verified_at, '0001-01-01'), pending_email, email_token_hash,
+	COALESCE(email_token_expires_at, '0001-01-01')
+`
+
+// scanGuest scans a row selected with guestColumns into a guest.
+func scanGuest(row *sql.Row) (models.Guest, error) {
+	var g models.Guest
+
+	err := row.Scan(
+		&g.ID,
+		&g.FirstName,
+		&g.LastName,
+		&g.Email,
+		&g.Phone,
+		&g.Password,
+		&g.InviteTokenHash,
+		&g.InviteExpiresAt,
+		&g.CreatedAt,
+		&g.UpdatedAt,
+		&g.EmailVerifiedAt,
+		&g.PendingEmail,
+		&g.EmailTokenHash,
+		&g.EmailTokenExpiresAt,
+	)
+
+	return g, err
+}
+
+// GetGuestByID retrieves a guest account from the database by ID.
+func (m *postgresDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + guestColumns + ` FROM guests WHERE id = $1`
+
+	return scanGuest(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetGuestByEmail retrieves a guest account from the database by email.
+// Emails are compared case-insensitively.
+func (m *postgresDBRepo) GetGuestByEmail(email string) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + guestColumns + ` FROM guests WHERE lower(email) = lower($1)`
+
+	return scanGuest(m.DB.QueryRowContext(ctx, query, email))
+}
+
+// GetGuestByInviteHash retrieves a guest account by the hash of its invitation
+// token. It is up to the caller to check whether the invitation has expired.
+func (m *postgresDBRepo) GetGuestByInviteHash(hash string) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + guestColumns + ` FROM guests WHERE invite_token_hash = $1 AND invite_token_hash <> ''`
+
+	return scanGuest(m.DB.QueryRowContext(ctx, query, hash))
+}
+
+// UpdateGuest updates a guest's contact details in the database. Their email
+// is only changed by VerifyGuestEmail.
+func (m *postgresDBRepo) UpdateGuest(g models.Guest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE
+			guests
+		SET
+			first_name = $1,
+			last_name = $2,
+			phone = $3,
+			updated_at = $4
+		WHERE
+			id = $5
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		g.FirstName,
+		g.LastName,
+		g.Phone,
+		time.Now(),
+		g.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateGuestInvite replaces a guest's invitation token, e.g. when an invitation
+// is sent again.
+func (m *postgresDBRepo) UpdateGuestInvite(id int, hash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE
+			guests
+		SET
+			invite_token_hash = $1,
+			invite_expires_at = $2,
+			updated_at = $3
+		WHERE
+			id = $4
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, hash, expiresAt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetGuestPassword hashes and sets a guest's password. Any outstanding
+// invitation is used up, so the invitation link can't be followed again.
+// Invitations are emailed, so following one verifies the guest's email, and
+// their stays booked under it are linked to the account.
+func (m *postgresDBRepo) SetGuestPassword(id int, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE
+			guests
+		SET
+			password = $1,
+			invite_token_hash = '',
+			invite_expires_at = NULL,
+			email_verified_at = COALESCE(email_verified_at, $2),
+			updated_at = $2
+		WHERE
+			id = $3
+	`
+
+	_, err = tx.ExecContext(ctx, query, string(hashedPassword), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	err = claimGuestReservations(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateGuestEmailToken sets the email a guest wants to verify, in lower case,
+// and the hash of the token emailed to it. Any earlier token stops working.
+func (m *postgresDBRepo) UpdateGuestEmailToken(id int, email, hash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE
+			guests
+		SET
+			pending_email = $1,
+			email_token_hash = $2,
+			email_token_expires_at = $3,
+			updated_at = $4
+		WHERE
+			id = $5
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, strings.ToLower(email), hash, expiresAt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetGuestByEmailTokenHash retrieves a guest account by the hash of the token
+// sent to verify its pending email. It is up to the caller to check whether
+// the token has expired.
+func (m *postgresDBRepo) GetGuestByEmailTokenHash(hash string) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + guestColumns + ` FROM guests WHERE email_token_hash = $1 AND email_token_hash <> ''`
+
+	return scanGuest(m.DB.QueryRowContext(ctx, query, hash))
+}
+
+// VerifyGuestEmail makes a guest's pending email their verified email, as long
+// as it's still the one waiting to be verified. Stays booked under it that
+// aren't linked to an account are linked to this one.
+func (m *postgresDBRepo) VerifyGuestEmail(id int, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE
+			guests
+		SET
+			email = pending_email,
+			pending_email = '',
+			email_token_hash = '',
+			email_token_expires_at = NULL,
+			email_verified_at = $1,
+			updated_at = $1
+		WHERE
+			id = $2 AND pending_email = $3 AND pending_email <> ''
+	`
+
+	result, err := tx.ExecContext(ctx, query, time.Now(), id, email)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = claimGuestReservations(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// claimGuestReservations links the reservations booked under a guest's email
+// that aren't linked to an account yet to the guest. It's only called once the
+// guest has verified their email.
+func claimGuestReservations(ctx context.Context, tx *sql.Tx, id int) error {
+	query := `
+		UPDATE
+			reservations r
+		SET
+			guest_id = g.id
+		FROM
+			guests g
+		WHERE
+			g.id = $1 AND r.guest_id IS NULL AND lower(r.email) = lower(g.email)
+	`
+
+	_, err := tx.ExecContext(ctx, query, id)
+
+	return err
+}
+
+// AuthenticateGuest verifies a guest's credentials by checking the email and
+// password, and returns the guest's ID.
+func (m *postgresDBRepo) AuthenticateGuest(email, testPassword string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, "SELECT id, password FROM guests WHERE lower(email) = lower($1)", email)
+
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	// Invited guests who haven't set a password yet can't log in
+	if hashedPassword == "" {
+		return 0, errors.New("guest has not set a password")
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, errors.New("incorrect password")
+	} else if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// ReservationsForGuest retrieves all reservations linked to a guest account,
+// latest stay first.
+func (m *postgresDBRepo) ReservationsForGuest(guestID int) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		SELECT
+			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.created_at, r.updated_at, r.processed, r.guest_id,
+			COALESCE(r.cancelled_at, '0001-01-01'), r.total, ` + amountPaid + `, rm.id, rm.room_name
+		FROM
+			reservations r
+		JOIN
+			rooms rm
+				ON (r.room_id = rm.id)
+		WHERE
+			r.guest_id = $1
+		ORDER BY
+			r.start_date DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, guestID)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.Reservation
+
+		err := rows.Scan(
+			&item.ID,
+			&item.FirstName,
+			&item.LastName,
+			&item.Email,
+			&item.Phone,
+			&item.StartDate,
+			&item.EndDate,
+			&item.RoomID,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+			&item.Processed,
+			&item.GuestID,
+			&item.CancelledAt,
+			&item.Total,
+			&item.AmountPaid,
+			&item.Room.ID,
+			&item.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// AllICalFeeds retrieves all imported calendar feeds, along with their room's
+// name, ordered by room name and then feed name.
+func (m *postgresDBRepo) AllICalFeeds() ([]models.ICalFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var feeds []models.ICalFeed
+
+	// coalesce lets us return the zero time if the feed was never synced
+	query := `
+		SELECT
+			f.id, f.room_id, f.name, f.url, COALESCE(f.last_synced_at, '0001-01-01'),
+			f.last_error, f.conflicts, f.created_at, f.updated_at, r.room_name
+		FROM
+			room_ical_feeds f
+			LEFT JOIN rooms r ON (f.room_id = r.id)
+		ORDER BY
+			r.room_name, f.name
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return feeds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f models.ICalFeed
+
+		err := rows.Scan(
+			&f.ID,
+			&f.RoomID,
+			&f.Name,
+			&f.URL,
+			&f.LastSyncedAt,
+			&f.LastError,
+			&f.Conflicts,
+			&f.CreatedAt,
+			&f.UpdatedAt,
+			&f.Room.RoomName,
+		)
+		if err != nil {
+			return feeds, err
+		}
+
+		f.Room.ID = f.RoomID
+		feeds = append(feeds, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return feeds, err
+	}
+
+	return feeds, nil
+}
+
+// GetICalFeedByID retrieves an imported calendar feed by ID.
+func (m *postgresDBRepo) GetICalFeedByID(id int) (models.ICalFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var f models.ICalFeed
+
+	query := `
+		SELECT
+			f.id, f.room_id, f.name, f.url, COALESCE(f.last_synced_at, '0001-01-01'),
+			f.last_error, f.conflicts, f.created_at, f.updated_at, r.room_name
+		FROM
+			room_ical_feeds f
+			LEFT JOIN rooms r ON (f.room_id = r.id)
+		WHERE
+			f.id = $1
+	`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&f.ID,
+		&f.RoomID,
+		&f.Name,
+		&f.URL,
+		&f.LastSyncedAt,
+		&f.LastError,
+		&f.Conflicts,
+		&f.CreatedAt,
+		&f.UpdatedAt,
+		&f.Room.RoomName,
+	)
+	if err != nil {
+		return f, err
+	}
+
+	f.Room.ID = f.RoomID
+
+	return f, nil
+}
+
+// InsertICalFeed inserts a new calendar feed to import, and returns its ID.
+func (m *postgresDBRepo) InsertICalFeed(f models.ICalFeed) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	query := `
+		INSERT INTO
+			room_ical_feeds (room_id, name, url, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5) returning id
+	`
+
+	err := m.DB.QueryRowContext(ctx, query, f.RoomID, f.Name, f.URL, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteICalFeed deletes an imported calendar feed. Its blocks are deleted
+// along with it.
+func (m *postgresDBRepo) DeleteICalFeed(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM room_ical_feeds WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateICalFeedStatus records the outcome of the last sync of a calendar feed.
+func (m *postgresDBRepo) UpdateICalFeedStatus(f models.ICalFeed) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE
+			room_ical_feeds
+		SET
+			last_synced_at = $1,
+			last_error = $2,
+			conflicts = $3,
+			updated_at = $4
+		WHERE
+			id = $5
+	`
+
+	lastSynced := sql.NullTime{Time: f.LastSyncedAt, Valid: !f.LastSyncedAt.IsZero()}
+
+	_, err := m.DB.ExecContext(ctx, query, lastSynced, f.LastError, f.Conflicts, time.Now(), f.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SyncExternalBlocks makes a feed's external blocks match blocks, which are
+// matched up with the existing ones by ExternalUID. New blocks are inserted,
+// ones whose dates changed are updated, and ones that are gone are deleted.
+// It's all or nothing, so a failed sync leaves the old blocks in place.
+func (m *postgresDBRepo) SyncExternalBlocks(feed models.ICalFeed, blocks []models.RoomRestriction) (created, updated, removed int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			id, external_uid, start_date, end_date
+		FROM
+			room_restrictions
+		WHERE
+			external_feed_id = $1
+	`, feed.ID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	existing := map[string]models.RoomRestriction{}
+
+	for rows.Next() {
+		var r models.RoomRestriction
+
+		err := rows.Scan(&r.ID, &r.ExternalUID, &r.StartDate, &r.EndDate)
+		if err != nil {
+			rows.Close()
+			return 0, 0, 0, err
+		}
+
+		existing[r.ExternalUID] = r
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	now := time.Now()
+
+	for _, b := range blocks {
+		old, ok := existing[b.ExternalUID]
+		delete(existing, b.ExternalUID)
+
+		switch {
+		case !ok:
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO
+					room_restrictions (start_date, end_date, room_id, restriction_id, external_feed_id, external_uid, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			`, b.StartDate, b.EndDate, feed.RoomID, models.RestrictionExternal, feed.ID, b.ExternalUID, now, now)
+			created++
+		case !old.StartDate.Equal(b.StartDate) || !old.EndDate.Equal(b.EndDate):
+			_, err = tx.ExecContext(ctx, `
+				UPDATE
+					room_restrictions
+				SET
+					start_date = $1,
+					end_date = $2,
+					updated_at = $3
+				WHERE
+					id = $4
+			`, b.StartDate, b.EndDate, now, old.ID)
+			updated++
+		}
+
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	for _, old := range existing {
+		_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE id = $1`, old.ID)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		removed++
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return created, updated, removed, nil
+}
+
+// AllWebhooks retrieves all webhooks, oldest first.
+func (m *postgresDBRepo) AllWebhooks() ([]models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var webhooks []models.Webhook
+
+	query := `
+		SELECT
+			id, url, secret, events, created_at, updated_at
+		FROM
+			webhooks
+		ORDER BY
+			created_at
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return webhooks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var wh models.Webhook
+
+		err := rows.Scan(&wh.ID, &wh.URL, &wh.Secret, &wh.Events, &wh.CreatedAt, &wh.UpdatedAt)
+		if err != nil {
+			return webhooks, err
+		}
+
+		webhooks = append(webhooks, wh)
+	}
+
+	if err = rows.Err(); err != nil {
+		return webhooks, err
+	}
+
+	return webhooks, nil
+}
+
+// GetWebhookByID retrieves a webhook by ID.
+func (m *postgresDBRepo) GetWebhookByID(id int) (models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var wh models.Webhook
+
+	query := `
+		SELECT
+			id, url, secret, events, created_at, updated_at
+		FROM
+			webhooks
+		WHERE
+			id = $1
+	`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&wh.ID, &wh.URL, &wh.Secret, &wh.Events, &wh.CreatedAt, &wh.UpdatedAt)
+	if err != nil {
+		return wh, err
+	}
+
+	return wh, nil
+}
+
+// InsertWebhook inserts a new webhook, and returns its ID.
+func (m *postgresDBRepo) InsertWebhook(wh models.Webhook) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	query := `
+		INSERT INTO
+			webhooks (url, secret, events, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5) returning id
+	`
+
+	err := m.DB.QueryRowContext(ctx, query, wh.URL, wh.Secret, wh.Events, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteWebhook deletes a webhook, along with its deliveries.
+func (m *postgresDBRepo) DeleteWebhook(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertWebhookDeliveries queues an event to be delivered to every webhook
+// subscribed to it. Webhooks with no events listed get every event.
+func (m *postgresDBRepo) InsertWebhookDeliveries(event string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO
+			webhook_deliveries (webhook_id, event, payload, status, next_attempt_at, created_at, updated_at)
+		SELECT
+			id, $1, $2, $3, $4, $4, $4
+		FROM
+			webhooks
+		WHERE
+			events = '' OR ',' || events || ',' LIKE '%,' || $1 || ',%'
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, event, string(payload), models.DeliveryPending, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// webhookDeliveryColumns are the columns scanned by scanWebhookDelivery
+const webhookDeliveryColumns = `
+	d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+	d.response_status, d.last_error, COALESCE(d.delivered_at, '0001-01-01'), d.created_at, d.updated_at,
+	w.url, w.secret
+`
+
+// scanWebhookDelivery scans a row of webhookDeliveryColumns
+func scanWebhookDelivery(rows *sql.Rows) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+
+	err := rows.Scan(
+		&d.ID,
+		&d.WebhookID,
+		&d.Event,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.NextAttemptAt,
+		&d.ResponseStatus,
+		&d.LastError,
+		&d.DeliveredAt,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+		&d.Webhook.URL,
+		&d.Webhook.Secret,
+	)
+
+	d.Webhook.ID = d.WebhookID
+
+	return d, err
+}
+
+// queryWebhookDeliveries runs a query selecting webhookDeliveryColumns
+func (m *postgresDBRepo) queryWebhookDeliveries(query string, args ...any) ([]models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var deliveries []models.WebhookDelivery
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return deliveries, err
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return deliveries, err
+	}
+
+	return deliveries, nil
+}
+
+// ClaimDueWebhookDeliveries claims up to limit pending deliveries that are
+// due to be attempted, oldest first, and returns them along with their
+// webhook's URL and secret. Claiming pushes their next attempt back by lease,
+// so other dispatchers skip them while they're being posted, and they're
+// tried again if this one dies before recording the outcome.
+func (m *postgresDBRepo) ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	query := `
+		WITH d AS (
+			UPDATE
+				webhook_deliveries
+			SET
+				next_attempt_at = $1,
+				updated_at = $2
+			WHERE
+				id IN (
+					SELECT
+						id
+					FROM
+						webhook_deliveries
+					WHERE
+						status = $3 AND next_attempt_at <= $2
+					ORDER BY
+						next_attempt_at, id
+					LIMIT $4
+					FOR UPDATE SKIP LOCKED
+				)
+			RETURNING *
+		)
+		SELECT ` + webhookDeliveryColumns + `
+		FROM
+			d
+			LEFT JOIN webhooks w ON (d.webhook_id = w.id)
+		ORDER BY
+			d.id
+	`
+
+	now := time.Now()
+
+	return m.queryWebhookDeliveries(query, now.Add(lease), now, models.DeliveryPending, limit)
+}
+
+// WebhookDeliveriesForWebhook retrieves a webhook's most recent deliveries,
+// newest first.
+func (m *postgresDBRepo) WebhookDeliveriesForWebhook(webhookID, limit int) ([]models.WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM
+			webhook_deliveries d
+			LEFT JOIN webhooks w ON (d.webhook_id = w.id)
+		WHERE
+			d.webhook_id = $1
+		ORDER BY
+			d.created_at DESC, d.id DESC
+		LIMIT $2
+	`
+
+	return m.queryWebhookDeliveries(query, webhookID, limit)
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt at a delivery.
+func (m *postgresDBRepo) UpdateWebhookDelivery(d models.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE
+			webhook_deliveries
+		SET
+			status = $1,
+			attempts = $2,
+			next_attempt_at = $3,
+			response_status = $4,
+			last_error = $5,
+			delivered_at = $6,
+			updated_at = $7
+		WHERE
+			id = $8
+	`
+
+	deliveredAt := sql.NullTime{Time: d.DeliveredAt, Valid: !d.DeliveredAt.IsZero()}
+
+	_, err := m.DB.ExecContext(ctx, query,
+		d.Status,
+		d.Attempts,
+		d.NextAttemptAt,
+		d.ResponseStatus,
+		d.LastError,
+		deliveredAt,
+		time.Now(),
+		d.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RedeliverWebhookDelivery queues a webhook's delivery to be sent again, as a
+// new delivery so the log keeps the old one. Returns sql.ErrNoRows if the
+// delivery doesn't belong to the webhook.
+func (m *postgresDBRepo) RedeliverWebhookDelivery(webhookID, deliveryID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO
+			webhook_deliveries (webhook_id, event, payload, status, next_attempt_at, created_at, updated_at)
+		SELECT
+			webhook_id, event, payload, $1, $2, $2, $2
+		FROM
+			webhook_deliveries
+		WHERE
+			id = $3 AND webhook_id = $4
+	`
+
+	result, err := m.DB.ExecContext(ctx, query, models.DeliveryPending, time.Now(), deliveryID, webhookID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// execer is a database connection or transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// queueEmail adds an email to the outbox, due to be sent straight away
+func queueEmail(ctx context.Context, db execer, msg models.MailData) error {
+	query := `
+		INSERT INTO
+			emails (to_address, from_address, subject, html_body, text_body, attachments, status, next_attempt_at, created_at, updated_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $8, $8)
+	`
+
+	// Attachments are kept as JSON, which is plenty for the odd small file
+	attachments, err := json.Marshal(msg.Attachments)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, query, msg.To, msg.From, msg.Subject, msg.HTML, msg.Text, string(attachments), models.EmailPending, time.Now())
+
+	return err
+}
+
+// QueueEmail adds an email to the outbox, to be sent in the background.
+func (m *postgresDBRepo) QueueEmail(msg models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return queueEmail(ctx, m.DB, msg)
+}
+
+// emailColumns are the columns scanEmail expects, in order
+const emailColumns = `
+	id, to_address, from_address, subject, html_body, text_body, attachments, status,
+	attempts, next_attempt_at, last_error, COALESCE(sent_at, '0001-01-01'), created_at, updated_at
+`
+
+// queryEmails runs a query selecting emailColumns
+func (m *postgresDBRepo) queryEmails(query string, args ...any) ([]models.Email, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var emails []models.Email
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return emails, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.Email
+		var attachments string
+
+		err := rows.Scan(
+			&e.ID,
+			&e.Mail.To,
+			&e.Mail.From,
+			&e.Mail.Subject,
+			&e.Mail.HTML,
+			&e.Mail.Text,
+			&attachments,
+			&e.Status,
+			&e.Attempts,
+			&e.NextAttemptAt,
+			&e.LastError,
+			&e.SentAt,
+			&e.CreatedAt,
+			&e.UpdatedAt,
+		)
+		if err != nil {
+			return emails, err
+		}
+
+		err = json.Unmarshal([]byte(attachments), &e.Mail.Attachments)
+		if err != nil {
+			return emails, err
+		}
+
+		emails = append(emails, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return emails, err
+	}
+
+	return emails, nil
+}
+
+// ClaimDueEmails claims up to limit pending emails that are due to be sent,
+// oldest first, and returns them. Claiming pushes their next attempt back by
+// lease, so other workers skip them while they're being sent, and they're
+// tried again if this one dies before recording the outcome.
+func (m *postgresDBRepo) ClaimDueEmails(limit int, lease time.Duration) ([]models.Email, error) {
+	query := `
+		UPDATE
+			emails
+		SET
+			next_attempt_at = $1,
+			updated_at = $2
+		WHERE
+			id IN (
+				SELECT
+					id
+				FROM
+					emails
+				WHERE
+					status = $3 AND next_attempt_at <= $2
+				ORDER BY
+					next_attempt_at, id
+				LIMIT $4
+				FOR UPDATE SKIP LOCKED
+			)
+		RETURNING ` + emailColumns
+
+	now := time.Now()
+
+	return m.queryEmails(query, now.Add(lease), now, models.EmailPending, limit)
+}
+
+// RecentEmails retrieves the most recent emails, newest first. If status
+// isn't empty, only emails with that status are included.
+func (m *postgresDBRepo) RecentEmails(status string, limit int) ([]models.Email, error) {
+	query := `
+		SELECT ` + emailColumns + `
+		FROM
+			emails
+		WHERE
+			$1 = '' OR status = $1
+		ORDER BY
+			created_at DESC, id DESC
+		LIMIT $2
+	`
+
+	return m.queryEmails(query, status, limit)
+}
+
+// UpdateEmail records the outcome of an attempt at sending an email.
+func (m *postgresDBRepo) UpdateEmail(e models.Email) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE
+			emails
+		SET
+			status = $1,
+			attempts = $2,
+			next_attempt_at = $3,
+			last_error = $4,
+			sent_at = $5,
+			updated_at = $6
+		WHERE
+			id = $7
+	`
+
+	sentAt := sql.NullTime{Time: e.SentAt, Valid: !e.SentAt.IsZero()}
+
+	_, err := m.DB.ExecContext(ctx, query,
+		e.Status,
+		e.Attempts,
+		e.NextAttemptAt,
+		e.LastError,
+		sentAt,
+		time.Now(),
+		e.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ResendEmail queues an email to be sent again, as a new email so the log
+// keeps the old one. Returns sql.ErrNoRows if there is no such email.
+func (m *postgresDBRepo) ResendEmail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO
+			emails (to_address, from_address, subject, html_body, text_body, attachments, status, next_attempt_at, created_at, updated_at)
+		SELECT
+			to_address, from_address, subject, html_body, text_body, attachments, $1, $2, $2, $2
+		FROM
+			emails
+		WHERE
+			id = $3
+	`
+
+	result, err := m.DB.ExecContext(ctx, query, models.EmailPending, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ReservationsArrivingBetween retrieves the reservations that start from start
+// to end, inclusive, and haven't been sent the scheduled email kind. Cancelled
+// reservations are left out.
+func (m *postgresDBRepo) ReservationsArrivingBetween(kind string, start, end time.Time) ([]models.Reservation, error) {
+	return m.reservationsForScheduledEmail("r.start_date", kind, start, end)
+}
+
+// ReservationsLeavingBetween retrieves the reservations that end from start to
+// end, inclusive, and haven't been sent the scheduled email kind. Cancelled
+// reservations are left out.
+func (m *postgresDBRepo) ReservationsLeavingBetween(kind string, start, end time.Time) ([]models.Reservation, error) {
+	return m.reservationsForScheduledEmail("r.end_date", kind, start, end)
+}
+
+// reservationsForScheduledEmail retrieves the reservations with dateColumn
+// from start to end that haven't been sent the scheduled email kind
+func (m *postgresDBRepo) reservationsForScheduledEmail(dateColumn, kind string, start, end time.Time) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		SELECT
+			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.created_at, r.updated_at, r.processed, r.sms_opt_in, rm.id, rm.room_name
+		FROM
+			reservations r
+		LEFT JOIN
+			rooms rm
+				ON (r.room_id = rm.id)
+		WHERE
+			` + dateColumn + ` BETWEEN $1 AND $2
+			AND r.cancelled_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1 FROM reservation_emails e WHERE e.reservation_id = r.id AND e.kind = $3
+			)
+		ORDER BY
+			` + dateColumn + `, r.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, kind)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.Reservation
+
+		err := rows.Scan(
+			&item.ID,
+			&item.FirstName,
+			&item.LastName,
+			&item.Email,
+			&item.Phone,
+			&item.StartDate,
+			&item.EndDate,
+			&item.RoomID,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+			&item.Processed,
+			&item.SMSOptIn,
+			&item.Room.ID,
+			&item.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// QueueScheduledEmail queues a scheduled email for a reservation, and records
+// that it was sent. Returns false, and queues nothing, if that kind of email
+// was already sent for the reservation.
+func (m *postgresDBRepo) QueueScheduledEmail(reservationID int, kind string, msg models.MailData) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO
+			reservation_emails (reservation_id, kind, created_at, updated_at)
+		VALUES
+			($1, $2, $3, $3)
+		ON CONFLICT (reservation_id, kind) DO NOTHING
+	`, reservationID, kind, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if n == 0 {
+		return false, nil
+	}
+
+	err = queueEmail(ctx, tx, msg)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// QueueSMS adds a text message to the outbox, to be sent in the background.
+func (m *postgresDBRepo) QueueSMS(to, body string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO
+			sms_messages (to_number, body, status, next_attempt_at, created_at, updated_at)
+		VALUES
+			($1, $2, $3, $4, $4, $4)
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, to, body, models.EmailPending, time.Now())
+
+	return err
+}
+
+// DueSMS retrieves up to limit pending text messages that are due to be
+// attempted, oldest first.
+func (m *postgresDBRepo) DueSMS(limit int) ([]models.SMS, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var messages []models.SMS
+
+	query := `
+		SELECT
+			id, to_number, body, status, attempts, next_attempt_at, last_error,
+			COALESCE(sent_at, '0001-01-01'), created_at, updated_at
+		FROM
+			sms_messages
+		WHERE
+			status = $1 AND next_attempt_at <= $2
+		ORDER BY
+			next_attempt_at, id
+		LIMIT $3
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.EmailPending, time.Now(), limit)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.SMS
+
+		err := rows.Scan(
+			&s.ID,
+			&s.To,
+			&s.Body,
+			&s.Status,
+			&s.Attempts,
+			&s.NextAttemptAt,
+			&s.LastError,
+			&s.SentAt,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+		)
+		if err != nil {
+			return messages, err
+		}
+
+		messages = append(messages, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, err
+	}
+
+	return messages, nil
+}
+
+// UpdateSMS records the outcome of an attempt at sending a text message.
+func (m *postgresDBRepo) UpdateSMS(s models.SMS) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE
+			sms_messages
+		SET
+			status = $1,
+			attempts = $2,
+			next_attempt_at = $3,
+			last_error = $4,
+			sent_at = $5,
+			updated_at = $6
+		WHERE
+			id = $7
+	`
+
+	sentAt := sql.NullTime{Time: s.SentAt, Valid: !s.SentAt.IsZero()}
+
+	_, err := m.DB.ExecContext(ctx, query,
+		s.Status,
+		s.Attempts,
+		s.NextAttemptAt,
+		s.LastError,
+		sentAt,
+		time.Now(),
+		s.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// NextInviteSequence bumps the sequence number of a reservation's calendar
+// invite, and returns the new one. Each update or cancellation of the invite
+// needs a higher number than the last, or calendars ignore it.
+func (m *postgresDBRepo) NextInviteSequence(id int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sequence int
+
+	query := `
+		UPDATE
+			reservations
+		SET
+			invite_sequence = invite_sequence + 1
+		WHERE
+			id = $1
+		RETURNING
+			invite_sequence
+	`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&sequence)
+	if err != nil {
+		return 0, err
+	}
+
+	return sequence, nil
+}
+
+// UpdateDailyDigest turns the daily digest email on or off for a user
+func (m *postgresDBRepo) UpdateDailyDigest(userID int, on bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE
+			users
+		SET
+			daily_digest = $1,
+			updated_at = $2
+		WHERE
+			id = $3
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, on, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DigestRecipients gets the users who opted in to the daily digest and haven't
+// been sent the one for day yet
+func (m *postgresDBRepo) DigestRecipients(day time.Time) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var users []models.User
+
+	query := `
+		SELECT
+			id, first_name, last_name, email, access_level
+		FROM
+			users
+		WHERE
+			daily_digest = true
+			AND (digest_sent_on IS NULL OR digest_sent_on < $1)
+		ORDER BY
+			id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, day)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u := models.User{DailyDigest: true}
+
+		err := rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.AccessLevel,
+		)
+		if err != nil {
+			return users, err
+		}
+
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+// QueueDigest queues a user's daily digest for day, and records that it was
+// sent in the same transaction. It reports false, and queues nothing, if they
+// already got the one for day.
+func (m *postgresDBRepo) QueueDigest(userID int, day time.Time, msg models.MailData) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE
+			users
+		SET
+			digest_sent_on = $1
+		WHERE
+			id = $2
+			AND (digest_sent_on IS NULL OR digest_sent_on < $1)
+	`, day, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if n == 0 {
+		return false, nil
+	}
+
+	err = queueEmail(ctx, tx, msg)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// GetDigest gets the overview of bookings for the daily digest: arrivals,
+// departures and guests staying on day, reservations made and cancelled since
+// since, unprocessed reservations, and blocked days from day until blocksUntil.
+func (m *postgresDBRepo) GetDigest(day, since, blocksUntil time.Time) (models.Digest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var digest models.Digest
+
+	lists := []struct {
+		dst   *[]models.Reservation
+		where string
+		args  []any
+	}{
+		{&digest.Arrivals, "r.start_date = $1 AND r.cancelled_at IS NULL", []any{day}},
+		{&digest.Departures, "r.end_date = $1 AND r.cancelled_at IS NULL", []any{day}},
+		{&digest.InHouse, "r.start_date < $1 AND r.end_date > $1 AND r.cancelled_at IS NULL", []any{day}},
+		{&digest.NewBookings, "r.created_at >= $1", []any{since}},
+		{&digest.Cancellations, "r.cancelled_at >= $1", []any{since}},
+		{&digest.Unprocessed, "r.processed = 0 AND r.cancelled_at IS NULL", nil},
+	}
+
+	for _, list := range lists {
+		reservations, err := m.digestReservations(ctx, list.where, list.args...)
+		if err != nil {
+			return digest, err
+		}
+
+		*list.dst = reservations
+	}
+
+	query := `
+		SELECT
+			rr.id, rr.restriction_id, rr.room_id, rr.start_date, rr.end_date, rm.id, rm.room_name
+		FROM
+			room_restrictions rr
+		LEFT JOIN
+			rooms rm
+				ON (rr.room_id = rm.id)
+		WHERE
+			rr.restriction_id <> $1
+			AND rr.end_date > $2 AND rr.start_date <= $3
+		ORDER BY
+			rr.start_date, rm.room_name
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.RestrictionReservation, day, blocksUntil)
+	if err != nil {
+		return digest, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+
+		err := rows.Scan(
+			&r.ID,
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.Room.ID,
+			&r.Room.RoomName,
+		)
+		if err != nil {
+			return digest, err
+		}
+
+		digest.Blocks = append(digest.Blocks, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return digest, err
+	}
+
+	return digest, nil
+}
+
+// digestReservations gets the reservations, with their rooms, that match a
+// condition for the daily digest
+func (m *postgresDBRepo) digestReservations(ctx context.Context, where string, args ...any) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	query := `
+		SELECT
+			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.created_at, r.updated_at, r.processed, COALESCE(r.cancelled_at, '0001-01-01'),
+			rm.id, rm.room_name
+		FROM
+			reservations r
+		LEFT JOIN
+			rooms rm
+				ON (r.room_id = rm.id)
+		WHERE
+			` + where + `
+		ORDER BY
+			r.start_date, r.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.Reservation
+
+		err := rows.Scan(
+			&item.ID,
+			&item.FirstName,
+			&item.LastName,
+			&item.Email,
+			&item.Phone,
+			&item.StartDate,
+			&item.EndDate,
+			&item.RoomID,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+			&item.Processed,
+			&item.CancelledAt,
+			&item.Room.ID,
+			&item.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// paymentColumns are the columns of a payment, in the order scanPayment
+// expects them
+const paymentColumns = `
+	id, reservation_id, provider, provider_ref, checkout_url, kind, amount, currency, status,
+	COALESCE(paid_at, '0001-01-01'), created_at, updated_at
+`
+
+// scanner is a row, or rows being iterated over
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanPayment scans a row of paymentColumns into a payment
+func scanPayment(row scanner) (models.Payment, error) {
+	var p models.Payment
+
+	err := row.Scan(
+		&p.ID,
+		&p.ReservationID,
+		&p.Provider,
+		&p.ProviderRef,
+		&p.CheckoutURL,
+		&p.Kind,
+		&p.Amount,
+		&p.Currency,
+		&p.Status,
+		&p.PaidAt,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+
+	return p, err
+}
+
+// InsertPayment adds a payment for a reservation, and returns its ID
+func (m *postgresDBRepo) InsertPayment(p models.Payment) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+
+	query := `
+		INSERT INTO
+			payments (reservation_id, provider, provider_ref, checkout_url, kind, amount, currency, status, created_at, updated_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		RETURNING
+			id
+	`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		p.ReservationID,
+		p.Provider,
+		p.ProviderRef,
+		p.CheckoutURL,
+		p.Kind,
+		p.Amount,
+		p.Currency,
+		p.Status,
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// UpdatePayment saves a payment's checkout and status
+func (m *postgresDBRepo) UpdatePayment(p models.Payment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE
+			payments
+		SET
+			provider_ref = $1,
+			checkout_url = $2,
+			status = $3,
+			paid_at = $4,
+			updated_at = $5
+		WHERE
+			id = $6
+	`
+
+	paidAt := sql.NullTime{Time: p.PaidAt, Valid: !p.PaidAt.IsZero()}
+
+	_, err := m.DB.ExecContext(ctx, query,
+		p.ProviderRef,
+		p.CheckoutURL,
+		p.Status,
+		paidAt,
+		time.Now(),
+		p.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPaymentByID gets a payment by its ID
+func (m *postgresDBRepo) GetPaymentByID(id int) (models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, `SELECT `+paymentColumns+` FROM payments WHERE id = $1`, id)
+
+	return scanPayment(row)
+}
+
+// GetPaymentByProviderRef gets the payment for a provider's checkout
+func (m *postgresDBRepo) GetPaymentByProviderRef(provider, ref string) (models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE provider = $1 AND provider_ref = $2`
+
+	return scanPayment(m.DB.QueryRowContext(ctx, query, provider, ref))
+}
+
+// PaymentsForReservation gets the payments for a reservation, oldest first
+func (m *postgresDBRepo) PaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var payments []models.Payment
+
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE reservation_id = $1 ORDER BY created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return payments, err
+		}
+
+		payments = append(payments, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return payments, err
+	}
+
+	return payments, nil
+}
+
+// IssueInvoice returns the invoice for a reservation, giving it the next
+// invoice number the first time. The number is only taken from the sequence
+// when the reservation has no invoice yet, so numbers aren't skipped.
+func (m *postgresDBRepo) IssueInvoice(reservationID int) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var inv models.Invoice
+
+	stmt := `
+		INSERT INTO invoices (reservation_id, number, issued_at, created_at, updated_at)
+		SELECT $1, nextval('invoice_numbers'), $2, $2, $2
+		WHERE NOT EXISTS (SELECT 1 FROM invoices WHERE reservation_id = $1)
+		ON CONFLICT (reservation_id) DO NOTHING
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, reservationID, time.Now())
+	if err != nil {
+		return inv, err
+	}
+
+	query := `SELECT id, reservation_id, number, issued_at FROM invoices WHERE reservation_id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, reservationID)
+	err = row.Scan(&inv.ID, &inv.ReservationID, &inv.Number, &inv.IssuedAt)
+	if err != nil {
+		return inv, err
+	}
+
+	return inv, nil
+}
+
+// InvoiceForReservation returns the invoice issued for a reservation. It
+// returns sql.ErrNoRows if none has been issued yet.
+func (m *postgresDBRepo) InvoiceForReservation(reservationID int) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var inv models.Invoice
+
+	query := `SELECT id, reservation_id, number, issued_at FROM invoices WHERE reservation_id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, reservationID)
+	err := row.Scan(&inv.ID, &inv.ReservationID, &inv.Number, &inv.IssuedAt)
+	if err != nil {
+		return inv, err
+	}
+
+	return inv, nil
+}
+
+// AllCharges retrieves every tax and fee, including ones no longer in effect,
+// fees first
+func (m *postgresDBRepo) AllCharges() ([]models.Charge, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var charges []models.Charge
+
+	query := `
+		SELECT
+			id, name, kind, basis, amount, COALESCE(starts_on, '0001-01-01'),
+			COALESCE(ends_on, '0001-01-01'), created_at, updated_at
+		FROM
+			charges
+		ORDER BY
+			kind, name, starts_on NULLS FIRST, id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return charges, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.Charge
+
+		err := rows.Scan(&c.ID, &c.Name, &c.Kind, &c.Basis, &c.Amount, &c.StartsOn, &c.EndsOn, &c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return charges, err
+		}
+
+		charges = append(charges, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return charges, err
+	}
+
+	return charges, nil
+}
+
+// InsertCharge adds a tax or fee, and returns its ID
+func (m *postgresDBRepo) InsertCharge(c models.Charge) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	query := `
+		INSERT INTO
+			charges (name, kind, basis, amount, starts_on, ends_on, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) returning id
+	`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		c.Name,
+		c.Kind,
+		c.Basis,
+		c.Amount,
+		sql.NullTime{Time: c.StartsOn, Valid: !c.StartsOn.IsZero()},
+		sql.NullTime{Time: c.EndsOn, Valid: !c.EndsOn.IsZero()},
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteCharge deletes a tax or fee. Stays already booked keep what they
+// were charged.
+func (m *postgresDBRepo) DeleteCharge(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM charges WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
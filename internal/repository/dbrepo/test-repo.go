@@ -1,30 +1,54 @@
 package dbrepo
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/BlackSound1/Go-B-and-B/internal/helpers"
 	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/repository"
 )
 
 func (m *testDBRepo) AllUsers() bool {
 	return true
 }
 
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *testDBRepo) InsertReservation(res models.Reservation, emails func(id int) ([]models.MailData, error)) (int, error) {
 	// if the room id is 2, then fail; otherwise, pass
 	if res.RoomID == 2 {
 		return 0, errors.New("some error)")
 	}
-	return 1, nil
-}
 
-func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	if r.RoomID == 1000 {
-		return errors.New("some error")
+	// Rooms are taken on the same dates as for availability searches
+	available, err := m.SearchAvailabilityByDatesByRoomID(res.StartDate, res.EndDate, res.RoomID)
+	if err != nil {
+		return 0, err
 	}
-	return nil
+
+	if !available {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	// Build the emails, so problems rendering them show up, and send them
+	// straight away as if the outbox had
+	if emails != nil {
+		msgs, err := emails(1)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, msg := range msgs {
+			if err := m.QueueEmail(msg); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return 1, nil
 }
 
 func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
@@ -98,21 +122,79 @@ func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 
 	var room models.Room
 
+	// Simulate case where looking the room up fails
+	if id == 99 {
+		return room, errors.New("some error")
+	}
+
 	// Simulate case where room is not found
 	if id > 2 {
-		return room, errors.New("some error")
+		return room, sql.ErrNoRows
+	}
+
+	room.ID = id
+	room.Price = 12000
+
+	// Room 2 has a minimum stay
+	if id == 2 {
+		room.MinStay = 3
 	}
 
 	return room, nil
 }
 
 func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
+	if id == ssoUser.ID {
+		return ssoUser, nil
+	}
 
-	var u models.User
+	u := models.User{ID: id}
 
 	return u, nil
 }
 
+// Users known to the test repo, besides the one with ID 1 who logs in with
+// a password
+var (
+	ssoUser         = models.User{ID: 3, Email: "sso@example.com", AccessLevel: 1, OIDCIssuer: "http://sso.test", OIDCSubject: "sso-subject"}
+	unlinkedSSOUser = models.User{ID: 4, Email: "unlinked@example.com", AccessLevel: 1}
+)
+
+func (m *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	switch email {
+	case "staff@example.com":
+		return models.User{ID: 1, Email: email, Password: "hashed", AccessLevel: 1}, nil
+	case ssoUser.Email:
+		return ssoUser, nil
+	case unlinkedSSOUser.Email:
+		return unlinkedSSOUser, nil
+	}
+
+	return models.User{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) GetUserByOIDC(issuer, subject string) (models.User, error) {
+	if subject == ssoUser.OIDCSubject {
+		return ssoUser, nil
+	}
+
+	return models.User{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) LinkUserOIDC(id int, issuer, subject string) error {
+
+	return nil
+}
+
+func (m *testDBRepo) InsertUser(u models.User) (int, error) {
+	// Simulate a failed insert
+	if u.FirstName == "fail" {
+		return 0, errors.New("some error")
+	}
+
+	return 2, nil
+}
+
 func (m *testDBRepo) UpdateUser(u models.User) error {
 
 	return nil
@@ -143,7 +225,55 @@ func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
 
 func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
 
-	var res models.Reservation
+	res := models.Reservation{
+		ID:         id,
+		FirstName:  "John",
+		LastName:   "Smith",
+		Email:      "john@smith.com",
+		RoomID:     1,
+		GuestID:    1,
+		Total:      24200,
+		AmountPaid: 9000,
+		Guests:     2,
+
+		InviteSequence: 2,
+		Items: []models.LineItem{
+			{Kind: models.LineItemRoom, Description: "General's Quarters", Quantity: 2, UnitPrice: 10000, Amount: 20000},
+			{Kind: models.ChargeFee, Description: "Cleaning", Quantity: 1, UnitPrice: 2000, Amount: 2000},
+			{Kind: models.ChargeTax, Description: "Occupancy tax (10%)", Quantity: 1, UnitPrice: 2200, Amount: 2200},
+		},
+	}
+
+	// Simulate a reservation that doesn't exist
+	if id == 100 {
+		return res, sql.ErrNoRows
+	}
+
+	// Simulate a reservation that was already cancelled
+	if id == 101 {
+		res.CancelledAt = time.Now()
+	}
+
+	return res, nil
+}
+
+func (m *testDBRepo) GetReservationByIdempotencyKey(key string) (models.Reservation, error) {
+	// Simulate a form or API request that was already submitted once
+	if key != helpers.IdempotencyKey("web", "replayed-key") && key != helpers.IdempotencyKey("api", "replayed-key") {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+
+	res := models.Reservation{
+		ID:             1,
+		FirstName:      "John",
+		LastName:       "Smith",
+		Email:          "john@smith.com",
+		StartDate:      time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:        time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+		RoomID:         1,
+		Room:           models.Room{ID: 1, RoomName: "General's Quarters"},
+		IdempotencyKey: key,
+	}
 
 	return res, nil
 }
@@ -158,9 +288,41 @@ func (m *testDBRepo) DeleteReservation(id int) error {
 	return nil
 }
 
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
+func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) (bool, error) {
+	// Simulate a reservation that was already processed
+	if id == 104 {
+		return false, nil
+	}
 
-	return nil
+	return true, nil
+}
+
+func (m *testDBRepo) UpdateReservationAndProcessed(r models.Reservation) (bool, error) {
+	// Simulate a failed update
+	if r.ID == 102 {
+		return false, errors.New("some error")
+	}
+
+	// Simulate a reservation that was already processed
+	if r.ID == 104 {
+		return r.Processed != 1, nil
+	}
+
+	return true, nil
+}
+
+func (m *testDBRepo) CancelReservation(id int) (bool, error) {
+	// Simulate a failed cancellation
+	if id == 102 {
+		return false, errors.New("some error")
+	}
+
+	// Simulate a reservation another request has just cancelled
+	if id == 105 {
+		return false, nil
+	}
+
+	return true, nil
 }
 
 func (m *testDBRepo) AllRooms() ([]models.Room, error) {
@@ -174,6 +336,38 @@ func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Ti
 
 	var restrictions []models.RoomRestriction
 
+	// Room 2 has a reservation for the nights of 2050-01-10 and 11, is
+	// blocked for the night of 2050-01-20, and was booked on another site for
+	// the nights of 2050-01-25 and 26
+	if roomID == 2 {
+		restrictions = append(restrictions,
+			models.RoomRestriction{
+				ID:            1,
+				StartDate:     time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+				EndDate:       time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC),
+				RoomID:        2,
+				ReservationID: 1,
+				RestrictionID: 1,
+			},
+			models.RoomRestriction{
+				ID:            2,
+				StartDate:     time.Date(2050, 1, 20, 0, 0, 0, 0, time.UTC),
+				EndDate:       time.Date(2050, 1, 21, 0, 0, 0, 0, time.UTC),
+				RoomID:        2,
+				RestrictionID: 2,
+			},
+			models.RoomRestriction{
+				ID:             3,
+				StartDate:      time.Date(2050, 1, 25, 0, 0, 0, 0, time.UTC),
+				EndDate:        time.Date(2050, 1, 27, 0, 0, 0, 0, time.UTC),
+				RoomID:         2,
+				RestrictionID:  models.RestrictionExternal,
+				ExternalFeedID: 1,
+				ExternalUID:    "abc@example.com",
+			},
+		)
+	}
+
 	return restrictions, nil
 }
 
@@ -186,3 +380,506 @@ func (m *testDBRepo) DeleteBlockByID(id int) error {
 
 	return nil
 }
+
+func (m *testDBRepo) InsertAccessToken(t models.AccessToken) (int, error) {
+	// Simulate a failed insert
+	if t.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+
+	return 1, nil
+}
+
+func (m *testDBRepo) SessionsForUser(userID int) ([]models.UserSession, error) {
+	// Simulate a user logged in on another device
+	if userID != 1 {
+		return nil, nil
+	}
+
+	return []models.UserSession{
+		{Token: "other-device", UserID: 1, IP: "203.0.113.7", UserAgent: "Firefox", LoggedInAt: time.Now(), LastSeen: time.Now()},
+	}, nil
+}
+
+func (m *testDBRepo) AccessTokensForUser(userID int) ([]models.AccessToken, error) {
+
+	var tokens []models.AccessToken
+
+	return tokens, nil
+}
+
+func (m *testDBRepo) GetAccessTokenByHash(hash string) (models.AccessToken, error) {
+	var t models.AccessToken
+
+	// Tokens are looked up by their hash, so hash the known test tokens
+	switch hash {
+	case helpers.HashToken("gbb_read"):
+		t = models.AccessToken{ID: 1, UserID: 1, Scope: models.ScopeRead, ExpiresAt: time.Now().Add(time.Hour)}
+	case helpers.HashToken("gbb_write"):
+		t = models.AccessToken{ID: 2, UserID: 1, Scope: models.ScopeWrite, ExpiresAt: time.Now().Add(time.Hour)}
+	case helpers.HashToken("gbb_expired"):
+		t = models.AccessToken{ID: 3, UserID: 1, Scope: models.ScopeWrite, ExpiresAt: time.Now().Add(-time.Hour)}
+	default:
+		return t, sql.ErrNoRows
+	}
+
+	return t, nil
+}
+
+func (m *testDBRepo) UpdateAccessTokenLastUsed(id int) error {
+
+	return nil
+}
+
+func (m *testDBRepo) DeleteAccessToken(id, userID int) error {
+
+	return nil
+}
+
+// Guests known to the test repo
+var (
+	registeredGuest = models.Guest{ID: 1, FirstName: "Reg", LastName: "Istered", Email: "registered@guest.test", Password: "hashed", EmailVerifiedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	invitedGuest    = models.Guest{ID: 2, FirstName: "Inv", LastName: "Ited", Email: "invited@guest.test"}
+
+	// Registered before emails were verified
+	unverifiedGuest = models.Guest{ID: 4, FirstName: "Un", LastName: "Verified", Email: "unverified@guest.test", Password: "hashed"}
+)
+
+func (m *testDBRepo) InsertGuest(g models.Guest) (int, error) {
+	// Simulate a failed insert
+	if g.FirstName == "fail" {
+		return 0, errors.New("some error")
+	}
+
+	return 3, nil
+}
+
+func (m *testDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	switch id {
+	case registeredGuest.ID:
+		return registeredGuest, nil
+	case invitedGuest.ID:
+		return invitedGuest, nil
+	case unverifiedGuest.ID:
+		return unverifiedGuest, nil
+	}
+
+	return models.Guest{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) GetGuestByEmail(email string) (models.Guest, error) {
+	switch email {
+	case registeredGuest.Email:
+		return registeredGuest, nil
+	case invitedGuest.Email:
+		return invitedGuest, nil
+	case unverifiedGuest.Email:
+		return unverifiedGuest, nil
+	case "fail@guest.test":
+		return models.Guest{}, errors.New("some error")
+	}
+
+	return models.Guest{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) GetGuestByInviteHash(hash string) (models.Guest, error) {
+	g := invitedGuest
+
+	switch hash {
+	case helpers.HashToken("valid-invite"):
+		g.InviteExpiresAt = time.Now().Add(time.Hour)
+	case helpers.HashToken("expired-invite"):
+		g.InviteExpiresAt = time.Now().Add(-time.Hour)
+	default:
+		return models.Guest{}, sql.ErrNoRows
+	}
+
+	return g, nil
+}
+
+func (m *testDBRepo) UpdateGuest(g models.Guest) error {
+	// Simulate a failed update
+	if g.FirstName == "fail" {
+		return errors.New("some error")
+	}
+
+	return nil
+}
+
+func (m *testDBRepo) UpdateGuestInvite(id int, hash string, expiresAt time.Time) error {
+
+	return nil
+}
+
+func (m *testDBRepo) SetGuestPassword(id int, password string) error {
+
+	return nil
+}
+
+func (m *testDBRepo) UpdateGuestEmailToken(id int, email, hash string, expiresAt time.Time) error {
+
+	return nil
+}
+
+func (m *testDBRepo) GetGuestByEmailTokenHash(hash string) (models.Guest, error) {
+	g := registeredGuest
+	g.EmailTokenExpiresAt = time.Now().Add(time.Hour)
+
+	switch hash {
+	case helpers.HashToken("valid-email-token"):
+		g.PendingEmail = "new@guest.test"
+	case helpers.HashToken("taken-email-token"):
+		g.PendingEmail = "taken@guest.test"
+	case helpers.HashToken("expired-email-token"):
+		g.PendingEmail = "new@guest.test"
+		g.EmailTokenExpiresAt = time.Now().Add(-time.Hour)
+	default:
+		return models.Guest{}, sql.ErrNoRows
+	}
+
+	return g, nil
+}
+
+func (m *testDBRepo) VerifyGuestEmail(id int, email string) error {
+	// Simulate another account having taken the email since
+	if email == "taken@guest.test" {
+		return errors.New("some error")
+	}
+
+	return nil
+}
+
+func (m *testDBRepo) AuthenticateGuest(email, testPassword string) (int, error) {
+	if email == registeredGuest.Email && testPassword == "password" {
+		return registeredGuest.ID, nil
+	}
+
+	return 0, errors.New("some error")
+}
+
+func (m *testDBRepo) ReservationsForGuest(guestID int) ([]models.Reservation, error) {
+	// One past and one upcoming stay
+	res := []models.Reservation{
+		{ID: 1, GuestID: guestID, StartDate: time.Now().AddDate(0, 0, 10), EndDate: time.Now().AddDate(0, 0, 12), Total: 24000},
+		{ID: 2, GuestID: guestID, StartDate: time.Now().AddDate(0, 0, -12), EndDate: time.Now().AddDate(0, 0, -10), Total: 24000, AmountPaid: 24000},
+	}
+
+	return res, nil
+}
+
+// The imported calendar feed known to the test repo. Nothing listens on its
+// URL, so syncing it fails.
+var testICalFeed = models.ICalFeed{ID: 1, RoomID: 1, Name: "Example channel", URL: "http://127.0.0.1:1/feed.ics", Room: models.Room{ID: 1, RoomName: "General's Quarters"}}
+
+func (m *testDBRepo) AllICalFeeds() ([]models.ICalFeed, error) {
+
+	return []models.ICalFeed{testICalFeed}, nil
+}
+
+func (m *testDBRepo) GetICalFeedByID(id int) (models.ICalFeed, error) {
+	if id != testICalFeed.ID {
+		return models.ICalFeed{}, sql.ErrNoRows
+	}
+
+	return testICalFeed, nil
+}
+
+func (m *testDBRepo) InsertICalFeed(f models.ICalFeed) (int, error) {
+	// Simulate a failed insert
+	if f.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+
+	return 2, nil
+}
+
+func (m *testDBRepo) DeleteICalFeed(id int) error {
+
+	return nil
+}
+
+func (m *testDBRepo) UpdateICalFeedStatus(f models.ICalFeed) error {
+
+	return nil
+}
+
+func (m *testDBRepo) SyncExternalBlocks(feed models.ICalFeed, blocks []models.RoomRestriction) (created, updated, removed int, err error) {
+
+	return len(blocks), 0, 0, nil
+}
+
+// The webhook known to the test repo
+var testWebhook = models.Webhook{ID: 1, URL: "https://hooks.example.com/bnb", Secret: "whsec_test", Events: "reservation.created,block.added"}
+
+func (m *testDBRepo) AllWebhooks() ([]models.Webhook, error) {
+
+	return []models.Webhook{testWebhook}, nil
+}
+
+func (m *testDBRepo) GetWebhookByID(id int) (models.Webhook, error) {
+	if id != testWebhook.ID {
+		return models.Webhook{}, sql.ErrNoRows
+	}
+
+	return testWebhook, nil
+}
+
+func (m *testDBRepo) InsertWebhook(wh models.Webhook) (int, error) {
+	// Simulate a failed insert
+	if strings.Contains(wh.URL, "fail") {
+		return 0, errors.New("some error")
+	}
+
+	return 2, nil
+}
+
+func (m *testDBRepo) DeleteWebhook(id int) error {
+
+	return nil
+}
+
+func (m *testDBRepo) InsertWebhookDeliveries(event string, payload []byte) error {
+
+	return nil
+}
+
+func (m *testDBRepo) ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+
+	return nil, nil
+}
+
+func (m *testDBRepo) WebhookDeliveriesForWebhook(webhookID, limit int) ([]models.WebhookDelivery, error) {
+	deliveries := []models.WebhookDelivery{
+		{ID: 1, WebhookID: webhookID, Event: "reservation.created", Payload: "{}", Status: models.DeliveryDelivered, Attempts: 1, ResponseStatus: 200, DeliveredAt: time.Now()},
+		{ID: 2, WebhookID: webhookID, Event: "block.added", Payload: "{}", Status: models.DeliveryPending, Attempts: 2, ResponseStatus: 500, LastError: "receiver returned 500", NextAttemptAt: time.Now().Add(time.Minute)},
+	}
+
+	return deliveries, nil
+}
+
+func (m *testDBRepo) UpdateWebhookDelivery(d models.WebhookDelivery) error {
+
+	return nil
+}
+
+func (m *testDBRepo) RedeliverWebhookDelivery(webhookID, deliveryID int) error {
+	// Simulate a delivery that doesn't exist
+	if deliveryID == 100 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// QueueEmail sends the email straight away, through the app's mailer if it
+// has one, as if the outbox had sent it
+func (m *testDBRepo) QueueEmail(msg models.MailData) error {
+	if m.App == nil || m.App.Mailer == nil {
+		return nil
+	}
+
+	return m.App.Mailer.Send(msg)
+}
+
+func (m *testDBRepo) ClaimDueEmails(limit int, lease time.Duration) ([]models.Email, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) RecentEmails(status string, limit int) ([]models.Email, error) {
+	emails := []models.Email{
+		{
+			ID:        1,
+			Mail:      models.MailData{To: "john@smith.com", From: "me@here.com", Subject: "Reservation Confirmation", Text: "Your stay is booked"},
+			Status:    models.EmailSent,
+			Attempts:  1,
+			SentAt:    time.Now(),
+			CreatedAt: time.Now(),
+		},
+		{
+			ID:            2,
+			Mail:          models.MailData{To: "jane@smith.com", From: "me@here.com", Subject: "Reservation Confirmation", Text: "Your stay is booked"},
+			Status:        models.EmailFailed,
+			Attempts:      8,
+			NextAttemptAt: time.Now(),
+			LastError:     "mailer: connecting to localhost:1025: connection refused",
+			CreatedAt:     time.Now(),
+		},
+	}
+
+	if status == "" {
+		return emails, nil
+	}
+
+	var filtered []models.Email
+	for _, e := range emails {
+		if e.Status == status {
+			filtered = append(filtered, e)
+		}
+	}
+
+	return filtered, nil
+}
+
+func (m *testDBRepo) UpdateEmail(e models.Email) error {
+	return nil
+}
+
+func (m *testDBRepo) ResendEmail(id int) error {
+	// Simulate an email that doesn't exist
+	if id == 100 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (m *testDBRepo) ReservationsArrivingBetween(kind string, start, end time.Time) ([]models.Reservation, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) ReservationsLeavingBetween(kind string, start, end time.Time) ([]models.Reservation, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) QueueScheduledEmail(reservationID int, kind string, msg models.MailData) (bool, error) {
+	return true, m.QueueEmail(msg)
+}
+
+// QueueSMS sends the text message straight away, through the app's provider
+// if it has one, as if the outbox had sent it
+func (m *testDBRepo) QueueSMS(to, body string) error {
+	if m.App == nil || m.App.SMS == nil {
+		return nil
+	}
+
+	return m.App.SMS.Send(to, body)
+}
+
+func (m *testDBRepo) DueSMS(limit int) ([]models.SMS, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) UpdateSMS(s models.SMS) error {
+	return nil
+}
+
+func (m *testDBRepo) NextInviteSequence(id int) (int, error) {
+	// Simulate a reservation that doesn't exist
+	if id == 100 {
+		return 0, sql.ErrNoRows
+	}
+
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdateDailyDigest(userID int, on bool) error {
+	return nil
+}
+
+func (m *testDBRepo) DigestRecipients(day time.Time) ([]models.User, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) QueueDigest(userID int, day time.Time, msg models.MailData) (bool, error) {
+	return true, m.QueueEmail(msg)
+}
+
+func (m *testDBRepo) GetDigest(day, since, blocksUntil time.Time) (models.Digest, error) {
+	return models.Digest{}, nil
+}
+
+func (m *testDBRepo) InsertPayment(p models.Payment) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdatePayment(p models.Payment) error {
+	return nil
+}
+
+func (m *testDBRepo) GetPaymentByID(id int) (models.Payment, error) {
+	// Simulate a payment that doesn't exist
+	if id == 100 {
+		return models.Payment{}, sql.ErrNoRows
+	}
+
+	return models.Payment{
+		ID:            id,
+		ReservationID: 1,
+		Provider:      "fake",
+		ProviderRef:   fmt.Sprintf("fake_%d", id),
+		Kind:          models.PaymentDeposit,
+		Amount:        9000,
+		Currency:      "USD",
+		Status:        models.PaymentPending,
+	}, nil
+}
+
+func (m *testDBRepo) GetPaymentByProviderRef(provider, ref string) (models.Payment, error) {
+	var id int
+
+	_, err := fmt.Sscanf(ref, "fake_%d", &id)
+	if provider != "fake" || err != nil {
+		return models.Payment{}, sql.ErrNoRows
+	}
+
+	return m.GetPaymentByID(id)
+}
+
+func (m *testDBRepo) PaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	// Simulate a reservation nothing has been paid for
+	if reservationID == 5 {
+		return nil, nil
+	}
+
+	p, err := m.GetPaymentByID(1)
+	p.ReservationID = reservationID
+	p.Status = models.PaymentPaid
+
+	return []models.Payment{p}, err
+}
+
+func (m *testDBRepo) IssueInvoice(reservationID int) (models.Invoice, error) {
+	// Simulate a failure to issue one
+	if reservationID == 102 {
+		return models.Invoice{}, errors.New("some error")
+	}
+
+	return models.Invoice{ID: 1, ReservationID: reservationID, Number: 42, IssuedAt: time.Now()}, nil
+}
+
+func (m *testDBRepo) InvoiceForReservation(reservationID int) (models.Invoice, error) {
+	switch reservationID {
+	case 1:
+		// Simulate a reservation that has been invoiced
+		return models.Invoice{ID: 1, ReservationID: reservationID, Number: 42, IssuedAt: time.Now()}, nil
+	case 102:
+		// Simulate a failure to look it up
+		return models.Invoice{}, errors.New("some error")
+	}
+
+	return models.Invoice{}, sql.ErrNoRows
+}
+
+// The taxes and fees known to the test repo: a cleaning fee, and a tax on the
+// room and the fee
+var testCharges = []models.Charge{
+	{ID: 1, Name: "Cleaning", Kind: models.ChargeFee, Basis: models.ChargePerStay, Amount: 5000},
+	{ID: 2, Name: "Occupancy tax", Kind: models.ChargeTax, Basis: models.ChargePercent, Amount: 1000},
+}
+
+func (m *testDBRepo) AllCharges() ([]models.Charge, error) {
+	return testCharges, nil
+}
+
+func (m *testDBRepo) InsertCharge(c models.Charge) (int, error) {
+	// Simulate a failed insert
+	if c.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+
+	return 3, nil
+}
+
+func (m *testDBRepo) DeleteCharge(id int) error {
+	return nil
+}
@@ -1,29 +1,101 @@
 package repository
 
 import (
+	"errors"
 	"time"
 
 	"github.com/BlackSound1/Go-B-and-B/internal/models"
 )
 
+// ErrRoomUnavailable is returned when a reservation is made for a room that's
+// already taken for some of the stay
+var ErrRoomUnavailable = errors.New("repository: room is not available for those dates")
+
 type DatabaseRepo interface {
 	AllUsers() bool
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
+	InsertReservation(res models.Reservation, emails func(id int) ([]models.MailData, error)) (int, error)
 	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
 	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
 	GetRoomByID(id int) (models.Room, error)
 	GetUserByID(id int) (models.User, error)
+	GetUserByEmail(email string) (models.User, error)
+	GetUserByOIDC(issuer, subject string) (models.User, error)
+	LinkUserOIDC(id int, issuer, subject string) error
+	InsertUser(u models.User) (int, error)
 	UpdateUser(u models.User) error
 	Authenticate(email, testPassword string) (int, string, error)
 	AllReservations() ([]models.Reservation, error)
 	AllNewReservations() ([]models.Reservation, error)
 	GetReservationByID(id int) (models.Reservation, error)
+	GetReservationByIdempotencyKey(key string) (models.Reservation, error)
 	UpdateReservation(r models.Reservation) error
 	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
+	UpdateProcessedForReservation(id, processed int) (bool, error)
+	UpdateReservationAndProcessed(r models.Reservation) (bool, error)
+	CancelReservation(id int) (bool, error)
 	AllRooms() ([]models.Room, error)
 	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
 	InsertBlockForRoom(id int, startDate time.Time) error
 	DeleteBlockByID(id int) error
+	InsertAccessToken(t models.AccessToken) (int, error)
+	AccessTokensForUser(userID int) ([]models.AccessToken, error)
+	SessionsForUser(userID int) ([]models.UserSession, error)
+	GetAccessTokenByHash(hash string) (models.AccessToken, error)
+	UpdateAccessTokenLastUsed(id int) error
+	DeleteAccessToken(id, userID int) error
+	InsertGuest(g models.Guest) (int, error)
+	GetGuestByID(id int) (models.Guest, error)
+	GetGuestByEmail(email string) (models.Guest, error)
+	GetGuestByInviteHash(hash string) (models.Guest, error)
+	UpdateGuest(g models.Guest) error
+	UpdateGuestInvite(id int, hash string, expiresAt time.Time) error
+	SetGuestPassword(id int, password string) error
+	UpdateGuestEmailToken(id int, email, hash string, expiresAt time.Time) error
+	GetGuestByEmailTokenHash(hash string) (models.Guest, error)
+	VerifyGuestEmail(id int, email string) error
+	AuthenticateGuest(email, testPassword string) (int, error)
+	ReservationsForGuest(guestID int) ([]models.Reservation, error)
+	AllICalFeeds() ([]models.ICalFeed, error)
+	GetICalFeedByID(id int) (models.ICalFeed, error)
+	InsertICalFeed(f models.ICalFeed) (int, error)
+	DeleteICalFeed(id int) error
+	UpdateICalFeedStatus(f models.ICalFeed) error
+	SyncExternalBlocks(feed models.ICalFeed, blocks []models.RoomRestriction) (created, updated, removed int, err error)
+	AllWebhooks() ([]models.Webhook, error)
+	GetWebhookByID(id int) (models.Webhook, error)
+	InsertWebhook(wh models.Webhook) (int, error)
+	DeleteWebhook(id int) error
+	InsertWebhookDeliveries(event string, payload []byte) error
+	ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	WebhookDeliveriesForWebhook(webhookID, limit int) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(d models.WebhookDelivery) error
+	RedeliverWebhookDelivery(webhookID, deliveryID int) error
+	QueueEmail(msg models.MailData) error
+	ClaimDueEmails(limit int, lease time.Duration) ([]models.Email, error)
+	RecentEmails(status string, limit int) ([]models.Email, error)
+	UpdateEmail(e models.Email) error
+	ResendEmail(id int) error
+	ReservationsArrivingBetween(kind string, start, end time.Time) ([]models.Reservation, error)
+	ReservationsLeavingBetween(kind string, start, end time.Time) ([]models.Reservation, error)
+	QueueScheduledEmail(reservationID int, kind string, msg models.MailData) (bool, error)
+	QueueSMS(to, body string) error
+	NextInviteSequence(id int) (int, error)
+	DueSMS(limit int) ([]models.SMS, error)
+	UpdateSMS(s models.SMS) error
+	UpdateDailyDigest(userID int, on bool) error
+	DigestRecipients(day time.Time) ([]models.User, error)
+	QueueDigest(userID int, day time.Time, msg models.MailData) (bool, error)
+	GetDigest(day, since, blocksUntil time.Time) (models.Digest, error)
+	InsertPayment(p models.Payment) (int, error)
+	UpdatePayment(p models.Payment) error
+	GetPaymentByID(id int) (models.Payment, error)
+	GetPaymentByProviderRef(provider, ref string) (models.Payment, error)
+	PaymentsForReservation(reservationID int) ([]models.Payment, error)
+
+	IssueInvoice(reservationID int) (models.Invoice, error)
+	InvoiceForReservation(reservationID int) (models.Invoice, error)
+
+	AllCharges() ([]models.Charge, error)
+	InsertCharge(c models.Charge) (int, error)
+	DeleteCharge(id int) error
 }
@@ -0,0 +1,173 @@
+// Package scheduler sends guests the emails that are due some days before or
+// after their stay, e.g. directions before they arrive and a review request
+// after they leave. Each is only sent once per reservation. Guests who opted
+// in to text messages are texted before they arrive too.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/emails"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/sms"
+)
+
+// postStayCatchUp is how many days late a post-stay email can still be sent,
+// e.g. after the app was down. Older stays are left alone.
+const postStayCatchUp = 7
+
+// Store is what the Scheduler needs from the database
+type Store interface {
+	ReservationsArrivingBetween(kind string, start, end time.Time) ([]models.Reservation, error)
+	ReservationsLeavingBetween(kind string, start, end time.Time) ([]models.Reservation, error)
+	QueueScheduledEmail(reservationID int, kind string, msg models.MailData) (bool, error)
+	QueueSMS(to, body string) error
+}
+
+// Config is when the emails are sent and what goes in them
+type Config struct {
+	PreArrivalDays int // Days before the stay. 0 turns the email off
+	PostStayDays   int // Days after the stay. 0 turns the email off
+	CheckInTime    string
+	ReviewURL      string
+	SiteURL        string
+	From           string
+
+	SMS            *sms.Templates // Nil if text messages are off
+	SMSCountryCode string         // For phone numbers given without one
+}
+
+// Scheduler queues guests' emails as they come due
+type Scheduler struct {
+	Store     Store
+	Templates *emails.Templates
+	Config    Config
+	ErrorLog  *log.Logger
+}
+
+// New creates a Scheduler
+func New(store Store, templates *emails.Templates, cfg Config, errorLog *log.Logger) *Scheduler {
+	return &Scheduler{
+		Store:     store,
+		Templates: templates,
+		Config:    cfg,
+		ErrorLog:  errorLog,
+	}
+}
+
+// Run queues due emails straight away, and then every interval until ctx is done
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.SendDue(time.Now())
+		if err != nil {
+			s.ErrorLog.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue queues every email that is due on the day of now and hasn't been
+// sent yet. Cancelled reservations don't get any.
+func (s *Scheduler) SendDue(now time.Time) error {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if days := s.Config.PreArrivalDays; days > 0 {
+		// Guests who booked less than the offset ahead get it straight away
+		reservations, err := s.Store.ReservationsArrivingBetween(models.ScheduledPreArrival, today, today.AddDate(0, 0, days))
+		if err != nil {
+			return err
+		}
+
+		s.queue(reservations, models.ScheduledPreArrival, emails.PreArrival, sms.PreArrival, "Your stay at the Go B & B is coming up")
+	}
+
+	if days := s.Config.PostStayDays; days > 0 {
+		last := today.AddDate(0, 0, -days)
+
+		reservations, err := s.Store.ReservationsLeavingBetween(models.ScheduledPostStay, last.AddDate(0, 0, -postStayCatchUp), last)
+		if err != nil {
+			return err
+		}
+
+		s.queue(reservations, models.ScheduledPostStay, emails.PostStay, "", "Thank you for staying at the Go B & B")
+	}
+
+	return nil
+}
+
+// queue renders and queues one kind of email for each reservation. One that
+// fails is logged, and tried again next time. Guests who opted in are also
+// texted smsTemplate, unless it's empty, when their email is first queued.
+func (s *Scheduler) queue(reservations []models.Reservation, kind, template, smsTemplate, subject string) {
+	for _, reservation := range reservations {
+		msg, err := s.render(reservation, template, subject)
+		if err != nil {
+			s.ErrorLog.Printf("queueing %s email for reservation %d: %v", kind, reservation.ID, err)
+			continue
+		}
+
+		queued, err := s.Store.QueueScheduledEmail(reservation.ID, kind, msg)
+		if err != nil {
+			s.ErrorLog.Printf("queueing %s email for reservation %d: %v", kind, reservation.ID, err)
+			continue
+		}
+
+		if queued && smsTemplate != "" && reservation.SMSOptIn && s.Config.SMS != nil {
+			err = s.text(reservation, smsTemplate)
+			if err != nil {
+				s.ErrorLog.Printf("queueing %s text message for reservation %d: %v", kind, reservation.ID, err)
+			}
+		}
+	}
+}
+
+// text renders and queues a text message to the guest of a reservation
+func (s *Scheduler) text(reservation models.Reservation, template string) error {
+	to, err := sms.Normalize(reservation.Phone, s.Config.SMSCountryCode)
+	if err != nil {
+		return err
+	}
+
+	body, err := s.Config.SMS.Render(template, s.data(reservation))
+	if err != nil {
+		return err
+	}
+
+	return s.Store.QueueSMS(to, body)
+}
+
+// data is what the templates are rendered with
+func (s *Scheduler) data(reservation models.Reservation) emails.StayEmail {
+	return emails.StayEmail{
+		ReservationEmail: emails.ReservationEmail{Reservation: reservation, SiteURL: s.Config.SiteURL},
+		CheckInTime:      s.Config.CheckInTime,
+		ReviewURL:        s.Config.ReviewURL,
+	}
+}
+
+// render renders an email to the guest of a reservation
+func (s *Scheduler) render(reservation models.Reservation, template, subject string) (models.MailData, error) {
+	html, text, err := s.Templates.Render(template, s.data(reservation))
+	if err != nil {
+		return models.MailData{}, fmt.Errorf("rendering %s: %w", template, err)
+	}
+
+	return models.MailData{
+		To:      reservation.Email,
+		From:    s.Config.From,
+		Subject: subject,
+		HTML:    html,
+		Text:    text,
+	}, nil
+}
@@ -0,0 +1,161 @@
+package scheduler
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/emails"
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/sms"
+)
+
+// memoryStore keeps reservations, and the emails sent for them, in memory
+type memoryStore struct {
+	reservations []models.Reservation
+	cancelled    map[int]bool
+	sent         map[string]bool // reservation ID and kind
+	queued       []models.MailData
+	texts        []string // Numbers texted
+}
+
+func (s *memoryStore) between(kind string, start, end time.Time, date func(models.Reservation) time.Time) []models.Reservation {
+	var found []models.Reservation
+
+	for _, r := range s.reservations {
+		d := date(r)
+		if !d.Before(start) && !d.After(end) && !s.cancelled[r.ID] && !s.sent[key(r.ID, kind)] {
+			found = append(found, r)
+		}
+	}
+
+	return found
+}
+
+func (s *memoryStore) ReservationsArrivingBetween(kind string, start, end time.Time) ([]models.Reservation, error) {
+	return s.between(kind, start, end, func(r models.Reservation) time.Time { return r.StartDate }), nil
+}
+
+func (s *memoryStore) ReservationsLeavingBetween(kind string, start, end time.Time) ([]models.Reservation, error) {
+	return s.between(kind, start, end, func(r models.Reservation) time.Time { return r.EndDate }), nil
+}
+
+func (s *memoryStore) QueueScheduledEmail(reservationID int, kind string, msg models.MailData) (bool, error) {
+	if s.sent[key(reservationID, kind)] {
+		return false, nil
+	}
+
+	s.sent[key(reservationID, kind)] = true
+	s.queued = append(s.queued, msg)
+
+	return true, nil
+}
+
+func (s *memoryStore) QueueSMS(to, body string) error {
+	s.texts = append(s.texts, to)
+	return nil
+}
+
+func key(id int, kind string) string {
+	return fmt.Sprintf("%d/%s", id, kind)
+}
+
+func date(day int) time.Time {
+	return time.Date(2050, 1, day, 0, 0, 0, 0, time.UTC)
+}
+
+func newScheduler(t *testing.T, store Store) *Scheduler {
+	templates, err := emails.Load("./../../email_templates", "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	smsTemplates, err := sms.LoadTemplates("./../../sms_templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(store, templates, Config{
+		PreArrivalDays: 3,
+		PostStayDays:   1,
+		CheckInTime:    "3 PM",
+		ReviewURL:      "https://bnb.example.com/review",
+		SiteURL:        "https://bnb.example.com",
+		From:           "me@here.com",
+		SMS:            smsTemplates,
+		SMSCountryCode: "1",
+	}, log.New(io.Discard, "", 0))
+}
+
+func TestSendDue(t *testing.T) {
+	store := &memoryStore{
+		reservations: []models.Reservation{
+			{ID: 1, Email: "soon@example.com", Phone: "(555) 555-5555", SMSOptIn: true, StartDate: date(12), EndDate: date(14)},
+			{ID: 2, Email: "later@example.com", StartDate: date(20), EndDate: date(22)},
+			{ID: 3, Email: "cancelled@example.com", StartDate: date(11), EndDate: date(13)},
+			{ID: 4, Email: "left@example.com", StartDate: date(5), EndDate: date(9)},
+			{ID: 5, Email: "long-gone@example.com", StartDate: date(-20), EndDate: date(-18)},
+		},
+		cancelled: map[int]bool{3: true},
+		sent:      map[string]bool{},
+	}
+
+	s := newScheduler(t, store)
+	now := time.Date(2050, 1, 10, 9, 30, 0, 0, time.Local)
+
+	err := s.SendDue(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.queued) != 2 {
+		t.Fatalf("expected 2 emails, got %d: %v", len(store.queued), store.queued)
+	}
+
+	arriving, leaving := store.queued[0], store.queued[1]
+
+	if arriving.To != "soon@example.com" || !strings.Contains(arriving.Text, "Check-in is from 3 PM") {
+		t.Errorf("expected a pre-arrival email to the arriving guest, got %+v", arriving)
+	}
+
+	if leaving.To != "left@example.com" || !strings.Contains(leaving.Text, "https://bnb.example.com/review") {
+		t.Errorf("expected a post-stay email to the guest who left, got %+v", leaving)
+	}
+
+	if len(store.texts) != 1 || store.texts[0] != "+15555555555" {
+		t.Errorf("expected the arriving guest to be texted, got %v", store.texts)
+	}
+
+	// Nothing is sent twice
+	err = s.SendDue(now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.queued) != 2 || len(store.texts) != 1 {
+		t.Errorf("expected no more emails or texts, got %d and %d", len(store.queued), len(store.texts))
+	}
+}
+
+func TestSendDueTurnedOff(t *testing.T) {
+	store := &memoryStore{
+		reservations: []models.Reservation{{ID: 1, StartDate: date(11), EndDate: date(9)}},
+		sent:         map[string]bool{},
+	}
+
+	s := newScheduler(t, store)
+	s.Config.PreArrivalDays = 0
+	s.Config.PostStayDays = 0
+
+	err := s.SendDue(date(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.queued) != 0 {
+		t.Errorf("expected no emails, got %v", store.queued)
+	}
+}
@@ -0,0 +1,216 @@
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// PostgresStore is an scs session store that keeps sessions in the "sessions"
+// table, so they survive restarts and deploys. Who a session belongs to and
+// where it's used from are copied into columns of their own, so a user's
+// sessions can be listed without decoding every session.
+type PostgresStore struct {
+	db          *sql.DB
+	codec       scs.Codec
+	stopCleanup chan bool
+}
+
+// New creates a PostgresStore using the given connection pool. Expired sessions
+// are deleted from the table every 5 minutes.
+func New(db *sql.DB) *PostgresStore {
+	return NewWithCleanupInterval(db, 5*time.Minute)
+}
+
+// NewWithCleanupInterval creates a PostgresStore that deletes expired sessions
+// at the given interval. An interval of 0 disables the cleanup, in which case
+// expired sessions are still never returned, just not deleted.
+func NewWithCleanupInterval(db *sql.DB, cleanupInterval time.Duration) *PostgresStore {
+	p := &PostgresStore{db: db, codec: scs.GobCodec{}}
+
+	if cleanupInterval > 0 {
+		p.stopCleanup = make(chan bool)
+		go p.startCleanup(cleanupInterval)
+	}
+
+	return p
+}
+
+// Find returns the data for the given session token. If the session doesn't
+// exist or has expired, found is false.
+func (p *PostgresStore) Find(token string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b []byte
+
+	query := `SELECT data FROM sessions WHERE token = $1 AND current_timestamp < expiry`
+
+	err := p.db.QueryRowContext(ctx, query, token).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return b, true, nil
+}
+
+// Commit adds a session to the store, or replaces the data and expiry of an
+// existing one.
+func (p *PostgresStore) Commit(token string, b []byte, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	d, err := p.details(b)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO
+			sessions (token, data, expiry, user_id, ip, user_agent, logged_in_at, last_seen)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (token) DO UPDATE SET
+			data = EXCLUDED.data,
+			expiry = EXCLUDED.expiry,
+			user_id = EXCLUDED.user_id,
+			ip = EXCLUDED.ip,
+			user_agent = EXCLUDED.user_agent,
+			logged_in_at = EXCLUDED.logged_in_at,
+			last_seen = EXCLUDED.last_seen
+	`
+
+	_, err = p.db.ExecContext(ctx, query, token, b, expiry,
+		d.userID,
+		d.ip,
+		d.userAgent,
+		nullTime(d.loggedInAt),
+		nullTime(d.lastSeen),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// sessionDetails are the values of a session that are copied into columns
+type sessionDetails struct {
+	userID     int
+	ip         string
+	userAgent  string
+	loggedInAt time.Time
+	lastSeen   time.Time
+}
+
+// details decodes the values of a session that are copied into columns.
+// Anonymous sessions have none.
+func (p *PostgresStore) details(b []byte) (sessionDetails, error) {
+	var d sessionDetails
+
+	_, values, err := p.codec.Decode(b)
+	if err != nil {
+		return d, err
+	}
+
+	d.userID, _ = values["user_id"].(int)
+	d.ip, _ = values["ip"].(string)
+	d.userAgent, _ = values["user_agent"].(string)
+	d.loggedInAt, _ = values["logged_in_at"].(time.Time)
+	d.lastSeen, _ = values["last_seen"].(time.Time)
+
+	return d, nil
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// Delete removes a session from the store. Deleting a session that doesn't
+// exist is not an error.
+func (p *PostgresStore) Delete(token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `DELETE FROM sessions WHERE token = $1`, token)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// All returns the data of every unexpired session, keyed by session token.
+// This is what lets scs iterate over sessions.
+func (p *PostgresStore) All() (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	sessions := make(map[string][]byte)
+
+	rows, err := p.db.QueryContext(ctx, `SELECT token, data FROM sessions WHERE current_timestamp < expiry`)
+	if err != nil {
+		return sessions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token string
+		var data []byte
+
+		err := rows.Scan(&token, &data)
+		if err != nil {
+			return sessions, err
+		}
+
+		sessions[token] = data
+	}
+
+	if err = rows.Err(); err != nil {
+		return sessions, err
+	}
+
+	return sessions, nil
+}
+
+// StopCleanup stops the background cleanup goroutine. It only needs to be
+// called when the store is thrown away before the program exits, e.g. in tests.
+func (p *PostgresStore) StopCleanup() {
+	if p.stopCleanup != nil {
+		p.stopCleanup <- true
+	}
+}
+
+// startCleanup deletes expired sessions every interval until StopCleanup is called.
+func (p *PostgresStore) startCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-ticker.C:
+			err := p.deleteExpired()
+			if err != nil {
+				log.Println(err)
+			}
+		case <-p.stopCleanup:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// deleteExpired deletes all sessions that have expired.
+func (p *PostgresStore) deleteExpired() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, `DELETE FROM sessions WHERE expiry < current_timestamp`)
+
+	return err
+}
@@ -0,0 +1,222 @@
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Sessions keep times, e.g. when they were last seen, as the app does
+func init() {
+	gob.Register(time.Time{})
+}
+
+// testTokenPrefix marks the sessions the tests make, so they can be cleaned up
+const testTokenPrefix = "sessionstore-test-"
+
+// testDB connects to the database in SESSIONSTORE_TEST_DB, which must already
+// be migrated. Tests that need it are skipped if it isn't set.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("SESSIONSTORE_TEST_DB")
+	if dsn == "" {
+		t.Skip("SESSIONSTORE_TEST_DB isn't set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM sessions WHERE token LIKE $1`, testTokenPrefix+"%")
+		db.Close()
+	})
+
+	return db
+}
+
+// encode encodes session values the way scs does
+func encode(t *testing.T, values map[string]interface{}) []byte {
+	t.Helper()
+
+	b, err := scs.GobCodec{}.Encode(time.Now().Add(time.Hour), values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestDetails(t *testing.T) {
+	p := &PostgresStore{codec: scs.GobCodec{}}
+	seen := time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC)
+
+	d, err := p.details(encode(t, map[string]interface{}{
+		"user_id":    1,
+		"ip":         "203.0.113.7",
+		"user_agent": "Firefox",
+		"last_seen":  seen,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.userID != 1 || d.ip != "203.0.113.7" || d.userAgent != "Firefox" || !d.lastSeen.Equal(seen) || !d.loggedInAt.IsZero() {
+		t.Errorf("got unexpected details %+v", d)
+	}
+
+	// Anonymous sessions belong to nobody
+	d, err = p.details(encode(t, map[string]interface{}{"reservation": "half-finished"}))
+	if err != nil || d.userID != 0 {
+		t.Errorf("expected no details for an anonymous session, got %+v, %v", d, err)
+	}
+
+	_, err = p.details([]byte("not a session"))
+	if err == nil {
+		t.Error("expected an error for data that isn't a session")
+	}
+}
+
+func TestCommitAndFind(t *testing.T) {
+	p := NewWithCleanupInterval(testDB(t), 0)
+	token := testTokenPrefix + "commit"
+	b := encode(t, map[string]interface{}{"user_id": 7, "ip": "203.0.113.7"})
+
+	err := p.Commit(token, b, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, ok, err := p.Find(token)
+	if err != nil || !ok || string(found) != string(b) {
+		t.Fatalf("expected to find the session, got %t, %v", ok, err)
+	}
+
+	// Committing again replaces the data, and the details
+	err = p.Commit(token, encode(t, map[string]interface{}{"user_id": 7, "ip": "198.51.100.9"}), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var userID int
+	var ip string
+
+	err = p.db.QueryRow(`SELECT user_id, ip FROM sessions WHERE token = $1`, token).Scan(&userID, &ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userID != 7 || ip != "198.51.100.9" {
+		t.Errorf("expected user 7 from 198.51.100.9, got user %d from %s", userID, ip)
+	}
+
+	all, err := p.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := all[token]; !ok {
+		t.Error("expected All to include the session")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	p := NewWithCleanupInterval(testDB(t), 0)
+	token := testTokenPrefix + "expired"
+
+	err := p.Commit(token, encode(t, nil), time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok, err := p.Find(token)
+	if err != nil || ok {
+		t.Errorf("expected an expired session not to be found, got %t, %v", ok, err)
+	}
+
+	all, err := p.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := all[token]; ok {
+		t.Error("expected All to leave out the expired session")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	p := NewWithCleanupInterval(testDB(t), 0)
+	token := testTokenPrefix + "deleted"
+
+	err := p.Commit(token, encode(t, nil), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Delete(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok, err := p.Find(token)
+	if err != nil || ok {
+		t.Errorf("expected a deleted session not to be found, got %t, %v", ok, err)
+	}
+
+	// Deleting it again isn't an error
+	err = p.Delete(token)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	db := testDB(t)
+	p := NewWithCleanupInterval(db, 50*time.Millisecond)
+	defer p.StopCleanup()
+
+	expired := testTokenPrefix + "cleanup-expired"
+	live := testTokenPrefix + "cleanup-live"
+
+	err := p.Commit(expired, encode(t, nil), time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Commit(live, encode(t, nil), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// Wait for the cleanup loop to delete the expired session
+	for {
+		var n int
+
+		err := db.QueryRowContext(ctx, `SELECT count(*) FROM sessions WHERE token = $1`, expired).Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n == 0 {
+			break
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	_, ok, err := p.Find(live)
+	if err != nil || !ok {
+		t.Errorf("expected the cleanup to leave the live session alone, got %t, %v", ok, err)
+	}
+}
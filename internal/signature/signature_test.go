@@ -0,0 +1,26 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"reservation.created"}`)
+
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte("1700000000." + string(body)))
+	expected := "t=1700000000,v1=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := Sign("whsec_test", at, body); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	if Sign("other", at, body) == expected {
+		t.Error("expected a different secret to give a different signature")
+	}
+}
@@ -0,0 +1,56 @@
+package sms
+
+import (
+	"log"
+	"sync"
+)
+
+// Fake logs text messages instead of sending them, and keeps them, for local
+// development and tests
+type Fake struct {
+	Log *log.Logger // Nil doesn't log
+
+	mu   sync.Mutex
+	sent []Message
+}
+
+// Message is a text message the Fake was given
+type Message struct {
+	To   string
+	Body string
+}
+
+// NewFake creates a Fake that logs to l
+func NewFake(l *log.Logger) *Fake {
+	return &Fake{Log: l}
+}
+
+// Send records a text message
+func (f *Fake) Send(to, body string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sent = append(f.sent, Message{To: to, Body: body})
+
+	if f.Log != nil {
+		f.Log.Printf("SMS to %s: %s", to, body)
+	}
+
+	return nil
+}
+
+// Sent returns the text messages sent so far, oldest first
+func (f *Fake) Sent() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Message(nil), f.sent...)
+}
+
+// Reset forgets the text messages sent so far
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sent = nil
+}
@@ -0,0 +1,66 @@
+package sms
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPProvider sends text messages through a provider's HTTP API. It posts a
+// form with To, From and Body, authenticated with basic auth, which is what
+// Twilio and most providers modelled on it expect.
+type HTTPProvider struct {
+	URL      string
+	Username string
+	Password string
+	From     string // The number or sender ID messages come from
+	Client   *http.Client
+}
+
+// NewHTTPProvider creates an HTTPProvider with a client that gives up on a
+// slow API
+func NewHTTPProvider(apiURL, username, password, from string) *HTTPProvider {
+	return &HTTPProvider{
+		URL:      apiURL,
+		Username: username,
+		Password: password,
+		From:     from,
+		Client:   &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Send sends one text message. Any response but a 2xx is an error.
+func (p *HTTPProvider) Send(to, body string) error {
+	form := url.Values{
+		"To":   {to},
+		"From": {p.From},
+		"Body": {body},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sms: sending to %s: %w", to, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// The body usually says why, and is worth keeping for the log
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sms: sending to %s: %s: %s", to, resp.Status, strings.TrimSpace(string(detail)))
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// Package sms sends text messages to guests and the owner, through a provider
+// with an HTTP API, or a fake one that only logs them for local development.
+// Messages are queued in the database and sent in the background, like email.
+package sms
+
+import (
+	"errors"
+	"strings"
+)
+
+// Provider sends one text message
+type Provider interface {
+	Send(to, body string) error
+}
+
+// ErrInvalidPhone is returned for phone numbers that can't be texted
+var ErrInvalidPhone = errors.New("sms: not a valid phone number")
+
+// Normalize turns a phone number as a guest typed it into E.164, e.g.
+// "(555) 555-5555" into "+15555555555". Numbers without an international
+// prefix (+ or 00) are taken to be in the country with countryCode.
+func Normalize(phone, countryCode string) (string, error) {
+	phone = strings.TrimSpace(phone)
+
+	var digits strings.Builder
+	for _, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case strings.ContainsRune(" -.()/", r):
+		case r == '+' && digits.Len() == 0 && strings.HasPrefix(phone, "+"):
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+
+	number := digits.String()
+
+	switch {
+	case strings.HasPrefix(phone, "+"):
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case countryCode == "1" && len(number) == 11 && number[0] == '1':
+		// Already has the North American country code
+	default:
+		// Drop the trunk prefix most countries dial inside the country
+		number = countryCode + strings.TrimPrefix(number, "0")
+	}
+
+	// E.164 numbers are at most 15 digits, and none are very short
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", ErrInvalidPhone
+	}
+
+	return "+" + number, nil
+}
@@ -0,0 +1,189 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/outbox"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		phone       string
+		countryCode string
+		expected    string
+	}{
+		{"(555) 555-5555", "1", "+15555555555"},
+		{"555.555.5555", "1", "+15555555555"},
+		{"1 555 555 5555", "1", "+15555555555"},
+		{"+44 20 7946 0958", "1", "+442079460958"},
+		{"0044 20 7946 0958", "1", "+442079460958"},
+		{"020 7946 0958", "44", "+442079460958"},
+		{"555", "1", ""},
+		{"call me", "1", ""},
+		{"", "1", ""},
+		{"+1 555 555 5555 5555 5555", "1", ""},
+	}
+
+	for _, test := range tests {
+		got, err := Normalize(test.phone, test.countryCode)
+
+		if test.expected == "" {
+			if !errors.Is(err, ErrInvalidPhone) {
+				t.Errorf("Normalize(%q): expected an invalid number, got %q", test.phone, got)
+			}
+			continue
+		}
+
+		if err != nil || got != test.expected {
+			t.Errorf("Normalize(%q): expected %s, got %q, %v", test.phone, test.expected, got, err)
+		}
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	var form map[string]string
+	var user, password string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = map[string]string{"To": r.Form.Get("To"), "From": r.Form.Get("From"), "Body": r.Form.Get("Body")}
+		user, password, _ = r.BasicAuth()
+
+		if r.Form.Get("To") == "+15555550000" {
+			http.Error(w, "not a mobile number", http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider(server.URL, "bnb", "secret", "+15555551111")
+
+	err := p.Send("+15555555555", "See you soon")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if form["To"] != "+15555555555" || form["From"] != "+15555551111" || form["Body"] != "See you soon" {
+		t.Errorf("unexpected form: %v", form)
+	}
+
+	if user != "bnb" || password != "secret" {
+		t.Errorf("expected basic auth, got %q and %q", user, password)
+	}
+
+	err = p.Send("+15555550000", "See you soon")
+	if err == nil || !strings.Contains(err.Error(), "not a mobile number") {
+		t.Errorf("expected the API's error, got %v", err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	templates, err := LoadTemplates("./../../sms_templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := templates.Render(OwnerAlert, struct {
+		Reservation models.Reservation
+		AdminURL    string
+	}{
+		Reservation: models.Reservation{
+			FirstName: "John",
+			LastName:  "Smith",
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			Room:      models.Room{RoomName: "General's Quarters"},
+		},
+		AdminURL: "https://bnb.example.com/admin/reservations/all/7/show",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "New booking: John Smith, General's Quarters, Jan 1 to Jan 3. https://bnb.example.com/admin/reservations/all/7/show"
+	if body != expected {
+		t.Errorf("expected %q, got %q", expected, body)
+	}
+
+	if _, err := templates.Render("nope", nil); err == nil {
+		t.Error("expected an error for a template that doesn't exist")
+	}
+}
+
+// memoryStore keeps text messages in memory
+type memoryStore struct {
+	messages map[int]models.SMS
+}
+
+func (s *memoryStore) DueSMS(limit int) ([]models.SMS, error) {
+	var due []models.SMS
+
+	for _, m := range s.messages {
+		if m.Status == models.EmailPending && len(due) < limit {
+			due = append(due, m)
+		}
+	}
+
+	return due, nil
+}
+
+func (s *memoryStore) UpdateSMS(m models.SMS) error {
+	s.messages[m.ID] = m
+	return nil
+}
+
+// downProvider fails for one number
+type downProvider struct {
+	Fake
+}
+
+func (p *downProvider) Send(to, body string) error {
+	if to == "+15555550000" {
+		return errors.New("service unavailable")
+	}
+
+	return p.Fake.Send(to, body)
+}
+
+func TestWorker(t *testing.T) {
+	store := &memoryStore{messages: map[int]models.SMS{
+		1: {ID: 1, To: "+15555555555", Body: "Hi", Status: models.EmailPending},
+		2: {ID: 2, To: "+15555550000", Body: "Hi", Status: models.EmailPending},
+	}}
+	provider := &downProvider{}
+
+	w := NewWorker(store, provider, log.New(io.Discard, "", 0))
+
+	err := w.SendDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent := provider.Sent(); len(sent) != 1 || sent[0].To != "+15555555555" {
+		t.Errorf("expected one text message to be sent, got %v", sent)
+	}
+
+	if m := store.messages[1]; m.Status != models.EmailSent || m.SentAt.IsZero() {
+		t.Errorf("expected message 1 to be sent, got %+v", m)
+	}
+
+	if m := store.messages[2]; m.Status != models.EmailPending || m.LastError != "service unavailable" || !m.NextAttemptAt.After(time.Now()) {
+		t.Errorf("expected message 2 to be retried later, got %+v", m)
+	}
+
+	m := w.Send(models.SMS{ID: 3, To: "+15555550000", Attempts: outbox.MaxAttempts - 1})
+	if m.Status != models.EmailFailed {
+		t.Errorf("expected the message to fail for good, got %+v", m)
+	}
+}
@@ -0,0 +1,65 @@
+package sms
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// The text messages there are templates for
+const (
+	GuestConfirmation = "guest_confirmation"
+	PreArrival        = "pre_arrival"
+	OwnerAlert        = "owner_alert"
+)
+
+// Templates are the parsed text message templates, ready to render. Each is a
+// name.txt file in sms_templates.
+type Templates struct {
+	pages map[string]*template.Template
+}
+
+// LoadTemplates parses every text message template in dir
+func LoadTemplates(dir string) (*Templates, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("sms: no templates in %s", dir)
+	}
+
+	t := &Templates{pages: map[string]*template.Template{}}
+
+	for _, file := range files {
+		page, err := template.ParseFiles(file)
+		if err != nil {
+			return nil, fmt.Errorf("sms: parsing %s: %w", file, err)
+		}
+
+		t.pages[strings.TrimSuffix(filepath.Base(file), ".txt")] = page
+	}
+
+	return t, nil
+}
+
+// Render renders a text message. Line breaks are kept, but the space around
+// the message is trimmed.
+func (t *Templates) Render(name string, data any) (string, error) {
+	page, ok := t.pages[name]
+	if !ok {
+		return "", fmt.Errorf("sms: no template called %s", name)
+	}
+
+	var buf bytes.Buffer
+
+	err := page.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("sms: rendering %s: %w", name, err)
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
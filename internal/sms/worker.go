@@ -0,0 +1,104 @@
+package sms
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/outbox"
+)
+
+// batchSize is how many text messages are fetched at once
+const batchSize = 50
+
+// Store is what the Worker needs from the database
+type Store interface {
+	DueSMS(limit int) ([]models.SMS, error)
+	UpdateSMS(s models.SMS) error
+}
+
+// Worker sends queued text messages as they come due. Failures are retried
+// on the same schedule as email.
+type Worker struct {
+	Store    Store
+	Provider Provider
+	ErrorLog *log.Logger
+}
+
+// NewWorker creates a Worker
+func NewWorker(store Store, provider Provider, errorLog *log.Logger) *Worker {
+	return &Worker{
+		Store:    store,
+		Provider: provider,
+		ErrorLog: errorLog,
+	}
+}
+
+// Run sends due text messages every interval until ctx is done
+func (w *Worker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := w.SendDue(ctx)
+		if err != nil {
+			w.ErrorLog.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue sends every text message that is due, in batches
+func (w *Worker) SendDue(ctx context.Context) error {
+	for {
+		due, err := w.Store.DueSMS(batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range due {
+			err := w.Store.UpdateSMS(w.Send(s))
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(due) < batchSize || ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// Send makes one attempt at sending a text message, and returns it updated
+// with the outcome
+func (w *Worker) Send(s models.SMS) models.SMS {
+	now := time.Now()
+
+	s.Attempts++
+	s.LastError = ""
+
+	err := w.Provider.Send(s.To, s.Body)
+	if err == nil {
+		s.Status = models.EmailSent
+		s.SentAt = now
+		return s
+	}
+
+	s.LastError = err.Error()
+
+	if s.Attempts >= outbox.MaxAttempts {
+		s.Status = models.EmailFailed
+		w.ErrorLog.Printf("giving up on text message %d to %s: %v", s.ID, s.To, err)
+	} else {
+		s.Status = models.EmailPending
+		s.NextAttemptAt = now.Add(outbox.Backoff(s.Attempts))
+	}
+
+	return s
+}
@@ -0,0 +1,224 @@
+// Package webhooks signs events and delivers them to the URLs subscribed to
+// them, retrying with exponential backoff until they're accepted.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/signature"
+)
+
+// Events that can be subscribed to
+const (
+	ReservationCreated   = "reservation.created"
+	ReservationUpdated   = "reservation.updated"
+	ReservationProcessed = "reservation.processed"
+	ReservationCancelled = "reservation.cancelled"
+	ReservationDeleted   = "reservation.deleted"
+	BlockAdded           = "block.added"
+	BlockRemoved         = "block.removed"
+)
+
+// Events lists every event, in the order they're shown to staff
+var Events = []string{
+	ReservationCreated,
+	ReservationUpdated,
+	ReservationProcessed,
+	ReservationCancelled,
+	ReservationDeleted,
+	BlockAdded,
+	BlockRemoved,
+}
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// MaxAttempts is how many times a delivery is tried before giving up
+const MaxAttempts = 8
+
+// batchSize is how many deliveries are claimed at once
+const batchSize = 50
+
+// claimLease is how long a claimed batch is left to this dispatcher. It's
+// long enough for every delivery in it to time out, after which another
+// dispatcher can try the ones this one didn't get to record.
+const claimLease = 15 * time.Minute
+
+// payload is the JSON body of every delivery
+type payload struct {
+	Event     string `json:"event"`
+	CreatedAt string `json:"created_at"`
+	Data      any    `json:"data"`
+}
+
+// Payload builds the JSON body of an event
+func Payload(event string, data any) ([]byte, error) {
+	return json.Marshal(payload{
+		Event:     event,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Data:      data,
+	})
+}
+
+// NewSecret generates a random secret to sign a webhook's payloads with
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Backoff is how long to wait before the next attempt, after attempts have
+// failed. It doubles every time, from a minute up to 12 hours.
+func Backoff(attempts int) time.Duration {
+	wait := time.Minute
+
+	for i := 1; i < attempts && wait < 12*time.Hour; i++ {
+		wait *= 2
+	}
+
+	return min(wait, 12*time.Hour)
+}
+
+// Store is what the Dispatcher needs from the database.
+// ClaimDueWebhookDeliveries must hide the deliveries it returns from other
+// callers until lease is up, so two dispatchers never post the same event.
+type Store interface {
+	ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(d models.WebhookDelivery) error
+}
+
+// Dispatcher sends queued deliveries as they come due
+type Dispatcher struct {
+	Store    Store
+	Client   *http.Client
+	ErrorLog *log.Logger
+}
+
+// New creates a Dispatcher with a client that gives up on slow receivers
+func New(store Store, errorLog *log.Logger) *Dispatcher {
+	return &Dispatcher{
+		Store:    store,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		ErrorLog: errorLog,
+	}
+}
+
+// Run sends due deliveries every interval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := d.DeliverDue(ctx)
+		if err != nil {
+			d.ErrorLog.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue sends every delivery that is due, in batches
+func (d *Dispatcher) DeliverDue(ctx context.Context) error {
+	for {
+		due, err := d.Store.ClaimDueWebhookDeliveries(batchSize, claimLease)
+		if err != nil {
+			return err
+		}
+
+		for _, delivery := range due {
+			err := d.Store.UpdateWebhookDelivery(d.Deliver(ctx, delivery))
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(due) < batchSize || ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// Deliver makes one attempt at sending a delivery, and returns it updated
+// with the outcome. Any 2xx response counts as delivered.
+func (d *Dispatcher) Deliver(ctx context.Context, delivery models.WebhookDelivery) models.WebhookDelivery {
+	now := time.Now()
+
+	delivery.Attempts++
+	delivery.ResponseStatus = 0
+	delivery.LastError = ""
+
+	status, err := d.post(ctx, delivery, now)
+	delivery.ResponseStatus = status
+
+	switch {
+	case err != nil:
+		delivery.LastError = err.Error()
+	case status < 200 || status > 299:
+		delivery.LastError = fmt.Sprintf("receiver returned %d", status)
+	default:
+		delivery.Status = models.DeliveryDelivered
+		delivery.DeliveredAt = now
+		return delivery
+	}
+
+	if delivery.Attempts >= MaxAttempts {
+		delivery.Status = models.DeliveryFailed
+	} else {
+		delivery.Status = models.DeliveryPending
+		delivery.NextAttemptAt = now.Add(Backoff(delivery.Attempts))
+	}
+
+	return delivery
+}
+
+// post sends the signed payload, and returns the response's status code
+func (d *Dispatcher) post(ctx context.Context, delivery models.WebhookDelivery, now time.Time) (int, error) {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Go-B-and-B-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(delivery.ID))
+	req.Header.Set(HeaderSignature, signature.Sign(delivery.Webhook.Secret, now, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Read some of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,183 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackSound1/Go-B-and-B/internal/models"
+	"github.com/BlackSound1/Go-B-and-B/internal/signature"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		4:  8 * time.Minute,
+		20: 12 * time.Hour,
+	}
+
+	for attempts, expected := range tests {
+		if got := Backoff(attempts); got != expected {
+			t.Errorf("Backoff(%d): expected %s, got %s", attempts, expected, got)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+	body, err := Payload(BlockAdded, map[string]int{"room_id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p struct {
+		Event     string         `json:"event"`
+		CreatedAt string         `json:"created_at"`
+		Data      map[string]int `json:"data"`
+	}
+
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Event != BlockAdded || p.CreatedAt == "" || p.Data["room_id"] != 1 {
+		t.Errorf("unexpected payload %s", body)
+	}
+}
+
+// memoryStore keeps deliveries in memory
+type memoryStore struct {
+	deliveries map[int]models.WebhookDelivery
+}
+
+func (s *memoryStore) ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	var due []models.WebhookDelivery
+
+	for id, d := range s.deliveries {
+		if d.Status == models.DeliveryPending && !d.NextAttemptAt.After(time.Now()) && len(due) < limit {
+			due = append(due, d)
+
+			d.NextAttemptAt = time.Now().Add(lease)
+			s.deliveries[id] = d
+		}
+	}
+
+	return due, nil
+}
+
+func (s *memoryStore) UpdateWebhookDelivery(d models.WebhookDelivery) error {
+	s.deliveries[d.ID] = d
+	return nil
+}
+
+func TestDeliverDue(t *testing.T) {
+	var received []*http.Request
+	var bodies []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, string(body))
+
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	secret := "whsec_test"
+
+	store := &memoryStore{deliveries: map[int]models.WebhookDelivery{
+		1: {ID: 1, Event: ReservationCreated, Payload: `{"n":1}`, Status: models.DeliveryPending, Webhook: models.Webhook{URL: srv.URL + "/ok", Secret: secret}},
+		2: {ID: 2, Event: BlockAdded, Payload: `{"n":2}`, Status: models.DeliveryPending, Webhook: models.Webhook{URL: srv.URL + "/broken", Secret: secret}},
+		3: {ID: 3, Event: BlockAdded, Payload: `{"n":3}`, Status: models.DeliveryPending, Attempts: MaxAttempts - 1, Webhook: models.Webhook{URL: srv.URL + "/broken", Secret: secret}},
+		4: {ID: 4, Event: BlockAdded, Payload: `{"n":4}`, Status: models.DeliveryPending, NextAttemptAt: time.Now().Add(time.Hour), Webhook: models.Webhook{URL: srv.URL + "/ok", Secret: secret}},
+	}}
+
+	err := New(store, log.New(io.Discard, "", 0)).DeliverDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(received))
+	}
+
+	// Receivers can check the signature with the secret
+	for i, r := range received {
+		sig := r.Header.Get(HeaderSignature)
+		ts, _, _ := strings.Cut(strings.TrimPrefix(sig, "t="), ",")
+		unix, _ := strconv.ParseInt(ts, 10, 64)
+
+		if sig != signature.Sign(secret, time.Unix(unix, 0), []byte(bodies[i])) {
+			t.Errorf("request %d has an invalid signature %q", i, sig)
+		}
+
+		if r.Header.Get(HeaderEvent) == "" || r.Header.Get(HeaderDelivery) == "" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request %d is missing headers: %v", i, r.Header)
+		}
+	}
+
+	if d := store.deliveries[1]; d.Status != models.DeliveryDelivered || d.Attempts != 1 || d.ResponseStatus != http.StatusOK || d.DeliveredAt.IsZero() {
+		t.Errorf("expected delivery 1 to be delivered, got %+v", d)
+	}
+
+	if d := store.deliveries[2]; d.Status != models.DeliveryPending || d.Attempts != 1 || d.ResponseStatus != http.StatusInternalServerError || d.LastError == "" || !d.NextAttemptAt.After(time.Now().Add(50*time.Second)) {
+		t.Errorf("expected delivery 2 to be retried in a minute, got %+v", d)
+	}
+
+	if d := store.deliveries[3]; d.Status != models.DeliveryFailed || d.Attempts != MaxAttempts {
+		t.Errorf("expected delivery 3 to have given up, got %+v", d)
+	}
+
+	if d := store.deliveries[4]; d.Attempts != 0 {
+		t.Errorf("expected delivery 4 not to be due yet, got %+v", d)
+	}
+
+	// A claimed delivery is left alone until its lease is up, then posted
+	// again if its dispatcher died before recording the outcome
+	store.deliveries[5] = models.WebhookDelivery{ID: 5, Event: BlockRemoved, Payload: `{"n":5}`, Status: models.DeliveryPending, Webhook: models.Webhook{URL: srv.URL + "/ok", Secret: secret}}
+	store.ClaimDueWebhookDeliveries(batchSize, claimLease)
+
+	err = New(store, log.New(io.Discard, "", 0)).DeliverDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 3 {
+		t.Fatalf("expected the claimed delivery to be skipped, got %d requests", len(received))
+	}
+
+	d := store.deliveries[5]
+	d.NextAttemptAt = time.Now().Add(-time.Second)
+	store.deliveries[5] = d
+
+	err = New(store, log.New(io.Discard, "", 0)).DeliverDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := store.deliveries[5]; len(received) != 4 || d.Status != models.DeliveryDelivered {
+		t.Errorf("expected the abandoned delivery to be posted once, got %+v", d)
+	}
+}
+
+func TestDeliverUnreachable(t *testing.T) {
+	delivery := New(&memoryStore{}, log.New(io.Discard, "", 0)).Deliver(context.Background(), models.WebhookDelivery{
+		ID:      1,
+		Payload: "{}",
+		Webhook: models.Webhook{URL: "http://127.0.0.1:1/hook"},
+	})
+
+	if delivery.Status != models.DeliveryPending || delivery.ResponseStatus != 0 || delivery.LastError == "" {
+		t.Errorf("expected a failed attempt to be retried, got %+v", delivery)
+	}
+}
@@ -0,0 +1,3 @@
+DELETE FROM restrictions WHERE id = 3;
+
+SELECT setval(pg_get_serial_sequence('restrictions', 'id'), (SELECT MAX(id) FROM restrictions));
@@ -0,0 +1,8 @@
+-- The app refers to this restriction as models.RestrictionExternal, so its
+-- ID is fixed rather than left to the sequence
+INSERT INTO 
+    restrictions (id, restriction_name, created_at, updated_at)
+VALUES
+	 (3, 'External', now(), now());
+
+SELECT setval(pg_get_serial_sequence('restrictions', 'id'), (SELECT MAX(id) FROM restrictions));
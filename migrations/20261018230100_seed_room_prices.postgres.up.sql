@@ -0,0 +1,2 @@
+UPDATE rooms SET price = 12000 WHERE room_name = 'General''s Quarters';
+UPDATE rooms SET price = 15000 WHERE room_name = 'Major''s Suite';
@@ -0,0 +1,8 @@
+DROP INDEX sessions_user_id_idx;
+
+ALTER TABLE sessions
+    DROP COLUMN user_id,
+    DROP COLUMN ip,
+    DROP COLUMN user_agent,
+    DROP COLUMN logged_in_at,
+    DROP COLUMN last_seen;
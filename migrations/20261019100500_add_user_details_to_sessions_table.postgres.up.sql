@@ -0,0 +1,8 @@
+ALTER TABLE sessions
+    ADD COLUMN user_id INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN ip TEXT NOT NULL DEFAULT '',
+    ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN logged_in_at TIMESTAMPTZ,
+    ADD COLUMN last_seen TIMESTAMPTZ;
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id) WHERE user_id <> 0;
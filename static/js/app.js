@@ -224,6 +224,8 @@ function HandleBookingOnRoomsPage(room_id, token) {
                     showOnFocus: true,
                     minDate: new Date(),
                 });
+
+                DisableUnavailableDates(rp, [room_id]);
             },
             didOpen: () => {
                 // After modal opens
@@ -259,6 +261,12 @@ function HandleBookingOnRoomsPage(room_id, token) {
                                  + data.end_date 
                                  + '" class="btn btn-primary">Book Now</a></p>'
                         });
+                    } else if (data.error) {
+                        // Show what was wrong with the dates, if anything
+                        const problems = Object.values(data.error.fields || {});
+                        attention.error({ msg: problems.length > 0 ? problems.join(" ") : data.error.message });
+                    } else if (data.conflicting_nights) {
+                        attention.error({ msg: "No availability. The room is taken on " + data.conflicting_nights.join(", ") });
                     } else {
                         attention.error({ msg: "No availability" });
                     }
@@ -267,3 +275,58 @@ function HandleBookingOnRoomsPage(room_id, token) {
         });
     });
 }
+
+/**
+ * Disables the dates in a date range picker that can't be booked, using the
+ * calendars of the given rooms for the next 12 months. An arrival date is
+ * disabled if no room can be booked from that night, and a departure date is
+ * disabled if every room is taken the night before.
+ *
+ * @param {DateRangePicker} rangePicker The date range picker to update.
+ * @param {number[]} roomIDs The IDs of the rooms the guest can choose from.
+ */
+async function DisableUnavailableDates(rangePicker, roomIDs) {
+    // Format a date as YYYY-MM
+    const month = (d) => d.getFullYear() + "-" + String(d.getMonth() + 1).padStart(2, "0");
+
+    const today = new Date();
+    const start = month(today);
+    const end = month(new Date(today.getFullYear(), today.getMonth() + 11, 1));
+
+    let calendars;
+    try {
+        calendars = await Promise.all(roomIDs.map(id =>
+            fetch("/api/v1/rooms/" + id + "/calendar?start=" + start + "&end=" + end)
+                .then(response => response.json())
+        ));
+    } catch (e) {
+        // Leave every date enabled. Conflicts are still caught when searching
+        return;
+    }
+
+    const arrivals = new Set();
+    const departures = new Set();
+
+    calendars.forEach(calendar => {
+        calendar.nights.forEach((night, i) => {
+            if (night.status === "available") {
+                arrivals.add(night.date);
+            }
+
+            // A guest can leave the morning after any night the room is free
+            const next = calendar.nights[i + 1];
+            if (next && night.status !== "booked" && night.status !== "blocked") {
+                departures.add(next.date);
+            }
+        });
+    });
+
+    const dates = calendars.length > 0 ? calendars[0].nights.map(night => night.date) : [];
+
+    rangePicker.datepickers[0].setOptions({
+        datesDisabled: dates.filter(date => !arrivals.has(date)),
+    });
+    rangePicker.datepickers[1].setOptions({
+        datesDisabled: dates.filter(date => !departures.has(date)),
+    });
+}